@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/joolshouston/pismo-technical-test/shared/backup"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
+)
+
+const (
+	backupUsage  = "usage: backup [-tenant id] <archive>"
+	restoreUsage = "usage: restore [-tenant id] <archive>"
+)
+
+// runBackup is the backup subcommand, it writes the accounts, transactions, journal and audit trail of the database,
+// of one tenant when TENANTS is set, to a compressed archive that restore loads into another database. The archive is
+// written next to its path first and moved there once complete
+func runBackup(ctx context.Context, logger *slog.Logger, args []string) error {
+	ctx, path, store, closeStore, err := openBackupStore(ctx, logger, "backup", backupUsage, args)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	manifest, err := backup.Write(ctx, store, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to back up: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		logger.InfoContext(ctx, "backed up", "file", file.Name, "records", file.Records, "sha256", file.SHA256)
+	}
+	logger.InfoContext(ctx, "backup complete", "archive", path)
+	return nil
+}
+
+// runRestore is the restore subcommand, it loads an archive written by backup into an empty database, of one tenant
+// when TENANTS is set. The archive is checked against its manifest before anything is written
+func runRestore(ctx context.Context, logger *slog.Logger, args []string) error {
+	ctx, path, store, closeStore, err := openBackupStore(ctx, logger, "restore", restoreUsage, args)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	manifest, err := backup.Restore(ctx, store, f)
+	if err != nil {
+		return fmt.Errorf("failed to restore: %w", err)
+	}
+	for _, file := range manifest.Files {
+		logger.InfoContext(ctx, "restored", "file", file.Name, "records", file.Records)
+	}
+	logger.InfoContext(ctx, "restore complete", "archive", path, "created_at", manifest.CreatedAt)
+	return nil
+}
+
+// openBackupStore parses the arguments of backup and restore and opens the store they work on, it returns a ctx for
+// the tenant given with -tenant and the path of the archive. The memory backend has nothing to back up or restore
+// into as it lives only as long as the process
+func openBackupStore(ctx context.Context, logger *slog.Logger, name string, usage string, args []string) (context.Context, string, storage, func(), error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	tenantID := flags.String("tenant", "", "the tenant to "+name+", required when TENANTS is set")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return nil, "", nil, nil, errors.New(usage)
+	}
+	if os.Getenv("STORAGE_BACKEND") == "memory" {
+		return nil, "", nil, nil, fmt.Errorf("%s needs a persistent STORAGE_BACKEND", name)
+	}
+
+	tenants, err := setupTenants()
+	if err != nil {
+		return nil, "", nil, nil, fmt.Errorf("failed to set up tenants: %w", err)
+	}
+	switch {
+	case tenants == nil && *tenantID != "":
+		return nil, "", nil, nil, errors.New("-tenant is given without TENANTS")
+	case tenants != nil && !slices.Contains(tenants.Tenants(), *tenantID):
+		return nil, "", nil, nil, fmt.Errorf("-tenant must be one of TENANTS, got %q", *tenantID)
+	case tenants != nil:
+		ctx = tenant.WithID(ctx, *tenantID)
+	}
+	store, closeStore, err := setupStorage(ctx, logger, tenants)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	return ctx, flags.Arg(0), store, closeStore, nil
+}
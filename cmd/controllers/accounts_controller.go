@@ -10,6 +10,7 @@ import (
 	"github.com/joolshouston/pismo-technical-test/cmd/services"
 	"github.com/joolshouston/pismo-technical-test/shared/json_handler"
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/validation"
 )
 
 type AccountsController struct {
@@ -30,6 +31,7 @@ func NewAccountsController(service *services.AccountsService, logger *slog.Logge
 //	@Success		201		{object}	model.AccountResponseBody
 //	@Failure		400		{object}	model.ErrorResponse
 //	@Failure		500		{object}	model.ErrorResponse
+//	@Failure		503		{object}	model.ErrorResponse
 //	@Failure		409		{object}	model.ErrorResponse
 //	@Failure		404		{object}	model.ErrorResponse
 //	@Accept			json
@@ -44,11 +46,8 @@ func (c *AccountsController) CreateAccount(w http.ResponseWriter, r *http.Reques
 		})
 		return
 	}
-	if account.DocumentNumber == "" {
-		json_handler.WriteError(w, &model.ErrorResponse{
-			Status:  http.StatusBadRequest,
-			Message: "document_number is required",
-		})
+	if errResp := validation.Validate(account); errResp != nil {
+		json_handler.WriteError(w, errResp)
 		return
 	}
 
@@ -66,10 +65,11 @@ func (c *AccountsController) CreateAccount(w http.ResponseWriter, r *http.Reques
 //	@Summary		Get a specific account by ID
 //	@Description	get account by ID
 //	@Tags			accounts
-//	@Param			id	path		string	true	"Account ID"
+//	@Param			id	path		string	true	"Account ID (acc_...), an internal ID is answered with a Deprecation header"
 //	@Success		200	{object}	model.AccountResponseBody
 //	@Failure		400	{object}	model.ErrorResponse
 //	@Failure		500	{object}	model.ErrorResponse
+//	@Failure		503	{object}	model.ErrorResponse
 //	@Failure		409	{object}	model.ErrorResponse
 //	@Failure		404	{object}	model.ErrorResponse
 //	@Accept			json
@@ -93,3 +93,40 @@ func (c *AccountsController) GetAccount(w http.ResponseWriter, r *http.Request)
 	}
 	json_handler.WriteJSON(w, http.StatusOK, account)
 }
+
+// GetAccountBalance 	 godoc
+//
+//	@Summary		Get the balance of an account
+//	@Description	what the account owes and the credit it holds, read from the account's balance projection
+//	@Description	pass as_of for what was owed at a past time, including how much of every debt had been paid off by then
+//	@Tags			accounts
+//	@Param			id		path		string	true	"Account ID (acc_...), an internal ID is answered with a Deprecation header"
+//	@Param			as_of	query		string	false	"Point in time (RFC3339)"
+//	@Success		200		{object}	model.AccountBalanceResponseBody
+//	@Failure		400		{object}	model.ErrorResponse
+//	@Failure		404		{object}	model.ErrorResponse
+//	@Failure		500		{object}	model.ErrorResponse
+//	@Failure		503		{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/accounts/{id}/balance [get]
+func (c *AccountsController) GetAccountBalance(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	if id == "" {
+		json_handler.WriteError(w, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "account ID is required",
+		})
+		return
+	}
+	asOf, errResp := parseTimeParam(r, "as_of")
+	if errResp != nil {
+		json_handler.WriteError(w, errResp)
+		return
+	}
+	balance, err := c.service.GetAccountBalance(r.Context(), id, asOf)
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusOK, balance)
+}
@@ -14,17 +14,17 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/joolshouston/pismo-technical-test/cmd/services"
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 type MockMongoRepo struct{}
 
 func (m *MockMongoRepo) FindAllTransactionsForAccountID(ctx context.Context, accountID string) ([]model.Transaction, error) {
-	//TODO implement me
-	panic("implement me")
+	return nil, nil
 }
 
-func (m *MockMongoRepo) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction) error {
+func (m *MockMongoRepo) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction, expectedVersion int64) error {
 	//TODO implement me
 	panic("implement me")
 }
@@ -38,16 +38,23 @@ func (m *MockMongoRepo) CreateAccount(ctx context.Context, documentID string) (*
 	default:
 		return &model.Account{
 			ID:             bson.NewObjectID(),
+			PublicID:       publicid.NewAccountID(),
 			DocumentNumber: documentID,
 		}, nil
 	}
 }
 
+// streamFailID is the internal ID of the account whose transactions cannot be streamed
+var streamFailID = bson.NewObjectID()
+
 func (m *MockMongoRepo) GetAccountByID(ctx context.Context, accountID string) (*model.Account, error) {
 	switch accountID {
+	case "stream_fail":
+		return &model.Account{ID: streamFailID, PublicID: accountID, DocumentNumber: "1"}, nil
 	case "valid_id":
 		return &model.Account{
 			ID:             bson.NewObjectID(),
+			PublicID:       accountID,
 			DocumentNumber: "123456789",
 		}, nil
 	case "invalid_id":
@@ -57,6 +64,7 @@ func (m *MockMongoRepo) GetAccountByID(ctx context.Context, accountID string) (*
 	default:
 		return &model.Account{
 			ID:             bson.NewObjectID(),
+			PublicID:       accountID,
 			DocumentNumber: "1",
 		}, nil
 	}
@@ -121,6 +129,23 @@ func Test_CreatAccount(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:           "Invalid account - document number with invalid characters",
+			requestBody:    `{"document_number":"123 456"}`,
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var errResp model.ErrorResponse
+				if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+					t.Fatalf("failed to decode response body: %v", err)
+				}
+				if len(errResp.Errors) != 1 || errResp.Errors[0].Field != "document_number" || errResp.Errors[0].Code != "format" {
+					t.Errorf("expected a format error for document_number, got %+v", errResp.Errors)
+				}
+			},
+		},
 		{
 			name:           "Invalid request body",
 			requestBody:    `{document_number":"invalid_json"}`,
@@ -247,3 +272,98 @@ func Test_GetAccount(t *testing.T) {
 		})
 	}
 }
+
+func Test_GetAccountBalance(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	accountService := services.NewAccountsService(repo, logger)
+	accountsController := NewAccountsController(accountService, logger)
+
+	tests := []struct {
+		name           string
+		accountID      string
+		query          string
+		expectedStatus int
+		validate       func(t *testing.T, resp *http.Response, expectedStatus int)
+	}{
+		{
+			name:           "balance from the projection",
+			accountID:      "valid_id",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Fatalf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var balance model.AccountBalanceResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&balance); err != nil {
+					t.Fatalf("failed to decode response body: %v", err)
+				}
+				if balance.AccountID != "valid_id" || balance.TotalDebt != 50 || balance.OpenDebts != 1 || balance.Version != 3 {
+					t.Errorf("unexpected balance %+v", balance)
+				}
+			},
+		},
+		{
+			name:           "balance as of a past time",
+			accountID:      "valid_id",
+			query:          "as_of=2025-01-15T00:00:00Z",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Fatalf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var balance model.AccountBalanceResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&balance); err != nil {
+					t.Fatalf("failed to decode response body: %v", err)
+				}
+				if balance.AsOf == nil || balance.TotalDebt != 50 || len(balance.Debts) != 1 || balance.Debts[0].TransactionID != "tx-1" {
+					t.Errorf("unexpected balance %+v", balance)
+				}
+			},
+		},
+		{
+			name:           "invalid as_of",
+			accountID:      "valid_id",
+			query:          "as_of=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Fatalf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "account not found",
+			accountID:      "account_nonexistent",
+			expectedStatus: http.StatusNotFound,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Fatalf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "missing account id in path",
+			accountID:      "",
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Fatalf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/accounts/"+tt.accountID+"/balance?"+tt.query, nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.accountID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			w := httptest.NewRecorder()
+			accountsController.GetAccountBalance(w, req)
+			tt.validate(t, w.Result(), tt.expectedStatus)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/json_handler"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+type AuditController struct {
+	service *services.AuditService
+	logger  *slog.Logger
+}
+
+func NewAuditController(service *services.AuditService, logger *slog.Logger) *AuditController {
+	return &AuditController{service: service, logger: logger}
+}
+
+// GetAccountAudit 	 godoc
+//
+//	@Summary		Get the audit trail of an account
+//	@Description	every recorded change to the account oldest first, with the actor, request ID and before and after snapshots
+//	@Tags			audit
+//	@Param			id	path		string	true	"Account ID (acc_...), an internal ID is answered with a Deprecation header"
+//	@Success		200	{array}		model.AuditRecordResponseBody
+//	@Failure		400	{object}	model.ErrorResponse
+//	@Failure		404	{object}	model.ErrorResponse
+//	@Failure		500	{object}	model.ErrorResponse
+//	@Failure		503	{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/accounts/{id}/audit [get]
+func (c *AuditController) GetAccountAudit(w http.ResponseWriter, r *http.Request) {
+	c.getAuditTrail(w, r, model.AuditEntityAccount)
+}
+
+// GetTransactionAudit 	 godoc
+//
+//	@Summary		Get the audit trail of a transaction
+//	@Description	every recorded change to the transaction oldest first, including the balance rewrites made when a payment discharges it
+//	@Tags			audit
+//	@Param			id	path		string	true	"Transaction ID (txn_...), an internal ID is answered with a Deprecation header"
+//	@Success		200	{array}		model.AuditRecordResponseBody
+//	@Failure		400	{object}	model.ErrorResponse
+//	@Failure		404	{object}	model.ErrorResponse
+//	@Failure		500	{object}	model.ErrorResponse
+//	@Failure		503	{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/transactions/{id}/audit [get]
+func (c *AuditController) GetTransactionAudit(w http.ResponseWriter, r *http.Request) {
+	c.getAuditTrail(w, r, model.AuditEntityTransaction)
+}
+
+func (c *AuditController) getAuditTrail(w http.ResponseWriter, r *http.Request, entityType model.AuditEntityType) {
+	records, err := c.service.GetAuditTrail(r.Context(), entityType, strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusOK, records)
+}
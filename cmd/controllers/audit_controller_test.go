@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// auditFailID is the internal ID of the transaction whose audit trail cannot be read
+var auditFailID = bson.NewObjectID()
+
+type MockAuditRepo struct{}
+
+func (m *MockAuditRepo) AppendAuditRecord(ctx context.Context, record model.AuditRecord) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockAuditRepo) FindAuditRecords(ctx context.Context, entityType model.AuditEntityType, entityID string, limit int) ([]model.AuditRecord, error) {
+	if entityID == auditFailID.Hex() {
+		return nil, errors.New("database error")
+	}
+	return []model.AuditRecord{{ID: bson.NewObjectID(), Actor: "backoffice", Action: model.AuditActionCreate, EntityType: entityType, EntityID: entityID}}, nil
+}
+
+func Test_GetAudit(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := services.NewAuditService(&MockAuditRepo{}, &MockMongoRepo{}, logger)
+	auditController := NewAuditController(service, logger)
+
+	tests := []struct {
+		name           string
+		id             string
+		handler        http.HandlerFunc
+		expectedStatus int
+		validate       func(t *testing.T, resp *http.Response, expectedStatus int)
+	}{
+		{
+			name:           "Account audit trail",
+			id:             publicid.NewAccountID(),
+			handler:        auditController.GetAccountAudit,
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var records []model.AuditRecordResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if len(records) != 1 || records[0].EntityType != model.AuditEntityAccount || records[0].Actor != "backoffice" {
+					t.Errorf("unexpected audit trail %+v", records)
+				}
+			},
+		},
+		{
+			name:           "Transaction audit trail fails",
+			id:             auditFailID.Hex(),
+			handler:        auditController.GetTransactionAudit,
+			expectedStatus: http.StatusInternalServerError,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "Transaction not found",
+			id:             publicid.NewTransactionID(),
+			handler:        auditController.GetTransactionAudit,
+			expectedStatus: http.StatusNotFound,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/audit", nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.id)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			resp := httptest.NewRecorder()
+			tt.handler(resp, req)
+			tt.validate(t, resp.Result(), tt.expectedStatus)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/export"
+	"github.com/joolshouston/pismo-technical-test/shared/json_handler"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+// maxImportUploadBytes is the largest file accepted by an import, comfortably above a few hundred thousand rows
+const maxImportUploadBytes = 256 << 20
+
+type JobsController struct {
+	service *services.ImportService
+	logger  *slog.Logger
+}
+
+func NewJobsController(service *services.ImportService, logger *slog.Logger) *JobsController {
+	return &JobsController{service: service, logger: logger}
+}
+
+// ImportAccounts 	 godoc
+//
+//	@Summary		Import accounts in bulk
+//	@Description	upload a CSV (with a document_number header column) or NDJSON file (a document_number field per line) of accounts to create asynchronously, other columns and fields are dropped and listed in the job's ignored_columns
+//	@Description	the file can be sent as the raw request body or as the file field of a multipart form
+//	@Description	the format is taken from the format query parameter, then the file extension, then the Content-Type
+//	@Tags			jobs
+//	@Param			format	query		string	false	"Upload format"	Enums(csv, ndjson)
+//	@Success		202		{object}	model.JobResponseBody
+//	@Failure		400		{object}	model.ErrorResponse
+//	@Failure		413		{object}	model.ErrorResponse
+//	@Failure		415		{object}	model.ErrorResponse
+//	@Failure		500		{object}	model.ErrorResponse
+//	@Accept			text/csv
+//	@Accept			application/x-ndjson
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Router			/accounts:import [post]
+func (c *JobsController) ImportAccounts(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadBytes)
+
+	var upload io.Reader = r.Body
+	contentType := r.Header.Get("Content-Type")
+	filename := ""
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			json_handler.WriteError(w, &model.ErrorResponse{
+				Status:  http.StatusBadRequest,
+				Message: "multipart upload must contain a file field",
+			})
+			return
+		}
+		defer file.Close()
+		upload = file
+		filename = header.Filename
+		contentType = header.Header.Get("Content-Type")
+	}
+
+	format, err := importFormat(r.URL.Query().Get("format"), filename, contentType)
+	if err != nil {
+		json_handler.WriteError(w, &model.ErrorResponse{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: "import format must be one of csv or ndjson",
+		})
+		return
+	}
+
+	job, errResp := c.service.StartAccountImport(r.Context(), format, upload)
+	if errResp != nil {
+		json_handler.WriteError(w, errResp)
+		return
+	}
+	w.Header().Set("Location", "/v1/jobs/"+job.JobID)
+	json_handler.WriteJSON(w, http.StatusAccepted, job)
+}
+
+// GetJob 	 godoc
+//
+//	@Summary		Get a job
+//	@Description	get the status and progress of an asynchronous job
+//	@Tags			jobs
+//	@Param			id	path		string	true	"Job ID"
+//	@Success		200	{object}	model.JobResponseBody
+//	@Failure		404	{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/jobs/{id} [get]
+func (c *JobsController) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := c.service.GetJob(r.Context(), strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusOK, job)
+}
+
+// GetJobResult 	 godoc
+//
+//	@Summary		Download a job result
+//	@Description	download the NDJSON result file of a finished job, each line is a model.AccountImportRowResult for account imports
+//	@Tags			jobs
+//	@Param			id	path		string	true	"Job ID"
+//	@Success		200	{file}		file
+//	@Failure		404	{object}	model.ErrorResponse
+//	@Failure		409	{object}	model.ErrorResponse
+//	@Failure		500	{object}	model.ErrorResponse
+//	@Produce		application/x-ndjson
+//	@Router			/jobs/{id}/result [get]
+func (c *JobsController) GetJobResult(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	result, err := c.service.OpenJobResult(r.Context(), id)
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	defer result.Close()
+
+	w.Header().Set("Content-Type", export.FormatNDJSON.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.ndjson"`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, result); err != nil {
+		c.logger.ErrorContext(r.Context(), "failed to write job result", "jobID", id, "error", err)
+	}
+}
+
+// importFormat works out the format of an upload, the Content-Type goes through the same matching as an Accept header
+func importFormat(queryFormat string, filename string, contentType string) (export.Format, error) {
+	if queryFormat == "" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".csv":
+			return export.FormatCSV, nil
+		case ".ndjson", ".jsonl":
+			return export.FormatNDJSON, nil
+		}
+	}
+	return export.NegotiateFormat(queryFormat, contentType)
+}
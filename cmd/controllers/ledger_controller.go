@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/json_handler"
+)
+
+type LedgerController struct {
+	service *services.LedgerService
+	logger  *slog.Logger
+}
+
+func NewLedgerController(service *services.LedgerService, logger *slog.Logger) *LedgerController {
+	return &LedgerController{service: service, logger: logger}
+}
+
+// GetTrialBalance 	 godoc
+//
+//	@Summary		Get the trial balance
+//	@Description	debits and credits posted to every ledger account in cents, total debits always equal total credits
+//	@Tags			ledger
+//	@Success		200	{object}	model.TrialBalanceResponseBody
+//	@Failure		500	{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/ledger/trial-balance [get]
+func (c *LedgerController) GetTrialBalance(w http.ResponseWriter, r *http.Request) {
+	trialBalance, err := c.service.TrialBalance(r.Context())
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusOK, trialBalance)
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+func Test_GetTrialBalance(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ledgerController := NewLedgerController(services.NewLedgerService(&MockMongoRepo{}, logger), logger)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/ledger/trial-balance", nil)
+	w := httptest.NewRecorder()
+	ledgerController.GetTrialBalance(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp model.TrialBalanceResponseBody
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("expected no error decoding response, got %v", err)
+	}
+	if !resp.Balanced || resp.TotalDebitCents != resp.TotalCreditCents {
+		t.Errorf("expected a balanced trial balance, got %+v", resp)
+	}
+	if resp.Accounts[0].LedgerAccount != model.LedgerAccountReceivable || resp.Accounts[0].BalanceCents != 12350 {
+		t.Errorf("unexpected receivable %+v", resp.Accounts[0])
+	}
+}
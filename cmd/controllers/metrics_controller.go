@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/joolshouston/pismo-technical-test/shared/cache"
+	"github.com/joolshouston/pismo-technical-test/shared/json_handler"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+type MetricsController struct {
+	// accountCache returns the counters of the account cache, nil when it is disabled
+	accountCache func() cache.Stats
+	logger       *slog.Logger
+}
+
+func NewMetricsController(accountCache func() cache.Stats, logger *slog.Logger) *MetricsController {
+	return &MetricsController{accountCache: accountCache, logger: logger}
+}
+
+// GetAccountCacheStats 	 godoc
+//
+//	@Summary		Get the account cache counters
+//	@Description	hits, misses and evictions of the account cache, 404 when it is disabled
+//	@Tags			metrics
+//	@Success		200	{object}	model.AccountCacheStatsResponseBody
+//	@Failure		404	{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/metrics/account-cache [get]
+func (c *MetricsController) GetAccountCacheStats(w http.ResponseWriter, r *http.Request) {
+	if c.accountCache == nil {
+		json_handler.WriteError(w, &model.ErrorResponse{
+			Status:  http.StatusNotFound,
+			Message: "the account cache is disabled",
+		})
+		return
+	}
+	stats := c.accountCache()
+	json_handler.WriteJSON(w, http.StatusOK, model.AccountCacheStatsResponseBody{
+		Hits:      stats.Hits,
+		Misses:    stats.Misses,
+		Evictions: stats.Evictions,
+		Entries:   stats.Entries,
+	})
+}
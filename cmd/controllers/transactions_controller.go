@@ -2,14 +2,28 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/export"
 	"github.com/joolshouston/pismo-technical-test/shared/json_handler"
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/statement"
+	"github.com/joolshouston/pismo-technical-test/shared/validation"
 )
 
+// exportFlushInterval is how many records are written before the response is flushed to the client
+const exportFlushInterval = 100
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
 type TransactionsController struct {
 	service *services.TransactionService
 	logger  *slog.Logger
@@ -29,6 +43,7 @@ func NewTransactionsController(service *services.TransactionService, logger *slo
 //	@Success		201					{object}	model.TransactionResponseBody
 //	@Failure		400					{object}	model.ErrorResponse
 //	@Failure		500					{object}	model.ErrorResponse
+//	@Failure		503					{object}	model.ErrorResponse
 //	@Failure		409					{object}	model.ErrorResponse
 //	@Failure		404					{object}	model.ErrorResponse
 //	@Accept			json
@@ -52,19 +67,303 @@ func (c *TransactionsController) CreateTransaction(w http.ResponseWriter, r *htt
 		})
 		return
 	}
-	// move to a better validator for the request bodies, we should take a set required attributes and validate, for now this will work
-	if req.AccountID == "" || req.OperationID == 0 || req.Amount == 0 {
+	if errResp := validation.Validate(req); errResp != nil {
+		json_handler.WriteError(w, errResp)
+		return
+	}
+
+	transaction, err := c.service.CreateTransaction(r.Context(), req, xIdempotencyKey)
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusCreated, transaction)
+}
+
+// PostTransactionsBatch 	 godoc
+//
+//	@Summary		Post a batch of transactions
+//	@Description	create up to 1000 transactions in one request, each item carries its own idempotency key
+//	@Description	atomic mode commits all items or none, best_effort mode commits every item that is valid
+//	@Description	201 when nothing was rejected, 207 when a best_effort batch had rejections and 422 when an atomic batch was rolled back
+//	@Tags			transactions
+//	@Param			batch	body		model.TransactionBatchRequestBody	true	"Transaction batch request body"
+//	@Success		201		{object}	model.TransactionBatchResponseBody
+//	@Success		207		{object}	model.TransactionBatchResponseBody
+//	@Failure		400		{object}	model.ErrorResponse
+//	@Failure		409		{object}	model.ErrorResponse
+//	@Failure		422		{object}	model.TransactionBatchResponseBody
+//	@Failure		500		{object}	model.ErrorResponse
+//	@Failure		503		{object}	model.ErrorResponse
+//	@Accept			json
+//	@Produce		json
+//	@Router			/transactions:batch [post]
+func (c *TransactionsController) CreateTransactionsBatch(w http.ResponseWriter, r *http.Request) {
+	var req model.TransactionBatchRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		json_handler.WriteError(w, &model.ErrorResponse{
 			Status:  http.StatusBadRequest,
-			Message: "one or more of account_id, operation_type_id or amount is missing",
+			Message: "invalid request body",
 		})
 		return
 	}
 
-	transaction, err := c.service.CreateTransaction(r.Context(), req, xIdempotencyKey)
+	batch, err := c.service.CreateTransactionsBatch(r.Context(), req)
 	if err != nil {
 		json_handler.WriteError(w, err)
 		return
 	}
-	json_handler.WriteJSON(w, http.StatusCreated, transaction)
+	status := http.StatusCreated
+	if batch.Rejected > 0 {
+		status = http.StatusMultiStatus
+		if batch.Mode == model.BatchModeAtomic {
+			status = http.StatusUnprocessableEntity
+		}
+	}
+	json_handler.WriteJSON(w, status, batch)
+}
+
+// ExportTransactions 	 godoc
+//
+//	@Summary		Export transactions
+//	@Description	stream an account's transactions, or any filtered set, as CSV or NDJSON
+//	@Description	the format is taken from the format query parameter first and the Accept header second
+//	@Tags			transactions
+//	@Param			account_id			query		string	false	"Account ID"
+//	@Param			operation_type_id	query		int		false	"Operation type ID"
+//	@Param			from				query		string	false	"Earliest event date (RFC3339)"
+//	@Param			to					query		string	false	"Latest event date (RFC3339)"
+//	@Param			format				query		string	false	"Export format"	Enums(csv, ndjson)
+//	@Success		200					{file}		file
+//	@Failure		400					{object}	model.ErrorResponse
+//	@Failure		404					{object}	model.ErrorResponse
+//	@Failure		406					{object}	model.ErrorResponse
+//	@Failure		500					{object}	model.ErrorResponse
+//	@Failure		503					{object}	model.ErrorResponse
+//	@Produce		text/csv
+//	@Produce		application/x-ndjson
+//	@Router			/transactions/export [get]
+func (c *TransactionsController) ExportTransactions(w http.ResponseWriter, r *http.Request) {
+	format, err := export.NegotiateFormat(r.URL.Query().Get("format"), r.Header.Get("Accept"))
+	if err != nil {
+		json_handler.WriteError(w, &model.ErrorResponse{
+			Status:  http.StatusNotAcceptable,
+			Message: "export format must be one of csv or ndjson",
+		})
+		return
+	}
+	filter, errResp := parseTransactionFilter(r)
+	if errResp != nil {
+		json_handler.WriteError(w, errResp)
+		return
+	}
+
+	// nothing is written until the first record arrives, that way a failure before the stream starts still gets a
+	// proper error response instead of an empty file with a 200
+	var encoder export.Encoder
+	started := false
+	written := 0
+	controller := http.NewResponseController(w)
+	start := func() error {
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="transactions.%s"`, format))
+		w.WriteHeader(http.StatusOK)
+		encoder, err = export.NewEncoder(format, w)
+		started = true
+		return err
+	}
+
+	errResp = c.service.ExportTransactions(r.Context(), filter, func(tx model.Transaction) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(tx); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushInterval == 0 {
+			if err := encoder.Flush(); err != nil {
+				return err
+			}
+			// not every writer supports flushing, the data still goes out once the buffer fills
+			_ = controller.Flush()
+		}
+		return nil
+	})
+	if errResp != nil {
+		if !started {
+			json_handler.WriteError(w, errResp)
+			return
+		}
+		// the status line has already gone out, aborting the connection is the only way to tell the client the
+		// file is truncated rather than letting it look like a complete export
+		c.logger.ErrorContext(r.Context(), "export failed after streaming started", "written", written, "message", errResp.Message)
+		panic(http.ErrAbortHandler)
+	}
+	if !started {
+		if err := start(); err != nil {
+			c.logger.ErrorContext(r.Context(), "failed to start export", "error", err)
+			return
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		c.logger.ErrorContext(r.Context(), "failed to flush export", "error", err)
+	}
+}
+
+// GetStatement 	 godoc
+//
+//	@Summary		Get an account statement
+//	@Description	get the account's transactions between from and to as an ISO 20022 camt.053 or OFX statement
+//	@Description	operation types are reported with ISO bank transaction codes and the debit/credit indicator follows the amount sign
+//	@Tags			accounts
+//	@Param			id			path		string	true	"Account ID (acc_...), an internal ID is answered with a Deprecation header"
+//	@Param			from		query		string	true	"Start of the statement period (RFC3339)"
+//	@Param			to			query		string	true	"End of the statement period (RFC3339)"
+//	@Param			format		query		string	false	"Statement format, camt053 when not set"	Enums(camt053, ofx)
+//	@Param			currency	query		string	false	"ISO 4217 currency code, BRL when not set"
+//	@Success		200			{file}		file
+//	@Failure		400			{object}	model.ErrorResponse
+//	@Failure		404			{object}	model.ErrorResponse
+//	@Failure		500			{object}	model.ErrorResponse
+//	@Failure		503			{object}	model.ErrorResponse
+//	@Produce		application/xml
+//	@Produce		application/x-ofx
+//	@Router			/accounts/{id}/statement [get]
+func (c *TransactionsController) GetStatement(w http.ResponseWriter, r *http.Request) {
+	accountID := strings.TrimSpace(chi.URLParam(r, "id"))
+	if accountID == "" {
+		json_handler.WriteError(w, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "account ID is required",
+		})
+		return
+	}
+	format := statement.FormatCAMT053
+	if raw := r.URL.Query().Get("format"); raw != "" {
+		var err error
+		if format, err = statement.ParseFormat(raw); err != nil {
+			json_handler.WriteError(w, &model.ErrorResponse{
+				Status:  http.StatusBadRequest,
+				Message: "format must be one of camt053 or ofx",
+			})
+			return
+		}
+	}
+	currency := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("currency")))
+	if currency == "" {
+		currency = statement.DefaultCurrency
+	}
+	if !currencyPattern.MatchString(currency) {
+		json_handler.WriteError(w, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "currency must be a three letter ISO 4217 code",
+		})
+		return
+	}
+	from, errResp := parseTimeParam(r, "from")
+	if errResp != nil {
+		json_handler.WriteError(w, errResp)
+		return
+	}
+	to, errResp := parseTimeParam(r, "to")
+	if errResp != nil {
+		json_handler.WriteError(w, errResp)
+		return
+	}
+
+	st, errResp := c.service.BuildStatement(r.Context(), accountID, from, to, currency)
+	if errResp != nil {
+		json_handler.WriteError(w, errResp)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%s.%s"`, st.ID, format.FileExtension()))
+	w.WriteHeader(http.StatusOK)
+	if err := statement.Encode(w, format, *st); err != nil {
+		c.logger.ErrorContext(r.Context(), "failed to write statement", "error", err)
+	}
+}
+
+func parseTransactionFilter(r *http.Request) (model.TransactionFilter, *model.ErrorResponse) {
+	query := r.URL.Query()
+	filter := model.TransactionFilter{
+		AccountID: strings.TrimSpace(query.Get("account_id")),
+	}
+	if raw := query.Get("operation_type_id"); raw != "" {
+		operationID, err := strconv.Atoi(raw)
+		if err != nil {
+			return filter, &model.ErrorResponse{
+				Status:  http.StatusBadRequest,
+				Message: "operation_type_id must be an integer",
+			}
+		}
+		filter.OperationID = model.OperationType(operationID)
+	}
+	var errResp *model.ErrorResponse
+	if filter.From, errResp = parseTimeParam(r, "from"); errResp != nil {
+		return filter, errResp
+	}
+	if filter.To, errResp = parseTimeParam(r, "to"); errResp != nil {
+		return filter, errResp
+	}
+	return filter, nil
+}
+
+// parseTimeParam reads an optional RFC3339 query parameter, the zero time is returned when it is not set
+func parseTimeParam(r *http.Request, name string) (time.Time, *model.ErrorResponse) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("%s must be an RFC3339 timestamp", name),
+		}
+	}
+	return parsed, nil
+}
+
+// Reconcile 	 godoc
+//
+//	@Summary		Reconcile transaction balances
+//	@Description	replay the transactions of an account, or of every account when account_id is left out, in event_date order
+//	@Description	and report every transaction whose stored balance differs from the replayed one, set repair to write the replayed balances back
+//	@Description	207 when the repair of some accounts failed, they are reported with their error
+//	@Tags			transactions
+//	@Param			reconciliation	body		model.ReconciliationRequestBody	true	"Reconciliation request body"
+//	@Success		200				{object}	model.ReconciliationResponseBody
+//	@Success		207				{object}	model.ReconciliationResponseBody
+//	@Failure		400				{object}	model.ErrorResponse
+//	@Failure		404				{object}	model.ErrorResponse
+//	@Failure		409				{object}	model.ErrorResponse
+//	@Failure		500				{object}	model.ErrorResponse
+//	@Failure		503				{object}	model.ErrorResponse
+//	@Accept			json
+//	@Produce		json
+//	@Router			/reconciliations [post]
+func (c *TransactionsController) Reconcile(w http.ResponseWriter, r *http.Request) {
+	var req model.ReconciliationRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		json_handler.WriteError(w, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "invalid request body",
+		})
+		return
+	}
+	req.AccountID = strings.TrimSpace(req.AccountID)
+	reconciliation, err := c.service.Reconcile(r.Context(), req)
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if reconciliation.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	json_handler.WriteJSON(w, status, reconciliation)
 }
@@ -3,16 +3,25 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/database"
+	"github.com/joolshouston/pismo-technical-test/shared/lock"
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
@@ -21,6 +30,7 @@ func (m *MockMongoRepo) CreateTransaction(ctx context.Context, transaction model
 	case -123.5:
 		return &model.Transaction{
 			ID:          bson.NewObjectID(),
+			PublicID:    publicid.NewTransactionID(),
 			AccountID:   transaction.AccountID,
 			OperationID: transaction.OperationID,
 			Amount:      transaction.Amount,
@@ -30,17 +40,27 @@ func (m *MockMongoRepo) CreateTransaction(ctx context.Context, transaction model
 	default:
 		return &model.Transaction{
 			ID:          bson.NewObjectID(),
+			PublicID:    publicid.NewTransactionID(),
 			AccountID:   transaction.AccountID,
 			OperationID: transaction.OperationID,
 		}, nil
 	}
 }
 
+func (m *MockMongoRepo) GetTransactionByID(ctx context.Context, transactionID string) (*model.Transaction, error) {
+	id, err := bson.ObjectIDFromHex(transactionID)
+	if err != nil {
+		return nil, nil
+	}
+	return &model.Transaction{ID: id, AccountID: "valid_id"}, nil
+}
+
 func (m *MockMongoRepo) FindTransactionByIdempotencyKey(ctx context.Context, idempotencyKey string) (*model.Transaction, error) {
 	switch idempotencyKey {
 	case "x-idempotency-key-duplicate":
 		return &model.Transaction{
 			ID:          bson.NewObjectID(),
+			PublicID:    publicid.NewTransactionID(),
 			AccountID:   "valid_id",
 			OperationID: 1,
 			Amount:      -123.5,
@@ -52,10 +72,73 @@ func (m *MockMongoRepo) FindTransactionByIdempotencyKey(ctx context.Context, ide
 	}
 }
 
+func (m *MockMongoRepo) StreamTransactions(ctx context.Context, filter model.TransactionFilter, fn func(model.Transaction) error) error {
+	if filter.AccountID == streamFailID.Hex() {
+		return errors.New("cursor failed")
+	}
+	return fn(model.Transaction{
+		ID:          bson.NewObjectID(),
+		AccountID:   filter.AccountID,
+		OperationID: model.OperationTypePurchase,
+		Amount:      -50,
+		Balance:     -50,
+		EventDate:   time.Date(2025, 1, 31, 10, 0, 0, 0, time.UTC),
+	})
+}
+
+func (m *MockMongoRepo) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (m *MockMongoRepo) AppendOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	return nil
+}
+
+func (m *MockMongoRepo) FindUnpublishedOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockMongoRepo) MarkOutboxEventPublished(ctx context.Context, eventID string, publishedAt time.Time) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockMongoRepo) PostJournalEntry(ctx context.Context, entry model.JournalEntry) error {
+	return nil
+}
+
+func (m *MockMongoRepo) FindJournalEntries(ctx context.Context, accountID string, postedBy time.Time) ([]model.JournalEntry, error) {
+	return []model.JournalEntry{{
+		TransactionID: "tx-1",
+		AccountID:     accountID,
+		PostedAt:      time.Date(2025, 1, 10, 10, 0, 0, 0, time.UTC),
+		Lines: []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 5000, AppliesTo: "tx-1"},
+			{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 5000},
+		},
+	}}, nil
+}
+
+func (m *MockMongoRepo) TrialBalance(ctx context.Context) ([]model.TrialBalanceLine, error) {
+	return []model.TrialBalanceLine{
+		{LedgerAccount: model.LedgerAccountReceivable, DebitCents: 12350},
+		{LedgerAccount: model.LedgerAccountMerchantPayable, CreditCents: 12350},
+	}, nil
+}
+
+func (m *MockMongoRepo) GetAccountBalance(ctx context.Context, accountID string) (*model.AccountBalance, error) {
+	return &model.AccountBalance{AccountID: accountID, TotalDebtCents: 5000, Version: 3, OpenDebts: []model.OpenDebt{{TransactionID: bson.NewObjectID().Hex(), BalanceCents: -5000}}}, nil
+}
+
+func (m *MockMongoRepo) SaveAccountBalance(ctx context.Context, balance model.AccountBalance) error {
+	return nil
+}
+
 func Test_CreateTransaction(t *testing.T) {
 	repo := &MockMongoRepo{}
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	service := services.NewTransactionService(repo, logger)
+	service := services.NewTransactionService(repo, logger, lock.NewLease(database.NewMemory(), logger, lock.DefaultConfig()))
 	transactionController := NewTransactionsController(service, logger)
 
 	tests := []struct {
@@ -131,8 +214,72 @@ func Test_CreateTransaction(t *testing.T) {
 				if err != nil {
 					t.Fatalf("expected no error decoding response, got %v", err)
 				}
-				if errResp.Message != "invalid operation type" {
-					t.Errorf("expected error message 'failed to create transaction: invalid operation type', got %s", errResp.Message)
+				expected := []model.FieldError{{Field: "operation_type_id", Code: "oneof", Message: "operation_type_id must be one of 1, 2, 3, 4"}}
+				if !reflect.DeepEqual(errResp.Errors, expected) {
+					t.Errorf("expected errors %+v, got %+v", expected, errResp.Errors)
+				}
+			},
+		},
+		{
+			name:           "Every invalid field is reported",
+			transaction:    `{"operation_type_id":4,"amount":2000000000000}`,
+			idempotencyKey: "x-idempotency-key-unique",
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var errResp model.ErrorResponse
+				if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				expected := []model.FieldError{
+					{Field: "account_id", Code: "required", Message: "account_id is required"},
+					{Field: "amount", Code: "max", Message: "amount must be at most 1000000000000"},
+				}
+				if !reflect.DeepEqual(errResp.Errors, expected) {
+					t.Errorf("expected errors %+v, got %+v", expected, errResp.Errors)
+				}
+				if errResp.Message != "account_id is required, amount must be at most 1000000000000" {
+					t.Errorf("expected the messages of every error, got %s", errResp.Message)
+				}
+			},
+		},
+		{
+			name:           "Amount of -0.004 is not a whole number of cents",
+			transaction:    `{"account_id":"valid_id","operation_type_id":1,"amount":-0.004}`,
+			idempotencyKey: "x-idempotency-key-unique",
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var errResp model.ErrorResponse
+				if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				expected := []model.FieldError{{Field: "amount", Code: "cents", Message: "amount must be a whole number of cents"}}
+				if !reflect.DeepEqual(errResp.Errors, expected) {
+					t.Errorf("expected errors %+v, got %+v", expected, errResp.Errors)
+				}
+			},
+		},
+		{
+			name:           "Amount of 0.005 is not a whole number of cents",
+			transaction:    `{"account_id":"valid_id","operation_type_id":4,"amount":0.005}`,
+			idempotencyKey: "x-idempotency-key-unique",
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var errResp model.ErrorResponse
+				if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				expected := []model.FieldError{{Field: "amount", Code: "cents", Message: "amount must be a whole number of cents"}}
+				if !reflect.DeepEqual(errResp.Errors, expected) {
+					t.Errorf("expected errors %+v, got %+v", expected, errResp.Errors)
 				}
 			},
 		},
@@ -188,3 +335,263 @@ func Test_CreateTransaction(t *testing.T) {
 		})
 	}
 }
+
+func Test_ExportTransactions(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := services.NewTransactionService(repo, logger, lock.NewLease(database.NewMemory(), logger, lock.DefaultConfig()))
+	transactionController := NewTransactionsController(service, logger)
+
+	tests := []struct {
+		name           string
+		url            string
+		accept         string
+		expectedStatus int
+		validate       func(t *testing.T, resp *http.Response, expectedStatus int)
+	}{
+		{
+			name:           "CSV selected by query parameter",
+			url:            "/transactions/export?account_id=valid_id&format=csv",
+			accept:         "application/x-ndjson",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				if resp.Header.Get("Content-Type") != "text/csv" {
+					t.Errorf("expected Content-Type 'text/csv', got %s", resp.Header.Get("Content-Type"))
+				}
+				records, err := csv.NewReader(resp.Body).ReadAll()
+				if err != nil {
+					t.Fatalf("expected no error reading csv, got %v", err)
+				}
+				if len(records) != 2 {
+					t.Fatalf("expected header and one row, got %d rows", len(records))
+				}
+				if records[1][1] != "valid_id" || records[1][4] != "-50.00" {
+					t.Errorf("unexpected csv row %v", records[1])
+				}
+			},
+		},
+		{
+			name:           "NDJSON selected by Accept header",
+			url:            "/transactions/export?account_id=valid_id",
+			accept:         "application/x-ndjson",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var record model.TransactionExportRecord
+				if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+					t.Fatalf("expected no error decoding record, got %v", err)
+				}
+				if record.AccountID != "valid_id" || record.OperationType != "PURCHASE" {
+					t.Errorf("unexpected record %+v", record)
+				}
+			},
+		},
+		{
+			name:           "Unsupported format",
+			url:            "/transactions/export?account_id=valid_id&format=xlsx",
+			expectedStatus: http.StatusNotAcceptable,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "Invalid from date",
+			url:            "/transactions/export?account_id=valid_id&from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var errResp model.ErrorResponse
+				if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if errResp.Message != "from must be an RFC3339 timestamp" {
+					t.Errorf("expected error message 'from must be an RFC3339 timestamp', got %s", errResp.Message)
+				}
+			},
+		},
+		{
+			name:           "Stream fails before anything is written",
+			url:            "/transactions/export?account_id=stream_fail",
+			expectedStatus: http.StatusInternalServerError,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			transactionController.ExportTransactions(resp, req)
+			tt.validate(t, resp.Result(), tt.expectedStatus)
+		})
+	}
+}
+
+func Test_GetStatement(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := services.NewTransactionService(repo, logger, lock.NewLease(database.NewMemory(), logger, lock.DefaultConfig()))
+	transactionController := NewTransactionsController(service, logger)
+
+	tests := []struct {
+		name           string
+		accountID      string
+		query          string
+		expectedStatus int
+		validate       func(t *testing.T, resp *http.Response, expectedStatus int)
+	}{
+		{
+			name:           "camt.053 by default",
+			accountID:      "valid_id",
+			query:          "from=2025-01-01T00:00:00Z&to=2025-01-31T23:59:59Z",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				if resp.Header.Get("Content-Type") != "application/xml" {
+					t.Errorf("expected Content-Type 'application/xml', got %s", resp.Header.Get("Content-Type"))
+				}
+				body, _ := io.ReadAll(resp.Body)
+				if !strings.Contains(string(body), "camt.053.001.08") || !strings.Contains(string(body), "<CdtDbtInd>DBIT</CdtDbtInd>") {
+					t.Errorf("expected a camt.053 document with a debit entry, got %s", body)
+				}
+			},
+		},
+		{
+			name:           "OFX",
+			accountID:      "valid_id",
+			query:          "from=2025-01-01T00:00:00Z&to=2025-01-31T23:59:59Z&format=ofx&currency=usd",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				if !strings.Contains(string(body), "<TRNTYPE>POS</TRNTYPE>") || !strings.Contains(string(body), "<CURDEF>USD</CURDEF>") {
+					t.Errorf("expected an OFX statement in USD, got %s", body)
+				}
+			},
+		},
+		{
+			name:           "Unknown format",
+			accountID:      "valid_id",
+			query:          "from=2025-01-01T00:00:00Z&to=2025-01-31T23:59:59Z&format=mt940",
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "Invalid currency",
+			accountID:      "valid_id",
+			query:          "from=2025-01-01T00:00:00Z&to=2025-01-31T23:59:59Z&currency=reais",
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "Missing period",
+			accountID:      "valid_id",
+			query:          "",
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/accounts/"+tt.accountID+"/statement?"+tt.query, nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.accountID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			resp := httptest.NewRecorder()
+			transactionController.GetStatement(resp, req)
+			tt.validate(t, resp.Result(), tt.expectedStatus)
+		})
+	}
+}
+
+func Test_Reconcile(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := services.NewTransactionService(repo, logger, lock.NewLease(database.NewMemory(), logger, lock.DefaultConfig()))
+	transactionController := NewTransactionsController(service, logger)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		validate       func(t *testing.T, resp *http.Response, expectedStatus int)
+	}{
+		{
+			name:           "Account without drift",
+			body:           `{"account_id":" valid_id ","repair":true}`,
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var reconciliation model.ReconciliationResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&reconciliation); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if reconciliation.TransactionsChecked != 1 || reconciliation.TransactionsDrifted != 0 || reconciliation.Accounts == nil {
+					t.Errorf("unexpected reconciliation %+v", reconciliation)
+				}
+			},
+		},
+		{
+			name:           "Account not found",
+			body:           `{"account_id":"account_nonexistent"}`,
+			expectedStatus: http.StatusNotFound,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "Invalid body",
+			body:           `{"repair":"yes"}`,
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/reconciliations", strings.NewReader(tt.body))
+			resp := httptest.NewRecorder()
+			transactionController.Reconcile(resp, req)
+			tt.validate(t, resp.Result(), tt.expectedStatus)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/json_handler"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+type WebhooksController struct {
+	service *services.WebhooksService
+	logger  *slog.Logger
+}
+
+func NewWebhooksController(service *services.WebhooksService, logger *slog.Logger) *WebhooksController {
+	return &WebhooksController{service: service, logger: logger}
+}
+
+// CreateSubscription 	 godoc
+//
+//	@Summary		Create a webhook subscription
+//	@Description	subscribe a URL to account and transaction events, deliveries are signed with HMAC-SHA256 of "<timestamp>.<body>"
+//	@Description	using the secret and sent in the X-Webhook-Signature header with the timestamp in X-Webhook-Timestamp
+//	@Description	the secret is generated when left out and is only ever returned in this response
+//	@Description	the URL must lead to a public address, loopback, link-local and private addresses are refused
+//	@Tags			webhooks
+//	@Param			subscription	body		model.WebhookSubscriptionRequestBody	true	"Subscription info"
+//	@Success		201				{object}	model.WebhookSubscriptionResponseBody
+//	@Failure		400				{object}	model.ErrorResponse
+//	@Failure		500				{object}	model.ErrorResponse
+//	@Failure		503				{object}	model.ErrorResponse
+//	@Accept			json
+//	@Produce		json
+//	@Router			/webhooks [post]
+func (c *WebhooksController) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var body model.WebhookSubscriptionRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		json_handler.WriteError(w, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "invalid request body",
+		})
+		return
+	}
+	subscription, err := c.service.CreateSubscription(r.Context(), body)
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/v1/webhooks/"+subscription.SubscriptionID)
+	json_handler.WriteJSON(w, http.StatusCreated, subscription)
+}
+
+// ListSubscriptions 	 godoc
+//
+//	@Summary		List webhook subscriptions
+//	@Description	list every webhook subscription
+//	@Tags			webhooks
+//	@Success		200	{array}		model.WebhookSubscriptionResponseBody
+//	@Failure		500	{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/webhooks [get]
+func (c *WebhooksController) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := c.service.ListSubscriptions(r.Context())
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusOK, subscriptions)
+}
+
+// GetSubscription 	 godoc
+//
+//	@Summary		Get a webhook subscription
+//	@Description	get webhook subscription by ID
+//	@Tags			webhooks
+//	@Param			id	path		string	true	"Subscription ID"
+//	@Success		200	{object}	model.WebhookSubscriptionResponseBody
+//	@Failure		404	{object}	model.ErrorResponse
+//	@Failure		500	{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/webhooks/{id} [get]
+func (c *WebhooksController) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	subscription, err := c.service.GetSubscription(r.Context(), strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusOK, subscription)
+}
+
+// UpdateSubscription 	 godoc
+//
+//	@Summary		Update a webhook subscription
+//	@Description	replace the URL and event types of a subscription, the secret is rotated only when a new one is given
+//	@Tags			webhooks
+//	@Param			id				path		string									true	"Subscription ID"
+//	@Param			subscription	body		model.WebhookSubscriptionRequestBody	true	"Subscription info"
+//	@Success		200				{object}	model.WebhookSubscriptionResponseBody
+//	@Failure		400				{object}	model.ErrorResponse
+//	@Failure		404				{object}	model.ErrorResponse
+//	@Failure		500				{object}	model.ErrorResponse
+//	@Failure		503				{object}	model.ErrorResponse
+//	@Accept			json
+//	@Produce		json
+//	@Router			/webhooks/{id} [put]
+func (c *WebhooksController) UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	var body model.WebhookSubscriptionRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		json_handler.WriteError(w, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "invalid request body",
+		})
+		return
+	}
+	subscription, err := c.service.UpdateSubscription(r.Context(), strings.TrimSpace(chi.URLParam(r, "id")), body)
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusOK, subscription)
+}
+
+// DeleteSubscription 	 godoc
+//
+//	@Summary		Delete a webhook subscription
+//	@Description	delete a webhook subscription, deliveries still queued for it are dead lettered
+//	@Tags			webhooks
+//	@Param			id	path	string	true	"Subscription ID"
+//	@Success		204
+//	@Failure		404	{object}	model.ErrorResponse
+//	@Failure		500	{object}	model.ErrorResponse
+//	@Router			/webhooks/{id} [delete]
+func (c *WebhooksController) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if err := c.service.DeleteSubscription(r.Context(), strings.TrimSpace(chi.URLParam(r, "id"))); err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries 	 godoc
+//
+//	@Summary		List webhook deliveries
+//	@Description	list webhook deliveries oldest first, pass status=dead_letter for the dead letter list
+//	@Tags			webhooks
+//	@Param			status			query		string	false	"Delivery status"	Enums(pending, succeeded, dead_letter)
+//	@Param			subscription_id	query		string	false	"Subscription ID"
+//	@Success		200				{array}		model.WebhookDeliveryResponseBody
+//	@Failure		400				{object}	model.ErrorResponse
+//	@Failure		500				{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/webhooks/deliveries [get]
+func (c *WebhooksController) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	deliveries, err := c.service.ListDeliveries(r.Context(), model.WebhookDeliveryFilter{
+		SubscriptionID: strings.TrimSpace(query.Get("subscription_id")),
+		Status:         model.WebhookDeliveryStatus(strings.TrimSpace(query.Get("status"))),
+	})
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusOK, deliveries)
+}
+
+// ReplayDelivery 	 godoc
+//
+//	@Summary		Replay a dead lettered webhook delivery
+//	@Description	queue a dead lettered delivery again with a fresh set of attempts
+//	@Tags			webhooks
+//	@Param			id	path		string	true	"Delivery ID"
+//	@Success		202	{object}	model.WebhookDeliveryResponseBody
+//	@Failure		404	{object}	model.ErrorResponse
+//	@Failure		409	{object}	model.ErrorResponse
+//	@Failure		500	{object}	model.ErrorResponse
+//	@Produce		json
+//	@Router			/webhooks/deliveries/{id}/replay [post]
+func (c *WebhooksController) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, err := c.service.ReplayDelivery(r.Context(), strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		json_handler.WriteError(w, err)
+		return
+	}
+	json_handler.WriteJSON(w, http.StatusAccepted, delivery)
+}
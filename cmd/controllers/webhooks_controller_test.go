@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var deadLetterDeliveryID = bson.NewObjectID()
+
+type MockWebhookRepo struct{}
+
+func (m *MockWebhookRepo) CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (*model.WebhookSubscription, error) {
+	subscription.ID = bson.NewObjectID()
+	return &subscription, nil
+}
+
+func (m *MockWebhookRepo) GetWebhookSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscription, error) {
+	return nil, nil
+}
+
+func (m *MockWebhookRepo) ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	return nil, nil
+}
+
+func (m *MockWebhookRepo) UpdateWebhookSubscription(ctx context.Context, subscriptionID string, subscription model.WebhookSubscription) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockWebhookRepo) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockWebhookRepo) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockWebhookRepo) GetWebhookDelivery(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error) {
+	if deliveryID == deadLetterDeliveryID.Hex() {
+		return &model.WebhookDelivery{ID: deadLetterDeliveryID, Status: model.WebhookDeliveryDeadLetter, Attempts: 8}, nil
+	}
+	return nil, nil
+}
+
+func (m *MockWebhookRepo) ListWebhookDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter, limit int) ([]model.WebhookDelivery, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockWebhookRepo) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockWebhookRepo) UpdateWebhookDelivery(ctx context.Context, deliveryID string, delivery model.WebhookDelivery, expectedNextAttemptAt time.Time) error {
+	return nil
+}
+
+// publicResolver resolves every host to a public address
+type publicResolver struct{}
+
+func (publicResolver) LookupNetIP(ctx context.Context, network string, host string) ([]netip.Addr, error) {
+	return []netip.Addr{netip.MustParseAddr("93.184.215.14")}, nil
+}
+
+func Test_CreateSubscription(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := services.NewWebhooksService(&MockWebhookRepo{}, logger, publicResolver{})
+	webhooksController := NewWebhooksController(service, logger)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		validate       func(t *testing.T, resp *http.Response, expectedStatus int)
+	}{
+		{
+			name:           "Successful subscription",
+			body:           `{"url":"https://example.com/hook","event_types":["TransactionCreated"]}`,
+			expectedStatus: http.StatusCreated,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var respBody model.WebhookSubscriptionResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if resp.Header.Get("Location") != "/v1/webhooks/"+respBody.SubscriptionID || respBody.Secret == "" {
+					t.Errorf("expected a Location header and the secret, got %+v", respBody)
+				}
+			},
+		},
+		{
+			name:           "Invalid request body",
+			body:           `{"url":`,
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "Invalid URL",
+			body:           `{"url":"ftp://example.com","event_types":["TransactionCreated"]}`,
+			expectedStatus: http.StatusBadRequest,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(tt.body))
+			resp := httptest.NewRecorder()
+			webhooksController.CreateSubscription(resp, req)
+			tt.validate(t, resp.Result(), tt.expectedStatus)
+		})
+	}
+}
+
+func Test_ReplayDelivery(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := services.NewWebhooksService(&MockWebhookRepo{}, logger, publicResolver{})
+	webhooksController := NewWebhooksController(service, logger)
+
+	tests := []struct {
+		name           string
+		deliveryID     string
+		expectedStatus int
+	}{
+		{name: "Dead letter replayed", deliveryID: deadLetterDeliveryID.Hex(), expectedStatus: http.StatusAccepted},
+		{name: "Delivery not found", deliveryID: bson.NewObjectID().Hex(), expectedStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/deliveries/"+tt.deliveryID+"/replay", nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.deliveryID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			resp := httptest.NewRecorder()
+			webhooksController.ReplayDelivery(resp, req)
+			if resp.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = "usage: migrate up | migrate down [steps] | migrate status"
+
+// runMigrate is the migrate subcommand. migrate up applies the pending MongoDB migrations, the server does the same when
+// it starts, migrate down reverts the last steps of them, one unless given, ahead of going back to an older release and
+// migrate status lists them. The database of every tenant in TENANTS is migrated. The SQL backends only migrate
+// forwards, on start or with migrate up
+func runMigrate(ctx context.Context, logger *slog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	steps := 1
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("steps must be a positive number, got %q", args[1])
+		}
+		steps = n
+	case args[0] != "up" && args[0] != "down" && args[0] != "status", len(args) > 1:
+		return errors.New(migrateUsage)
+	}
+
+	tenants, err := setupTenants()
+	if err != nil {
+		return fmt.Errorf("failed to set up tenants: %w", err)
+	}
+	if backend := os.Getenv("STORAGE_BACKEND"); backend != "" && backend != "mongodb" {
+		if args[0] != "up" {
+			return fmt.Errorf("migrate %s needs the mongodb STORAGE_BACKEND, %s only migrates up", args[0], backend)
+		}
+		// setupStorage migrates the SQL backends as it opens them
+		_, closeStore, err := setupStorage(ctx, logger, tenants)
+		if err != nil {
+			return err
+		}
+		closeStore()
+		return nil
+	}
+
+	store, config, closeStore, err := openMongoDB(ctx, logger)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	for _, tenantCtx := range tenantContexts(ctx, tenants) {
+		name := config.DatabaseName(tenantCtx)
+		switch args[0] {
+		case "up":
+			applied, err := store.MigrateUp(tenantCtx)
+			for _, migration := range applied {
+				logger.InfoContext(ctx, "applied migration", "database", name, "version", migration.Version, "description", migration.Description)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to migrate %s: %w", name, err)
+			}
+		case "down":
+			reverted, err := store.MigrateDown(tenantCtx, steps)
+			for _, migration := range reverted {
+				logger.InfoContext(ctx, "reverted migration", "database", name, "version", migration.Version, "description", migration.Description)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to revert the migrations of %s: %w", name, err)
+			}
+		case "status":
+			migrations, err := store.Migrations(tenantCtx)
+			if err != nil {
+				return fmt.Errorf("failed to list the migrations of %s: %w", name, err)
+			}
+			for _, migration := range migrations {
+				status := "pending"
+				if migration.AppliedAt != nil {
+					status = "applied at " + migration.AppliedAt.String()
+				}
+				fmt.Printf("%s\t%d\t%s\t%s\n", name, migration.Version, status, migration.Description)
+			}
+		}
+	}
+	return nil
+}
@@ -8,24 +8,67 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/joolshouston/pismo-technical-test/cmd/controllers"
 	_ "github.com/joolshouston/pismo-technical-test/docs"
+	"github.com/joolshouston/pismo-technical-test/shared/audit"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
 )
 
-func (app *Application) routes(accountController *controllers.AccountsController, transactionController *controllers.TransactionsController) http.Handler {
+func (app *Application) routes(accountController *controllers.AccountsController, transactionController *controllers.TransactionsController, jobsController *controllers.JobsController, webhooksController *controllers.WebhooksController, auditController *controllers.AuditController, ledgerController *controllers.LedgerController, metricsController *controllers.MetricsController) http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer)
-	mux.Use(middleware.Timeout(60 * time.Second))
+	mux.Use(middleware.RequestID)
+	mux.Use(audit.Middleware)
+
+	// The docs are the same for every tenant so they are served without one
+	mux.Get("/v1/swagger/*", httpSwagger.Handler(httpSwagger.URL("http://localhost:8080/v1/swagger/doc.json")))
 
 	mux.Route("/v1", func(r chi.Router) {
-		// Account routes
-		r.Post("/accounts", accountController.CreateAccount)
-		r.Get("/accounts/{id}", accountController.GetAccount)
+		if app.Tenants != nil {
+			r.Use(app.Tenants.Middleware)
+		}
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Timeout(60 * time.Second))
+
+			// Account routes, accounts named by their internal ID are answered with a Deprecation header
+			r.Post("/accounts", accountController.CreateAccount)
+			r.With(publicid.Deprecate(publicid.AccountPrefix)).Route("/accounts/{id}", func(r chi.Router) {
+				r.Get("/", accountController.GetAccount)
+				r.Get("/balance", accountController.GetAccountBalance)
+				r.Get("/statement", transactionController.GetStatement)
+				r.Get("/audit", auditController.GetAccountAudit)
+			})
+
+			// Transaction routes
+			r.Post("/transactions", transactionController.CreateTransaction)
+			r.Post("/transactions:batch", transactionController.CreateTransactionsBatch)
+			r.With(publicid.Deprecate(publicid.TransactionPrefix)).Get("/transactions/{id}/audit", auditController.GetTransactionAudit)
+
+			// Job routes
+			r.Get("/jobs/{id}", jobsController.GetJob)
+
+			// Webhook routes
+			r.Post("/webhooks", webhooksController.CreateSubscription)
+			r.Get("/webhooks", webhooksController.ListSubscriptions)
+			r.Get("/webhooks/deliveries", webhooksController.ListDeliveries)
+			r.Post("/webhooks/deliveries/{id}/replay", webhooksController.ReplayDelivery)
+			r.Get("/webhooks/{id}", webhooksController.GetSubscription)
+			r.Put("/webhooks/{id}", webhooksController.UpdateSubscription)
+			r.Delete("/webhooks/{id}", webhooksController.DeleteSubscription)
+
+			// Ledger routes
+			r.Get("/ledger/trial-balance", ledgerController.GetTrialBalance)
 
-		// Transaction routes
-		r.Post("/transactions", transactionController.CreateTransaction)
+			// Metrics routes, behind the tenant middleware like everything else that is not the docs
+			r.Get("/metrics/account-cache", metricsController.GetAccountCacheStats)
+		})
 
-		r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL("http://localhost:8080/v1/swagger/doc.json")))
+		// Uploads, downloads and full replays take as long as they need so they are kept out of the request timeout
+		r.Get("/transactions/export", transactionController.ExportTransactions)
+		r.Post("/accounts:import", jobsController.ImportAccounts)
+		r.Get("/jobs/{id}/result", jobsController.GetJobResult)
+		r.Post("/reconciliations", transactionController.Reconcile)
 	})
 
 	return mux
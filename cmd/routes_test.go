@@ -8,23 +8,35 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/joolshouston/pismo-technical-test/cmd/controllers"
 	"github.com/joolshouston/pismo-technical-test/cmd/services"
+	"github.com/joolshouston/pismo-technical-test/shared/cache"
+	"github.com/joolshouston/pismo-technical-test/shared/database"
+	"github.com/joolshouston/pismo-technical-test/shared/lock"
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 type MockRouteRepo struct{}
 
+// publicAccountID is the public ID of the account also known by its internal ID, valid_id
+var publicAccountID = publicid.NewAccountID()
+
 func (m *MockRouteRepo) GetAccountByID(ctx context.Context, accountID string) (*model.Account, error) {
 	switch accountID {
-	case "valid_id":
+	case "valid_id", publicAccountID:
 		return &model.Account{
 			ID:             bson.NewObjectID(),
+			PublicID:       accountID,
 			DocumentNumber: "123456789",
 		}, nil
 	case "invalid_id":
@@ -51,6 +63,7 @@ func (m *MockRouteRepo) GetAccountByDocumentNumber(ctx context.Context, document
 func (m *MockRouteRepo) CreateAccount(ctx context.Context, documentID string) (*model.Account, error) {
 	return &model.Account{
 		ID:             bson.NewObjectID(),
+		PublicID:       publicid.NewAccountID(),
 		DocumentNumber: documentID,
 	}, nil
 }
@@ -71,12 +84,20 @@ func (m *MockRouteRepo) FindAccountByID(ctx context.Context, accountID string) (
 func (m *MockRouteRepo) CreateTransaction(ctx context.Context, transaction model.Transaction) (*model.Transaction, error) {
 	return &model.Transaction{
 		ID:          bson.NewObjectID(),
+		PublicID:    publicid.NewTransactionID(),
 		AccountID:   transaction.AccountID,
 		OperationID: transaction.OperationID,
 		Amount:      transaction.Amount,
 	}, nil
 }
 
+func (m *MockRouteRepo) GetTransactionByID(ctx context.Context, transactionID string) (*model.Transaction, error) {
+	if transactionID != auditedTransactionID.Hex() {
+		return nil, nil
+	}
+	return &model.Transaction{ID: auditedTransactionID, AccountID: "valid_id"}, nil
+}
+
 func (m *MockRouteRepo) FindTransactionByIdempotencyKey(ctx context.Context, idempotencyKey string) (*model.Transaction, error) {
 	return nil, nil
 }
@@ -86,21 +107,167 @@ func (m *MockRouteRepo) FindAllTransactionsForAccountID(ctx context.Context, acc
 	panic("implement me")
 }
 
-func (m *MockRouteRepo) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction) error {
+func (m *MockRouteRepo) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction, expectedVersion int64) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockRouteRepo) StreamTransactions(ctx context.Context, filter model.TransactionFilter, fn func(model.Transaction) error) error {
+	return fn(model.Transaction{
+		ID:          bson.NewObjectID(),
+		AccountID:   filter.AccountID,
+		OperationID: model.OperationTypePurchase,
+		Amount:      -50,
+		Balance:     -50,
+		EventDate:   time.Date(2025, 1, 31, 10, 0, 0, 0, time.UTC),
+	})
+}
+
+func (m *MockRouteRepo) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (m *MockRouteRepo) AppendOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	return nil
+}
+
+func (m *MockRouteRepo) FindUnpublishedOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockRouteRepo) MarkOutboxEventPublished(ctx context.Context, eventID string, publishedAt time.Time) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockRouteRepo) PostJournalEntry(ctx context.Context, entry model.JournalEntry) error {
+	return nil
+}
+
+func (m *MockRouteRepo) FindJournalEntries(ctx context.Context, accountID string, postedBy time.Time) ([]model.JournalEntry, error) {
+	return []model.JournalEntry{{
+		TransactionID: "tx-1",
+		AccountID:     accountID,
+		PostedAt:      time.Date(2025, 1, 10, 10, 0, 0, 0, time.UTC),
+		Lines: []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 5000, AppliesTo: "tx-1"},
+			{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 5000},
+		},
+	}}, nil
+}
+
+func (m *MockRouteRepo) TrialBalance(ctx context.Context) ([]model.TrialBalanceLine, error) {
+	return []model.TrialBalanceLine{
+		{LedgerAccount: model.LedgerAccountReceivable, DebitCents: 12350},
+		{LedgerAccount: model.LedgerAccountMerchantPayable, CreditCents: 12350},
+	}, nil
+}
+
+func (m *MockRouteRepo) GetAccountBalance(ctx context.Context, accountID string) (*model.AccountBalance, error) {
+	return &model.AccountBalance{AccountID: accountID, TotalDebtCents: 5000, Version: 3, OpenDebts: []model.OpenDebt{{TransactionID: bson.NewObjectID().Hex(), BalanceCents: -5000}}}, nil
+}
+
+func (m *MockRouteRepo) SaveAccountBalance(ctx context.Context, balance model.AccountBalance) error {
+	return nil
+}
+
+type MockRouteWebhookRepo struct{}
+
+func (m *MockRouteWebhookRepo) CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (*model.WebhookSubscription, error) {
+	subscription.ID = bson.NewObjectID()
+	return &subscription, nil
+}
+
+func (m *MockRouteWebhookRepo) GetWebhookSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscription, error) {
+	return nil, nil
+}
+
+func (m *MockRouteWebhookRepo) ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	return nil, nil
+}
+
+func (m *MockRouteWebhookRepo) UpdateWebhookSubscription(ctx context.Context, subscriptionID string, subscription model.WebhookSubscription) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockRouteWebhookRepo) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockRouteWebhookRepo) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockRouteWebhookRepo) GetWebhookDelivery(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (m *MockRouteWebhookRepo) ListWebhookDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter, limit int) ([]model.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (m *MockRouteWebhookRepo) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockRouteWebhookRepo) UpdateWebhookDelivery(ctx context.Context, deliveryID string, delivery model.WebhookDelivery, expectedNextAttemptAt time.Time) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+// publicResolver resolves every host to a public address
+type publicResolver struct{}
+
+func (publicResolver) LookupNetIP(ctx context.Context, network string, host string) ([]netip.Addr, error) {
+	return []netip.Addr{netip.MustParseAddr("93.184.215.14")}, nil
+}
+
+// auditedTransactionID is the only transaction the routes can read the audit trail of
+var auditedTransactionID = bson.NewObjectID()
+
+type MockRouteAuditRepo struct{}
+
+func (m *MockRouteAuditRepo) AppendAuditRecord(ctx context.Context, record model.AuditRecord) error {
 	//TODO implement me
 	panic("implement me")
 }
 
+func (m *MockRouteAuditRepo) FindAuditRecords(ctx context.Context, entityType model.AuditEntityType, entityID string, limit int) ([]model.AuditRecord, error) {
+	return []model.AuditRecord{{
+		ID:         bson.NewObjectID(),
+		Actor:      "backoffice",
+		Action:     model.AuditActionUpdate,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     []byte(`{"balance":-50}`),
+		After:      []byte(`{"balance":0}`),
+	}}, nil
+}
+
 func TestRoutes(t *testing.T) {
 	repo := &MockRouteRepo{}
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	accountService := services.NewAccountsService(repo, logger)
-	transactionService := services.NewTransactionService(repo, logger)
+	transactionService := services.NewTransactionService(repo, logger, lock.NewLease(database.NewMemory(), logger, lock.DefaultConfig()))
 	accountController := controllers.NewAccountsController(accountService, logger)
 	transactionController := controllers.NewTransactionsController(transactionService, logger)
+	importService := services.NewImportService(repo, database.NewMemory(), logger, t.TempDir(), services.DefaultImportJobRetention)
+	t.Cleanup(importService.Wait)
+	jobsController := controllers.NewJobsController(importService, logger)
+	webhooksService := services.NewWebhooksService(&MockRouteWebhookRepo{}, logger, publicResolver{})
+	webhooksController := controllers.NewWebhooksController(webhooksService, logger)
+	auditService := services.NewAuditService(&MockRouteAuditRepo{}, repo, logger)
+	auditController := controllers.NewAuditController(auditService, logger)
+	ledgerController := controllers.NewLedgerController(services.NewLedgerService(repo, logger), logger)
+	metricsController := controllers.NewMetricsController(nil, logger)
 
 	app := &Application{}
-	router := app.routes(accountController, transactionController)
+	router := app.routes(accountController, transactionController, jobsController, webhooksController, auditController, ledgerController, metricsController)
 
 	tests := []struct {
 		name           string
@@ -163,6 +330,35 @@ func TestRoutes(t *testing.T) {
 				if account.DocumentNumber != "123456789" {
 					t.Errorf("expected document number '123456789', got %s", account.DocumentNumber)
 				}
+				if resp.Header.Get(publicid.DeprecationHeader) != "true" {
+					t.Errorf("expected an account named by its internal ID to be deprecated")
+				}
+			},
+		},
+		{
+			name:           "GET /v1/accounts/{id} - account retrieval by public ID",
+			method:         "GET",
+			url:            "/v1/accounts/" + publicAccountID,
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.Header.Get(publicid.DeprecationHeader) != "" {
+					t.Errorf("expected no Deprecation header for a public ID, got %q", resp.Header.Get(publicid.DeprecationHeader))
+				}
+			},
+		},
+		{
+			name:           "GET /v1/accounts/{id}/balance - balance projection",
+			method:         "GET",
+			url:            "/v1/accounts/valid_id/balance",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				var balance model.AccountBalanceResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&balance); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if balance.TotalDebt != 50 || balance.Version != 3 {
+					t.Errorf("unexpected balance %+v", balance)
+				}
 			},
 		},
 		{
@@ -244,8 +440,196 @@ func TestRoutes(t *testing.T) {
 				if err != nil {
 					t.Fatalf("expected no error decoding response, got %v", err)
 				}
-				if !strings.Contains(errResp.Message, "missing") || !strings.Contains(errResp.Message, "account_id") {
-					t.Errorf("expected error message to contain missing fields, got %s", errResp.Message)
+				expected := []model.FieldError{
+					{Field: "account_id", Code: "required", Message: "account_id is required"},
+					{Field: "operation_type_id", Code: "required", Message: "operation_type_id is required"},
+					{Field: "amount", Code: "required", Message: "amount is required"},
+				}
+				if !reflect.DeepEqual(errResp.Errors, expected) {
+					t.Errorf("expected an error for every missing field %+v, got %+v", expected, errResp.Errors)
+				}
+			},
+		},
+		{
+			name:   "POST /v1/transactions:batch - best effort batch",
+			method: "POST",
+			url:    "/v1/transactions:batch",
+			body:   `{"mode":"best_effort","items":[{"idempotency_key":"batch-1","account_id":"valid_id","operation_type_id":1,"amount":-10},{"idempotency_key":"batch-2","account_id":"valid_id","operation_type_id":1,"amount":10}]}`,
+			headers: map[string]string{
+				"Content-Type": "application/json",
+			},
+			expectedStatus: http.StatusMultiStatus,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var batch model.TransactionBatchResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if batch.Created != 1 || batch.Rejected != 1 {
+					t.Errorf("expected 1 created and 1 rejected, got %+v", batch)
+				}
+			},
+		},
+		{
+			name:           "POST /v1/accounts:import - accepts an import job",
+			method:         "POST",
+			url:            "/v1/accounts:import",
+			body:           "document_number\n123456789\n",
+			headers:        map[string]string{"Content-Type": "text/csv"},
+			expectedStatus: http.StatusAccepted,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var job model.JobResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if resp.Header.Get("Location") != "/v1/jobs/"+job.JobID {
+					t.Errorf("expected Location header for job %s, got %s", job.JobID, resp.Header.Get("Location"))
+				}
+			},
+		},
+		{
+			name:           "GET /v1/jobs/{id} - job not found",
+			method:         "GET",
+			url:            "/v1/jobs/unknown",
+			body:           "",
+			headers:        map[string]string{},
+			expectedStatus: http.StatusNotFound,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "GET /v1/transactions/export - streams csv",
+			method:         "GET",
+			url:            "/v1/transactions/export?account_id=valid_id&format=csv",
+			body:           "",
+			headers:        map[string]string{},
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				if resp.Header.Get("Content-Type") != "text/csv" {
+					t.Errorf("expected Content-Type 'text/csv', got %s", resp.Header.Get("Content-Type"))
+				}
+			},
+		},
+		{
+			name:           "POST /v1/webhooks - successful subscription",
+			method:         "POST",
+			url:            "/v1/webhooks",
+			body:           `{"url":"https://example.com/hook","event_types":["AccountCreated","TransactionCreated"]}`,
+			expectedStatus: http.StatusCreated,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "GET /v1/webhooks/deliveries - not taken for a subscription ID",
+			method:         "GET",
+			url:            "/v1/webhooks/deliveries?status=dead_letter",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "GET /v1/webhooks/{id} - subscription not found",
+			method:         "GET",
+			url:            "/v1/webhooks/" + bson.NewObjectID().Hex(),
+			expectedStatus: http.StatusNotFound,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "GET /v1/transactions/{id}/audit - audit trail",
+			method:         "GET",
+			url:            "/v1/transactions/" + auditedTransactionID.Hex() + "/audit",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var records []model.AuditRecordResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if len(records) != 1 || records[0].EntityType != model.AuditEntityTransaction || records[0].EntityID != auditedTransactionID.Hex() || string(records[0].After) != `{"balance":0}` {
+					t.Errorf("unexpected audit trail %+v", records)
+				}
+			},
+		},
+		{
+			name:           "GET /v1/transactions/{id}/audit - transaction not found",
+			method:         "GET",
+			url:            "/v1/transactions/" + bson.NewObjectID().Hex() + "/audit",
+			expectedStatus: http.StatusNotFound,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:           "POST /v1/reconciliations - every account",
+			method:         "POST",
+			url:            "/v1/reconciliations",
+			body:           `{}`,
+			headers:        map[string]string{"Content-Type": "application/json"},
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var reconciliation model.ReconciliationResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&reconciliation); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if reconciliation.AccountsChecked != 1 || reconciliation.TransactionsDrifted != 0 {
+					t.Errorf("unexpected reconciliation %+v", reconciliation)
+				}
+			},
+		},
+		{
+			name:           "GET /v1/ledger/trial-balance - trial balance",
+			method:         "GET",
+			url:            "/v1/ledger/trial-balance",
+			expectedStatus: http.StatusOK,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+				}
+				var trialBalance model.TrialBalanceResponseBody
+				if err := json.NewDecoder(resp.Body).Decode(&trialBalance); err != nil {
+					t.Fatalf("expected no error decoding response, got %v", err)
+				}
+				if !trialBalance.Balanced || trialBalance.TotalDebitCents != 12350 || len(trialBalance.Accounts) != 2 {
+					t.Errorf("unexpected trial balance %+v", trialBalance)
+				}
+			},
+		},
+		{
+			name:           "GET /v1/metrics/account-cache - cache disabled",
+			method:         "GET",
+			url:            "/v1/metrics/account-cache",
+			expectedStatus: http.StatusNotFound,
+			validate: func(t *testing.T, resp *http.Response, expectedStatus int) {
+				if resp.StatusCode != expectedStatus {
+					t.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
 				}
 			},
 		},
@@ -303,12 +687,21 @@ func TestRoutesMiddleware(t *testing.T) {
 	repo := &MockRouteRepo{}
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	accountService := services.NewAccountsService(repo, logger)
-	transactionService := services.NewTransactionService(repo, logger)
+	transactionService := services.NewTransactionService(repo, logger, lock.NewLease(database.NewMemory(), logger, lock.DefaultConfig()))
 	accountController := controllers.NewAccountsController(accountService, logger)
 	transactionController := controllers.NewTransactionsController(transactionService, logger)
+	importService := services.NewImportService(repo, database.NewMemory(), logger, t.TempDir(), services.DefaultImportJobRetention)
+	t.Cleanup(importService.Wait)
+	jobsController := controllers.NewJobsController(importService, logger)
+	webhooksService := services.NewWebhooksService(&MockRouteWebhookRepo{}, logger, publicResolver{})
+	webhooksController := controllers.NewWebhooksController(webhooksService, logger)
+	auditService := services.NewAuditService(&MockRouteAuditRepo{}, repo, logger)
+	auditController := controllers.NewAuditController(auditService, logger)
+	ledgerController := controllers.NewLedgerController(services.NewLedgerService(repo, logger), logger)
+	metricsController := controllers.NewMetricsController(nil, logger)
 
 	app := &Application{}
-	router := app.routes(accountController, transactionController)
+	router := app.routes(accountController, transactionController, jobsController, webhooksController, auditController, ledgerController, metricsController)
 
 	tests := []struct {
 		name           string
@@ -368,3 +761,55 @@ func TestRoutesMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestRoutesTenants(t *testing.T) {
+	repo := &MockRouteRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	accountController := controllers.NewAccountsController(services.NewAccountsService(repo, logger), logger)
+	transactionController := controllers.NewTransactionsController(services.NewTransactionService(repo, logger, lock.NewLease(database.NewMemory(), logger, lock.DefaultConfig())), logger)
+	importService := services.NewImportService(repo, database.NewMemory(), logger, t.TempDir(), services.DefaultImportJobRetention)
+	t.Cleanup(importService.Wait)
+	jobsController := controllers.NewJobsController(importService, logger)
+	webhooksController := controllers.NewWebhooksController(services.NewWebhooksService(&MockRouteWebhookRepo{}, logger, publicResolver{}), logger)
+	auditController := controllers.NewAuditController(services.NewAuditService(&MockRouteAuditRepo{}, repo, logger), logger)
+	ledgerController := controllers.NewLedgerController(services.NewLedgerService(repo, logger), logger)
+	metricsController := controllers.NewMetricsController(func() cache.Stats { return cache.Stats{Hits: 3, Misses: 1} }, logger)
+
+	tenants, err := tenant.NewResolver([]string{"program-a"}, map[string]string{"key-a": "program-a"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	app := &Application{Tenants: tenants}
+	router := app.routes(accountController, transactionController, jobsController, webhooksController, auditController, ledgerController, metricsController)
+
+	tests := []struct {
+		name           string
+		url            string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{name: "Tenant header", url: "/v1/accounts/valid_id", headers: map[string]string{tenant.Header: "program-a"}, expectedStatus: http.StatusOK},
+		{name: "API key", url: "/v1/accounts/valid_id", headers: map[string]string{tenant.APIKeyHeader: "key-a"}, expectedStatus: http.StatusOK},
+		{name: "No tenant", url: "/v1/accounts/valid_id", expectedStatus: http.StatusBadRequest},
+		{name: "Unknown tenant", url: "/v1/accounts/valid_id", headers: map[string]string{tenant.Header: "program-b"}, expectedStatus: http.StatusForbidden},
+		{name: "Routes outside the timeout", url: "/v1/transactions/export", expectedStatus: http.StatusBadRequest},
+		{name: "Docs need no tenant", url: "/v1/swagger/index.html", expectedStatus: http.StatusOK},
+		{name: "Metrics", url: "/v1/metrics/account-cache", headers: map[string]string{tenant.APIKeyHeader: "key-a"}, expectedStatus: http.StatusOK},
+		{name: "Metrics need a tenant", url: "/v1/metrics/account-cache", expectedStatus: http.StatusBadRequest},
+		{name: "Metrics need a known API key", url: "/v1/metrics/account-cache", headers: map[string]string{tenant.APIKeyHeader: "key-b"}, expectedStatus: http.StatusUnauthorized},
+		{name: "No expvar", url: "/debug/vars", expectedStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			if resp.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, resp.Code, resp.Body.String())
+			}
+		})
+	}
+}
@@ -6,36 +6,50 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/joolshouston/pismo-technical-test/shared/ledger"
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/outbox"
 	"github.com/joolshouston/pismo-technical-test/shared/repository"
-	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 type AccountsInterface interface {
 	CreateAccount(ctx context.Context, documentID string) (*model.AccountResponseBody, *model.ErrorResponse)
 	GetAccountByID(ctx context.Context, accountID string) (*model.AccountResponseBody, *model.ErrorResponse)
+	GetAccountBalance(ctx context.Context, accountID string, asOf time.Time) (*model.AccountBalanceResponseBody, *model.ErrorResponse)
 }
 
 type AccountsService struct {
 	repo   repository.DatabaseRepository
 	logger *slog.Logger
+	retry  retryPolicy
 }
 
 func NewAccountsService(repo repository.DatabaseRepository, logger *slog.Logger) *AccountsService {
 	logger.InfoContext(context.Background(), "AccountsService initialized")
-	return &AccountsService{repo: repo, logger: logger}
+	return &AccountsService{repo: repo, logger: logger, retry: defaultRetryPolicy}
 }
 
 func (s *AccountsService) CreateAccount(ctx context.Context, documentID string) (*model.AccountResponseBody, *model.ErrorResponse) {
 	s.logger.InfoContext(ctx, "creating account", "documentID", documentID)
+	var resp *model.AccountResponseBody
+	errResp := s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		var errResp *model.ErrorResponse
+		resp, errResp = s.createAccount(ctx, documentID)
+		return errResp
+	})
+	if errResp != nil {
+		return nil, errResp
+	}
+	return resp, nil
+}
+
+func (s *AccountsService) createAccount(ctx context.Context, documentID string) (*model.AccountResponseBody, *model.ErrorResponse) {
 	existingAccount, err := s.repo.GetAccountByDocumentNumber(ctx, documentID)
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to check account existence", "error", err)
-		return nil, &model.ErrorResponse{
-			Status:  http.StatusInternalServerError,
-			Message: fmt.Sprintf("failed to check account existence"),
-		}
+		return nil, failure(err, "failed to check account existence")
 	}
 	if existingAccount != nil {
 		s.logger.InfoContext(ctx, "account already exists")
@@ -44,44 +58,180 @@ func (s *AccountsService) CreateAccount(ctx context.Context, documentID string)
 			Message: fmt.Sprintf("account already exists"),
 		}
 	}
-	acc, err := s.repo.CreateAccount(ctx, documentID)
-	if err != nil {
-		s.logger.ErrorContext(ctx, "failed to create account", "error", err)
+	acc, err := createAccount(ctx, s.repo, documentID)
+	if errors.Is(err, repository.ErrDuplicate) {
+		s.logger.InfoContext(ctx, "account was created concurrently")
 		return nil, &model.ErrorResponse{
-			Status:  http.StatusInternalServerError,
-			Message: fmt.Sprintf("failed to create account"),
+			Status:  http.StatusConflict,
+			Message: fmt.Sprintf("account already exists"),
 		}
 	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create account", "error", err)
+		return nil, failure(err, "failed to create account")
+	}
 	return &model.AccountResponseBody{
-		AccountID:      acc.ID.Hex(),
+		AccountID:      acc.PublicID,
 		DocumentNumber: acc.DocumentNumber,
 	}, nil
 }
 
+// createAccount inserts the account together with its AccountCreated event, the import uses it as well so every new
+// account is announced the same way
+func createAccount(ctx context.Context, repo repository.DatabaseRepository, documentNumber string) (*model.Account, error) {
+	var acc *model.Account
+	err := repo.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		acc, err = repo.CreateAccount(ctx, documentNumber)
+		if err != nil {
+			return err
+		}
+		event, err := outbox.NewEvent(model.EventTypeAccountCreated, acc.PublicID, acc.PublicID, model.AccountCreatedEvent{
+			AccountID:      acc.PublicID,
+			DocumentNumber: acc.DocumentNumber,
+		})
+		if err != nil {
+			return err
+		}
+		return repo.AppendOutboxEvent(ctx, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
 func (s *AccountsService) GetAccountByID(ctx context.Context, accountID string) (*model.AccountResponseBody, *model.ErrorResponse) {
-	acc, err := s.repo.GetAccountByID(ctx, accountID)
-	if errors.Is(err, mongo.ErrNoDocuments) {
+	acc, errResp := s.getAccount(ctx, accountID)
+	if errResp != nil {
+		return nil, errResp
+	}
+	return &model.AccountResponseBody{
+		AccountID:      acc.PublicID,
+		DocumentNumber: acc.DocumentNumber,
+	}, nil
+}
+
+// getAccount looks the account up by its public ID or, while clients move over to those, by its internal ID
+func (s *AccountsService) getAccount(ctx context.Context, accountID string) (*model.Account, *model.ErrorResponse) {
+	var acc *model.Account
+	errResp := s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		var err error
+		acc, err = s.repo.GetAccountByID(ctx, accountID)
+		return s.accountError(ctx, acc, err)
+	})
+	if errResp != nil {
+		return nil, errResp
+	}
+	return acc, nil
+}
+
+// accountError is the response for looking up acc and getting err, nil when the account was found
+func (s *AccountsService) accountError(ctx context.Context, acc *model.Account, err error) *model.ErrorResponse {
+	if errors.Is(err, repository.ErrNotFound) {
 		s.logger.ErrorContext(ctx, "failed to get account", "error", err)
-		return nil, &model.ErrorResponse{
+		return &model.ErrorResponse{
 			Status:  http.StatusNotFound,
 			Message: fmt.Sprintf("account not found"),
 		}
 	}
 	if err != nil {
-		return nil, &model.ErrorResponse{
-			Status:  http.StatusInternalServerError,
-			Message: fmt.Sprintf("failed to get account"),
-		}
+		s.logger.ErrorContext(ctx, "failed to get account", "error", err)
+		return failure(err, "failed to get account")
 	}
 	if acc == nil {
 		s.logger.InfoContext(ctx, "account not found")
-		return nil, &model.ErrorResponse{
+		return &model.ErrorResponse{
 			Status:  http.StatusNotFound,
 			Message: "account not found",
 		}
 	}
-	return &model.AccountResponseBody{
-		AccountID:      acc.ID.Hex(),
-		DocumentNumber: acc.DocumentNumber,
-	}, nil
+	return nil
+}
+
+// loadAccountBalance reads the account's balance projection, building it from the account's transactions and journal
+// when the account has none yet. A projection built here has version 0 and is created by the first write
+func loadAccountBalance(ctx context.Context, repo repository.DatabaseRepository, accountID string) (*model.AccountBalance, error) {
+	balance, err := repo.GetAccountBalance(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if balance != nil {
+		return balance, nil
+	}
+	transactions, err := findJournalTransactions(ctx, repo, accountID)
+	if err != nil {
+		return nil, err
+	}
+	rebuilt := ledger.NewAccountBalance(accountID, transactions)
+	return &rebuilt, nil
+}
+
+// GetAccountBalance returns the account's position from its balance projection, or from its journal as of asOf when
+// asOf is set since the projection only knows the present
+func (s *AccountsService) GetAccountBalance(ctx context.Context, accountID string, asOf time.Time) (*model.AccountBalanceResponseBody, *model.ErrorResponse) {
+	acc, errResp := s.getAccount(ctx, accountID)
+	if errResp != nil {
+		return nil, errResp
+	}
+	// the journal and the projection are kept under the internal ID
+	accountID = acc.ID.Hex()
+	if !asOf.IsZero() {
+		var (
+			entries      []model.JournalEntry
+			transactions []model.Transaction
+		)
+		errResp := s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+			var err error
+			entries, err = s.repo.FindJournalEntries(ctx, accountID, asOf)
+			if err == nil {
+				// the debts are named by the public IDs of their transactions, which also say what a debt made before
+				// the journal was for
+				transactions, err = s.repo.FindAllTransactionsForAccountID(ctx, accountID)
+			}
+			if err != nil {
+				s.logger.ErrorContext(ctx, "failed to find journal entries", "accountID", accountID, "asOf", asOf, "error", err)
+				return failure(err, "failed to get account balance")
+			}
+			return nil
+		})
+		if errResp != nil {
+			return nil, errResp
+		}
+		publicIDs := make(map[string]string, len(transactions))
+		for _, tx := range transactions {
+			publicIDs[tx.ID.Hex()] = tx.PublicID
+		}
+		resp := ledger.BalanceAsOf(acc.PublicID, entries, transactions, asOf.UTC())
+		for i, debt := range resp.Debts {
+			if publicID, ok := publicIDs[debt.TransactionID]; ok {
+				resp.Debts[i].TransactionID = publicID
+			}
+		}
+		return &resp, nil
+	}
+	var balance *model.AccountBalance
+	errResp = s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		var err error
+		balance, err = loadAccountBalance(ctx, s.repo, accountID)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to get account balance", "accountID", accountID, "error", err)
+			return failure(err, "failed to get account balance")
+		}
+		return nil
+	})
+	if errResp != nil {
+		return nil, errResp
+	}
+	resp := &model.AccountBalanceResponseBody{
+		AccountID:       acc.PublicID,
+		TotalDebt:       ledger.Amount(balance.TotalDebtCents),
+		AvailableCredit: ledger.Amount(balance.AvailableCreditCents),
+		OpenDebts:       len(balance.OpenDebts),
+		Version:         balance.Version,
+	}
+	if !balance.LastTransactionAt.IsZero() {
+		resp.LastTransactionAt = &balance.LastTransactionAt
+	}
+	return resp, nil
 }
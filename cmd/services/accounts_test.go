@@ -3,15 +3,50 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
-type MockMongoRepo struct{}
+type MockMongoRepo struct {
+	mu       sync.Mutex
+	events   []model.OutboxEvent
+	journal  []model.JournalEntry
+	updated  map[string]model.Transaction
+	balances []model.AccountBalance
+	attempts map[string]int
+}
+
+// flaky fails with a transient error the first time it is called for key and succeeds from then on
+func (m *MockMongoRepo) flaky(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attempts == nil {
+		m.attempts = map[string]int{}
+	}
+	m.attempts[key]++
+	if m.attempts[key] == 1 {
+		return fmt.Errorf("primary stepped down: %w", repository.ErrUnavailable)
+	}
+	return nil
+}
+
+// contended fails with a conflict the first time it is called for key, as if another request had written it in between
+func (m *MockMongoRepo) contended(key string) error {
+	if m.flaky(key) != nil {
+		return fmt.Errorf("written by another request: %w", repository.ErrConflict)
+	}
+	return nil
+}
 
 func (m *MockMongoRepo) CreateAccount(ctx context.Context, documentID string) (*model.Account, error) {
 	switch documentID {
@@ -19,6 +54,9 @@ func (m *MockMongoRepo) CreateAccount(ctx context.Context, documentID string) (*
 		return nil, errors.New("invalid document ID")
 	case "1234567895":
 		return nil, errors.New("duplicate document ID")
+	case "98765432100":
+		// created by another request between the lookup and the insert
+		return nil, fmt.Errorf("account with document number %s: %w", documentID, repository.ErrDuplicate)
 	default:
 		return &model.Account{
 			ID:             bson.NewObjectID(),
@@ -27,32 +65,50 @@ func (m *MockMongoRepo) CreateAccount(ctx context.Context, documentID string) (*
 	}
 }
 
+// mockAccountIDs holds the ID each account handed out by the mock was asked for, by its internal ID, so the mock can
+// still tell the accounts named after a failure apart once the services have moved on to their internal IDs
+var mockAccountIDs sync.Map
+
+// mockAccount returns an account known publicly by id
+func mockAccount(id, documentNumber string) *model.Account {
+	account := &model.Account{ID: bson.NewObjectID(), PublicID: id, DocumentNumber: documentNumber}
+	mockAccountIDs.Store(account.ID.Hex(), id)
+	return account
+}
+
+// accountKey returns the ID the account with the internal ID was asked for by, or the ID itself for any other ID
+func accountKey(accountID string) string {
+	if id, ok := mockAccountIDs.Load(accountID); ok {
+		return id.(string)
+	}
+	return accountID
+}
+
 func (m *MockMongoRepo) GetAccountByID(ctx context.Context, accountID string) (*model.Account, error) {
+	accountID = accountKey(accountID)
 	switch accountID {
 	case "valid_id":
-		return &model.Account{
-			ID:             bson.NewObjectID(),
-			DocumentNumber: "123456789",
-		}, nil
+		return mockAccount(accountID, "123456789"), nil
 	case "invalid_id":
 		return nil, errors.New("account not found")
+	case "unavailable_id":
+		return nil, fmt.Errorf("no reachable servers: %w", repository.ErrUnavailable)
+	case "flaky_id":
+		if err := m.flaky(accountID); err != nil {
+			return nil, err
+		}
+		return mockAccount(accountID, "123456789"), nil
 	case "account_nonexistent":
 		return nil, nil
 	default:
-		return &model.Account{
-			ID:             bson.NewObjectID(),
-			DocumentNumber: "1",
-		}, nil
+		return mockAccount(accountID, "1"), nil
 	}
 }
 
 func (m *MockMongoRepo) GetAccountByDocumentNumber(ctx context.Context, documentNumber string) (*model.Account, error) {
 	switch documentNumber {
-	case "failed_to_get_account":
-		return &model.Account{
-			ID:             bson.NewObjectID(),
-			DocumentNumber: documentNumber,
-		}, nil
+	case "failed_to_get_account", "111.222.333-44":
+		return mockAccount(publicid.NewAccountID(), documentNumber), nil
 	case "":
 		return nil, errors.New("invalid document number")
 	default:
@@ -105,6 +161,17 @@ func Test_CreateAccount(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Account created concurrently",
+			requestBody: model.AccountRequestBody{
+				DocumentNumber: "98765432100",
+			},
+			validate: func(t *testing.T, resp *model.AccountResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusConflict {
+					t.Fatalf("expected a conflict, got %v", err)
+				}
+			},
+		},
 		{
 			name: "Failed to get account",
 			requestBody: model.AccountRequestBody{
@@ -130,6 +197,7 @@ func Test_GetAccountByID(t *testing.T) {
 	repo := &MockMongoRepo{}
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	accountService := NewAccountsService(repo, logger)
+	accountService.retry = retryPolicy{attempts: 3}
 	tests := []struct {
 		name      string
 		accountID string
@@ -151,8 +219,32 @@ func Test_GetAccountByID(t *testing.T) {
 			name:      "Invalid account ID",
 			accountID: "invalid_id",
 			validate: func(t *testing.T, resp *model.AccountResponseBody, err *model.ErrorResponse) {
-				if err == nil {
-					t.Fatalf("expected error, got nil")
+				if err == nil || err.Status != http.StatusInternalServerError {
+					t.Fatalf("expected status 500, got %v", err)
+				}
+			},
+		},
+		{
+			name:      "Database unavailable",
+			accountID: "unavailable_id",
+			validate: func(t *testing.T, resp *model.AccountResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusServiceUnavailable {
+					t.Fatalf("expected status 503, got %v", err)
+				}
+				if err.RetryAfter != 1 {
+					t.Errorf("expected Retry-After 1, got %d", err.RetryAfter)
+				}
+			},
+		},
+		{
+			name:      "Database unavailable once",
+			accountID: "flaky_id",
+			validate: func(t *testing.T, resp *model.AccountResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected the retry to succeed, got %v", err)
+				}
+				if resp.DocumentNumber != "123456789" {
+					t.Errorf("expected document number '123456789', got %s", resp.DocumentNumber)
 				}
 			},
 		},
@@ -165,3 +257,138 @@ func Test_GetAccountByID(t *testing.T) {
 		})
 	}
 }
+
+func Test_CreateAccountAppendsEvent(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	accountService := NewAccountsService(repo, logger)
+
+	if _, err := accountService.CreateAccount(context.Background(), "123456789"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := accountService.CreateAccount(context.Background(), "1234567895"); err == nil {
+		t.Fatalf("expected error for duplicate account, got nil")
+	}
+	events := repo.appendedEvents()
+	if len(events) != 1 || events[0] != model.EventTypeAccountCreated {
+		t.Errorf("expected a single AccountCreated event, got %v", events)
+	}
+}
+
+func Test_GetAccountBalance(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	accountService := NewAccountsService(repo, logger)
+
+	tests := []struct {
+		name      string
+		accountID string
+		asOf      time.Time
+		validate  func(t *testing.T, resp *model.AccountBalanceResponseBody, err *model.ErrorResponse)
+	}{
+		{
+			name:      "Read from the projection",
+			accountID: "projected_id",
+			validate: func(t *testing.T, resp *model.AccountBalanceResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.TotalDebt != 73.5 || resp.OpenDebts != 2 || resp.Version != 4 || resp.LastTransactionAt != nil {
+					t.Errorf("unexpected balance %+v", resp)
+				}
+			},
+		},
+		{
+			name:      "Built from the transactions without a projection",
+			accountID: "valid_id",
+			validate: func(t *testing.T, resp *model.AccountBalanceResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.TotalDebt != 73.5 || resp.Version != 0 {
+					t.Errorf("unexpected balance %+v", resp)
+				}
+			},
+		},
+		{
+			name:      "Built from the transactions and the journal without a projection",
+			accountID: "paid_id",
+			validate: func(t *testing.T, resp *model.AccountBalanceResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				// 20 of the oldest debt was paid off, which is only posted to the journal
+				if resp.TotalDebt != 53.5 || resp.OpenDebts != 2 || resp.Version != 0 {
+					t.Errorf("unexpected balance %+v", resp)
+				}
+			},
+		},
+		{
+			name:      "Before the payment",
+			accountID: "paid_id",
+			asOf:      time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+			validate: func(t *testing.T, resp *model.AccountBalanceResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.TotalDebt != 50 || resp.OpenDebts != 1 || resp.AsOf == nil || resp.Version != 0 {
+					t.Errorf("unexpected balance %+v", resp)
+				}
+				if len(resp.Debts) != 1 || resp.Debts[0] != (model.Debt{TransactionID: debtPublicIDs[oldestDebtID], Amount: -50, Paid: 0, Balance: -50}) {
+					t.Errorf("expected the debt untouched, got %+v", resp.Debts)
+				}
+			},
+		},
+		{
+			name:      "After the payment",
+			accountID: "paid_id",
+			asOf:      time.Date(2025, 1, 25, 0, 0, 0, 0, time.UTC),
+			validate: func(t *testing.T, resp *model.AccountBalanceResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.TotalDebt != 30 || !resp.LastTransactionAt.Equal(time.Date(2025, 1, 20, 10, 0, 0, 0, time.UTC)) {
+					t.Errorf("unexpected balance %+v", resp)
+				}
+				if resp.Debts[0].Paid != 20 || resp.Debts[0].Balance != -30 {
+					t.Errorf("expected the debt to be part paid, got %+v", resp.Debts[0])
+				}
+			},
+		},
+		{
+			name:      "Journal read fails",
+			accountID: "journal_fail",
+			asOf:      time.Date(2025, 1, 25, 0, 0, 0, 0, time.UTC),
+			validate: func(t *testing.T, resp *model.AccountBalanceResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != 500 {
+					t.Fatalf("expected internal server error, got %v", err)
+				}
+			},
+		},
+		{
+			name:      "Account not found",
+			accountID: "account_nonexistent",
+			validate: func(t *testing.T, resp *model.AccountBalanceResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != 404 {
+					t.Fatalf("expected not found, got %v", err)
+				}
+			},
+		},
+		{
+			name:      "Projection read fails",
+			accountID: "balance_fail",
+			validate: func(t *testing.T, resp *model.AccountBalanceResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Message != "failed to get account balance" {
+					t.Fatalf("expected balance error, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := accountService.GetAccountBalance(context.Background(), tt.accountID, tt.asOf)
+			tt.validate(t, resp, err)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// maxAuditRecords is the most audit records returned for a single entity
+const maxAuditRecords = 1000
+
+type AuditInterface interface {
+	GetAuditTrail(ctx context.Context, entityType model.AuditEntityType, entityID string) ([]model.AuditRecordResponseBody, *model.ErrorResponse)
+}
+
+type AuditService struct {
+	repo repository.AuditRepository
+	// entities looks up the accounts and transactions named by their public IDs, audit records are kept under the
+	// internal ID
+	entities repository.DatabaseRepository
+	logger   *slog.Logger
+}
+
+func NewAuditService(repo repository.AuditRepository, entities repository.DatabaseRepository, logger *slog.Logger) *AuditService {
+	logger.InfoContext(context.Background(), "AuditService initialized")
+	return &AuditService{repo: repo, entities: entities, logger: logger}
+}
+
+// GetAuditTrail returns every recorded change to the entity oldest first, an entity nobody has changed has an empty trail
+// and one that does not exist is not found
+func (s *AuditService) GetAuditTrail(ctx context.Context, entityType model.AuditEntityType, entityID string) ([]model.AuditRecordResponseBody, *model.ErrorResponse) {
+	if entityID == "" {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "id is required",
+		}
+	}
+	internalID, err := s.internalID(ctx, entityType, entityID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to look up audited entity", "entityType", entityType, "entityID", entityID, "error", err)
+		return nil, failure(err, "failed to get audit trail")
+	}
+	if internalID == "" {
+		s.logger.InfoContext(ctx, "audited entity not found", "entityType", entityType, "entityID", entityID)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusNotFound,
+			Message: fmt.Sprintf("%s not found", entityType),
+		}
+	}
+	records, err := s.repo.FindAuditRecords(ctx, entityType, internalID, maxAuditRecords)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to find audit records", "entityType", entityType, "entityID", entityID, "error", err)
+		return nil, failure(err, "failed to get audit trail")
+	}
+	resp := make([]model.AuditRecordResponseBody, len(records))
+	for i, record := range records {
+		resp[i] = model.AuditRecordResponseBody{
+			AuditID:    record.ID.Hex(),
+			Actor:      record.Actor,
+			RequestID:  record.RequestID,
+			Timestamp:  record.Timestamp,
+			Action:     record.Action,
+			EntityType: record.EntityType,
+			EntityID:   entityID,
+			Before:     record.Before,
+			After:      record.After,
+		}
+	}
+	return resp, nil
+}
+
+// internalID returns the internal ID of the entity entityID names, or "" when there is no such entity. The entity is
+// looked up by its public ID or, while clients move over to those, by its internal ID
+func (s *AuditService) internalID(ctx context.Context, entityType model.AuditEntityType, entityID string) (string, error) {
+	prefix := publicid.AccountPrefix
+	if entityType == model.AuditEntityTransaction {
+		prefix = publicid.TransactionPrefix
+	}
+	if _, err := bson.ObjectIDFromHex(entityID); err != nil && !publicid.Valid(entityID, prefix) {
+		// neither ID the entity could be known by
+		return "", nil
+	}
+	switch entityType {
+	case model.AuditEntityAccount:
+		account, err := s.entities.GetAccountByID(ctx, entityID)
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && account == nil) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return account.ID.Hex(), nil
+	case model.AuditEntityTransaction:
+		tx, err := s.entities.GetTransactionByID(ctx, entityID)
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && tx == nil) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return tx.ID.Hex(), nil
+	default:
+		return "", fmt.Errorf("unknown audit entity type %q", entityType)
+	}
+}
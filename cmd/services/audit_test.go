@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var (
+	// the mock audit repository fails to read the trails of these transactions
+	auditFailID        = bson.NewObjectID()
+	auditUnavailableID = bson.NewObjectID()
+	// nobody has changed this transaction since it was created
+	unchangedID = bson.NewObjectID()
+)
+
+type MockAuditRepo struct{}
+
+func (m *MockAuditRepo) AppendAuditRecord(ctx context.Context, record model.AuditRecord) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockAuditRepo) FindAuditRecords(ctx context.Context, entityType model.AuditEntityType, entityID string, limit int) ([]model.AuditRecord, error) {
+	switch entityID {
+	case auditFailID.Hex():
+		return nil, errors.New("database error")
+	case auditUnavailableID.Hex():
+		return nil, fmt.Errorf("no reachable servers: %w", repository.ErrUnavailable)
+	case unchangedID.Hex():
+		return nil, nil
+	case newestDebtID.Hex():
+		return []model.AuditRecord{
+			{ID: bson.NewObjectID(), Actor: "anonymous", Action: model.AuditActionCreate, EntityType: entityType, EntityID: entityID},
+		}, nil
+	default:
+		return []model.AuditRecord{
+			{ID: bson.NewObjectID(), Actor: "anonymous", Action: model.AuditActionCreate, EntityType: entityType, EntityID: entityID, After: []byte(`{"balance":-50}`)},
+			{ID: bson.NewObjectID(), Actor: "anonymous", Action: model.AuditActionUpdate, EntityType: entityType, EntityID: entityID, Before: []byte(`{"balance":-50}`), After: []byte(`{"balance":0}`)},
+		}, nil
+	}
+}
+
+func Test_GetAuditTrail(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewAuditService(&MockAuditRepo{}, &MockMongoRepo{}, logger)
+
+	tests := []struct {
+		name       string
+		entityType model.AuditEntityType
+		entityID   string
+		validate   func(t *testing.T, resp []model.AuditRecordResponseBody, err *model.ErrorResponse)
+	}{
+		{
+			name:       "Records oldest first",
+			entityType: model.AuditEntityTransaction,
+			entityID:   bson.NewObjectID().Hex(),
+			validate: func(t *testing.T, resp []model.AuditRecordResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if len(resp) != 2 || resp[0].Action != model.AuditActionCreate || string(resp[1].Before) != `{"balance":-50}` {
+					t.Errorf("unexpected audit trail %+v", resp)
+				}
+			},
+		},
+		{
+			name:       "Unchanged entity has an empty trail",
+			entityType: model.AuditEntityTransaction,
+			entityID:   unchangedID.Hex(),
+			validate: func(t *testing.T, resp []model.AuditRecordResponseBody, err *model.ErrorResponse) {
+				if err != nil || resp == nil || len(resp) != 0 {
+					t.Fatalf("expected an empty trail, got %v (%v)", resp, err)
+				}
+			},
+		},
+		{
+			name:       "Public ID is looked up by the internal ID it names",
+			entityType: model.AuditEntityTransaction,
+			entityID:   debtPublicIDs[newestDebtID],
+			validate: func(t *testing.T, resp []model.AuditRecordResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if len(resp) != 1 || resp[0].EntityID != debtPublicIDs[newestDebtID] {
+					t.Errorf("expected the trail of the newest debt under its public ID, got %+v", resp)
+				}
+			},
+		},
+		{
+			name:       "Account trail",
+			entityType: model.AuditEntityAccount,
+			entityID:   publicid.NewAccountID(),
+			validate: func(t *testing.T, resp []model.AuditRecordResponseBody, err *model.ErrorResponse) {
+				if err != nil || len(resp) != 2 || resp[0].EntityType != model.AuditEntityAccount {
+					t.Fatalf("expected the account's trail, got %+v (%v)", resp, err)
+				}
+			},
+		},
+		{
+			name:       "Unknown public ID is not found",
+			entityType: model.AuditEntityTransaction,
+			entityID:   publicid.NewTransactionID(),
+			validate: func(t *testing.T, resp []model.AuditRecordResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusNotFound || err.Message != "transaction not found" {
+					t.Fatalf("expected transaction not found, got %v (%v)", resp, err)
+				}
+			},
+		},
+		{
+			name:       "Unknown account is not found",
+			entityType: model.AuditEntityAccount,
+			entityID:   "account_nonexistent",
+			validate: func(t *testing.T, resp []model.AuditRecordResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusNotFound || err.Message != "account not found" {
+					t.Fatalf("expected account not found, got %v (%v)", resp, err)
+				}
+			},
+		},
+		{
+			name:       "Lookup fails",
+			entityType: model.AuditEntityTransaction,
+			entityID:   auditFailID.Hex(),
+			validate: func(t *testing.T, resp []model.AuditRecordResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusInternalServerError {
+					t.Fatalf("expected internal server error, got %v", err)
+				}
+			},
+		},
+		{
+			name:       "Lookup is unavailable",
+			entityType: model.AuditEntityTransaction,
+			entityID:   auditUnavailableID.Hex(),
+			validate: func(t *testing.T, resp []model.AuditRecordResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusServiceUnavailable || err.RetryAfter != unavailableRetryAfter {
+					t.Fatalf("expected service unavailable with a retry after, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := service.GetAuditTrail(context.Background(), tt.entityType, tt.entityID)
+			tt.validate(t, resp, err)
+		})
+	}
+}
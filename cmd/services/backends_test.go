@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/audit"
+	"github.com/joolshouston/pismo-technical-test/shared/database"
+	"github.com/joolshouston/pismo-technical-test/shared/lock"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+)
+
+// backendStore is what a storage backend implements
+type backendStore interface {
+	repository.DatabaseRepository
+	repository.AuditRepository
+	repository.LeaseRepository
+}
+
+// Test_StorageBackends runs the services against the real stores that need nothing running rather than a mock, a payment
+// has to discharge the debts it pays off across the transactions, the journal, the balance projection and the audit trail
+func Test_StorageBackends(t *testing.T) {
+	sqlite, err := database.OpenSQLite(filepath.Join(t.TempDir(), "pismo.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer sqlite.Close()
+	sqliteStore := database.NewSQLite(sqlite)
+	if err := sqliteStore.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate sqlite: %v", err)
+	}
+
+	for name, store := range map[string]backendStore{"memory": database.NewMemory(), "sqlite": sqliteStore} {
+		t.Run(name, func(t *testing.T) {
+			testStorageBackend(t, store)
+		})
+	}
+}
+
+func testStorageBackend(t *testing.T, store backendStore) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	repo := audit.NewRepository(store, store, logger)
+	accountService := NewAccountsService(repo, logger)
+	transactionService := NewTransactionService(repo, logger, lock.NewLease(store, logger, lock.DefaultConfig()))
+
+	account, errResp := accountService.CreateAccount(ctx, "12345678900")
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	if _, errResp := accountService.CreateAccount(ctx, "12345678900"); errResp == nil {
+		t.Fatalf("expected an error for a duplicate document number")
+	}
+
+	requests := []struct {
+		key         string
+		operationID model.OperationType
+		amount      float64
+	}{
+		{key: "purchase", operationID: model.OperationTypePurchase, amount: -50},
+		{key: "withdrawal", operationID: model.OperationTypeWithdrawal, amount: -23.5},
+		{key: "payment", operationID: model.OperationTypePayment, amount: 60},
+	}
+	var transactionIDs []string
+	for _, req := range requests {
+		resp, errResp := transactionService.CreateTransaction(ctx, model.TransactionRequestBody{
+			AccountID:   account.AccountID,
+			OperationID: req.operationID,
+			Amount:      req.amount,
+		}, req.key)
+		if errResp != nil {
+			t.Fatalf("expected no error for %s, got %v", req.key, errResp)
+		}
+		transactionIDs = append(transactionIDs, resp.TransactionID)
+	}
+	if _, errResp := transactionService.CreateTransaction(ctx, model.TransactionRequestBody{
+		AccountID:   account.AccountID,
+		OperationID: model.OperationTypePayment,
+		Amount:      60,
+	}, "payment"); errResp != nil {
+		t.Fatalf("expected a replayed payment to succeed, got %v", errResp)
+	}
+
+	// the store keeps the transactions under the internal ID of the account
+	stored, err := store.GetAccountByID(ctx, account.AccountID)
+	if err != nil {
+		t.Fatalf("expected the account to be found by its public ID, got %v", err)
+	}
+	transactions, err := store.FindAllTransactionsForAccountID(ctx, stored.ID.Hex())
+	if err != nil || len(transactions) != 3 {
+		t.Fatalf("expected three transactions, got %d, %v", len(transactions), err)
+	}
+	// the transactions keep the balance they were made with, the payment is only posted against the debts
+	for i, want := range []float64{-50, -23.5, 0} {
+		if transactions[i].Balance != want {
+			t.Errorf("expected %s to be stored with a balance of %v, got %v", requests[i].key, want, transactions[i].Balance)
+		}
+		if transactions[i].PublicID != transactionIDs[i] {
+			t.Errorf("expected %s to be known as %s, got %s", requests[i].key, transactionIDs[i], transactions[i].PublicID)
+		}
+	}
+
+	var exported []float64
+	if errResp := transactionService.ExportTransactions(ctx, model.TransactionFilter{AccountID: account.AccountID}, func(tx model.Transaction) error {
+		exported = append(exported, tx.Balance)
+		return nil
+	}); errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	if !slices.Equal(exported, []float64{0, -13.5, 0}) {
+		t.Errorf("expected the balances the journal gives the transactions to be exported, got %v", exported)
+	}
+
+	balance, errResp := accountService.GetAccountBalance(ctx, account.AccountID, time.Time{})
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	if balance.TotalDebt != 13.5 || balance.OpenDebts != 1 || balance.Version != 3 {
+		t.Errorf("unexpected balance %+v", balance)
+	}
+
+	trialBalance, errResp := NewLedgerService(store, logger).TrialBalance(ctx)
+	if errResp != nil || !trialBalance.Balanced {
+		t.Errorf("expected a balanced trial balance, got %+v, %v", trialBalance, errResp)
+	}
+
+	report, errResp := transactionService.Reconcile(ctx, model.ReconciliationRequestBody{})
+	if errResp != nil || report.TransactionsChecked != 3 || report.TransactionsDrifted != 0 {
+		t.Errorf("expected no drift, got %+v, %v", report, errResp)
+	}
+
+	records, err := store.FindAuditRecords(ctx, model.AuditEntityTransaction, transactions[0].ID.Hex(), 10)
+	if err != nil || len(records) != 1 {
+		t.Errorf("expected the purchase to be audited once when created, got %d, %v", len(records), err)
+	}
+
+	// payments made at the same time take turns, together they discharge the debt exactly once
+	indebted, errResp := accountService.CreateAccount(ctx, "98765432100")
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	if _, errResp := transactionService.CreateTransaction(ctx, model.TransactionRequestBody{
+		AccountID: indebted.AccountID, OperationID: model.OperationTypePurchase, Amount: -50,
+	}, "concurrent-purchase"); errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	var wg sync.WaitGroup
+	for i := range 5 {
+		wg.Go(func() {
+			_, errResp := transactionService.CreateTransaction(ctx, model.TransactionRequestBody{
+				AccountID: indebted.AccountID, OperationID: model.OperationTypePayment, Amount: 20,
+			}, fmt.Sprintf("concurrent-payment-%d", i))
+			if errResp != nil {
+				t.Errorf("expected no error, got %v", errResp)
+			}
+		})
+	}
+	wg.Wait()
+	balance, errResp = accountService.GetAccountBalance(ctx, indebted.AccountID, time.Time{})
+	if errResp != nil || balance.TotalDebt != 0 || balance.AvailableCredit != 50 || balance.Version != 6 {
+		t.Errorf("expected the debt to be paid once and the rest held as credit, got %+v, %v", balance, errResp)
+	}
+}
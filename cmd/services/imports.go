@@ -0,0 +1,526 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/audit"
+	"github.com/joolshouston/pismo-technical-test/shared/export"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
+	"github.com/joolshouston/pismo-technical-test/shared/validation"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	JobTypeAccountImport = "account_import"
+
+	// maxImportLineBytes caps a single NDJSON line so a file without newlines cannot exhaust memory
+	maxImportLineBytes = 1 << 20
+	// importRowTimeout bounds the database calls made for a single row
+	importRowTimeout = 10 * time.Second
+	// importJobStallTimeout is how long a job that has not finished can go without an update before it is reported as
+	// interrupted, well above the time a single row can take
+	importJobStallTimeout = time.Minute
+	// DefaultImportJobRetention is how long a job and its result file are kept after its last update
+	DefaultImportJobRetention = 24 * time.Hour
+)
+
+type ImportsInterface interface {
+	StartAccountImport(ctx context.Context, format export.Format, upload io.Reader) (*model.JobResponseBody, *model.ErrorResponse)
+	GetJob(ctx context.Context, jobID string) (*model.JobResponseBody, *model.ErrorResponse)
+	OpenJobResult(ctx context.Context, jobID string) (io.ReadCloser, *model.ErrorResponse)
+}
+
+// ImportService runs bulk imports in the background. Jobs are kept in the store so any instance sharing it can report
+// on them, result files are written to dir which every instance serving results has to share. A job is forgotten and
+// its result file deleted once it has not been updated for longer than retention
+type ImportService struct {
+	repo      repository.DatabaseRepository
+	jobs      repository.JobRepository
+	logger    *slog.Logger
+	dir       string
+	retention time.Duration
+
+	wg sync.WaitGroup
+}
+
+func NewImportService(repo repository.DatabaseRepository, jobs repository.JobRepository, logger *slog.Logger, dir string, retention time.Duration) *ImportService {
+	logger.InfoContext(context.Background(), "ImportService initialized", "dir", dir, "retention", retention)
+	s := &ImportService{repo: repo, jobs: jobs, logger: logger, dir: dir, retention: retention}
+	s.removeStaleFiles(time.Now())
+	return s
+}
+
+// removeStaleFiles deletes the import files in dir older than the retention, they belong to jobs that expired in a
+// tenant nobody has imported into since, or to uploads lost in a restart. Newer ones are left alone since another
+// instance sharing dir may still be serving them
+func (s *ImportService) removeStaleFiles(now time.Time) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "account-import-*"))
+	if err != nil {
+		s.logger.Error("failed to list import files", "dir", s.dir, "error", err)
+		return
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || now.Sub(info.ModTime()) <= s.retention {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			s.logger.Error("failed to remove stale import file", "path", path, "error", err)
+		}
+	}
+}
+
+// expireJobs forgets the jobs not updated for longer than the retention and deletes their result files
+func (s *ImportService) expireJobs(ctx context.Context, now time.Time) {
+	expired, err := s.jobs.DeleteJobsUpdatedBefore(ctx, now.Add(-s.retention))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to expire import jobs", "error", err)
+		return
+	}
+	for _, jobID := range expired {
+		if err := os.Remove(s.resultPath(jobID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.logger.ErrorContext(ctx, "failed to remove job result", "jobID", jobID, "error", err)
+		}
+		s.logger.InfoContext(ctx, "import job expired", "jobID", jobID)
+	}
+}
+
+func (s *ImportService) resultPath(jobID string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("account-import-%s.ndjson", jobID))
+}
+
+// StartAccountImport spools the upload to disk and starts creating the accounts in the background. The upload is fully
+// read before returning since the request body is gone once the handler has responded
+func (s *ImportService) StartAccountImport(ctx context.Context, format export.Format, upload io.Reader) (*model.JobResponseBody, *model.ErrorResponse) {
+	if format != export.FormatCSV && format != export.FormatNDJSON {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: "import format must be one of csv or ndjson",
+		}
+	}
+	spool, err := os.CreateTemp(s.dir, "account-import-*.upload")
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create import spool file", "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to start import",
+		}
+	}
+	_, err = io.Copy(spool, upload)
+	closeErr := spool.Close()
+	if err != nil || closeErr != nil {
+		os.Remove(spool.Name())
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, &model.ErrorResponse{
+				Status:  http.StatusRequestEntityTooLarge,
+				Message: fmt.Sprintf("upload must not be larger than %d bytes", maxBytesErr.Limit),
+			}
+		}
+		s.logger.ErrorContext(ctx, "failed to read import upload", "error", errors.Join(err, closeErr))
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "failed to read upload",
+		}
+	}
+
+	now := time.Now().UTC()
+	// jobs are only added here, so expiring the old ones as new ones come in keeps the store bounded
+	s.expireJobs(ctx, now)
+	job := model.Job{
+		ID:        bson.NewObjectID().Hex(),
+		Tenant:    tenant.FromContext(ctx),
+		Type:      JobTypeAccountImport,
+		Status:    model.JobStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		os.Remove(spool.Name())
+		s.logger.ErrorContext(ctx, "failed to create import job", "error", err)
+		return nil, failure(err, "failed to start import")
+	}
+
+	s.logger.InfoContext(ctx, "account import accepted", "jobID", job.ID, "format", format)
+	s.wg.Add(1)
+	go s.runAccountImport(audit.Detach(ctx), job, spool.Name(), format)
+	resp := s.jobResponse(job, now)
+	return &resp, nil
+}
+
+func (s *ImportService) GetJob(ctx context.Context, jobID string) (*model.JobResponseBody, *model.ErrorResponse) {
+	job, errResp := s.lookupJob(ctx, jobID)
+	if errResp != nil {
+		return nil, errResp
+	}
+	resp := s.jobResponse(*job, time.Now().UTC())
+	return &resp, nil
+}
+
+// OpenJobResult opens the NDJSON result file of a finished job, a failed job still has the rows processed before it failed
+func (s *ImportService) OpenJobResult(ctx context.Context, jobID string) (io.ReadCloser, *model.ErrorResponse) {
+	job, errResp := s.lookupJob(ctx, jobID)
+	if errResp != nil {
+		return nil, errResp
+	}
+	status := s.jobResponse(*job, time.Now().UTC()).Status
+	if status != model.JobStatusCompleted && status != model.JobStatusFailed {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusConflict,
+			Message: "job has not finished yet",
+		}
+	}
+	f, err := os.Open(s.resultPath(jobID))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to open job result", "jobID", jobID, "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to open job result",
+		}
+	}
+	return f, nil
+}
+
+// lookupJob finds a job of the tenant of ctx that has not expired yet
+func (s *ImportService) lookupJob(ctx context.Context, jobID string) (*model.Job, *model.ErrorResponse) {
+	job, err := s.jobs.GetJob(ctx, jobID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get job", "jobID", jobID, "error", err)
+		return nil, failure(err, "failed to get job")
+	}
+	if job == nil || job.Tenant != tenant.FromContext(ctx) || time.Since(job.UpdatedAt) > s.retention {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusNotFound,
+			Message: "job not found",
+		}
+	}
+	return job, nil
+}
+
+// jobResponse reports a job that has not finished and has gone without an update for longer than importJobStallTimeout
+// as failed, the instance running it stopped and nothing will pick it up again
+func (s *ImportService) jobResponse(job model.Job, now time.Time) model.JobResponseBody {
+	resp := model.JobResponseBody{
+		JobID:          job.ID,
+		Type:           job.Type,
+		Status:         job.Status,
+		Rows:           job.Rows,
+		Created:        job.Created,
+		Duplicates:     job.Duplicates,
+		Invalid:        job.Invalid,
+		Failed:         job.Failed,
+		IgnoredColumns: job.IgnoredColumns,
+		Error:          job.Error,
+		ResultURL:      fmt.Sprintf("/v1/jobs/%s/result", job.ID),
+		CreatedAt:      job.CreatedAt,
+		StartedAt:      job.StartedAt,
+		FinishedAt:     job.FinishedAt,
+	}
+	if job.FinishedAt == nil && now.Sub(job.UpdatedAt) > importJobStallTimeout {
+		resp.Status = model.JobStatusFailed
+		resp.Error = "job was interrupted, the instance running it stopped"
+		resp.FinishedAt = &job.UpdatedAt
+	}
+	return resp
+}
+
+// Wait blocks until every running import has finished
+func (s *ImportService) Wait() {
+	s.wg.Wait()
+}
+
+// runAccountImport runs on a context detached from the request that started the job, since that request is long gone,
+// but the accounts are still audited as created by whoever made it
+func (s *ImportService) runAccountImport(ctx context.Context, job model.Job, spoolPath string, format export.Format) {
+	defer s.wg.Done()
+	defer os.Remove(spoolPath)
+	logger := s.logger.With("jobID", job.ID)
+
+	s.updateJob(ctx, &job, func(job *model.Job) {
+		startedAt := job.UpdatedAt
+		job.Status = model.JobStatusRunning
+		job.StartedAt = &startedAt
+	})
+
+	err := s.importAccounts(ctx, &job, spoolPath, format)
+
+	s.updateJob(ctx, &job, func(job *model.Job) {
+		finishedAt := job.UpdatedAt
+		job.FinishedAt = &finishedAt
+		job.Status = model.JobStatusCompleted
+		if err != nil {
+			job.Status = model.JobStatusFailed
+			job.Error = err.Error()
+		}
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "account import failed", "error", err)
+		return
+	}
+	logger.InfoContext(ctx, "account import completed")
+}
+
+func (s *ImportService) importAccounts(ctx context.Context, job *model.Job, spoolPath string, format export.Format) error {
+	in, err := os.Open(spoolPath)
+	if err != nil {
+		return fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer in.Close()
+	out, err := os.Create(s.resultPath(job.ID))
+	if err != nil {
+		return fmt.Errorf("failed to create result file: %w", err)
+	}
+	defer out.Close()
+	buf := bufio.NewWriter(out)
+	encoder := json.NewEncoder(buf)
+
+	rows, err := newAccountImportReader(format, in)
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return errors.Join(err, buf.Flush())
+		}
+		result := s.importAccountRow(ctx, row)
+		if err := encoder.Encode(result); err != nil {
+			return fmt.Errorf("failed to write result file: %w", err)
+		}
+		ignored := rows.ignoredColumns()
+		s.updateJob(ctx, job, func(job *model.Job) {
+			// columns are only ever added to what is ignored
+			if len(job.IgnoredColumns) != len(ignored) {
+				job.IgnoredColumns = slices.Clone(ignored)
+			}
+			job.Rows++
+			switch result.Status {
+			case model.AccountImportRowCreated:
+				job.Created++
+			case model.AccountImportRowDuplicate:
+				job.Duplicates++
+			case model.AccountImportRowInvalid:
+				job.Invalid++
+			case model.AccountImportRowFailed:
+				job.Failed++
+			}
+		})
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write result file: %w", err)
+	}
+	return nil
+}
+
+func (s *ImportService) importAccountRow(ctx context.Context, row accountImportRow) model.AccountImportRowResult {
+	result := model.AccountImportRowResult{
+		Row:            row.number,
+		DocumentNumber: row.documentNumber,
+		Status:         model.AccountImportRowInvalid,
+	}
+	if row.err != nil {
+		result.Error = row.err.Error()
+		return result
+	}
+	// a row is held to the same rules as an account created through the API
+	if errResp := validation.Validate(model.AccountRequestBody{DocumentNumber: row.documentNumber}); errResp != nil {
+		result.Error = errResp.Message
+		result.Errors = errResp.Errors
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, importRowTimeout)
+	defer cancel()
+	existing, err := s.repo.GetAccountByDocumentNumber(ctx, row.documentNumber)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to check account existence", "row", row.number, "error", err)
+		result.Status = model.AccountImportRowFailed
+		result.Error = "failed to check account existence"
+		return result
+	}
+	if existing != nil {
+		result.Status = model.AccountImportRowDuplicate
+		result.AccountID = existing.PublicID
+		return result
+	}
+	acc, err := createAccount(ctx, s.repo, row.documentNumber)
+	if errors.Is(err, repository.ErrDuplicate) {
+		// created by someone else since the lookup above
+		result.Status = model.AccountImportRowDuplicate
+		if existing, err := s.repo.GetAccountByDocumentNumber(ctx, row.documentNumber); err == nil && existing != nil {
+			result.AccountID = existing.PublicID
+		}
+		return result
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create account", "row", row.number, "error", err)
+		result.Status = model.AccountImportRowFailed
+		result.Error = "failed to create account"
+		return result
+	}
+	result.Status = model.AccountImportRowCreated
+	result.AccountID = acc.PublicID
+	return result
+}
+
+// updateJob applies fn to job, stamped with the time of the update, and writes it to the store. A failed write is only
+// logged, the job carries on and the next update writes its progress so far
+func (s *ImportService) updateJob(ctx context.Context, job *model.Job, fn func(job *model.Job)) {
+	job.UpdatedAt = time.Now().UTC()
+	fn(job)
+	ctx, cancel := context.WithTimeout(ctx, importRowTimeout)
+	defer cancel()
+	if err := s.jobs.UpdateJob(ctx, *job); err != nil {
+		s.logger.ErrorContext(ctx, "failed to update import job", "jobID", job.ID, "error", err)
+	}
+}
+
+// accountImportRow is a single row of an upload, err is set when the row itself could not be parsed
+type accountImportRow struct {
+	number         int
+	documentNumber string
+	err            error
+}
+
+// accountImportReader yields upload rows one by one, it returns io.EOF once the upload is exhausted and any other error
+// only when the upload as a whole cannot be read any further
+type accountImportReader interface {
+	next() (accountImportRow, error)
+	// ignoredColumns returns the names, sorted, of the columns or fields read so far other than document_number
+	ignoredColumns() []string
+}
+
+func newAccountImportReader(format export.Format, r io.Reader) (accountImportReader, error) {
+	switch format {
+	case export.FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		header, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("upload is empty")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV header: %w", err)
+		}
+		column := -1
+		var ignored []string
+		for i, name := range header {
+			name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+			if strings.EqualFold(name, "document_number") && column < 0 {
+				column = i
+				continue
+			}
+			ignored = append(ignored, name)
+		}
+		if column < 0 {
+			return nil, errors.New("CSV header must contain a document_number column")
+		}
+		slices.Sort(ignored)
+		return &csvAccountImportReader{reader: reader, columns: len(header), column: column, ignored: slices.Compact(ignored)}, nil
+	case export.FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+		return &ndjsonAccountImportReader{scanner: scanner}, nil
+	default:
+		return nil, export.ErrUnsupportedFormat
+	}
+}
+
+// csvAccountImportReader reads the document_number column, the other columns are ignored
+type csvAccountImportReader struct {
+	reader  *csv.Reader
+	columns int
+	column  int
+	ignored []string
+	rows    int
+}
+
+func (c *csvAccountImportReader) ignoredColumns() []string {
+	return c.ignored
+}
+
+func (c *csvAccountImportReader) next() (accountImportRow, error) {
+	record, err := c.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return accountImportRow{}, io.EOF
+	}
+	c.rows++
+	row := accountImportRow{number: c.rows}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		row.err = fmt.Errorf("malformed CSV row: %w", parseErr.Err)
+		return row, nil
+	}
+	if err != nil {
+		return row, fmt.Errorf("failed to read CSV row %d: %w", c.rows, err)
+	}
+	if len(record) != c.columns {
+		row.err = fmt.Errorf("row has %d fields, the header has %d", len(record), c.columns)
+		return row, nil
+	}
+	row.documentNumber = strings.TrimSpace(record[c.column])
+	return row, nil
+}
+
+// ndjsonAccountImportReader reads the document_number field of every line, the other fields are ignored
+type ndjsonAccountImportReader struct {
+	scanner *bufio.Scanner
+	lines   int
+	ignored []string
+}
+
+func (n *ndjsonAccountImportReader) ignoredColumns() []string {
+	return n.ignored
+}
+
+func (n *ndjsonAccountImportReader) next() (accountImportRow, error) {
+	for n.scanner.Scan() {
+		n.lines++
+		line := strings.TrimSpace(n.scanner.Text())
+		if line == "" {
+			continue
+		}
+		row := accountImportRow{number: n.lines}
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			row.err = errors.New("line is not a JSON object")
+			return row, nil
+		}
+		for name := range fields {
+			if i, found := slices.BinarySearch(n.ignored, name); !found && name != "document_number" {
+				n.ignored = slices.Insert(n.ignored, i, name)
+			}
+		}
+		documentNumber, ok := fields["document_number"].(string)
+		if !ok && fields["document_number"] != nil {
+			row.err = errors.New("document_number must be a string")
+			return row, nil
+		}
+		row.documentNumber = strings.TrimSpace(documentNumber)
+		return row, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return accountImportRow{}, fmt.Errorf("failed to read NDJSON line %d: %w", n.lines+1, err)
+	}
+	return accountImportRow{}, io.EOF
+}
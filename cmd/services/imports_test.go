@@ -0,0 +1,296 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/database"
+	"github.com/joolshouston/pismo-technical-test/shared/export"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
+	"github.com/joolshouston/pismo-technical-test/shared/validation"
+)
+
+func Test_StartAccountImport(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	tests := []struct {
+		name     string
+		format   export.Format
+		upload   string
+		validate func(t *testing.T, job *model.JobResponseBody, results []model.AccountImportRowResult)
+	}{
+		{
+			name:   "CSV with every kind of row",
+			format: export.FormatCSV,
+			upload: "document_number\n123456789\n111.222.333-44\n\"\"\n1234567895\n12 34\n1,2\n98765432100\n",
+			validate: func(t *testing.T, job *model.JobResponseBody, results []model.AccountImportRowResult) {
+				if job.Status != model.JobStatusCompleted {
+					t.Fatalf("expected job to complete, got %s (%s)", job.Status, job.Error)
+				}
+				if job.Rows != 7 || job.Created != 1 || job.Duplicates != 2 || job.Invalid != 3 || job.Failed != 1 {
+					t.Fatalf("unexpected job counts %+v", job)
+				}
+				expected := []model.AccountImportRowStatus{
+					model.AccountImportRowCreated,
+					model.AccountImportRowDuplicate,
+					model.AccountImportRowInvalid,
+					model.AccountImportRowFailed,
+					model.AccountImportRowInvalid,
+					model.AccountImportRowInvalid,
+					// created by another request after the lookup
+					model.AccountImportRowDuplicate,
+				}
+				for i, status := range expected {
+					if results[i].Status != status {
+						t.Errorf("expected row %d to be %s, got %s", i+1, status, results[i].Status)
+					}
+					if results[i].Row != i+1 {
+						t.Errorf("expected row number %d, got %d", i+1, results[i].Row)
+					}
+				}
+				if results[1].AccountID == "" {
+					t.Errorf("expected duplicate row to reference the existing account")
+				}
+				for i, code := range map[int]string{2: validation.CodeRequired, 4: validation.CodeFormat} {
+					if len(results[i].Errors) != 1 || results[i].Errors[0].Field != "document_number" || results[i].Errors[0].Code != code {
+						t.Errorf("expected row %d to break the %s rule of document_number, got %+v", i+1, code, results[i])
+					}
+				}
+			},
+		},
+		{
+			name:   "NDJSON with a malformed line",
+			format: export.FormatNDJSON,
+			upload: "{\"document_number\":\"123456789\"}\n\n{not json}\n{\"document_number\":42}\n{\"document_number\":\"98765432100\",\"name\":\"Ana\"}\n",
+			validate: func(t *testing.T, job *model.JobResponseBody, results []model.AccountImportRowResult) {
+				if job.Status != model.JobStatusCompleted {
+					t.Fatalf("expected job to complete, got %s (%s)", job.Status, job.Error)
+				}
+				if job.Rows != 4 || job.Created != 1 || job.Duplicates != 1 || job.Invalid != 2 {
+					t.Fatalf("unexpected job counts %+v", job)
+				}
+				if results[1].Row != 3 || results[1].Error != "line is not a JSON object" {
+					t.Errorf("unexpected result for malformed line %+v", results[1])
+				}
+				// the name is dropped and the account imported from the document number
+				if results[3].Row != 5 || results[3].Status != model.AccountImportRowDuplicate {
+					t.Errorf("expected the line with a name field to be imported, got %+v", results[3])
+				}
+				if !slices.Equal(job.IgnoredColumns, []string{"name"}) {
+					t.Errorf("expected the name field to be reported as ignored, got %v", job.IgnoredColumns)
+				}
+			},
+		},
+		{
+			name:   "CSV with columns accounts cannot hold",
+			format: export.FormatCSV,
+			upload: "name,document_number,email\nAna,123456789,ana@example.com\n",
+			validate: func(t *testing.T, job *model.JobResponseBody, results []model.AccountImportRowResult) {
+				if job.Status != model.JobStatusCompleted || job.Rows != 1 || job.Created != 1 {
+					t.Fatalf("expected the row to be imported, got %+v", job)
+				}
+				if results[0].DocumentNumber != "123456789" {
+					t.Errorf("expected the document number to be read from its column, got %+v", results[0])
+				}
+				if !slices.Equal(job.IgnoredColumns, []string{"email", "name"}) {
+					t.Errorf("expected the other columns to be reported as ignored, got %v", job.IgnoredColumns)
+				}
+			},
+		},
+		{
+			name:   "CSV without a document_number column",
+			format: export.FormatCSV,
+			upload: "name\nAna\n",
+			validate: func(t *testing.T, job *model.JobResponseBody, results []model.AccountImportRowResult) {
+				if job.Status != model.JobStatusFailed {
+					t.Fatalf("expected job to fail, got %s", job.Status)
+				}
+				if !strings.Contains(job.Error, "document_number") {
+					t.Errorf("expected error to mention document_number, got %s", job.Error)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewImportService(repo, database.NewMemory(), logger, t.TempDir(), DefaultImportJobRetention)
+			job, errResp := service.StartAccountImport(context.Background(), tt.format, strings.NewReader(tt.upload))
+			if errResp != nil {
+				t.Fatalf("expected no error, got %v", errResp)
+			}
+			service.Wait()
+
+			job, errResp = service.GetJob(context.Background(), job.JobID)
+			if errResp != nil {
+				t.Fatalf("expected no error getting job, got %v", errResp)
+			}
+			var results []model.AccountImportRowResult
+			result, errResp := service.OpenJobResult(context.Background(), job.JobID)
+			if errResp != nil {
+				t.Fatalf("expected no error opening result, got %v", errResp)
+			}
+			defer result.Close()
+			scanner := bufio.NewScanner(result)
+			for scanner.Scan() {
+				var row model.AccountImportRowResult
+				if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+					t.Fatalf("expected no error decoding result row, got %v", err)
+				}
+				results = append(results, row)
+			}
+			tt.validate(t, job, results)
+		})
+	}
+}
+
+func Test_GetJob(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewImportService(&MockMongoRepo{}, database.NewMemory(), logger, t.TempDir(), DefaultImportJobRetention)
+
+	if _, err := service.GetJob(context.Background(), "missing"); err == nil || err.Status != http.StatusNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if _, err := service.OpenJobResult(context.Background(), "missing"); err == nil || err.Status != http.StatusNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if _, err := service.StartAccountImport(context.Background(), "xlsx", strings.NewReader("")); err == nil || err.Status != http.StatusUnsupportedMediaType {
+		t.Errorf("expected unsupported media type, got %v", err)
+	}
+}
+
+func Test_GetJob_OtherTenant(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewImportService(&MockMongoRepo{}, database.NewMemory(), logger, t.TempDir(), DefaultImportJobRetention)
+
+	ctx := tenant.WithID(context.Background(), "program-a")
+	job, errResp := service.StartAccountImport(ctx, export.FormatCSV, strings.NewReader("document_number\n123456789\n"))
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	service.Wait()
+
+	if _, err := service.GetJob(ctx, job.JobID); err != nil {
+		t.Errorf("expected the job, got %v", err)
+	}
+	other := tenant.WithID(context.Background(), "program-b")
+	if _, err := service.GetJob(other, job.JobID); err == nil || err.Status != http.StatusNotFound {
+		t.Errorf("expected another tenant not to find the job, got %v", err)
+	}
+	if _, err := service.OpenJobResult(other, job.JobID); err == nil || err.Status != http.StatusNotFound {
+		t.Errorf("expected another tenant not to find the result, got %v", err)
+	}
+}
+
+func Test_ImportJobExpiry(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "account-import-stale.ndjson")
+	if err := os.WriteFile(stale, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	jobs := database.NewMemory()
+	service := NewImportService(&MockMongoRepo{}, jobs, logger, dir, time.Hour)
+	if _, err := os.Stat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected an import file older than the retention to be removed on startup, got %v", err)
+	}
+
+	first, errResp := service.StartAccountImport(context.Background(), export.FormatCSV, strings.NewReader("document_number\n123456789\n"))
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	service.Wait()
+	resultPath := service.resultPath(first.JobID)
+	job, err := jobs.GetJob(context.Background(), first.JobID)
+	if err != nil || job == nil {
+		t.Fatalf("expected the job to be stored, got %v and %v", job, err)
+	}
+	job.UpdatedAt = time.Now().UTC().Add(-2 * time.Hour)
+	if err := jobs.UpdateJob(context.Background(), *job); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.GetJob(context.Background(), first.JobID); err == nil || err.Status != http.StatusNotFound {
+		t.Errorf("expected an expired job not to be found, got %v", err)
+	}
+	if _, err := service.OpenJobResult(context.Background(), first.JobID); err == nil || err.Status != http.StatusNotFound {
+		t.Errorf("expected the result of an expired job not to be found, got %v", err)
+	}
+
+	second, errResp := service.StartAccountImport(context.Background(), export.FormatCSV, strings.NewReader("document_number\n123456789\n"))
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	service.Wait()
+	if job, err := jobs.GetJob(context.Background(), first.JobID); err != nil || job != nil {
+		t.Errorf("expected the expired job to be forgotten once another starts, got %v and %v", job, err)
+	}
+	if _, err := os.Stat(resultPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the result file of the expired job to be removed, got %v", err)
+	}
+	if _, err := service.GetJob(context.Background(), second.JobID); err != nil {
+		t.Errorf("expected the new job, got %v", err)
+	}
+}
+
+func Test_GetJob_OtherInstance(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	jobs := database.NewMemory()
+	dir := t.TempDir()
+	service := NewImportService(&MockMongoRepo{}, jobs, logger, dir, DefaultImportJobRetention)
+	job, errResp := service.StartAccountImport(context.Background(), export.FormatCSV, strings.NewReader("document_number\n123456789\n"))
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	service.Wait()
+
+	// another instance sharing the store and the directory, or this one after a restart
+	other := NewImportService(&MockMongoRepo{}, jobs, logger, dir, DefaultImportJobRetention)
+	got, errResp := other.GetJob(context.Background(), job.JobID)
+	if errResp != nil {
+		t.Fatalf("expected the job, got %v", errResp)
+	}
+	if got.Status != model.JobStatusCompleted || got.Rows != 1 || got.Created != 1 {
+		t.Errorf("expected the completed job with one account created, got %+v", got)
+	}
+	result, errResp := other.OpenJobResult(context.Background(), job.JobID)
+	if errResp != nil {
+		t.Fatalf("expected the result, got %v", errResp)
+	}
+	result.Close()
+
+	// a job whose instance stopped while running it
+	stalled := model.Job{
+		ID:        "stalled",
+		Type:      JobTypeAccountImport,
+		Status:    model.JobStatusRunning,
+		CreatedAt: time.Now().UTC().Add(-time.Hour),
+		UpdatedAt: time.Now().UTC().Add(-time.Hour),
+	}
+	if err := jobs.CreateJob(context.Background(), stalled); err != nil {
+		t.Fatal(err)
+	}
+	got, errResp = other.GetJob(context.Background(), stalled.ID)
+	if errResp != nil {
+		t.Fatalf("expected the job, got %v", errResp)
+	}
+	if got.Status != model.JobStatusFailed || got.Error == "" || got.FinishedAt == nil {
+		t.Errorf("expected a job no longer updated to be reported as failed, got %+v", got)
+	}
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/ledger"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+)
+
+type LedgerInterface interface {
+	TrialBalance(ctx context.Context) (*model.TrialBalanceResponseBody, *model.ErrorResponse)
+}
+
+type LedgerService struct {
+	repo   repository.DatabaseRepository
+	logger *slog.Logger
+}
+
+func NewLedgerService(repo repository.DatabaseRepository, logger *slog.Logger) *LedgerService {
+	logger.InfoContext(context.Background(), "LedgerService initialized")
+	return &LedgerService{repo: repo, logger: logger}
+}
+
+// TrialBalance totals the journal per ledger account. Every entry is balanced when it is posted so an unbalanced trial
+// balance means the journal has been changed outside the service, it is logged but still returned for the accountants
+func (s *LedgerService) TrialBalance(ctx context.Context) (*model.TrialBalanceResponseBody, *model.ErrorResponse) {
+	lines, err := s.repo.TrialBalance(ctx)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to total journal", "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to get trial balance",
+		}
+	}
+	resp := ledger.NewTrialBalance(lines)
+	if !resp.Balanced {
+		s.logger.ErrorContext(ctx, "trial balance does not balance", "debitCents", resp.TotalDebitCents, "creditCents", resp.TotalCreditCents)
+	}
+	return &resp, nil
+}
+
+// journalBalances gives transactions the balances the journal has for them now, reading the journal of each account
+// once however many of its transactions are handed to it
+type journalBalances struct {
+	repo     repository.DatabaseRepository
+	postedBy time.Time
+	accounts map[string]map[string]int64
+}
+
+func newJournalBalances(repo repository.DatabaseRepository) *journalBalances {
+	return &journalBalances{repo: repo, postedBy: time.Now().UTC(), accounts: make(map[string]map[string]int64)}
+}
+
+// apply sets the balance of the transaction to the one the journal gives it
+func (b *journalBalances) apply(ctx context.Context, tx *model.Transaction) error {
+	balances, ok := b.accounts[tx.AccountID]
+	if !ok {
+		entries, err := b.repo.FindJournalEntries(ctx, tx.AccountID, b.postedBy)
+		if err != nil {
+			return err
+		}
+		balances = ledger.TransactionBalances(entries)
+		b.accounts[tx.AccountID] = balances
+	}
+	ledger.JournalBalance(tx, balances)
+	return nil
+}
+
+// findJournalTransactions returns the account's transactions, oldest first, with the balances the journal gives them now
+func findJournalTransactions(ctx context.Context, repo repository.DatabaseRepository, accountID string) ([]model.Transaction, error) {
+	transactions, err := repo.FindAllTransactionsForAccountID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	balances := newJournalBalances(repo)
+	for i := range transactions {
+		if err := balances.apply(ctx, &transactions[i]); err != nil {
+			return nil, err
+		}
+	}
+	return transactions, nil
+}
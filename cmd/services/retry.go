@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/lock"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+)
+
+const (
+	// unavailableRetryAfter is the Retry-After, in seconds, sent once the retries have run out
+	unavailableRetryAfter = 1
+	// conflictRetryAfter is the Retry-After, in seconds, sent when a concurrent request kept winning the race
+	conflictRetryAfter = 1
+)
+
+// retryPolicy bounds how often and for how long a service retries work that failed with repository.ErrUnavailable or
+// repository.ErrConflict
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration // wait after the first failure, doubled after every further failure
+	maxDelay  time.Duration
+}
+
+// defaultRetryPolicy rides out a replica set election, which usually completes within a couple of seconds
+var defaultRetryPolicy = retryPolicy{
+	attempts:  4,
+	baseDelay: 100 * time.Millisecond,
+	maxDelay:  time.Second,
+}
+
+type retryingKey struct{}
+
+// do runs fn until it returns anything but a retryable response or the attempts run out. When ctx is already being retried by an
+// enclosing call fn only runs once, the enclosing call retries the whole unit including any transaction it started. A
+// response from fn once ctx has lost an account lock is a conflict, it is not retried
+func (p retryPolicy) do(ctx context.Context, logger *slog.Logger, fn func(ctx context.Context) *model.ErrorResponse) *model.ErrorResponse {
+	if ctx.Value(retryingKey{}) != nil {
+		return fn(ctx)
+	}
+	ctx = context.WithValue(ctx, retryingKey{}, true)
+	delay := p.baseDelay
+	for attempt := 1; ; attempt++ {
+		errResp := fn(ctx)
+		if errResp != nil && errors.Is(context.Cause(ctx), lock.ErrLost) {
+			// the work stopped and rolled back as it lost the lock on an account, which someone else may hold now
+			return &model.ErrorResponse{
+				Status:     http.StatusConflict,
+				Message:    "the account lock was lost before the work finished, try again",
+				RetryAfter: conflictRetryAfter,
+			}
+		}
+		if !retryable(errResp) || attempt >= p.attempts {
+			return errResp
+		}
+		// half the delay plus up to as much again at random so instances failing together do not retry together
+		wait := delay/2 + rand.N(delay/2+1)
+		logger.WarnContext(ctx, "retrying after a transient database error", "status", errResp.Status, "attempt", attempt, "wait", wait.String())
+		select {
+		case <-ctx.Done():
+			return errResp
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, p.maxDelay)
+	}
+}
+
+// retryable reports whether errResp tells the client to try again, which the service does itself first. The work read
+// everything it needed again when it is retried so a conflict is not retried from stale data
+func retryable(errResp *model.ErrorResponse) bool {
+	return errResp != nil && errResp.RetryAfter > 0
+}
+
+// failure is the response for work that failed with err, 503 with a Retry-After when err may go away on its own and
+// 409 with a Retry-After when a concurrent request changed what the work read
+func failure(err error, message string) *model.ErrorResponse {
+	if errors.Is(err, repository.ErrUnavailable) {
+		return &model.ErrorResponse{
+			Status:     http.StatusServiceUnavailable,
+			Message:    "the database is temporarily unavailable, " + message,
+			RetryAfter: unavailableRetryAfter,
+		}
+	}
+	if errors.Is(err, repository.ErrConflict) {
+		return &model.ErrorResponse{
+			Status:     http.StatusConflict,
+			Message:    "a concurrent request changed the same data, " + message,
+			RetryAfter: conflictRetryAfter,
+		}
+	}
+	return &model.ErrorResponse{
+		Status:  http.StatusInternalServerError,
+		Message: message,
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+)
+
+func Test_RetryPolicy(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	policy := retryPolicy{attempts: 3}
+	unavailable := failure(fmt.Errorf("write conflict: %w", repository.ErrUnavailable), "failed")
+
+	tests := []struct {
+		name             string
+		results          []*model.ErrorResponse
+		expectedStatus   int
+		expectedAttempts int
+	}{
+		{name: "Succeeds", results: []*model.ErrorResponse{nil}, expectedAttempts: 1},
+		{name: "Succeeds after transient errors", results: []*model.ErrorResponse{unavailable, unavailable, nil}, expectedAttempts: 3},
+		{name: "Gives up", results: []*model.ErrorResponse{unavailable, unavailable, unavailable, nil}, expectedStatus: http.StatusServiceUnavailable, expectedAttempts: 3},
+		{name: "Does not retry other errors", results: []*model.ErrorResponse{{Status: http.StatusInternalServerError}, nil}, expectedStatus: http.StatusInternalServerError, expectedAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			errResp := policy.do(context.Background(), logger, func(ctx context.Context) *model.ErrorResponse {
+				attempts++
+				return tt.results[attempts-1]
+			})
+			status := 0
+			if errResp != nil {
+				status = errResp.Status
+			}
+			if status != tt.expectedStatus || attempts != tt.expectedAttempts {
+				t.Errorf("expected status %d after %d attempts, got %d after %d", tt.expectedStatus, tt.expectedAttempts, status, attempts)
+			}
+		})
+	}
+}
+
+func Test_RetryPolicyNested(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	policy := retryPolicy{attempts: 3}
+	unavailable := failure(repository.ErrUnavailable, "failed")
+
+	outer, inner := 0, 0
+	policy.do(context.Background(), logger, func(ctx context.Context) *model.ErrorResponse {
+		outer++
+		return policy.do(ctx, logger, func(ctx context.Context) *model.ErrorResponse {
+			inner++
+			return unavailable
+		})
+	})
+	if outer != 3 || inner != 3 {
+		t.Errorf("expected only the outer call to retry, got %d outer and %d inner attempts", outer, inner)
+	}
+}
+
+func Test_Failure(t *testing.T) {
+	if resp := failure(fmt.Errorf("find: %w", repository.ErrUnavailable), "failed to get account"); resp.Status != http.StatusServiceUnavailable || resp.RetryAfter != unavailableRetryAfter {
+		t.Errorf("expected status 503 with a Retry-After, got %+v", resp)
+	}
+	if resp := failure(fmt.Errorf("update: %w", repository.ErrConflict), "failed to update transaction"); resp.Status != http.StatusConflict || !retryable(resp) {
+		t.Errorf("expected a retryable status 409, got %+v", resp)
+	}
+	if resp := failure(fmt.Errorf("decode"), "failed to get account"); resp.Status != http.StatusInternalServerError || resp.RetryAfter != 0 {
+		t.Errorf("expected status 500 without a Retry-After, got %+v", resp)
+	}
+}
@@ -5,53 +5,214 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math"
 	"net/http"
+	"slices"
 	"time"
 
+	"github.com/joolshouston/pismo-technical-test/shared/ledger"
+	"github.com/joolshouston/pismo-technical-test/shared/lock"
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/outbox"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
 	"github.com/joolshouston/pismo-technical-test/shared/repository"
-	"go.mongodb.org/mongo-driver/v2/mongo"
+	"github.com/joolshouston/pismo-technical-test/shared/statement"
+	"github.com/joolshouston/pismo-technical-test/shared/validation"
+	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 type TransactionsInferface interface {
 	CreateTransaction(ctx context.Context, transaction model.TransactionRequestBody, idempotencyKey string) (*model.TransactionResponseBody, *model.ErrorResponse)
+	ExportTransactions(ctx context.Context, filter model.TransactionFilter, fn func(model.Transaction) error) *model.ErrorResponse
+	CreateTransactionsBatch(ctx context.Context, batch model.TransactionBatchRequestBody) (*model.TransactionBatchResponseBody, *model.ErrorResponse)
+	BuildStatement(ctx context.Context, accountID string, from time.Time, to time.Time, currency string) (*statement.Statement, *model.ErrorResponse)
+	Reconcile(ctx context.Context, req model.ReconciliationRequestBody) (*model.ReconciliationResponseBody, *model.ErrorResponse)
 }
 
+// MaxBatchSize is the most items a single batch request may carry
+const MaxBatchSize = 1000
+
+var (
+	// errBatchRejected rolls back an atomic batch once one of its items has been rejected
+	errBatchRejected = errors.New("batch item rejected")
+	// errTransactionRejected rolls back a transaction whose request turned out to be invalid part way through
+	errTransactionRejected = errors.New("transaction rejected")
+)
+
 type TransactionService struct {
 	repo   repository.DatabaseRepository
 	logger *slog.Logger
+	retry  retryPolicy
+	// locker makes the transactions of an account run one at a time, across instances when it is shared between them
+	locker lock.Locker
 }
 
-func NewTransactionService(repo repository.DatabaseRepository, logger *slog.Logger) *TransactionService {
+func NewTransactionService(repo repository.DatabaseRepository, logger *slog.Logger, locker lock.Locker) *TransactionService {
 	logger.InfoContext(context.Background(), "TransactionService initialized")
-	return &TransactionService{repo: repo, logger: logger}
+	return &TransactionService{repo: repo, logger: logger, retry: defaultRetryPolicy, locker: locker}
 }
 
 func (s *TransactionService) CreateTransaction(ctx context.Context, transaction model.TransactionRequestBody, idempotencyKey string) (*model.TransactionResponseBody, *model.ErrorResponse) {
+	resp, _, errResp := s.createTransaction(ctx, transaction, idempotencyKey)
+	return resp, errResp
+}
+
+// createTransaction does the work for CreateTransaction, it also reports whether the response was replayed from an
+// earlier request with the same idempotency key so the batch endpoint can tell the two apart.
+// The transaction, the debts a payment discharges and the events describing them are committed together, a transient
+// failure retries all of them from the idempotency lookup on. The account is locked throughout so its debts are not
+// read by another transaction while this one is discharging them
+func (s *TransactionService) createTransaction(ctx context.Context, transaction model.TransactionRequestBody, idempotencyKey string) (*model.TransactionResponseBody, bool, *model.ErrorResponse) {
+	var (
+		resp     *model.TransactionResponseBody
+		replayed bool
+	)
+	accountID, errResp := s.internalAccountID(ctx, transaction.AccountID)
+	if errResp != nil {
+		return nil, false, errResp
+	}
+	transaction.AccountID = accountID
+	ctx, unlock, errResp := s.lockAccounts(ctx, transaction.AccountID)
+	if errResp != nil {
+		return nil, false, errResp
+	}
+	defer unlock()
+	errResp = s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		var errResp *model.ErrorResponse
+		err := s.repo.WithTransaction(ctx, func(ctx context.Context) error {
+			resp, replayed, errResp = s.applyTransaction(ctx, transaction, idempotencyKey)
+			if errResp != nil {
+				return errTransactionRejected
+			}
+			return nil
+		})
+		if errResp != nil {
+			return errResp
+		}
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to commit transaction", "error", err)
+			return failure(err, "failed to create transaction")
+		}
+		return nil
+	})
+	if errResp != nil {
+		return nil, false, errResp
+	}
+	return resp, replayed, nil
+}
+
+// internalAccountID returns the internal ID of the account accountID is the public ID of, which its transactions,
+// balance and lock are kept under. Any other ID is returned as it is, internal IDs are still accepted while clients
+// move over to public IDs and an ID matching no account is reported as not found by the lookup that follows
+func (s *TransactionService) internalAccountID(ctx context.Context, accountID string) (string, *model.ErrorResponse) {
+	if !publicid.Valid(accountID, publicid.AccountPrefix) {
+		return accountID, nil
+	}
+	var account *model.Account
+	errResp := s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		var err error
+		account, err = s.repo.GetAccountByID(ctx, accountID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			s.logger.ErrorContext(ctx, "failed to get account", "error", err)
+			return failure(err, "failed to get account")
+		}
+		return nil
+	})
+	if errResp != nil {
+		return "", errResp
+	}
+	if account == nil {
+		return accountID, nil
+	}
+	return account.ID.Hex(), nil
+}
+
+// publicAccountID returns the public ID of the account with the internal ID, or the internal ID itself when there is
+// no such account for transactions left behind by one
+func (s *TransactionService) publicAccountID(ctx context.Context, accountID string) (string, error) {
+	account, err := s.repo.GetAccountByID(ctx, accountID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return accountID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return account.PublicID, nil
+}
+
+type lockedAccountsKey struct{}
+
+// lockAccounts locks the accounts in ID order, so two requests locking some of the same accounts cannot each hold one
+// the other is waiting for. Accounts ctx already holds are skipped, the work runs under the locks of the enclosing call.
+// The returned ctx marks the accounts as held and is canceled as soon as one of their locks is lost, unlock lets go of
+// the locks taken here. Call it outside of any database transaction
+func (s *TransactionService) lockAccounts(ctx context.Context, accountIDs ...string) (context.Context, func(), *model.ErrorResponse) {
+	held, _ := ctx.Value(lockedAccountsKey{}).(map[string]bool)
+	locked := maps.Clone(held)
+	if locked == nil {
+		locked = make(map[string]bool, len(accountIDs))
+	}
+	var unlocks []func()
+	unlock := func() {
+		for _, unlock := range slices.Backward(unlocks) {
+			unlock()
+		}
+	}
+	for _, accountID := range slices.Compact(slices.Sorted(slices.Values(accountIDs))) {
+		if locked[accountID] {
+			continue
+		}
+		lockedCtx, accountUnlock, err := s.locker.Lock(ctx, "account/"+accountID)
+		if errors.Is(err, lock.ErrTimeout) {
+			unlock()
+			s.logger.WarnContext(ctx, "timed out waiting for the account lock", "accountID", accountID)
+			return nil, nil, &model.ErrorResponse{
+				Status:     http.StatusConflict,
+				Message:    "the account is busy with another transaction, try again",
+				RetryAfter: conflictRetryAfter,
+			}
+		}
+		if err != nil {
+			unlock()
+			s.logger.ErrorContext(ctx, "failed to lock account", "accountID", accountID, "error", err)
+			return nil, nil, failure(err, "failed to lock the account")
+		}
+		unlocks = append(unlocks, accountUnlock)
+		locked[accountID] = true
+		ctx = lockedCtx
+	}
+	return context.WithValue(ctx, lockedAccountsKey{}, locked), unlock, nil
+}
+
+// applyTransaction validates and writes a transaction, it must be called inside repo.WithTransaction
+func (s *TransactionService) applyTransaction(ctx context.Context, transaction model.TransactionRequestBody, idempotencyKey string) (*model.TransactionResponseBody, bool, *model.ErrorResponse) {
 	s.logger.InfoContext(ctx, "creating transaction", "accountID", transaction.AccountID, "operationTypeID", transaction.OperationID.String(), "amount", transaction.Amount)
 
 	// Check if a transaction with the same idempotency key exists
 	existingTx, err := s.repo.FindTransactionByIdempotencyKey(ctx, idempotencyKey)
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to check existing transaction by idempotency key", "error", err)
-		return nil, &model.ErrorResponse{
-			Status:  http.StatusBadRequest,
-			Message: fmt.Sprintf("failed to check existing transaction by idempotency key"),
-		}
+		return nil, false, failure(err, "failed to check existing transaction by idempotency key")
 	}
 
 	if existingTx != nil {
 		s.logger.InfoContext(ctx, "transaction with the same idempotency key already exists", "idempotencyKey", idempotencyKey)
+		accountID, err := s.publicAccountID(ctx, existingTx.AccountID)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to get the account of the existing transaction", "error", err)
+			return nil, false, failure(err, "failed to get account")
+		}
 		return &model.TransactionResponseBody{
-			TransactionID: existingTx.ID.Hex(),
-			AccountID:     existingTx.AccountID,
+			TransactionID: existingTx.PublicID,
+			AccountID:     accountID,
 			OperationID:   existingTx.OperationID,
 			Amount:        existingTx.Amount,
-		}, nil
+		}, true, nil
 	}
 	// Validate request in service layer
 	if transaction.OperationID.String() == "UNKNOWN" {
-		return nil, &model.ErrorResponse{
+		return nil, false, &model.ErrorResponse{
 			Status:  http.StatusBadRequest,
 			Message: "invalid operation type",
 		}
@@ -59,7 +220,7 @@ func (s *TransactionService) CreateTransaction(ctx context.Context, transaction
 
 	// installment purchase, installment purchase and withdrawal should be negative amounts
 	if (transaction.OperationID == model.OperationTypePurchase || transaction.OperationID == model.OperationTypeInstallmentPurchase || transaction.OperationID == model.OperationTypeWithdrawal) && transaction.Amount > 0 {
-		return nil, &model.ErrorResponse{
+		return nil, false, &model.ErrorResponse{
 			Status:  http.StatusBadRequest,
 			Message: "invalid operation type for transaction amount",
 		}
@@ -67,105 +228,675 @@ func (s *TransactionService) CreateTransaction(ctx context.Context, transaction
 
 	// payment should be positive amount
 	if transaction.OperationID == model.OperationTypePayment && transaction.Amount < 0 {
-		return nil, &model.ErrorResponse{
+		return nil, false, &model.ErrorResponse{
 			Status:  http.StatusBadRequest,
 			Message: "invalid operation type for transaction amount",
 		}
 	}
 
+	// the journal is kept in cents, an amount with a fraction of a cent would be stored as something other than what is
+	// posted for it, or posted as nothing at all
+	amountCents := ledger.Cents(transaction.Amount)
+	if amountCents == 0 || ledger.Amount(amountCents) != transaction.Amount {
+		return nil, false, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "amount must be a whole number of cents",
+		}
+	}
+	transaction.Amount = ledger.Amount(amountCents)
+
 	// Check if account exists
 	account, err := s.repo.GetAccountByID(ctx, transaction.AccountID)
-	if errors.Is(err, mongo.ErrNoDocuments) {
-		return nil, &model.ErrorResponse{
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, false, &model.ErrorResponse{
 			Status:  http.StatusNotFound,
 			Message: "account not found",
 		}
 	}
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to get account", "error", err)
-		return nil, &model.ErrorResponse{
-			Status:  http.StatusInternalServerError,
-			Message: fmt.Sprintf("failed to get account"),
-		}
+		return nil, false, failure(err, "failed to get account")
 	}
 	if account == nil {
-		return nil, &model.ErrorResponse{
+		return nil, false, &model.ErrorResponse{
 			Status:  http.StatusNotFound,
 			Message: "account not found",
 		}
 	}
+	accountBalance, err := loadAccountBalance(ctx, s.repo, transaction.AccountID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get account balance", "error", err)
+		return nil, false, failure(err, "failed to get account balance")
+	}
 	balance := transaction.Amount
-	// If its a payment type 4 then look through previous transaction and adjust the already existing balances
+	var (
+		allocations []ledger.Allocation
+		discharged  []model.DebtDischargedEvent
+	)
+	// If its a payment type 4 then allocate it to the open debts in the balance projection, oldest first. The
+	// allocations are posted to the journal with the payment and taken off the debts in the projection, the debts' own
+	// transactions are left as they were made
 	if transaction.OperationID == model.OperationTypePayment {
-		allTransactions, err := s.repo.FindAllTransactionsForAccountID(ctx, transaction.AccountID)
-		if err != nil {
-			return nil, &model.ErrorResponse{
-				Status:  http.StatusInternalServerError,
-				Message: fmt.Sprintf("failed to get all transactions for account"),
-			}
-		}
-		var remainder float64 = 0
-		for _, tx := range allTransactions {
-			if tx.Balance < 0 {
-				remainder = tx.Balance + balance
-				if remainder <= 0 {
-					err := s.repo.UpdateTransactionByID(ctx, tx.ID.Hex(), model.Transaction{
-						AccountID:   tx.AccountID,
-						Balance:     remainder,
-						OperationID: tx.OperationID,
-						Amount:      tx.Amount,
-					})
-					s.logger.InfoContext(ctx, "updated transaction", "transactionID", tx.ID.Hex(), "balance", remainder)
-					if err != nil {
-						s.logger.ErrorContext(ctx, "failed to update transaction", "error", err)
-						return nil, &model.ErrorResponse{
-							Status:  http.StatusInternalServerError,
-							Message: fmt.Sprintf("failed to update transaction"),
-						}
-					}
-					balance = 0
-					break
-				}
-				err := s.repo.UpdateTransactionByID(ctx, tx.ID.Hex(), model.Transaction{
-					AccountID:   tx.AccountID,
-					Balance:     0,
-					OperationID: tx.OperationID,
-					Amount:      tx.Amount,
-				})
-				s.logger.InfoContext(ctx, "updated transaction", "transactionID", tx.ID.Hex(), "balance", 0)
-				if err != nil {
-					s.logger.ErrorContext(ctx, "failed to update transaction", "error", err)
-					return nil, &model.ErrorResponse{
-						Status:  http.StatusInternalServerError,
-						Message: fmt.Sprintf("failed to update transaction"),
-					}
-				}
-				balance = remainder
+		var unapplied int64
+		allocations, unapplied = ledger.Allocate(ledger.Cents(transaction.Amount), ledger.Debts(*accountBalance))
+		for _, allocation := range allocations {
+			debt, errResp := s.dischargedDebt(ctx, allocation)
+			if errResp != nil {
+				return nil, false, errResp
 			}
+			discharged = append(discharged, model.DebtDischargedEvent{
+				TransactionID:   debt.PublicID,
+				AccountID:       account.PublicID,
+				Amount:          ledger.Amount(allocation.AmountCents),
+				PreviousBalance: ledger.Amount(allocation.PreviousBalance),
+				Balance:         ledger.Amount(allocation.Balance),
+			})
 		}
+		balance = ledger.Amount(unapplied)
 	}
 
+	// truncated to what a BSON date keeps so the event date reads back the same from every backend
+	now := time.Now().UTC().Truncate(time.Millisecond)
 	tx := model.Transaction{
-		AccountID:   transaction.AccountID,
-		OperationID: transaction.OperationID,
-		Amount:      transaction.Amount,
-		Balance:     balance,
-		EventDate:   time.Now().Format(time.RFC3339Nano),
+		AccountID:      transaction.AccountID,
+		OperationID:    transaction.OperationID,
+		Amount:         transaction.Amount,
+		Balance:        balance,
+		EventDate:      now,
+		IdempotencyKey: idempotencyKey,
 	}
 
 	// I am wondering whether it would make sense to ALWAYS save the transaction with the idempotency key even if the request is invalid
 	// For now I will not do this but validate the if a record with the idempotency key exists first and fail before it reaches here
 	createdTx, err := s.repo.CreateTransaction(ctx, tx)
-	if err != nil {
-		return nil, &model.ErrorResponse{
-			Status:  http.StatusInternalServerError,
-			Message: fmt.Sprintf("failed to create transaction"),
+	if errors.Is(err, repository.ErrDuplicate) {
+		// another request with the same key got in between the lookup above and the insert
+		s.logger.InfoContext(ctx, "transaction with the same idempotency key was created concurrently", "idempotencyKey", idempotencyKey)
+		return nil, false, &model.ErrorResponse{
+			Status:  http.StatusConflict,
+			Message: "a transaction with the same idempotency key is already being processed",
 		}
 	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create transaction", "error", err)
+		return nil, false, failure(err, "failed to create transaction")
+	}
+	entry, err := ledger.NewTransactionEntry(createdTx.ID.Hex(), tx, allocations, now)
+	if err == nil {
+		err = s.repo.PostJournalEntry(ctx, entry)
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to post journal entry", "error", err)
+		return nil, false, failure(err, "failed to create transaction")
+	}
+	ledger.Apply(accountBalance, createdTx.ID.Hex(), tx, allocations, now)
+	if err := s.repo.SaveAccountBalance(ctx, *accountBalance); err != nil {
+		s.logger.ErrorContext(ctx, "failed to save account balance", "error", err)
+		return nil, false, failure(err, "failed to create transaction")
+	}
+	if err := s.appendTransactionEvents(ctx, createdTx.PublicID, account.PublicID, tx, discharged); err != nil {
+		s.logger.ErrorContext(ctx, "failed to append transaction events", "error", err)
+		return nil, false, failure(err, "failed to create transaction")
+	}
 	return &model.TransactionResponseBody{
-		TransactionID: createdTx.ID.Hex(),
-		AccountID:     createdTx.AccountID,
+		TransactionID: createdTx.PublicID,
+		AccountID:     account.PublicID,
 		OperationID:   createdTx.OperationID,
 		Amount:        createdTx.Amount,
-	}, nil
+	}, false, nil
+}
+
+// dischargedDebt reads the transaction of a debt a payment was allocated to, the DebtDischarged event names it by its
+// public ID. Nothing is written to it, what is left of the debt is read from the journal and the projection. A payment
+// that discharged the debt since it was read is caught when the projection is saved, the payment is then retried as a
+// whole so it is allocated against what is left of the debt rather than discharging it twice. The debt is returned
+// with the balance left on it
+func (s *TransactionService) dischargedDebt(ctx context.Context, allocation ledger.Allocation) (*model.Transaction, *model.ErrorResponse) {
+	debt, err := s.repo.GetTransactionByID(ctx, allocation.TransactionID)
+	if err != nil || debt == nil {
+		s.logger.ErrorContext(ctx, "failed to get discharged transaction", "transactionID", allocation.TransactionID, "error", err)
+		return nil, failure(err, "failed to get discharged transaction")
+	}
+	debt.Balance = ledger.Amount(allocation.Balance)
+	s.logger.InfoContext(ctx, "discharged transaction", "transactionID", allocation.TransactionID, "balance", debt.Balance)
+	return debt, nil
+}
+
+// appendTransactionEvents writes the TransactionCreated event followed by a DebtDischarged event for every debt the
+// transaction paid off, in the order they were discharged. Events are read by clients so they carry public IDs
+func (s *TransactionService) appendTransactionEvents(ctx context.Context, transactionID, accountID string, tx model.Transaction, discharged []model.DebtDischargedEvent) error {
+	event, err := outbox.NewEvent(model.EventTypeTransactionCreated, transactionID, accountID, model.TransactionCreatedEvent{
+		TransactionID: transactionID,
+		AccountID:     accountID,
+		OperationID:   tx.OperationID,
+		Amount:        tx.Amount,
+		Balance:       tx.Balance,
+		EventDate:     tx.EventDate,
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.repo.AppendOutboxEvent(ctx, event); err != nil {
+		return err
+	}
+	for _, payload := range discharged {
+		payload.PaymentTransactionID = transactionID
+		event, err := outbox.NewEvent(model.EventTypeDebtDischarged, payload.TransactionID, payload.AccountID, payload)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.AppendOutboxEvent(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTransactionsBatch creates every transaction in the batch, in request order, returning a result per item.
+// In atomic mode the items share one database transaction and the first rejection rolls all of them back, in best effort
+// mode each item stands on its own and rejections are simply reported
+func (s *TransactionService) CreateTransactionsBatch(ctx context.Context, batch model.TransactionBatchRequestBody) (*model.TransactionBatchResponseBody, *model.ErrorResponse) {
+	if batch.Mode == "" {
+		batch.Mode = model.BatchModeAtomic
+	}
+	if batch.Mode != model.BatchModeAtomic && batch.Mode != model.BatchModeBestEffort {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "mode must be one of atomic or best_effort",
+		}
+	}
+	if len(batch.Items) == 0 || len(batch.Items) > MaxBatchSize {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("a batch must contain between 1 and %d items", MaxBatchSize),
+		}
+	}
+	s.logger.InfoContext(ctx, "creating transaction batch", "mode", batch.Mode, "items", len(batch.Items))
+
+	if batch.Mode == model.BatchModeBestEffort {
+		results := make([]model.TransactionBatchItemResult, len(batch.Items))
+		for i, item := range batch.Items {
+			results[i] = s.createBatchItem(ctx, i, item)
+		}
+		return newBatchResponse(batch.Mode, results), nil
+	}
+
+	var (
+		results  []model.TransactionBatchItemResult
+		rejected int
+		err      error
+	)
+	// the accounts are locked up front since the items share one transaction, which no lock may be taken inside of
+	// and the items are given the internal IDs of their accounts so they are locked under the same keys whichever
+	// ID they were named by
+	batch.Items = slices.Clone(batch.Items)
+	accountIDs := make([]string, 0, len(batch.Items))
+	for i, item := range batch.Items {
+		if item.AccountID == "" {
+			continue
+		}
+		accountID, errResp := s.internalAccountID(ctx, item.AccountID)
+		if errResp != nil {
+			return nil, errResp
+		}
+		batch.Items[i].AccountID = accountID
+		accountIDs = append(accountIDs, accountID)
+	}
+	ctx, unlock, errResp := s.lockAccounts(ctx, accountIDs...)
+	if errResp != nil {
+		return nil, errResp
+	}
+	defer unlock()
+	// the items share one transaction so a transient failure of any of them retries the whole batch rather than the item
+	errResp = s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		err = s.repo.WithTransaction(ctx, func(ctx context.Context) error {
+			// the transaction can be retried from the top so nothing from a previous attempt can be kept
+			results = make([]model.TransactionBatchItemResult, len(batch.Items))
+			rejected = -1
+			for i, item := range batch.Items {
+				results[i] = s.createBatchItem(ctx, i, item)
+				if results[i].Status == model.BatchItemStatusRejected {
+					rejected = i
+					return errBatchRejected
+				}
+			}
+			return nil
+		})
+		// an item that failed as ctx was canceled, by a lost lock or a client gone, fails the batch as a whole
+		if errors.Is(err, errBatchRejected) && (retryable(results[rejected].Error) || ctx.Err() != nil) {
+			return results[rejected].Error
+		}
+		if err != nil && !errors.Is(err, errBatchRejected) {
+			s.logger.ErrorContext(ctx, "failed to commit transaction batch", "error", err)
+			return failure(err, "failed to create transaction batch")
+		}
+		return nil
+	})
+	if errResp != nil {
+		return nil, errResp
+	}
+	if errors.Is(err, errBatchRejected) {
+		s.logger.InfoContext(ctx, "transaction batch rolled back", "rejectedIndex", rejected)
+		for i, item := range batch.Items {
+			if i == rejected {
+				continue
+			}
+			results[i] = model.TransactionBatchItemResult{
+				Index:          i,
+				IdempotencyKey: item.IdempotencyKey,
+				Status:         model.BatchItemStatusRejected,
+				Error: &model.ErrorResponse{
+					Status:  http.StatusFailedDependency,
+					Message: fmt.Sprintf("batch rolled back because item %d was rejected", rejected),
+				},
+			}
+		}
+		return newBatchResponse(batch.Mode, results), nil
+	}
+	return newBatchResponse(batch.Mode, results), nil
+}
+
+func (s *TransactionService) createBatchItem(ctx context.Context, index int, item model.TransactionBatchItem) model.TransactionBatchItemResult {
+	result := model.TransactionBatchItemResult{
+		Index:          index,
+		IdempotencyKey: item.IdempotencyKey,
+		Status:         model.BatchItemStatusRejected,
+	}
+	// each item is checked against the same rules as a single transaction, plus its idempotency key
+	if errResp := validation.Validate(item); errResp != nil {
+		result.Error = errResp
+		return result
+	}
+	resp, replayed, errResp := s.createTransaction(ctx, item.TransactionRequestBody, item.IdempotencyKey)
+	if errResp != nil {
+		result.Error = errResp
+		return result
+	}
+	result.Transaction = resp
+	result.Status = model.BatchItemStatusCreated
+	if replayed {
+		result.Status = model.BatchItemStatusReplayed
+	}
+	return result
+}
+
+func newBatchResponse(mode model.BatchMode, results []model.TransactionBatchItemResult) *model.TransactionBatchResponseBody {
+	resp := &model.TransactionBatchResponseBody{
+		Mode:    mode,
+		Results: results,
+	}
+	for _, result := range results {
+		switch result.Status {
+		case model.BatchItemStatusCreated:
+			resp.Created++
+		case model.BatchItemStatusReplayed:
+			resp.Replayed++
+		case model.BatchItemStatusRejected:
+			resp.Rejected++
+		}
+	}
+	return resp
+}
+
+// ExportTransactions streams every transaction matching the filter to fn. The filter is validated and the account looked up
+// before anything is streamed so those failures can still be reported to the caller with a proper status. The
+// transactions are exported so fn is handed them with the public ID of their account as AccountID and the balance the
+// journal gives them
+func (s *TransactionService) ExportTransactions(ctx context.Context, filter model.TransactionFilter, fn func(model.Transaction) error) *model.ErrorResponse {
+	s.logger.InfoContext(ctx, "exporting transactions", "accountID", filter.AccountID, "operationTypeID", filter.OperationID.String(), "from", filter.From, "to", filter.To)
+	if filter.OperationID != 0 && filter.OperationID.String() == "UNKNOWN" {
+		return &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "invalid operation type",
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		return &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "to must not be before from",
+		}
+	}
+
+	// the public IDs of the accounts exported so far, by internal ID
+	accountIDs := map[string]string{}
+	if filter.AccountID != "" {
+		account, errResp := s.getAccount(ctx, filter.AccountID)
+		if errResp != nil {
+			return errResp
+		}
+		filter.AccountID = account.ID.Hex()
+		accountIDs[filter.AccountID] = account.PublicID
+	}
+
+	// not retried, part of the export may already have been written to the caller
+	balances := newJournalBalances(s.repo)
+	err := s.repo.StreamTransactions(ctx, filter, func(tx model.Transaction) error {
+		if err := balances.apply(ctx, &tx); err != nil {
+			return err
+		}
+		accountID, ok := accountIDs[tx.AccountID]
+		if !ok {
+			var err error
+			if accountID, err = s.publicAccountID(ctx, tx.AccountID); err != nil {
+				return err
+			}
+			accountIDs[tx.AccountID] = accountID
+		}
+		tx.AccountID = accountID
+		return fn(tx)
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to export transactions", "error", err)
+		return failure(err, "failed to export transactions")
+	}
+	return nil
+}
+
+// BuildStatement collects the account's transactions booked between from and to. Everything before from is streamed as
+// well since the opening balance is the sum of every earlier transaction
+func (s *TransactionService) BuildStatement(ctx context.Context, accountID string, from time.Time, to time.Time, currency string) (*statement.Statement, *model.ErrorResponse) {
+	s.logger.InfoContext(ctx, "building statement", "accountID", accountID, "from", from, "to", to)
+	if from.IsZero() || to.IsZero() {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "from and to are required",
+		}
+	}
+	if to.Before(from) {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "to must not be before from",
+		}
+	}
+	account, errResp := s.getAccount(ctx, accountID)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	st := &statement.Statement{
+		ID:        bson.NewObjectID().Hex(),
+		AccountID: account.PublicID,
+		Currency:  currency,
+		From:      from,
+		To:        to,
+		CreatedAt: time.Now().UTC(),
+	}
+	var opening, period float64
+	errResp = s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		opening, period, st.Entries = 0, 0, nil
+		err := s.streamStatement(ctx, account.ID.Hex(), st, &opening, &period)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to build statement", "error", err)
+			return failure(err, "failed to build statement")
+		}
+		return nil
+	})
+	if errResp != nil {
+		return nil, errResp
+	}
+	// amounts are floats so the sums are rounded to cents to keep the balances exact in the statement
+	st.OpeningBalance = math.Round(opening*100) / 100
+	st.ClosingBalance = math.Round((opening+period)*100) / 100
+	return st, nil
+}
+
+// streamStatement adds the transactions of the account with the internal ID booked in the statement's period to it,
+// summing the ones before it into opening and the ones in it into period
+func (s *TransactionService) streamStatement(ctx context.Context, accountID string, st *statement.Statement, opening *float64, period *float64) error {
+	from, to := st.From, st.To
+	return s.repo.StreamTransactions(ctx, model.TransactionFilter{AccountID: accountID, To: to}, func(tx model.Transaction) error {
+		bookedAt := tx.EventDate
+		if bookedAt.After(to) {
+			return nil
+		}
+		if bookedAt.Before(from) {
+			*opening += tx.Amount
+			return nil
+		}
+		*period += tx.Amount
+		st.Entries = append(st.Entries, statement.Entry{
+			TransactionID: tx.PublicID,
+			OperationID:   tx.OperationID,
+			Amount:        tx.Amount,
+			BookedAt:      bookedAt,
+		})
+		return nil
+	})
+}
+
+// Reconcile replays the transactions of one account, or of every account, in event_date order and reports every
+// transaction whose balance in the journal is not the one the replay gives it. With repair set the journal is brought
+// in line with the replayed balances once the replay has finished, one database transaction per account, and the
+// account's balance projection is rebuilt from it. Each account is locked and replayed again before it is repaired, so
+// a payment made to it since the first replay is part of what is repaired rather than undone by it. An account that
+// cannot be repaired is reported with its error and the others are still repaired
+func (s *TransactionService) Reconcile(ctx context.Context, req model.ReconciliationRequestBody) (*model.ReconciliationResponseBody, *model.ErrorResponse) {
+	s.logger.InfoContext(ctx, "reconciling balances", "accountID", req.AccountID, "repair", req.Repair)
+	if req.AccountID != "" {
+		account, errResp := s.getAccount(ctx, req.AccountID)
+		if errResp != nil {
+			return nil, errResp
+		}
+		req.AccountID = account.ID.Hex()
+	}
+
+	var (
+		replay *ledger.Replay
+		// the public IDs of the transactions replayed, by internal ID
+		transactionIDs map[string]string
+	)
+	errResp := s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		replay, transactionIDs = ledger.NewReplay(), map[string]string{}
+		balances := newJournalBalances(s.repo)
+		err := s.repo.StreamTransactions(ctx, model.TransactionFilter{AccountID: req.AccountID}, func(tx model.Transaction) error {
+			if err := balances.apply(ctx, &tx); err != nil {
+				return err
+			}
+			replay.Apply(tx)
+			transactionIDs[tx.ID.Hex()] = tx.PublicID
+			return nil
+		})
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to replay transactions", "error", err)
+			return failure(err, "failed to reconcile balances")
+		}
+		return nil
+	})
+	if errResp != nil {
+		return nil, errResp
+	}
+	resp := &model.ReconciliationResponseBody{
+		AccountsChecked:     replay.Accounts(),
+		TransactionsChecked: replay.Transactions(),
+		Accounts:            replay.Drift(),
+	}
+	// the drift is reported by public IDs, the accounts are repaired under their internal ones
+	internalIDs := make([]string, len(resp.Accounts))
+	for i := range resp.Accounts {
+		account := &resp.Accounts[i]
+		resp.TransactionsDrifted += len(account.Transactions)
+		s.logger.WarnContext(ctx, "account balance drift", "accountID", account.AccountID, "transactions", len(account.Transactions), "drift", account.Drift)
+		internalIDs[i] = account.AccountID
+		errResp := s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+			var err error
+			if account.AccountID, err = s.publicAccountID(ctx, internalIDs[i]); err != nil {
+				s.logger.ErrorContext(ctx, "failed to get account", "accountID", internalIDs[i], "error", err)
+				return failure(err, "failed to reconcile balances")
+			}
+			return nil
+		})
+		if errResp != nil {
+			return nil, errResp
+		}
+		for j := range account.Transactions {
+			account.Transactions[j].TransactionID = transactionIDs[account.Transactions[j].TransactionID]
+		}
+	}
+	if resp.Accounts == nil {
+		resp.Accounts = []model.AccountDrift{}
+	}
+	if !req.Repair {
+		return resp, nil
+	}
+
+	for i := range resp.Accounts {
+		account := &resp.Accounts[i]
+		repaired, errResp := s.repairAccount(ctx, internalIDs[i], account.AccountID)
+		if errResp != nil {
+			// nothing was written to the account, it is reported with why so the accounts repaired so far are not lost
+			account.Error = errResp
+			resp.Failed++
+			continue
+		}
+		// what was repaired is reported, it differs from the drift found above when the account was written to since
+		resp.TransactionsDrifted += len(repaired.Transactions) - len(account.Transactions)
+		account.Drift, account.Transactions = repaired.Drift, repaired.Transactions
+		resp.Repaired += len(account.Transactions)
+		s.logger.InfoContext(ctx, "repaired account balances", "accountID", account.AccountID, "transactions", len(account.Transactions))
+	}
+	return resp, nil
+}
+
+// repairAccount locks the account, replays it again as it is now and posts a journal adjustment for whatever the journal
+// disagrees with the replayed balances by in one database transaction, along with a balance projection rebuilt from the
+// repaired journal. It returns the drift repaired, reported by public IDs
+func (s *TransactionService) repairAccount(ctx context.Context, accountID string, publicID string) (model.AccountDrift, *model.ErrorResponse) {
+	ctx, unlock, errResp := s.lockAccounts(ctx, accountID)
+	if errResp != nil {
+		return model.AccountDrift{}, errResp
+	}
+	defer unlock()
+
+	var repaired model.AccountDrift
+	errResp = s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		err := s.repo.WithTransaction(ctx, func(ctx context.Context) error {
+			drift, transactionIDs, err := s.replayAccount(ctx, accountID)
+			if err != nil {
+				return err
+			}
+			transactions := make([]model.Transaction, 0, len(drift.Transactions))
+			for _, drift := range drift.Transactions {
+				tx, err := s.repo.GetTransactionByID(ctx, drift.TransactionID)
+				if err != nil {
+					return err
+				}
+				if tx == nil {
+					return fmt.Errorf("transaction %s not found", drift.TransactionID)
+				}
+				tx.Balance = drift.ExpectedBalance
+				transactions = append(transactions, *tx)
+			}
+
+			// the journal is corrected by a new entry, entries already posted are never changed
+			postedAt := time.Now().UTC().Truncate(time.Millisecond)
+			entries, err := s.repo.FindJournalEntries(ctx, accountID, postedAt)
+			if err != nil {
+				return err
+			}
+			entry, ok, err := ledger.NewAdjustmentEntry(accountID, entries, transactions, postedAt)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err := s.repo.PostJournalEntry(ctx, entry); err != nil {
+					return err
+				}
+			}
+			if err := s.rebuildAccountBalance(ctx, accountID); err != nil {
+				return err
+			}
+
+			repaired = drift
+			for j := range repaired.Transactions {
+				repaired.Transactions[j].TransactionID = transactionIDs[repaired.Transactions[j].TransactionID]
+				repaired.Transactions[j].Repaired = true
+			}
+			return nil
+		})
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to repair account balances", "accountID", publicID, "error", err)
+			return failure(err, fmt.Sprintf("failed to repair balances of account %s", publicID))
+		}
+		return nil
+	})
+	if errResp != nil {
+		return model.AccountDrift{}, errResp
+	}
+	repaired.AccountID = publicID
+	return repaired, nil
+}
+
+// replayAccount replays the transactions of one account as they are now and returns its drift from the journal, by
+// internal IDs, along with the public IDs of its transactions
+func (s *TransactionService) replayAccount(ctx context.Context, accountID string) (model.AccountDrift, map[string]string, error) {
+	// read before the stream, which may hold the connection of the database transaction until it is done
+	entries, err := s.repo.FindJournalEntries(ctx, accountID, time.Now().UTC())
+	if err != nil {
+		return model.AccountDrift{}, nil, err
+	}
+	balances := ledger.TransactionBalances(entries)
+	replay, transactionIDs := ledger.NewReplay(), map[string]string{}
+	err = s.repo.StreamTransactions(ctx, model.TransactionFilter{AccountID: accountID}, func(tx model.Transaction) error {
+		ledger.JournalBalance(&tx, balances)
+		replay.Apply(tx)
+		transactionIDs[tx.ID.Hex()] = tx.PublicID
+		return nil
+	})
+	if err != nil {
+		return model.AccountDrift{}, nil, err
+	}
+	drift := model.AccountDrift{AccountID: accountID, Transactions: []model.TransactionDrift{}}
+	if accounts := replay.Drift(); len(accounts) > 0 {
+		drift = accounts[0]
+	}
+	return drift, transactionIDs, nil
+}
+
+// rebuildAccountBalance replaces the account's balance projection with one built from its transactions and journal as
+// they are now
+func (s *TransactionService) rebuildAccountBalance(ctx context.Context, accountID string) error {
+	current, err := s.repo.GetAccountBalance(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	transactions, err := findJournalTransactions(ctx, s.repo, accountID)
+	if err != nil {
+		return err
+	}
+	rebuilt := ledger.NewAccountBalance(accountID, transactions)
+	rebuilt.Version = 1
+	if current != nil {
+		rebuilt.Version = current.Version + 1
+	}
+	return s.repo.SaveAccountBalance(ctx, rebuilt)
+}
+
+// getAccount returns a not found error when there is no account with the ID, public or internal
+func (s *TransactionService) getAccount(ctx context.Context, accountID string) (*model.Account, *model.ErrorResponse) {
+	var account *model.Account
+	errResp := s.retry.do(ctx, s.logger, func(ctx context.Context) *model.ErrorResponse {
+		var err error
+		account, err = s.repo.GetAccountByID(ctx, accountID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return &model.ErrorResponse{
+				Status:  http.StatusNotFound,
+				Message: "account not found",
+			}
+		}
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to get account", "error", err)
+			return failure(err, "failed to get account")
+		}
+		if account == nil {
+			return &model.ErrorResponse{
+				Status:  http.StatusNotFound,
+				Message: "account not found",
+			}
+		}
+		return nil
+	})
+	if errResp != nil {
+		return nil, errResp
+	}
+	return account, nil
 }
@@ -2,20 +2,37 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/joolshouston/pismo-technical-test/shared/database"
+	"github.com/joolshouston/pismo-technical-test/shared/ledger"
+	"github.com/joolshouston/pismo-technical-test/shared/lock"
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/statement"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 func (m *MockMongoRepo) CreateTransaction(ctx context.Context, transaction model.Transaction) (*model.Transaction, error) {
+	if transaction.IdempotencyKey == "x-idempotency-key-raced" {
+		// inserted by another request after the idempotency lookup
+		return nil, fmt.Errorf("transaction with idempotency key %s: %w", transaction.IdempotencyKey, repository.ErrDuplicate)
+	}
 	switch transaction.Amount {
 	case -123.5:
 		return &model.Transaction{
 			ID:          bson.NewObjectID(),
+			PublicID:    publicid.NewTransactionID(),
 			AccountID:   transaction.AccountID,
 			OperationID: transaction.OperationID,
 		}, nil
@@ -24,42 +41,302 @@ func (m *MockMongoRepo) CreateTransaction(ctx context.Context, transaction model
 	default:
 		return &model.Transaction{
 			ID:          bson.NewObjectID(),
+			PublicID:    publicid.NewTransactionID(),
 			AccountID:   transaction.AccountID,
 			OperationID: transaction.OperationID,
 		}, nil
 	}
 }
 
+func (m *MockMongoRepo) GetTransactionByID(ctx context.Context, transactionID string) (*model.Transaction, error) {
+	for id, publicID := range debtPublicIDs {
+		if transactionID == publicID {
+			transactionID = id.Hex()
+		}
+	}
+	id, err := bson.ObjectIDFromHex(transactionID)
+	if err != nil {
+		return nil, nil
+	}
+	return &model.Transaction{ID: id, PublicID: debtPublicIDs[id], AccountID: "drifted_id", IdempotencyKey: "stored", Version: 3}, nil
+}
+
 func (m *MockMongoRepo) FindTransactionByIdempotencyKey(ctx context.Context, idempotencyKey string) (*model.Transaction, error) {
 	switch idempotencyKey {
 	case "x-idempotency-key-duplicate":
 		return &model.Transaction{
 			ID:          bson.NewObjectID(),
+			PublicID:    publicid.NewTransactionID(),
 			AccountID:   "valid_id",
 			OperationID: 1,
 			Amount:      -123.5,
 		}, nil
 	case "x-idempotency-key-fail":
 		return nil, errors.New("database error")
+	case "x-idempotency-key-unavailable":
+		return nil, fmt.Errorf("no reachable servers: %w", repository.ErrUnavailable)
+	case "x-idempotency-key-flaky":
+		return nil, m.flaky(idempotencyKey)
 	default:
 		return nil, nil
 	}
 }
 
+// MockLocker records the keys locked, in order, times out on the keys in busy and loses the keys in lost as soon as they
+// are locked
+type MockLocker struct {
+	mu     sync.Mutex
+	busy   map[string]bool
+	lost   map[string]bool
+	locked []string
+	held   int
+}
+
+func (l *MockLocker) Lock(ctx context.Context, key string) (context.Context, func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.busy[key] {
+		return nil, nil, lock.ErrTimeout
+	}
+	l.locked = append(l.locked, key)
+	l.held++
+	ctx, cancel := context.WithCancelCause(ctx)
+	if l.lost[key] {
+		cancel(lock.ErrLost)
+	}
+	return ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		cancel(nil)
+		l.held--
+	}, nil
+}
+
+var (
+	settledDebtID = bson.NewObjectID()
+	oldestDebtID  = bson.NewObjectID()
+	newestDebtID  = bson.NewObjectID()
+	// a concurrent payment discharges it first, the first time the balance of its account is saved
+	contendedDebtID = bson.NewObjectID()
+	// the public IDs of the debts above, by internal ID
+	debtPublicIDs = map[bson.ObjectID]string{
+		settledDebtID:   publicid.NewTransactionID(),
+		oldestDebtID:    publicid.NewTransactionID(),
+		newestDebtID:    publicid.NewTransactionID(),
+		contendedDebtID: publicid.NewTransactionID(),
+	}
+)
+
 func (m *MockMongoRepo) FindAllTransactionsForAccountID(ctx context.Context, accountID string) ([]model.Transaction, error) {
+	accountID = accountKey(accountID)
+	if accountID == "transactions_fail" {
+		return nil, errors.New("database error")
+	}
+	if accountID == "contended_id" {
+		return []model.Transaction{
+			{ID: contendedDebtID, PublicID: debtPublicIDs[contendedDebtID], AccountID: accountID, OperationID: model.OperationTypePurchase, Amount: -40, Balance: -40, IdempotencyKey: "contended"},
+		}, nil
+	}
+	return []model.Transaction{
+		{ID: settledDebtID, PublicID: debtPublicIDs[settledDebtID], AccountID: accountID, OperationID: model.OperationTypePurchase, Amount: -10, Balance: 0, IdempotencyKey: "settled"},
+		{ID: oldestDebtID, PublicID: debtPublicIDs[oldestDebtID], AccountID: accountID, OperationID: model.OperationTypePurchase, Amount: -50, Balance: -50, IdempotencyKey: "oldest"},
+		{ID: newestDebtID, PublicID: debtPublicIDs[newestDebtID], AccountID: accountID, OperationID: model.OperationTypeWithdrawal, Amount: -23.5, Balance: -23.5, IdempotencyKey: "newest"},
+	}, nil
+}
+
+func (m *MockMongoRepo) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction, expectedVersion int64) error {
+	transaction.Version = expectedVersion + 1
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.updated == nil {
+		m.updated = make(map[string]model.Transaction)
+	}
+	m.updated[transactionID] = transaction
+	return nil
+}
+
+func (m *MockMongoRepo) StreamTransactions(ctx context.Context, filter model.TransactionFilter, fn func(model.Transaction) error) error {
+	filter.AccountID = accountKey(filter.AccountID)
+	if filter.AccountID == "stream_fail" {
+		return errors.New("cursor failed")
+	}
+	if filter.AccountID == "drifted_id" {
+		// the payment was applied to the first debt but its balance was never written back
+		for _, tx := range []model.Transaction{
+			{ID: oldestDebtID, PublicID: debtPublicIDs[oldestDebtID], OperationID: model.OperationTypePurchase, Amount: -50, Balance: -50},
+			{ID: newestDebtID, PublicID: debtPublicIDs[newestDebtID], OperationID: model.OperationTypeWithdrawal, Amount: -20, Balance: -10},
+			{ID: bson.NewObjectID(), PublicID: publicid.NewTransactionID(), OperationID: model.OperationTypePayment, Amount: 60, Balance: 0},
+		} {
+			tx.AccountID = filter.AccountID
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, amount := range []float64{-50, -23.5} {
+		if err := fn(model.Transaction{
+			ID:          bson.NewObjectID(),
+			PublicID:    publicid.NewTransactionID(),
+			AccountID:   filter.AccountID,
+			OperationID: model.OperationTypePurchase,
+			Amount:      amount,
+			Balance:     amount,
+			EventDate:   time.Date(2025, 1, 10*(i+1), 10, 0, 0, 0, time.UTC),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithTransaction drops what fn posted or appended when it fails, as rolling back the database transaction would
+func (m *MockMongoRepo) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	m.mu.Lock()
+	journal, events := len(m.journal), len(m.events)
+	m.mu.Unlock()
+	err := fn(ctx)
+	if err != nil {
+		m.mu.Lock()
+		m.journal, m.events = m.journal[:journal], m.events[:events]
+		m.mu.Unlock()
+	}
+	return err
+}
+
+func (m *MockMongoRepo) AppendOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *MockMongoRepo) FindUnpublishedOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (m *MockMongoRepo) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction) error {
+func (m *MockMongoRepo) MarkOutboxEventPublished(ctx context.Context, eventID string, publishedAt time.Time) error {
 	//TODO implement me
 	panic("implement me")
 }
 
+func (m *MockMongoRepo) PostJournalEntry(ctx context.Context, entry model.JournalEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.journal = append(m.journal, entry)
+	return nil
+}
+
+func (m *MockMongoRepo) FindJournalEntries(ctx context.Context, accountID string, postedBy time.Time) ([]model.JournalEntry, error) {
+	var entries []model.JournalEntry
+	switch accountID = accountKey(accountID); accountID {
+	case "journal_fail":
+		return nil, errors.New("database error")
+	case "drifted_id":
+		// the balances stored on the transactions streamed for the account, which the replay disagrees with
+		entries = []model.JournalEntry{
+			{TransactionID: oldestDebtID.Hex(), AccountID: accountID, Lines: []model.JournalLine{
+				{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 5000, AppliesTo: oldestDebtID.Hex()},
+				{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 5000},
+			}},
+			{TransactionID: newestDebtID.Hex(), AccountID: accountID, Lines: []model.JournalLine{
+				{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 2000, AppliesTo: newestDebtID.Hex()},
+				{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideCredit, AmountCents: 2000},
+			}},
+			{TransactionID: "payment", AccountID: accountID, Lines: []model.JournalLine{
+				{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 1000},
+				{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 1000, AppliesTo: newestDebtID.Hex()},
+			}},
+		}
+	case "paid_id":
+		// a purchase on the 10th of January paid off in part on the 20th
+		entries = []model.JournalEntry{
+			{
+				TransactionID: oldestDebtID.Hex(),
+				AccountID:     accountID,
+				PostedAt:      time.Date(2025, 1, 10, 10, 0, 0, 0, time.UTC),
+				Lines: []model.JournalLine{
+					{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 5000, AppliesTo: oldestDebtID.Hex()},
+					{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 5000},
+				},
+			},
+			{
+				TransactionID: "payment",
+				AccountID:     accountID,
+				PostedAt:      time.Date(2025, 1, 20, 10, 0, 0, 0, time.UTC),
+				Lines: []model.JournalLine{
+					{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 2000},
+					{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 2000, AppliesTo: oldestDebtID.Hex()},
+				},
+			},
+		}
+	}
+	var posted []model.JournalEntry
+	for _, entry := range entries {
+		if !entry.PostedAt.After(postedBy) {
+			posted = append(posted, entry)
+		}
+	}
+	return posted, nil
+}
+
+func (m *MockMongoRepo) TrialBalance(ctx context.Context) ([]model.TrialBalanceLine, error) {
+	return []model.TrialBalanceLine{
+		{LedgerAccount: model.LedgerAccountCash, DebitCents: 5000, CreditCents: 2350},
+		{LedgerAccount: model.LedgerAccountReceivable, DebitCents: 7350, CreditCents: 5000},
+		{LedgerAccount: model.LedgerAccountMerchantPayable, CreditCents: 5000},
+	}, nil
+}
+
+func (m *MockMongoRepo) GetAccountBalance(ctx context.Context, accountID string) (*model.AccountBalance, error) {
+	accountID = accountKey(accountID)
+	switch accountID {
+	case "balance_fail":
+		return nil, errors.New("database error")
+	case "projected_id":
+		return &model.AccountBalance{
+			AccountID:      accountID,
+			TotalDebtCents: 7350,
+			Version:        4,
+			OpenDebts: []model.OpenDebt{
+				{TransactionID: oldestDebtID.Hex(), BalanceCents: -5000},
+				{TransactionID: newestDebtID.Hex(), BalanceCents: -2350},
+			},
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *MockMongoRepo) SaveAccountBalance(ctx context.Context, balance model.AccountBalance) error {
+	if accountID := accountKey(balance.AccountID); accountID == "contended_id" {
+		if err := m.contended(accountID); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.balances = append(m.balances, balance)
+	return nil
+}
+
+// appendedEvents returns the types of the outbox events appended so far
+func (m *MockMongoRepo) appendedEvents() []model.EventType {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	types := make([]model.EventType, len(m.events))
+	for i, event := range m.events {
+		types[i] = event.Type
+	}
+	return types
+}
+
 func Test_CreateTransaction(t *testing.T) {
 	repo := &MockMongoRepo{}
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	service := NewTransactionService(repo, logger)
+	service := NewTransactionService(repo, logger, &MockLocker{})
+	service.retry = retryPolicy{attempts: 3}
 
 	tests := []struct {
 		name           string
@@ -110,6 +387,20 @@ func Test_CreateTransaction(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Same idempotency key created concurrently",
+			transaction: model.TransactionRequestBody{
+				AccountID:   "valid_id",
+				OperationID: 1,
+				Amount:      -100.0,
+			},
+			idempotencyKey: "x-idempotency-key-raced",
+			validate: func(t *testing.T, resp *model.TransactionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusConflict {
+					t.Fatalf("expected a conflict, got %v", err)
+				}
+			},
+		},
 		{
 			name: "Idempotency query fails",
 			transaction: model.TransactionRequestBody{
@@ -119,8 +410,67 @@ func Test_CreateTransaction(t *testing.T) {
 			},
 			idempotencyKey: "x-idempotency-key-fail",
 			validate: func(t *testing.T, resp *model.TransactionResponseBody, err *model.ErrorResponse) {
-				if err == nil {
-					t.Fatalf("expected error, got nil")
+				if err == nil || err.Status != http.StatusInternalServerError {
+					t.Fatalf("expected status 500, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Database unavailable",
+			transaction: model.TransactionRequestBody{
+				AccountID:   "valid_id",
+				OperationID: 1,
+				Amount:      -100.0,
+			},
+			idempotencyKey: "x-idempotency-key-unavailable",
+			validate: func(t *testing.T, resp *model.TransactionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusServiceUnavailable || err.RetryAfter != 1 {
+					t.Fatalf("expected status 503 with Retry-After 1, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Database unavailable once",
+			transaction: model.TransactionRequestBody{
+				AccountID:   "valid_id",
+				OperationID: 1,
+				Amount:      -100.0,
+			},
+			idempotencyKey: "x-idempotency-key-flaky",
+			validate: func(t *testing.T, resp *model.TransactionResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected the retry to succeed, got %v", err)
+				}
+				if resp.TransactionID == "" {
+					t.Errorf("expected a transaction ID")
+				}
+			},
+		},
+		{
+			name: "Amount of -0.004 is not a whole number of cents",
+			transaction: model.TransactionRequestBody{
+				AccountID:   "valid_id",
+				OperationID: 1,
+				Amount:      -0.004,
+			},
+			idempotencyKey: "x-idempotency-key-1",
+			validate: func(t *testing.T, resp *model.TransactionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest || err.Message != "amount must be a whole number of cents" {
+					t.Fatalf("expected a 400 for a fraction of a cent, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Amount of 0.005 is not a whole number of cents",
+			transaction: model.TransactionRequestBody{
+				AccountID:   "valid_id",
+				OperationID: 4,
+				Amount:      0.005,
+			},
+			idempotencyKey: "x-idempotency-key-1",
+			validate: func(t *testing.T, resp *model.TransactionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest || err.Message != "amount must be a whole number of cents" {
+					t.Fatalf("expected a 400 for a fraction of a cent, got %v", err)
 				}
 			},
 		},
@@ -266,3 +616,811 @@ func Test_CreateTransaction(t *testing.T) {
 		})
 	}
 }
+
+func Test_ExportTransactions(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewTransactionService(repo, logger, &MockLocker{})
+
+	tests := []struct {
+		name     string
+		filter   model.TransactionFilter
+		validate func(t *testing.T, exported []model.Transaction, err *model.ErrorResponse)
+	}{
+		{
+			name:   "Streams every transaction for the account",
+			filter: model.TransactionFilter{AccountID: "valid_id"},
+			validate: func(t *testing.T, exported []model.Transaction, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if len(exported) != 2 {
+					t.Fatalf("expected 2 transactions, got %d", len(exported))
+				}
+			},
+		},
+		{
+			name:   "Account not found",
+			filter: model.TransactionFilter{AccountID: "account_nonexistent"},
+			validate: func(t *testing.T, exported []model.Transaction, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusNotFound {
+					t.Fatalf("expected not found error, got %v", err)
+				}
+			},
+		},
+		{
+			name:   "Invalid operation type",
+			filter: model.TransactionFilter{OperationID: 9},
+			validate: func(t *testing.T, exported []model.Transaction, err *model.ErrorResponse) {
+				if err == nil || err.Message != "invalid operation type" {
+					t.Fatalf("expected error 'invalid operation type', got %v", err)
+				}
+			},
+		},
+		{
+			name: "To before from",
+			filter: model.TransactionFilter{
+				From: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			validate: func(t *testing.T, exported []model.Transaction, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest {
+					t.Fatalf("expected bad request, got %v", err)
+				}
+			},
+		},
+		{
+			name:   "Cursor fails",
+			filter: model.TransactionFilter{AccountID: "stream_fail"},
+			validate: func(t *testing.T, exported []model.Transaction, err *model.ErrorResponse) {
+				if err == nil || err.Message != "failed to export transactions" {
+					t.Fatalf("expected error 'failed to export transactions', got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var exported []model.Transaction
+			err := service.ExportTransactions(context.Background(), tt.filter, func(tx model.Transaction) error {
+				exported = append(exported, tx)
+				return nil
+			})
+			tt.validate(t, exported, err)
+		})
+	}
+}
+
+func Test_CreateTransactionsBatch(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewTransactionService(repo, logger, &MockLocker{})
+	service.retry = retryPolicy{attempts: 3}
+
+	purchase := func(key string, amount float64) model.TransactionBatchItem {
+		return model.TransactionBatchItem{
+			IdempotencyKey: key,
+			TransactionRequestBody: model.TransactionRequestBody{
+				AccountID:   "valid_id",
+				OperationID: model.OperationTypePurchase,
+				Amount:      amount,
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		batch    model.TransactionBatchRequestBody
+		validate func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse)
+	}{
+		{
+			name: "Best effort reports every outcome",
+			batch: model.TransactionBatchRequestBody{
+				Mode: model.BatchModeBestEffort,
+				Items: []model.TransactionBatchItem{
+					purchase("batch-key-1", -10),
+					purchase("x-idempotency-key-duplicate", -123.5),
+					purchase("batch-key-3", 10),
+					purchase("", -10),
+				},
+			},
+			validate: func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.Created != 1 || resp.Replayed != 1 || resp.Rejected != 2 {
+					t.Fatalf("expected 1 created, 1 replayed and 2 rejected, got %+v", resp)
+				}
+				expected := []model.BatchItemStatus{model.BatchItemStatusCreated, model.BatchItemStatusReplayed, model.BatchItemStatusRejected, model.BatchItemStatusRejected}
+				for i, status := range expected {
+					if resp.Results[i].Status != status {
+						t.Errorf("expected item %d to be %s, got %s", i, status, resp.Results[i].Status)
+					}
+				}
+				if resp.Results[2].Error.Message != "invalid operation type for transaction amount" {
+					t.Errorf("expected item 2 error 'invalid operation type for transaction amount', got %s", resp.Results[2].Error.Message)
+				}
+				if resp.Results[3].Error.Message != "idempotency_key is required" {
+					t.Errorf("expected item 3 error 'idempotency_key is required', got %s", resp.Results[3].Error.Message)
+				}
+			},
+		},
+		{
+			name: "Items are validated field by field",
+			batch: model.TransactionBatchRequestBody{
+				Mode: model.BatchModeBestEffort,
+				Items: []model.TransactionBatchItem{
+					{IdempotencyKey: "batch-key-1", TransactionRequestBody: model.TransactionRequestBody{OperationID: 9, Amount: -0.004}},
+				},
+			},
+			validate: func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.Rejected != 1 || resp.Results[0].Error == nil || resp.Results[0].Error.Status != http.StatusBadRequest {
+					t.Fatalf("expected the item to be rejected, got %+v", resp)
+				}
+				var fields []string
+				for _, fieldErr := range resp.Results[0].Error.Errors {
+					fields = append(fields, fieldErr.Field+"/"+fieldErr.Code)
+				}
+				if expected := []string{"account_id/required", "operation_type_id/oneof", "amount/cents"}; !slices.Equal(fields, expected) {
+					t.Errorf("expected field errors %v, got %v", expected, fields)
+				}
+			},
+		},
+		{
+			name: "Atomic batch is rolled back by a rejected item",
+			batch: model.TransactionBatchRequestBody{
+				Items: []model.TransactionBatchItem{
+					purchase("batch-key-1", -10),
+					purchase("batch-key-2", 10),
+					purchase("batch-key-3", -10),
+				},
+			},
+			validate: func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.Mode != model.BatchModeAtomic {
+					t.Errorf("expected atomic mode by default, got %s", resp.Mode)
+				}
+				if resp.Rejected != 3 || resp.Created != 0 {
+					t.Fatalf("expected every item to be rejected, got %+v", resp)
+				}
+				if resp.Results[0].Error.Status != http.StatusFailedDependency {
+					t.Errorf("expected item 0 to fail on its dependency, got %v", resp.Results[0].Error)
+				}
+				if resp.Results[1].Error.Status != http.StatusBadRequest {
+					t.Errorf("expected item 1 to carry its own error, got %v", resp.Results[1].Error)
+				}
+			},
+		},
+		{
+			name: "Atomic batch with valid items",
+			batch: model.TransactionBatchRequestBody{
+				Mode:  model.BatchModeAtomic,
+				Items: []model.TransactionBatchItem{purchase("batch-key-1", -10), purchase("batch-key-2", -20)},
+			},
+			validate: func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.Created != 2 {
+					t.Fatalf("expected 2 created, got %+v", resp)
+				}
+			},
+		},
+		{
+			name: "Atomic batch is retried as a whole after a transient error",
+			batch: model.TransactionBatchRequestBody{
+				Items: []model.TransactionBatchItem{purchase("batch-key-1", -10), purchase("x-idempotency-key-flaky", -20)},
+			},
+			validate: func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.Created != 2 {
+					t.Fatalf("expected 2 created, got %+v", resp)
+				}
+			},
+		},
+		{
+			name: "Atomic batch while the database is unavailable",
+			batch: model.TransactionBatchRequestBody{
+				Items: []model.TransactionBatchItem{purchase("batch-key-1", -10), purchase("x-idempotency-key-unavailable", -20)},
+			},
+			validate: func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusServiceUnavailable {
+					t.Fatalf("expected status 503, got %v", err)
+				}
+			},
+		},
+		{
+			name:  "Unknown mode",
+			batch: model.TransactionBatchRequestBody{Mode: "sometimes", Items: []model.TransactionBatchItem{purchase("batch-key-1", -10)}},
+			validate: func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest {
+					t.Fatalf("expected bad request, got %v", err)
+				}
+			},
+		},
+		{
+			name:  "Empty batch",
+			batch: model.TransactionBatchRequestBody{},
+			validate: func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest {
+					t.Fatalf("expected bad request, got %v", err)
+				}
+			},
+		},
+		{
+			name:  "Batch too large",
+			batch: model.TransactionBatchRequestBody{Items: make([]model.TransactionBatchItem, MaxBatchSize+1)},
+			validate: func(t *testing.T, resp *model.TransactionBatchResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest {
+					t.Fatalf("expected bad request, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := service.CreateTransactionsBatch(context.Background(), tt.batch)
+			tt.validate(t, resp, err)
+		})
+	}
+}
+
+func Test_BuildStatement(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewTransactionService(repo, logger, &MockLocker{})
+
+	tests := []struct {
+		name      string
+		accountID string
+		from      time.Time
+		to        time.Time
+		validate  func(t *testing.T, st *statement.Statement, err *model.ErrorResponse)
+	}{
+		{
+			name:      "Earlier transactions make up the opening balance",
+			accountID: "valid_id",
+			from:      time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+			to:        time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+			validate: func(t *testing.T, st *statement.Statement, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if st.OpeningBalance != -50 || st.ClosingBalance != -73.5 {
+					t.Errorf("expected opening -50 and closing -73.5, got %v and %v", st.OpeningBalance, st.ClosingBalance)
+				}
+				if len(st.Entries) != 1 || st.Entries[0].Amount != -23.5 {
+					t.Errorf("expected the one transaction in the period, got %+v", st.Entries)
+				}
+				if st.Currency != "BRL" {
+					t.Errorf("expected currency BRL, got %s", st.Currency)
+				}
+			},
+		},
+		{
+			name:      "Missing period",
+			accountID: "valid_id",
+			validate: func(t *testing.T, st *statement.Statement, err *model.ErrorResponse) {
+				if err == nil || err.Message != "from and to are required" {
+					t.Fatalf("expected error 'from and to are required', got %v", err)
+				}
+			},
+		},
+		{
+			name:      "Account not found",
+			accountID: "account_nonexistent",
+			from:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:        time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+			validate: func(t *testing.T, st *statement.Statement, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusNotFound {
+					t.Fatalf("expected not found, got %v", err)
+				}
+			},
+		},
+		{
+			name:      "Stream fails",
+			accountID: "stream_fail",
+			from:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:        time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+			validate: func(t *testing.T, st *statement.Statement, err *model.ErrorResponse) {
+				if err == nil || err.Message != "failed to build statement" {
+					t.Fatalf("expected error 'failed to build statement', got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := service.BuildStatement(context.Background(), tt.accountID, tt.from, tt.to, statement.DefaultCurrency)
+			tt.validate(t, st, err)
+		})
+	}
+}
+
+func Test_CreateTransactionLocksAccounts(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	purchase := model.TransactionRequestBody{AccountID: "valid_id", OperationID: model.OperationTypePurchase, Amount: -10}
+
+	locker := &MockLocker{}
+	service := NewTransactionService(&MockMongoRepo{}, logger, locker)
+	if _, errResp := service.CreateTransaction(ctx, purchase, "x-idempotency-key-locked"); errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	if len(locker.locked) != 1 || locker.locked[0] != "account/valid_id" || locker.held != 0 {
+		t.Errorf("expected the account to be locked and let go, got %v with %d held", locker.locked, locker.held)
+	}
+
+	// the items of an atomic batch share a transaction so their accounts are all locked up front, once each, in order
+	locker = &MockLocker{}
+	service = NewTransactionService(&MockMongoRepo{}, logger, locker)
+	batch := model.TransactionBatchRequestBody{Items: []model.TransactionBatchItem{
+		{IdempotencyKey: "batch-key-1", TransactionRequestBody: model.TransactionRequestBody{AccountID: "valid_id", OperationID: model.OperationTypePurchase, Amount: -10}},
+		{IdempotencyKey: "batch-key-2", TransactionRequestBody: model.TransactionRequestBody{AccountID: "projected_id", OperationID: model.OperationTypePurchase, Amount: -10}},
+		{IdempotencyKey: "batch-key-3", TransactionRequestBody: model.TransactionRequestBody{AccountID: "valid_id", OperationID: model.OperationTypePurchase, Amount: -10}},
+	}}
+	if _, errResp := service.CreateTransactionsBatch(ctx, batch); errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	if want := []string{"account/projected_id", "account/valid_id"}; !slices.Equal(locker.locked, want) || locker.held != 0 {
+		t.Errorf("expected %v to be locked and let go, got %v with %d held", want, locker.locked, locker.held)
+	}
+
+	locker = &MockLocker{busy: map[string]bool{"account/valid_id": true}}
+	service = NewTransactionService(&MockMongoRepo{}, logger, locker)
+	if _, errResp := service.CreateTransaction(ctx, purchase, "x-idempotency-key-busy"); errResp == nil || errResp.Status != http.StatusConflict || errResp.RetryAfter != 1 {
+		t.Errorf("expected status 409 with Retry-After 1 while the account is busy, got %v", errResp)
+	}
+	if _, errResp := service.CreateTransactionsBatch(ctx, batch); errResp == nil || errResp.Status != http.StatusConflict || locker.held != 0 {
+		t.Errorf("expected the batch to fail without holding any lock, got %v with %d held", errResp, locker.held)
+	}
+}
+
+func Test_CreateTransactionStopsOnceTheLockIsLost(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	store := database.NewMemory()
+	account, errResp := NewAccountsService(store, logger).CreateAccount(ctx, "12345678900")
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	stored, err := store.GetAccountByID(ctx, account.AccountID)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	locker := &MockLocker{lost: map[string]bool{"account/" + stored.ID.Hex(): true}}
+	service := NewTransactionService(store, logger, locker)
+
+	purchase := model.TransactionRequestBody{AccountID: account.AccountID, OperationID: model.OperationTypePurchase, Amount: -10}
+	if _, errResp := service.CreateTransaction(ctx, purchase, "lost"); errResp == nil || errResp.Status != http.StatusConflict || errResp.RetryAfter != 1 {
+		t.Errorf("expected status 409 with Retry-After 1 once the lock is lost, got %v", errResp)
+	}
+	batch := model.TransactionBatchRequestBody{Items: []model.TransactionBatchItem{
+		{IdempotencyKey: "lost-batch", TransactionRequestBody: purchase},
+	}}
+	if _, errResp := service.CreateTransactionsBatch(ctx, batch); errResp == nil || errResp.Status != http.StatusConflict {
+		t.Errorf("expected the batch to fail with status 409 once the lock is lost, got %v", errResp)
+	}
+	for _, key := range []string{"lost", "lost-batch"} {
+		if tx, err := store.FindTransactionByIdempotencyKey(ctx, key); err != nil || tx != nil {
+			t.Errorf("expected nothing committed once the lock was lost, got %+v and %v", tx, err)
+		}
+	}
+	if locker.held != 0 {
+		t.Errorf("expected the lost locks to be let go, got %d held", locker.held)
+	}
+}
+
+func Test_CreateTransactionAppendsEvent(t *testing.T) {
+	repo := &MockMongoRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewTransactionService(repo, logger, &MockLocker{})
+
+	_, err := service.CreateTransaction(context.Background(), model.TransactionRequestBody{
+		AccountID:   "valid_id",
+		OperationID: model.OperationTypePurchase,
+		Amount:      -123.5,
+	}, "x-idempotency-key-event")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// a replayed request changes nothing so it must not raise another event
+	_, err = service.CreateTransaction(context.Background(), model.TransactionRequestBody{
+		AccountID:   "valid_id",
+		OperationID: model.OperationTypePurchase,
+		Amount:      -123.5,
+	}, "x-idempotency-key-duplicate")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if len(repo.events) != 1 || repo.events[0].Type != model.EventTypeTransactionCreated {
+		t.Fatalf("expected a single TransactionCreated event, got %v", repo.events)
+	}
+	var payload model.TransactionCreatedEvent
+	if err := json.Unmarshal(repo.events[0].Payload, &payload); err != nil {
+		t.Fatalf("expected a JSON payload, got %v", err)
+	}
+	if payload.AccountID != "valid_id" || payload.Amount != -123.5 || payload.Balance != -123.5 || payload.TransactionID != repo.events[0].AggregateID {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func Test_CreateTransactionPostsJournalEntry(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	tests := []struct {
+		name        string
+		transaction model.TransactionRequestBody
+		validate    func(t *testing.T, repo *MockMongoRepo, resp *model.TransactionResponseBody)
+	}{
+		{
+			name:        "Purchase is owed by the customer to the merchant",
+			transaction: model.TransactionRequestBody{AccountID: "valid_id", OperationID: model.OperationTypePurchase, Amount: -12.3},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.TransactionResponseBody) {
+				if len(repo.journal) != 1 {
+					t.Fatalf("expected one journal entry, got %d", len(repo.journal))
+				}
+				lines := repo.journal[0].Lines
+				if len(lines) != 2 || lines[0] != (model.JournalLine{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 1230, AppliesTo: repo.journal[0].TransactionID}) ||
+					lines[1] != (model.JournalLine{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 1230}) {
+					t.Errorf("unexpected lines %+v", lines)
+				}
+			},
+		},
+		{
+			name:        "Payment is allocated to the oldest debts first",
+			transaction: model.TransactionRequestBody{AccountID: "valid_id", OperationID: model.OperationTypePayment, Amount: 60},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.TransactionResponseBody) {
+				if len(repo.journal) != 1 {
+					t.Fatalf("expected one journal entry, got %d", len(repo.journal))
+				}
+				want := []model.JournalLine{
+					{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 6000},
+					{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 5000, AppliesTo: oldestDebtID.Hex()},
+					{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 1000, AppliesTo: newestDebtID.Hex()},
+				}
+				lines := repo.journal[0].Lines
+				if len(lines) != len(want) {
+					t.Fatalf("expected %d lines, got %+v", len(want), lines)
+				}
+				for i := range want {
+					if lines[i] != want[i] {
+						t.Errorf("line %d: expected %+v, got %+v", i, want[i], lines[i])
+					}
+				}
+				// what is left of the debts is only posted, the transactions stay as they were made
+				if len(repo.updated) != 0 {
+					t.Errorf("expected the debts' transactions to be left alone, got %v", repo.updated)
+				}
+				if types := repo.appendedEvents(); len(types) != 3 || types[1] != model.EventTypeDebtDischarged || types[2] != model.EventTypeDebtDischarged {
+					t.Fatalf("expected two DebtDischarged events, got %v", types)
+				}
+				var discharged model.DebtDischargedEvent
+				if err := json.Unmarshal(repo.events[2].Payload, &discharged); err != nil {
+					t.Fatalf("expected a JSON payload, got %v", err)
+				}
+				if discharged.TransactionID != debtPublicIDs[newestDebtID] || discharged.PreviousBalance != -23.5 || discharged.Balance != -13.5 {
+					t.Errorf("expected the newest debt to be partly discharged, got %+v", discharged)
+				}
+				// the account had no projection so it is built from the transactions and created at version 1
+				if len(repo.balances) != 1 {
+					t.Fatalf("expected the balance projection to be saved once, got %d", len(repo.balances))
+				}
+				if balance := repo.balances[0]; balance.Version != 1 || balance.TotalDebtCents != 1350 || balance.AvailableCreditCents != 0 ||
+					len(balance.OpenDebts) != 1 || balance.OpenDebts[0] != (model.OpenDebt{TransactionID: newestDebtID.Hex(), BalanceCents: -1350}) {
+					t.Errorf("unexpected balance projection %+v", balance)
+				}
+			},
+		},
+		{
+			name:        "Payment beyond the debt is held as customer credit",
+			transaction: model.TransactionRequestBody{AccountID: "valid_id", OperationID: model.OperationTypePayment, Amount: 100},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.TransactionResponseBody) {
+				lines := repo.journal[0].Lines
+				last := lines[len(lines)-1]
+				if last != (model.JournalLine{LedgerAccount: model.LedgerAccountCustomerCredit, Side: model.EntrySideCredit, AmountCents: 2650, AppliesTo: repo.journal[0].TransactionID}) {
+					t.Errorf("expected the excess as customer credit, got %+v", last)
+				}
+			},
+		},
+		{
+			name:        "Payment is planned from the balance projection",
+			transaction: model.TransactionRequestBody{AccountID: "projected_id", OperationID: model.OperationTypePayment, Amount: 100},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.TransactionResponseBody) {
+				if types := repo.appendedEvents(); len(types) != 3 {
+					t.Errorf("expected both debts to be discharged, got %v", types)
+				}
+				if balance := repo.balances[0]; balance.Version != 5 || balance.TotalDebtCents != 0 || balance.AvailableCreditCents != 2650 || len(balance.OpenDebts) != 0 {
+					t.Errorf("unexpected balance projection %+v", balance)
+				}
+			},
+		},
+		{
+			name:        "Payment is retried when a concurrent request discharged the same debt",
+			transaction: model.TransactionRequestBody{AccountID: "contended_id", OperationID: model.OperationTypePayment, Amount: 30},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.TransactionResponseBody) {
+				if repo.attempts["contended_id"] != 2 {
+					t.Errorf("expected the payment to be retried once, got %d attempts", repo.attempts["contended_id"])
+				}
+				// the attempt that lost the race was rolled back along with what it posted
+				if len(repo.journal) != 1 || len(repo.balances) != 1 {
+					t.Errorf("expected one journal entry and one balance projection, got %d and %d", len(repo.journal), len(repo.balances))
+				}
+				if types := repo.appendedEvents(); len(types) != 2 || types[1] != model.EventTypeDebtDischarged {
+					t.Errorf("expected the debt to be discharged once, got %v", types)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &MockMongoRepo{}
+			service := NewTransactionService(repo, logger, &MockLocker{})
+			resp, err := service.CreateTransaction(context.Background(), tt.transaction, "x-idempotency-key-journal")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			tt.validate(t, repo, resp)
+		})
+	}
+}
+
+func Test_Reconcile(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	tests := []struct {
+		name     string
+		req      model.ReconciliationRequestBody
+		validate func(t *testing.T, repo *MockMongoRepo, resp *model.ReconciliationResponseBody, err *model.ErrorResponse)
+	}{
+		{
+			name: "Drift is reported without being repaired",
+			req:  model.ReconciliationRequestBody{AccountID: "drifted_id"},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.ReconciliationResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.AccountsChecked != 1 || resp.TransactionsChecked != 3 || resp.TransactionsDrifted != 1 || resp.Repaired != 0 {
+					t.Errorf("unexpected counts %+v", resp)
+				}
+				if len(resp.Accounts) != 1 || resp.Accounts[0].Drift != 50 {
+					t.Fatalf("expected 50 of drift on the account, got %+v", resp.Accounts)
+				}
+				drift := resp.Accounts[0].Transactions[0]
+				if drift.TransactionID != debtPublicIDs[oldestDebtID] || drift.StoredBalance != -50 || drift.ExpectedBalance != 0 || drift.Repaired {
+					t.Errorf("unexpected drift %+v", drift)
+				}
+				if len(repo.updated) != 0 {
+					t.Errorf("expected nothing to be written, got %v", repo.updated)
+				}
+			},
+		},
+		{
+			name: "Drift is repaired",
+			req:  model.ReconciliationRequestBody{AccountID: "drifted_id", Repair: true},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.ReconciliationResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.Repaired != 1 || !resp.Accounts[0].Transactions[0].Repaired {
+					t.Errorf("expected the drift to be repaired, got %+v", resp)
+				}
+				if len(repo.updated) != 0 {
+					t.Errorf("expected the transactions to be left alone, got %v", repo.updated)
+				}
+				want := []model.JournalLine{
+					{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 5000, AppliesTo: oldestDebtID.Hex()},
+					{LedgerAccount: model.LedgerAccountSuspense, Side: model.EntrySideDebit, AmountCents: 5000},
+				}
+				if len(repo.journal) != 1 || !slices.Equal(repo.journal[0].Lines, want) {
+					t.Errorf("expected the journal to be adjusted to the replayed balance, got %+v", repo.journal)
+				}
+				if len(repo.balances) != 1 || repo.balances[0].Version != 1 || accountKey(repo.balances[0].AccountID) != "drifted_id" {
+					t.Errorf("expected the balance projection to be rebuilt, got %+v", repo.balances)
+				}
+			},
+		},
+		{
+			name: "Balances without drift",
+			req:  model.ReconciliationRequestBody{AccountID: "valid_id", Repair: true},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.ReconciliationResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.TransactionsChecked != 2 || resp.TransactionsDrifted != 0 || resp.Accounts == nil {
+					t.Errorf("expected no drift, got %+v", resp)
+				}
+			},
+		},
+		{
+			name: "Account not found",
+			req:  model.ReconciliationRequestBody{AccountID: "account_nonexistent"},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.ReconciliationResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusNotFound {
+					t.Fatalf("expected not found, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Stream fails",
+			req:  model.ReconciliationRequestBody{AccountID: "stream_fail"},
+			validate: func(t *testing.T, repo *MockMongoRepo, resp *model.ReconciliationResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusInternalServerError {
+					t.Fatalf("expected internal server error, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &MockMongoRepo{}
+			service := NewTransactionService(repo, logger, &MockLocker{})
+			resp, err := service.Reconcile(context.Background(), tt.req)
+			tt.validate(t, repo, resp, err)
+		})
+	}
+}
+
+// hookLocker runs before once, as the first lock is taken, and otherwise locks like MockLocker
+type hookLocker struct {
+	MockLocker
+	before func()
+}
+
+func (l *hookLocker) Lock(ctx context.Context, key string) (context.Context, func(), error) {
+	if before := l.before; before != nil {
+		l.before = nil
+		before()
+	}
+	return l.MockLocker.Lock(ctx, key)
+}
+
+func Test_ReconcileRepairsTheAccountAsItIsOnceLocked(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	store := database.NewMemory()
+	locker := &hookLocker{}
+	accountService := NewAccountsService(store, logger)
+	service := NewTransactionService(store, logger, locker)
+
+	account, errResp := accountService.CreateAccount(ctx, "12345678900")
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	if _, errResp := service.CreateTransaction(ctx, model.TransactionRequestBody{AccountID: account.AccountID, OperationID: model.OperationTypePurchase, Amount: -50}, "purchase"); errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	stored, err := store.GetAccountByID(ctx, account.AccountID)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	purchase, err := store.FindTransactionByIdempotencyKey(ctx, "purchase")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// the purchase drifts, the journal has 30 of it paid off where the replay expects 50 owed on it
+	if err := store.PostJournalEntry(ctx, model.JournalEntry{AccountID: stored.ID.Hex(), PostedAt: purchase.EventDate, Lines: []model.JournalLine{
+		{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 3000, AppliesTo: purchase.ID.Hex()},
+		{LedgerAccount: model.LedgerAccountSuspense, Side: model.EntrySideDebit, AmountCents: 3000},
+	}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// a payment pays the purchase off between the replay and the repair
+	locker.before = func() {
+		if _, errResp := service.CreateTransaction(ctx, model.TransactionRequestBody{AccountID: account.AccountID, OperationID: model.OperationTypePayment, Amount: 50}, "payment"); errResp != nil {
+			t.Errorf("expected no error, got %v", errResp)
+		}
+	}
+	resp, errResp := service.Reconcile(ctx, model.ReconciliationRequestBody{AccountID: account.AccountID, Repair: true})
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	// the drift repaired is the one left once the payment was posted, repairing the one found first would undo it
+	if resp.Repaired != 1 || len(resp.Accounts) != 1 || len(resp.Accounts[0].Transactions) != 1 {
+		t.Fatalf("expected the purchase to be repaired, got %+v", resp)
+	}
+	if drift := resp.Accounts[0].Transactions[0]; drift.StoredBalance != 30 || drift.ExpectedBalance != 0 {
+		t.Errorf("expected the drift as it was once the payment was made, got %+v", drift)
+	}
+	entries, err := store.FindJournalEntries(ctx, stored.ID.Hex(), time.Now().UTC())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if balance := ledger.TransactionBalances(entries)[purchase.ID.Hex()]; balance != 0 {
+		t.Errorf("expected the payment to keep the purchase paid off, got a balance of %d", balance)
+	}
+}
+
+func Test_ReconcileAdjustsTheJournalAndReportsAccountsThatFail(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	store := database.NewMemory()
+	locker := &hookLocker{}
+	accountService := NewAccountsService(store, logger)
+	service := NewTransactionService(store, logger, locker)
+
+	// each account has a purchase the journal says 20 is owed on rather than 50
+	var accountIDs, internalIDs []string
+	for i, document := range []string{"12345678900", "98765432100"} {
+		account, errResp := accountService.CreateAccount(ctx, document)
+		if errResp != nil {
+			t.Fatalf("expected no error, got %v", errResp)
+		}
+		key := fmt.Sprintf("purchase-%d", i)
+		if _, errResp := service.CreateTransaction(ctx, model.TransactionRequestBody{AccountID: account.AccountID, OperationID: model.OperationTypePurchase, Amount: -50}, key); errResp != nil {
+			t.Fatalf("expected no error, got %v", errResp)
+		}
+		purchase, err := store.FindTransactionByIdempotencyKey(ctx, key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		err = store.PostJournalEntry(ctx, model.JournalEntry{ID: bson.NewObjectID(), AccountID: purchase.AccountID, PostedAt: time.Now().UTC().Truncate(time.Millisecond), Lines: []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 3000, AppliesTo: purchase.ID.Hex()},
+			{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 3000},
+		}})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		accountIDs, internalIDs = append(accountIDs, account.AccountID), append(internalIDs, purchase.AccountID)
+	}
+
+	// the second account stays locked by someone else
+	locker.busy = map[string]bool{"account/" + internalIDs[1]: true}
+	resp, errResp := service.Reconcile(ctx, model.ReconciliationRequestBody{Repair: true})
+	if errResp != nil {
+		t.Fatalf("expected no error, got %v", errResp)
+	}
+	if resp.Repaired != 1 || resp.Failed != 1 || len(resp.Accounts) != 2 {
+		t.Fatalf("expected one account repaired and one failed, got %+v", resp)
+	}
+	for _, account := range resp.Accounts {
+		switch account.AccountID {
+		case accountIDs[0]:
+			if account.Error != nil || len(account.Transactions) != 1 || !account.Transactions[0].Repaired {
+				t.Errorf("expected the first account to be repaired, got %+v", account)
+			}
+		case accountIDs[1]:
+			if account.Error == nil || account.Error.Status != http.StatusConflict || len(account.Transactions) != 1 || account.Transactions[0].Repaired {
+				t.Errorf("expected the second account to be reported with its error, got %+v", account)
+			}
+		default:
+			t.Errorf("unexpected account %s", account.AccountID)
+		}
+	}
+
+	// the journal was adjusted, the projection rebuilt from it and the journal as of now both give the repaired balance
+	entries, err := store.FindJournalEntries(ctx, internalIDs[0], time.Now().UTC())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	adjustment := entries[len(entries)-1]
+	if adjustment.TransactionID != "" || len(adjustment.Lines) != 2 || adjustment.Lines[1].LedgerAccount != model.LedgerAccountSuspense {
+		t.Errorf("expected an adjustment against the suspense account, got %+v", adjustment)
+	}
+	for _, asOf := range []time.Time{{}, time.Now().UTC()} {
+		balance, errResp := accountService.GetAccountBalance(ctx, accountIDs[0], asOf)
+		if errResp != nil || balance.TotalDebt != 50 {
+			t.Errorf("expected 50 owed as of %v, got %+v, %v", asOf, balance, errResp)
+		}
+	}
+	trialBalance, errResp := NewLedgerService(store, logger).TrialBalance(ctx)
+	if errResp != nil || !trialBalance.Balanced {
+		t.Errorf("expected a balanced trial balance, got %+v, %v", trialBalance, errResp)
+	}
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/webhook"
+)
+
+const (
+	// minWebhookSecretLength keeps caller chosen secrets long enough to be worth signing with
+	minWebhookSecretLength = 16
+	// maxWebhookDeliveries is the most deliveries returned by a single list request
+	maxWebhookDeliveries = 500
+)
+
+type WebhooksInterface interface {
+	CreateSubscription(ctx context.Context, body model.WebhookSubscriptionRequestBody) (*model.WebhookSubscriptionResponseBody, *model.ErrorResponse)
+	GetSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscriptionResponseBody, *model.ErrorResponse)
+	ListSubscriptions(ctx context.Context) ([]model.WebhookSubscriptionResponseBody, *model.ErrorResponse)
+	UpdateSubscription(ctx context.Context, subscriptionID string, body model.WebhookSubscriptionRequestBody) (*model.WebhookSubscriptionResponseBody, *model.ErrorResponse)
+	DeleteSubscription(ctx context.Context, subscriptionID string) *model.ErrorResponse
+	ListDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter) ([]model.WebhookDeliveryResponseBody, *model.ErrorResponse)
+	ReplayDelivery(ctx context.Context, deliveryID string) (*model.WebhookDeliveryResponseBody, *model.ErrorResponse)
+}
+
+type WebhooksService struct {
+	repo     repository.WebhookRepository
+	logger   *slog.Logger
+	resolver webhook.Resolver
+}
+
+// NewWebhooksService checks the URL of every subscription with resolver, only URLs leading to public addresses are
+// accepted
+func NewWebhooksService(repo repository.WebhookRepository, logger *slog.Logger, resolver webhook.Resolver) *WebhooksService {
+	logger.InfoContext(context.Background(), "WebhooksService initialized")
+	return &WebhooksService{repo: repo, logger: logger, resolver: resolver}
+}
+
+func (s *WebhooksService) CreateSubscription(ctx context.Context, body model.WebhookSubscriptionRequestBody) (*model.WebhookSubscriptionResponseBody, *model.ErrorResponse) {
+	if errResp := validateSubscription(body, true); errResp != nil {
+		return nil, errResp
+	}
+	if errResp := s.checkDestination(ctx, body.URL); errResp != nil {
+		return nil, errResp
+	}
+	secret := body.Secret
+	if secret == "" {
+		secret = newWebhookSecret()
+	}
+	now := time.Now().UTC()
+	subscription, err := s.repo.CreateWebhookSubscription(ctx, model.WebhookSubscription{
+		URL:        body.URL,
+		EventTypes: body.EventTypes,
+		Secret:     secret,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create webhook subscription", "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to create webhook subscription",
+		}
+	}
+	s.logger.InfoContext(ctx, "created webhook subscription", "subscriptionID", subscription.ID.Hex(), "url", subscription.URL)
+	resp := newSubscriptionResponse(*subscription)
+	resp.Secret = subscription.Secret
+	return &resp, nil
+}
+
+func (s *WebhooksService) GetSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscriptionResponseBody, *model.ErrorResponse) {
+	subscription, errResp := s.getSubscription(ctx, subscriptionID)
+	if errResp != nil {
+		return nil, errResp
+	}
+	resp := newSubscriptionResponse(*subscription)
+	return &resp, nil
+}
+
+func (s *WebhooksService) ListSubscriptions(ctx context.Context) ([]model.WebhookSubscriptionResponseBody, *model.ErrorResponse) {
+	subscriptions, err := s.repo.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list webhook subscriptions", "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to list webhook subscriptions",
+		}
+	}
+	resp := make([]model.WebhookSubscriptionResponseBody, len(subscriptions))
+	for i, subscription := range subscriptions {
+		resp[i] = newSubscriptionResponse(subscription)
+	}
+	return resp, nil
+}
+
+// UpdateSubscription replaces the URL and event types of a subscription, the secret is only rotated when a new one is given
+func (s *WebhooksService) UpdateSubscription(ctx context.Context, subscriptionID string, body model.WebhookSubscriptionRequestBody) (*model.WebhookSubscriptionResponseBody, *model.ErrorResponse) {
+	if errResp := validateSubscription(body, false); errResp != nil {
+		return nil, errResp
+	}
+	if errResp := s.checkDestination(ctx, body.URL); errResp != nil {
+		return nil, errResp
+	}
+	subscription, errResp := s.getSubscription(ctx, subscriptionID)
+	if errResp != nil {
+		return nil, errResp
+	}
+	subscription.URL = body.URL
+	subscription.EventTypes = body.EventTypes
+	if body.Secret != "" {
+		subscription.Secret = body.Secret
+	}
+	subscription.UpdatedAt = time.Now().UTC()
+	if err := s.repo.UpdateWebhookSubscription(ctx, subscriptionID, *subscription); err != nil {
+		s.logger.ErrorContext(ctx, "failed to update webhook subscription", "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to update webhook subscription",
+		}
+	}
+	resp := newSubscriptionResponse(*subscription)
+	return &resp, nil
+}
+
+func (s *WebhooksService) DeleteSubscription(ctx context.Context, subscriptionID string) *model.ErrorResponse {
+	if _, errResp := s.getSubscription(ctx, subscriptionID); errResp != nil {
+		return errResp
+	}
+	if err := s.repo.DeleteWebhookSubscription(ctx, subscriptionID); err != nil {
+		s.logger.ErrorContext(ctx, "failed to delete webhook subscription", "error", err)
+		return &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to delete webhook subscription",
+		}
+	}
+	s.logger.InfoContext(ctx, "deleted webhook subscription", "subscriptionID", subscriptionID)
+	return nil
+}
+
+func (s *WebhooksService) ListDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter) ([]model.WebhookDeliveryResponseBody, *model.ErrorResponse) {
+	switch filter.Status {
+	case "", model.WebhookDeliveryPending, model.WebhookDeliverySucceeded, model.WebhookDeliveryDeadLetter:
+	default:
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "status must be one of pending, succeeded or dead_letter",
+		}
+	}
+	deliveries, err := s.repo.ListWebhookDeliveries(ctx, filter, maxWebhookDeliveries)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list webhook deliveries", "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to list webhook deliveries",
+		}
+	}
+	resp := make([]model.WebhookDeliveryResponseBody, len(deliveries))
+	for i, delivery := range deliveries {
+		resp[i] = newDeliveryResponse(delivery)
+	}
+	return resp, nil
+}
+
+// ReplayDelivery puts a dead lettered delivery back in the queue with a fresh set of attempts
+func (s *WebhooksService) ReplayDelivery(ctx context.Context, deliveryID string) (*model.WebhookDeliveryResponseBody, *model.ErrorResponse) {
+	delivery, err := s.repo.GetWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get webhook delivery", "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to get webhook delivery",
+		}
+	}
+	if delivery == nil {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusNotFound,
+			Message: "webhook delivery not found",
+		}
+	}
+	if delivery.Status != model.WebhookDeliveryDeadLetter {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusConflict,
+			Message: "only dead lettered deliveries can be replayed",
+		}
+	}
+	deadLetteredAt := delivery.NextAttemptAt
+	delivery.Status = model.WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now().UTC()
+	delivery.LastError = ""
+	delivery.LastStatusCode = 0
+	if err := s.repo.UpdateWebhookDelivery(ctx, deliveryID, *delivery, deadLetteredAt); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, &model.ErrorResponse{
+				Status:  http.StatusConflict,
+				Message: "webhook delivery was replayed concurrently",
+			}
+		}
+		s.logger.ErrorContext(ctx, "failed to replay webhook delivery", "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to replay webhook delivery",
+		}
+	}
+	s.logger.InfoContext(ctx, "replaying webhook delivery", "deliveryID", deliveryID)
+	resp := newDeliveryResponse(*delivery)
+	return &resp, nil
+}
+
+func (s *WebhooksService) getSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscription, *model.ErrorResponse) {
+	subscription, err := s.repo.GetWebhookSubscription(ctx, subscriptionID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get webhook subscription", "error", err)
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusInternalServerError,
+			Message: "failed to get webhook subscription",
+		}
+	}
+	if subscription == nil {
+		return nil, &model.ErrorResponse{
+			Status:  http.StatusNotFound,
+			Message: "webhook subscription not found",
+		}
+	}
+	return subscription, nil
+}
+
+func validateSubscription(body model.WebhookSubscriptionRequestBody, creating bool) *model.ErrorResponse {
+	target, err := url.Parse(body.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "url must be an absolute http or https URL",
+		}
+	}
+	if len(body.EventTypes) == 0 {
+		return &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "event_types must contain at least one event type",
+		}
+	}
+	for _, eventType := range body.EventTypes {
+		if !eventType.Valid() {
+			return &model.ErrorResponse{
+				Status:  http.StatusBadRequest,
+				Message: "event_types must only contain AccountCreated, TransactionCreated or DebtDischarged",
+			}
+		}
+	}
+	if body.Secret != "" && len(body.Secret) < minWebhookSecretLength {
+		return &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "secret must be at least 16 characters",
+		}
+	}
+	return nil
+}
+
+// checkDestination rejects a URL leading to an address off the public internet, the dispatcher refuses to connect to
+// one as well but a subscription that can never be delivered to is better refused up front
+func (s *WebhooksService) checkDestination(ctx context.Context, rawURL string) *model.ErrorResponse {
+	err := webhook.CheckDestination(ctx, s.resolver, rawURL)
+	if err == nil {
+		return nil
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, webhook.ErrForbiddenDestination):
+		return &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "url must lead to a public address",
+		}
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return &model.ErrorResponse{
+			Status:  http.StatusBadRequest,
+			Message: "url host could not be found",
+		}
+	default:
+		s.logger.ErrorContext(ctx, "failed to resolve webhook url", "url", rawURL, "error", err)
+		return &model.ErrorResponse{
+			Status:     http.StatusServiceUnavailable,
+			Message:    "failed to resolve the url host",
+			RetryAfter: unavailableRetryAfter,
+		}
+	}
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	// crypto/rand.Read never returns an error
+	_, _ = rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+func newSubscriptionResponse(subscription model.WebhookSubscription) model.WebhookSubscriptionResponseBody {
+	return model.WebhookSubscriptionResponseBody{
+		SubscriptionID: subscription.ID.Hex(),
+		URL:            subscription.URL,
+		EventTypes:     subscription.EventTypes,
+		CreatedAt:      subscription.CreatedAt,
+		UpdatedAt:      subscription.UpdatedAt,
+	}
+}
+
+func newDeliveryResponse(delivery model.WebhookDelivery) model.WebhookDeliveryResponseBody {
+	resp := model.WebhookDeliveryResponseBody{
+		DeliveryID:     delivery.ID.Hex(),
+		SubscriptionID: delivery.SubscriptionID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastError:      delivery.LastError,
+		LastStatusCode: delivery.LastStatusCode,
+		CreatedAt:      delivery.CreatedAt,
+		DeliveredAt:    delivery.DeliveredAt,
+	}
+	if delivery.Status == model.WebhookDeliveryPending {
+		resp.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	return resp
+}
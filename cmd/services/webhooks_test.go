@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var (
+	webhookSubscriptionID = bson.NewObjectID()
+	deadLetterDeliveryID  = bson.NewObjectID()
+	pendingDeliveryID     = bson.NewObjectID()
+)
+
+type MockWebhookRepo struct {
+	updated *model.WebhookDelivery
+}
+
+func (m *MockWebhookRepo) CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (*model.WebhookSubscription, error) {
+	if subscription.URL == "https://example.com/fail" {
+		return nil, errors.New("insert failed")
+	}
+	subscription.ID = bson.NewObjectID()
+	return &subscription, nil
+}
+
+func (m *MockWebhookRepo) GetWebhookSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscription, error) {
+	switch subscriptionID {
+	case webhookSubscriptionID.Hex():
+		return &model.WebhookSubscription{
+			ID:         webhookSubscriptionID,
+			URL:        "https://example.com/hook",
+			EventTypes: []model.EventType{model.EventTypeAccountCreated},
+			Secret:     "existing-secret-value",
+		}, nil
+	case "subscription_fail":
+		return nil, errors.New("database error")
+	default:
+		return nil, nil
+	}
+}
+
+func (m *MockWebhookRepo) ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	return []model.WebhookSubscription{{ID: webhookSubscriptionID, URL: "https://example.com/hook", Secret: "existing-secret-value"}}, nil
+}
+
+func (m *MockWebhookRepo) UpdateWebhookSubscription(ctx context.Context, subscriptionID string, subscription model.WebhookSubscription) error {
+	return nil
+}
+
+func (m *MockWebhookRepo) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	return nil
+}
+
+func (m *MockWebhookRepo) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockWebhookRepo) GetWebhookDelivery(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error) {
+	switch deliveryID {
+	case deadLetterDeliveryID.Hex():
+		return &model.WebhookDelivery{
+			ID:             deadLetterDeliveryID,
+			Status:         model.WebhookDeliveryDeadLetter,
+			Attempts:       8,
+			LastError:      "endpoint responded with 500",
+			LastStatusCode: http.StatusInternalServerError,
+		}, nil
+	case pendingDeliveryID.Hex():
+		return &model.WebhookDelivery{ID: pendingDeliveryID, Status: model.WebhookDeliveryPending}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *MockWebhookRepo) ListWebhookDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter, limit int) ([]model.WebhookDelivery, error) {
+	return []model.WebhookDelivery{{ID: deadLetterDeliveryID, Status: filter.Status}}, nil
+}
+
+func (m *MockWebhookRepo) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockWebhookRepo) UpdateWebhookDelivery(ctx context.Context, deliveryID string, delivery model.WebhookDelivery, expectedNextAttemptAt time.Time) error {
+	m.updated = &delivery
+	return nil
+}
+
+// testResolver resolves the hosts of the tests, every other host to a public address
+type testResolver struct{}
+
+func (testResolver) LookupNetIP(ctx context.Context, network string, host string) ([]netip.Addr, error) {
+	switch host {
+	case "metadata.internal":
+		return []netip.Addr{netip.MustParseAddr("93.184.215.14"), netip.MustParseAddr("169.254.169.254")}, nil
+	case "missing.example.com":
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	case "flaky.example.com":
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	}
+	return []netip.Addr{netip.MustParseAddr("93.184.215.14")}, nil
+}
+
+func Test_CreateSubscription(t *testing.T) {
+	repo := &MockWebhookRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewWebhooksService(repo, logger, testResolver{})
+
+	tests := []struct {
+		name     string
+		body     model.WebhookSubscriptionRequestBody
+		validate func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse)
+	}{
+		{
+			name: "Secret is generated when left out",
+			body: model.WebhookSubscriptionRequestBody{URL: "https://example.com/hook", EventTypes: []model.EventType{model.EventTypeTransactionCreated}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if !strings.HasPrefix(resp.Secret, "whsec_") || resp.SubscriptionID == "" {
+					t.Errorf("expected a generated secret and an ID, got %+v", resp)
+				}
+			},
+		},
+		{
+			name: "Given secret is kept",
+			body: model.WebhookSubscriptionRequestBody{URL: "http://partner.internal/events", EventTypes: []model.EventType{model.EventTypeAccountCreated}, Secret: "a-long-enough-secret"},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if resp.Secret != "a-long-enough-secret" {
+					t.Errorf("expected the given secret, got %s", resp.Secret)
+				}
+			},
+		},
+		{
+			name: "Relative URL",
+			body: model.WebhookSubscriptionRequestBody{URL: "/hook", EventTypes: []model.EventType{model.EventTypeAccountCreated}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Message != "url must be an absolute http or https URL" {
+					t.Fatalf("expected URL error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Unknown event type",
+			body: model.WebhookSubscriptionRequestBody{URL: "https://example.com/hook", EventTypes: []model.EventType{"AccountDeleted"}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest {
+					t.Fatalf("expected bad request, got %v", err)
+				}
+			},
+		},
+		{
+			name: "No event types",
+			body: model.WebhookSubscriptionRequestBody{URL: "https://example.com/hook"},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Message != "event_types must contain at least one event type" {
+					t.Fatalf("expected event types error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Secret too short",
+			body: model.WebhookSubscriptionRequestBody{URL: "https://example.com/hook", EventTypes: []model.EventType{model.EventTypeAccountCreated}, Secret: "short"},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Message != "secret must be at least 16 characters" {
+					t.Fatalf("expected secret error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Loopback URL",
+			body: model.WebhookSubscriptionRequestBody{URL: "http://127.0.0.1:8080/hook", EventTypes: []model.EventType{model.EventTypeAccountCreated}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest || err.Message != "url must lead to a public address" {
+					t.Fatalf("expected public address error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Cloud metadata URL",
+			body: model.WebhookSubscriptionRequestBody{URL: "http://169.254.169.254/latest/meta-data", EventTypes: []model.EventType{model.EventTypeAccountCreated}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest || err.Message != "url must lead to a public address" {
+					t.Fatalf("expected public address error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Private IPv6 URL",
+			body: model.WebhookSubscriptionRequestBody{URL: "http://[fd00::1]/hook", EventTypes: []model.EventType{model.EventTypeAccountCreated}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest || err.Message != "url must lead to a public address" {
+					t.Fatalf("expected public address error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Host resolving to a private address",
+			body: model.WebhookSubscriptionRequestBody{URL: "https://metadata.internal/hook", EventTypes: []model.EventType{model.EventTypeAccountCreated}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest || err.Message != "url must lead to a public address" {
+					t.Fatalf("expected public address error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Unknown host",
+			body: model.WebhookSubscriptionRequestBody{URL: "https://missing.example.com/hook", EventTypes: []model.EventType{model.EventTypeAccountCreated}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusBadRequest || err.Message != "url host could not be found" {
+					t.Fatalf("expected unknown host error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Host that cannot be resolved for now",
+			body: model.WebhookSubscriptionRequestBody{URL: "https://flaky.example.com/hook", EventTypes: []model.EventType{model.EventTypeAccountCreated}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusServiceUnavailable || err.RetryAfter == 0 {
+					t.Fatalf("expected service unavailable with a Retry-After, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Insert fails",
+			body: model.WebhookSubscriptionRequestBody{URL: "https://example.com/fail", EventTypes: []model.EventType{model.EventTypeAccountCreated}},
+			validate: func(t *testing.T, resp *model.WebhookSubscriptionResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusInternalServerError {
+					t.Fatalf("expected internal server error, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := service.CreateSubscription(context.Background(), tt.body)
+			tt.validate(t, resp, err)
+		})
+	}
+}
+
+func Test_UpdateSubscription(t *testing.T) {
+	repo := &MockWebhookRepo{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewWebhooksService(repo, logger, testResolver{})
+	body := model.WebhookSubscriptionRequestBody{URL: "https://example.com/v2/hook", EventTypes: []model.EventType{model.EventTypeDebtDischarged}}
+
+	resp, err := service.UpdateSubscription(context.Background(), webhookSubscriptionID.Hex(), body)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if resp.URL != body.URL || resp.EventTypes[0] != model.EventTypeDebtDischarged || resp.Secret != "" {
+		t.Errorf("expected the new URL and event types without the secret, got %+v", resp)
+	}
+
+	if _, err := service.UpdateSubscription(context.Background(), bson.NewObjectID().Hex(), body); err == nil || err.Status != http.StatusNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if err := service.DeleteSubscription(context.Background(), "subscription_fail"); err == nil || err.Status != http.StatusInternalServerError {
+		t.Errorf("expected internal server error, got %v", err)
+	}
+	if err := service.DeleteSubscription(context.Background(), webhookSubscriptionID.Hex()); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func Test_ReplayDelivery(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	tests := []struct {
+		name       string
+		deliveryID string
+		validate   func(t *testing.T, repo *MockWebhookRepo, resp *model.WebhookDeliveryResponseBody, err *model.ErrorResponse)
+	}{
+		{
+			name:       "Dead letter is queued again",
+			deliveryID: deadLetterDeliveryID.Hex(),
+			validate: func(t *testing.T, repo *MockWebhookRepo, resp *model.WebhookDeliveryResponseBody, err *model.ErrorResponse) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if repo.updated == nil || repo.updated.Status != model.WebhookDeliveryPending || repo.updated.Attempts != 0 || repo.updated.LastError != "" {
+					t.Errorf("expected the delivery to be reset, got %+v", repo.updated)
+				}
+				if resp.NextAttemptAt == nil {
+					t.Errorf("expected the next attempt to be set")
+				}
+			},
+		},
+		{
+			name:       "Pending delivery cannot be replayed",
+			deliveryID: pendingDeliveryID.Hex(),
+			validate: func(t *testing.T, repo *MockWebhookRepo, resp *model.WebhookDeliveryResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusConflict || repo.updated != nil {
+					t.Fatalf("expected conflict, got %v", err)
+				}
+			},
+		},
+		{
+			name:       "Delivery not found",
+			deliveryID: bson.NewObjectID().Hex(),
+			validate: func(t *testing.T, repo *MockWebhookRepo, resp *model.WebhookDeliveryResponseBody, err *model.ErrorResponse) {
+				if err == nil || err.Status != http.StatusNotFound {
+					t.Fatalf("expected not found, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &MockWebhookRepo{}
+			service := NewWebhooksService(repo, logger, testResolver{})
+			resp, err := service.ReplayDelivery(context.Background(), tt.deliveryID)
+			tt.validate(t, repo, resp, err)
+		})
+	}
+}
+
+func Test_ListDeliveries(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	service := NewWebhooksService(&MockWebhookRepo{}, logger, testResolver{})
+
+	deliveries, err := service.ListDeliveries(context.Background(), model.WebhookDeliveryFilter{Status: model.WebhookDeliveryDeadLetter})
+	if err != nil || len(deliveries) != 1 || deliveries[0].Status != model.WebhookDeliveryDeadLetter {
+		t.Fatalf("expected the dead letter list, got %v (%v)", deliveries, err)
+	}
+	if _, err := service.ListDeliveries(context.Background(), model.WebhookDeliveryFilter{Status: "failed"}); err == nil || err.Status != http.StatusBadRequest {
+		t.Errorf("expected bad request for an unknown status, got %v", err)
+	}
+}
@@ -78,6 +78,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/model.ErrorResponse"
                         }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
                     }
                 }
             }
@@ -98,7 +104,7 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Account ID",
+                        "description": "Account ID (acc_...), an internal ID is answered with a Deprecation header",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -134,46 +140,102 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/model.ErrorResponse"
                         }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/transactions": {
-            "post": {
-                "description": "create a transaction",
-                "consumes": [
-                    "application/json"
-                ],
+        "/accounts/{id}/audit": {
+            "get": {
+                "description": "every recorded change to the account oldest first, with the actor, request ID and before and after snapshots",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "transactions"
+                    "audit"
                 ],
-                "summary": "Post a transaction",
+                "summary": "Get the audit trail of an account",
                 "parameters": [
                     {
-                        "description": "Transaction request body",
-                        "name": "transaction",
-                        "in": "body",
-                        "required": true,
+                        "type": "string",
+                        "description": "Account ID (acc_...), an internal ID is answered with a Deprecation header",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.TransactionRequestBody"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.AuditRecordResponseBody"
+                            }
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/accounts/{id}/balance": {
+            "get": {
+                "description": "what the account owes and the credit it holds, read from the account's balance projection\npass as_of for what was owed at a past time, including how much of every debt had been paid off by then",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "accounts"
+                ],
+                "summary": "Get the balance of an account",
+                "parameters": [
                     {
                         "type": "string",
-                        "description": "Idempotency Key",
-                        "name": "X-idempotency-Key",
-                        "in": "header",
+                        "description": "Account ID (acc_...), an internal ID is answered with a Deprecation header",
+                        "name": "id",
+                        "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Point in time (RFC3339)",
+                        "name": "as_of",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.TransactionResponseBody"
+                            "$ref": "#/definitions/model.AccountBalanceResponseBody"
                         }
                     },
                     "400": {
@@ -188,85 +250,1585 @@ const docTemplate = `{
                             "$ref": "#/definitions/model.ErrorResponse"
                         }
                     },
-                    "409": {
-                        "description": "Conflict",
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/model.ErrorResponse"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "503": {
+                        "description": "Service Unavailable",
                         "schema": {
                             "$ref": "#/definitions/model.ErrorResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "model.AccountRequestBody": {
-            "description": "Account request body Document number used to create an account",
-            "type": "object",
-            "properties": {
-                "document_number": {
-                    "description": "Document number",
-                    "type": "string"
+        },
+        "/accounts/{id}/statement": {
+            "get": {
+                "description": "get the account's transactions between from and to as an ISO 20022 camt.053 or OFX statement\noperation types are reported with ISO bank transaction codes and the debit/credit indicator follows the amount sign",
+                "produces": [
+                    "application/xml",
+                    "application/x-ofx"
+                ],
+                "tags": [
+                    "accounts"
+                ],
+                "summary": "Get an account statement",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Account ID (acc_...), an internal ID is answered with a Deprecation header",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of the statement period (RFC3339)",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the statement period (RFC3339)",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "camt053",
+                            "ofx"
+                        ],
+                        "type": "string",
+                        "description": "Statement format, camt053 when not set",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ISO 4217 currency code, BRL when not set",
+                        "name": "currency",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "model.AccountResponseBody": {
-            "description": "Account response body ID and Document number of the created account",
-            "type": "object",
-            "properties": {
-                "account_id": {
-                    "type": "string"
-                },
-                "document_number": {
-                    "type": "string"
+        "/accounts:import": {
+            "post": {
+                "description": "upload a CSV (with a document_number header column) or NDJSON file (a document_number field per line) of accounts to create asynchronously, other columns and fields are dropped and listed in the job's ignored_columns\nthe file can be sent as the raw request body or as the file field of a multipart form\nthe format is taken from the format query parameter, then the file extension, then the Content-Type",
+                "consumes": [
+                    "text/csv",
+                    "application/x-ndjson",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Import accounts in bulk",
+                "parameters": [
+                    {
+                        "enum": [
+                            "csv",
+                            "ndjson"
+                        ],
+                        "type": "string",
+                        "description": "Upload format",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/model.JobResponseBody"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "model.ErrorResponse": {
-            "description": "Error response body Message and Status code of the error",
-            "type": "object",
-            "properties": {
-                "message": {
-                    "type": "string"
-                },
-                "status": {
-                    "type": "integer"
+        "/jobs/{id}": {
+            "get": {
+                "description": "get the status and progress of an asynchronous job",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Get a job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.JobResponseBody"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "model.OperationType": {
-            "type": "integer",
-            "enum": [
-                1,
-                2,
-                3,
-                4
-            ],
-            "x-enum-varnames": [
-                "PURCHASE",
-                "INSTALLMENT_PURCHASE",
-                "WITHDRAWAL",
-                "PAYMENT"
-            ]
-        },
-        "model.TransactionRequestBody": {
-            "description": "Transaction request body Account ID, Operation type ID and Amount are required to create a transaction",
-            "type": "object",
-            "properties": {
-                "account_id": {
-                    "description": "required: true",
-                    "type": "string"
-                },
-                "amount": {
-                    "type": "number"
+        "/jobs/{id}/result": {
+            "get": {
+                "description": "download the NDJSON result file of a finished job, each line is a model.AccountImportRowResult for account imports",
+                "produces": [
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Download a job result",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/ledger/trial-balance": {
+            "get": {
+                "description": "debits and credits posted to every ledger account in cents, total debits always equal total credits",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ledger"
+                ],
+                "summary": "Get the trial balance",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.TrialBalanceResponseBody"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/account-cache": {
+            "get": {
+                "description": "hits, misses and evictions of the account cache, 404 when it is disabled",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "Get the account cache counters",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.AccountCacheStatsResponseBody"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reconciliations": {
+            "post": {
+                "description": "replay the transactions of an account, or of every account when account_id is left out, in event_date order\nand report every transaction whose stored balance differs from the replayed one, set repair to write the replayed balances back\n207 when the repair of some accounts failed, they are reported with their error",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transactions"
+                ],
+                "summary": "Reconcile transaction balances",
+                "parameters": [
+                    {
+                        "description": "Reconciliation request body",
+                        "name": "reconciliation",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.ReconciliationRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.ReconciliationResponseBody"
+                        }
+                    },
+                    "207": {
+                        "description": "Multi-Status",
+                        "schema": {
+                            "$ref": "#/definitions/model.ReconciliationResponseBody"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transactions": {
+            "post": {
+                "description": "create a transaction",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transactions"
+                ],
+                "summary": "Post a transaction",
+                "parameters": [
+                    {
+                        "description": "Transaction request body",
+                        "name": "transaction",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.TransactionRequestBody"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Idempotency Key",
+                        "name": "X-idempotency-Key",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/model.TransactionResponseBody"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transactions/export": {
+            "get": {
+                "description": "stream an account's transactions, or any filtered set, as CSV or NDJSON\nthe format is taken from the format query parameter first and the Accept header second",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "transactions"
+                ],
+                "summary": "Export transactions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Account ID",
+                        "name": "account_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Operation type ID",
+                        "name": "operation_type_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Earliest event date (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Latest event date (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "csv",
+                            "ndjson"
+                        ],
+                        "type": "string",
+                        "description": "Export format",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transactions/{id}/audit": {
+            "get": {
+                "description": "every recorded change to the transaction oldest first, including the balance rewrites made when a payment discharges it",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "audit"
+                ],
+                "summary": "Get the audit trail of a transaction",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transaction ID (txn_...), an internal ID is answered with a Deprecation header",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.AuditRecordResponseBody"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transactions:batch": {
+            "post": {
+                "description": "create up to 1000 transactions in one request, each item carries its own idempotency key\natomic mode commits all items or none, best_effort mode commits every item that is valid\n201 when nothing was rejected, 207 when a best_effort batch had rejections and 422 when an atomic batch was rolled back",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transactions"
+                ],
+                "summary": "Post a batch of transactions",
+                "parameters": [
+                    {
+                        "description": "Transaction batch request body",
+                        "name": "batch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.TransactionBatchRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/model.TransactionBatchResponseBody"
+                        }
+                    },
+                    "207": {
+                        "description": "Multi-Status",
+                        "schema": {
+                            "$ref": "#/definitions/model.TransactionBatchResponseBody"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/model.TransactionBatchResponseBody"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "description": "list every webhook subscription",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhook subscriptions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.WebhookSubscriptionResponseBody"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "subscribe a URL to account and transaction events, deliveries are signed with HMAC-SHA256 of \"\u003ctimestamp\u003e.\u003cbody\u003e\"\nusing the secret and sent in the X-Webhook-Signature header with the timestamp in X-Webhook-Timestamp\nthe secret is generated when left out and is only ever returned in this response\nthe URL must lead to a public address, loopback, link-local and private addresses are refused",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Create a webhook subscription",
+                "parameters": [
+                    {
+                        "description": "Subscription info",
+                        "name": "subscription",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.WebhookSubscriptionRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/model.WebhookSubscriptionResponseBody"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/deliveries": {
+            "get": {
+                "description": "list webhook deliveries oldest first, pass status=dead_letter for the dead letter list",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhook deliveries",
+                "parameters": [
+                    {
+                        "enum": [
+                            "pending",
+                            "succeeded",
+                            "dead_letter"
+                        ],
+                        "type": "string",
+                        "description": "Delivery status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "subscription_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.WebhookDeliveryResponseBody"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/deliveries/{id}/replay": {
+            "post": {
+                "description": "queue a dead lettered delivery again with a fresh set of attempts",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Replay a dead lettered webhook delivery",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Delivery ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/model.WebhookDeliveryResponseBody"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}": {
+            "get": {
+                "description": "get webhook subscription by ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Get a webhook subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.WebhookSubscriptionResponseBody"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "replace the URL and event types of a subscription, the secret is rotated only when a new one is given",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Update a webhook subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Subscription info",
+                        "name": "subscription",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.WebhookSubscriptionRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.WebhookSubscriptionResponseBody"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "delete a webhook subscription, deliveries still queued for it are dead lettered",
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete a webhook subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "model.AccountBalanceResponseBody": {
+            "description": "Account balance response body What the account owes and the credit it holds, version increases with every transaction A balance as of a past time is worked out from the journal and lists the state of every debt instead of a version",
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "as_of": {
+                    "type": "string"
+                },
+                "available_credit": {
+                    "type": "number"
+                },
+                "debts": {
+                    "description": "only returned for as_of queries",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Debt"
+                    }
+                },
+                "last_transaction_at": {
+                    "type": "string"
+                },
+                "open_debts": {
+                    "type": "integer"
+                },
+                "total_debt": {
+                    "type": "number"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.AccountCacheStatsResponseBody": {
+            "description": "Account cache stats response body Counters of the account cache of the instance answering since it started, and the accounts it holds now",
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "integer"
+                },
+                "evictions": {
+                    "type": "integer"
+                },
+                "hits": {
+                    "type": "integer"
+                },
+                "misses": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.AccountDrift": {
+            "description": "Account drift The transactions of one account that have drifted, drift is the sum of expected minus stored balances",
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "drift": {
+                    "type": "number"
+                },
+                "error": {
+                    "description": "Error is set when the account could not be repaired, nothing was written to it",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.ErrorResponse"
+                        }
+                    ]
+                },
+                "transactions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.TransactionDrift"
+                    }
+                }
+            }
+        },
+        "model.AccountRequestBody": {
+            "description": "Account request body Document number used to create an account",
+            "type": "object",
+            "required": [
+                "document_number"
+            ],
+            "properties": {
+                "document_number": {
+                    "description": "Document number",
+                    "type": "string",
+                    "maxLength": 64
+                }
+            }
+        },
+        "model.AccountResponseBody": {
+            "description": "Account response body ID and Document number of the created account",
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "document_number": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.AuditAction": {
+            "type": "string",
+            "enum": [
+                "create",
+                "update"
+            ],
+            "x-enum-varnames": [
+                "AuditActionCreate",
+                "AuditActionUpdate"
+            ]
+        },
+        "model.AuditEntityType": {
+            "type": "string",
+            "enum": [
+                "account",
+                "transaction"
+            ],
+            "x-enum-varnames": [
+                "AuditEntityAccount",
+                "AuditEntityTransaction"
+            ]
+        },
+        "model.AuditRecordResponseBody": {
+            "description": "Audit record response body Who changed an entity, when, and what it looked like before and after the change",
+            "type": "object",
+            "properties": {
+                "action": {
+                    "enum": [
+                        "create",
+                        "update"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.AuditAction"
+                        }
+                    ]
+                },
+                "actor": {
+                    "type": "string"
+                },
+                "after": {
+                    "type": "object"
+                },
+                "audit_id": {
+                    "type": "string"
+                },
+                "before": {
+                    "type": "object"
+                },
+                "entity_id": {
+                    "type": "string"
+                },
+                "entity_type": {
+                    "enum": [
+                        "account",
+                        "transaction"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.AuditEntityType"
+                        }
+                    ]
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "timestamp": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.BatchItemStatus": {
+            "type": "string",
+            "enum": [
+                "created",
+                "replayed",
+                "rejected"
+            ],
+            "x-enum-varnames": [
+                "BatchItemStatusCreated",
+                "BatchItemStatusReplayed",
+                "BatchItemStatusRejected"
+            ]
+        },
+        "model.BatchMode": {
+            "type": "string",
+            "enum": [
+                "atomic",
+                "best_effort"
+            ],
+            "x-enum-comments": {
+                "BatchModeAtomic": "every item is committed or none are",
+                "BatchModeBestEffort": "each item is committed on its own"
+            },
+            "x-enum-descriptions": [
+                "every item is committed or none are",
+                "each item is committed on its own"
+            ],
+            "x-enum-varnames": [
+                "BatchModeAtomic",
+                "BatchModeBestEffort"
+            ]
+        },
+        "model.Debt": {
+            "description": "Debt How much of a purchase or withdrawal had been paid off at the time of the balance",
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "balance": {
+                    "description": "negative while something is owed",
+                    "type": "number"
+                },
+                "paid": {
+                    "type": "number"
+                },
+                "transaction_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.ErrorResponse": {
+            "description": "Error response body Message and Status code of the error",
+            "type": "object",
+            "properties": {
+                "errors": {
+                    "description": "Errors lists every rule the fields of an invalid request body break, Message joins their messages",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.FieldError"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.EventType": {
+            "type": "string",
+            "enum": [
+                "AccountCreated",
+                "TransactionCreated",
+                "DebtDischarged"
+            ],
+            "x-enum-comments": {
+                "EventTypeDebtDischarged": "a payment paid off some or all of an earlier debt"
+            },
+            "x-enum-descriptions": [
+                "",
+                "",
+                "a payment paid off some or all of an earlier debt"
+            ],
+            "x-enum-varnames": [
+                "EventTypeAccountCreated",
+                "EventTypeTransactionCreated",
+                "EventTypeDebtDischarged"
+            ]
+        },
+        "model.FieldError": {
+            "description": "A rule a field of the request body breaks Code is one of required, min, max, oneof, format or cents",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "field": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.JobResponseBody": {
+            "description": "Job response body Progress of an asynchronous job, the result can be downloaded once the job has completed",
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "duplicates": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "ignored_columns": {
+                    "description": "IgnoredColumns are the columns, or NDJSON fields, of the upload other than document_number. Accounts only hold a\ndocument number so they are dropped",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "invalid": {
+                    "type": "integer"
+                },
+                "job_id": {
+                    "type": "string"
+                },
+                "result_url": {
+                    "type": "string"
+                },
+                "rows": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "enum": [
+                        "pending",
+                        "running",
+                        "completed",
+                        "failed"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.JobStatus"
+                        }
+                    ]
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.JobStatus": {
+            "type": "string",
+            "enum": [
+                "pending",
+                "running",
+                "completed",
+                "failed"
+            ],
+            "x-enum-varnames": [
+                "JobStatusPending",
+                "JobStatusRunning",
+                "JobStatusCompleted",
+                "JobStatusFailed"
+            ]
+        },
+        "model.LedgerAccount": {
+            "type": "string",
+            "enum": [
+                "customer_receivable",
+                "cash",
+                "merchant_payable",
+                "customer_credit",
+                "fee_income",
+                "reconciliation_suspense",
+                "opening_balances"
+            ],
+            "x-enum-comments": {
+                "LedgerAccountCash": "asset, money received from payments and paid out by withdrawals",
+                "LedgerAccountCustomerCredit": "liability, payments received beyond what the customer owed",
+                "LedgerAccountFeeIncome": "income, fees charged to customers",
+                "LedgerAccountMerchantPayable": "liability, purchases owed to merchants through the card network",
+                "LedgerAccountReceivable": "asset, what customers owe for purchases and withdrawals"
+            },
+            "x-enum-descriptions": [
+                "asset, what customers owe for purchases and withdrawals",
+                "asset, money received from payments and paid out by withdrawals",
+                "liability, purchases owed to merchants through the card network",
+                "liability, payments received beyond what the customer owed",
+                "income, fees charged to customers",
+                "",
+                ""
+            ],
+            "x-enum-varnames": [
+                "LedgerAccountReceivable",
+                "LedgerAccountCash",
+                "LedgerAccountMerchantPayable",
+                "LedgerAccountCustomerCredit",
+                "LedgerAccountFeeIncome",
+                "LedgerAccountSuspense",
+                "LedgerAccountOpeningBalances"
+            ]
+        },
+        "model.OperationType": {
+            "type": "integer",
+            "enum": [
+                1,
+                2,
+                3,
+                4
+            ],
+            "x-enum-varnames": [
+                "PURCHASE",
+                "INSTALLMENT_PURCHASE",
+                "WITHDRAWAL",
+                "PAYMENT"
+            ]
+        },
+        "model.ReconciliationRequestBody": {
+            "description": "Reconciliation request body Leave account_id out to reconcile every account, the journal is only adjusted when repair is true",
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "repair": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "model.ReconciliationResponseBody": {
+            "description": "Reconciliation response body Counts of what was checked and the drift found per account",
+            "type": "object",
+            "properties": {
+                "accounts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.AccountDrift"
+                    }
+                },
+                "accounts_checked": {
+                    "type": "integer"
+                },
+                "failed": {
+                    "description": "accounts whose repair failed",
+                    "type": "integer"
+                },
+                "repaired": {
+                    "type": "integer"
+                },
+                "transactions_checked": {
+                    "type": "integer"
+                },
+                "transactions_drifted": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.TransactionBatchItem": {
+            "description": "Transaction batch item A transaction request body with the idempotency key for that transaction",
+            "type": "object",
+            "required": [
+                "account_id",
+                "amount",
+                "idempotency_key",
+                "operation_type_id"
+            ],
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "amount": {
+                    "description": "Amount is a whole number of cents, capped at a trillion either way so its cents are held exactly",
+                    "type": "number",
+                    "maximum": 1000000000000,
+                    "minimum": -1000000000000
+                },
+                "idempotency_key": {
+                    "type": "string"
                 },
                 "operation_type_id": {
-                    "$ref": "#/definitions/model.OperationType"
+                    "enum": [
+                        1,
+                        2,
+                        3,
+                        4
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.OperationType"
+                        }
+                    ]
+                }
+            }
+        },
+        "model.TransactionBatchItemResult": {
+            "description": "Transaction batch item result Outcome of a single batch item, the transaction is set when it was created or replayed and the error when it was rejected",
+            "type": "object",
+            "properties": {
+                "error": {
+                    "$ref": "#/definitions/model.ErrorResponse"
+                },
+                "idempotency_key": {
+                    "type": "string"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "status": {
+                    "enum": [
+                        "created",
+                        "replayed",
+                        "rejected"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.BatchItemStatus"
+                        }
+                    ]
+                },
+                "transaction": {
+                    "$ref": "#/definitions/model.TransactionResponseBody"
+                }
+            }
+        },
+        "model.TransactionBatchRequestBody": {
+            "description": "Transaction batch request body Mode is either atomic (all or nothing) or best_effort, atomic is used when it is left out",
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.TransactionBatchItem"
+                    }
+                },
+                "mode": {
+                    "enum": [
+                        "atomic",
+                        "best_effort"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.BatchMode"
+                        }
+                    ]
+                }
+            }
+        },
+        "model.TransactionBatchResponseBody": {
+            "description": "Transaction batch response body Per item results in request order along with counts for each outcome",
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "mode": {
+                    "$ref": "#/definitions/model.BatchMode"
+                },
+                "rejected": {
+                    "type": "integer"
+                },
+                "replayed": {
+                    "type": "integer"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.TransactionBatchItemResult"
+                    }
+                }
+            }
+        },
+        "model.TransactionDrift": {
+            "description": "Transaction drift A transaction whose balance in the journal differs from the balance replaying the account gives it",
+            "type": "object",
+            "properties": {
+                "expected_balance": {
+                    "type": "number"
+                },
+                "repaired": {
+                    "type": "boolean"
+                },
+                "stored_balance": {
+                    "type": "number"
+                },
+                "transaction_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.TransactionRequestBody": {
+            "description": "Transaction request body Account ID, Operation type ID and Amount are required to create a transaction",
+            "type": "object",
+            "required": [
+                "account_id",
+                "amount",
+                "operation_type_id"
+            ],
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "amount": {
+                    "description": "Amount is a whole number of cents, capped at a trillion either way so its cents are held exactly",
+                    "type": "number",
+                    "maximum": 1000000000000,
+                    "minimum": -1000000000000
+                },
+                "operation_type_id": {
+                    "enum": [
+                        1,
+                        2,
+                        3,
+                        4
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.OperationType"
+                        }
+                    ]
                 }
             }
         },
@@ -287,6 +1849,164 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "model.TrialBalanceAccount": {
+            "description": "Trial balance account Totals posted to one ledger account in cents, balance is debits minus credits",
+            "type": "object",
+            "properties": {
+                "balance_cents": {
+                    "type": "integer"
+                },
+                "credit_cents": {
+                    "type": "integer"
+                },
+                "debit_cents": {
+                    "type": "integer"
+                },
+                "ledger_account": {
+                    "$ref": "#/definitions/model.LedgerAccount"
+                }
+            }
+        },
+        "model.TrialBalanceResponseBody": {
+            "description": "Trial balance response body Totals for every ledger account, balanced is true when total debits equal total credits",
+            "type": "object",
+            "properties": {
+                "accounts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.TrialBalanceAccount"
+                    }
+                },
+                "balanced": {
+                    "type": "boolean"
+                },
+                "total_credit_cents": {
+                    "type": "integer"
+                },
+                "total_debit_cents": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.WebhookDeliveryResponseBody": {
+            "description": "Webhook delivery response body State of a single event delivery to a subscription",
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "delivered_at": {
+                    "type": "string"
+                },
+                "delivery_id": {
+                    "type": "string"
+                },
+                "event_id": {
+                    "type": "string"
+                },
+                "event_type": {
+                    "$ref": "#/definitions/model.EventType"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "last_status_code": {
+                    "type": "integer"
+                },
+                "next_attempt_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "enum": [
+                        "pending",
+                        "succeeded",
+                        "dead_letter"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.WebhookDeliveryStatus"
+                        }
+                    ]
+                },
+                "subscription_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.WebhookDeliveryStatus": {
+            "type": "string",
+            "enum": [
+                "pending",
+                "succeeded",
+                "dead_letter"
+            ],
+            "x-enum-comments": {
+                "WebhookDeliveryDeadLetter": "every attempt failed, it is only retried again when replayed"
+            },
+            "x-enum-descriptions": [
+                "",
+                "",
+                "every attempt failed, it is only retried again when replayed"
+            ],
+            "x-enum-varnames": [
+                "WebhookDeliveryPending",
+                "WebhookDeliverySucceeded",
+                "WebhookDeliveryDeadLetter"
+            ]
+        },
+        "model.WebhookSubscriptionRequestBody": {
+            "description": "Webhook subscription request body URL to deliver to, the event types to deliver and the secret deliveries are signed with A secret is generated when it is left out",
+            "type": "object",
+            "properties": {
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "enum": [
+                            "AccountCreated",
+                            "TransactionCreated",
+                            "DebtDischarged"
+                        ],
+                        "$ref": "#/definitions/model.EventType"
+                    }
+                },
+                "secret": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.WebhookSubscriptionResponseBody": {
+            "description": "Webhook subscription response body The secret is only returned when the subscription is created",
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.EventType"
+                    }
+                },
+                "secret": {
+                    "type": "string"
+                },
+                "subscription_id": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
         }
     },
     "externalDocs": {
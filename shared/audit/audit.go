@@ -0,0 +1,258 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
+)
+
+const (
+	// ActorHeader names who is making the request, it is trusted as is so it must be set by the gateway in front of the API
+	ActorHeader = "X-Actor"
+	// AnonymousActor is recorded when a mutation is made without an actor
+	AnonymousActor = "anonymous"
+)
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	requestIDKey
+)
+
+// WithActor returns a copy of ctx that attributes mutations to actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return AnonymousActor
+}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request that caused its mutations
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext falls back to the ID set by chi's RequestID middleware
+func RequestIDFromContext(ctx context.Context) string {
+	if requestID, ok := ctx.Value(requestIDKey).(string); ok {
+		return requestID
+	}
+	return middleware.GetReqID(ctx)
+}
+
+// Detach returns a background context carrying the actor, request ID and tenant of ctx, for work that outlives the
+// request
+func Detach(ctx context.Context) context.Context {
+	detached := tenant.WithID(context.Background(), tenant.FromContext(ctx))
+	return WithRequestID(WithActor(detached, ActorFromContext(ctx)), RequestIDFromContext(ctx))
+}
+
+// Middleware puts the actor from the ActorHeader on the request context, it goes after chi's RequestID middleware
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actor := strings.TrimSpace(r.Header.Get(ActorHeader)); actor != "" {
+			r = r.WithContext(WithActor(r.Context(), actor))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Repository decorates a DatabaseRepository so every account, balance projection and transaction mutation appends an audit record in the
+// same database transaction as the mutation itself. Reads and the outbox pass straight through, a mutation added to
+// the repository has to be overridden here as well to be audited
+type Repository struct {
+	repository.DatabaseRepository
+	store  repository.AuditRepository
+	logger *slog.Logger
+}
+
+func NewRepository(next repository.DatabaseRepository, store repository.AuditRepository, logger *slog.Logger) *Repository {
+	logger.InfoContext(context.Background(), "audit Repository initialized")
+	return &Repository{DatabaseRepository: next, store: store, logger: logger}
+}
+
+func (r *Repository) CreateAccount(ctx context.Context, documentID string) (*model.Account, error) {
+	var acc *model.Account
+	err := r.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		acc, err = r.DatabaseRepository.CreateAccount(ctx, documentID)
+		if err != nil {
+			return err
+		}
+		return r.append(ctx, model.AuditActionCreate, model.AuditEntityAccount, acc.ID.Hex(), nil, accountSnapshot(acc))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+func (r *Repository) CreateTransaction(ctx context.Context, transaction model.Transaction) (*model.Transaction, error) {
+	var tx *model.Transaction
+	err := r.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		tx, err = r.DatabaseRepository.CreateTransaction(ctx, transaction)
+		if err != nil {
+			return err
+		}
+		return r.append(ctx, model.AuditActionCreate, model.AuditEntityTransaction, tx.ID.Hex(), nil, transactionSnapshot(tx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// UpdateTransactionByID reads the transaction back after updating it so the after snapshot is what was really stored
+func (r *Repository) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction, expectedVersion int64) error {
+	return r.WithTransaction(ctx, func(ctx context.Context) error {
+		before, err := r.DatabaseRepository.GetTransactionByID(ctx, transactionID)
+		if err != nil {
+			return err
+		}
+		if err := r.DatabaseRepository.UpdateTransactionByID(ctx, transactionID, transaction, expectedVersion); err != nil {
+			return err
+		}
+		after, err := r.DatabaseRepository.GetTransactionByID(ctx, transactionID)
+		if err != nil {
+			return err
+		}
+		return r.append(ctx, model.AuditActionUpdate, model.AuditEntityTransaction, transactionID, transactionSnapshot(before), transactionSnapshot(after))
+	})
+}
+
+// SaveAccountBalance records the balance projection change on the account's trail, before is left out when the account
+// had no projection yet
+func (r *Repository) SaveAccountBalance(ctx context.Context, balance model.AccountBalance) error {
+	return r.WithTransaction(ctx, func(ctx context.Context) error {
+		before, err := r.DatabaseRepository.GetAccountBalance(ctx, balance.AccountID)
+		if err != nil {
+			return err
+		}
+		if err := r.DatabaseRepository.SaveAccountBalance(ctx, balance); err != nil {
+			return err
+		}
+		return r.append(ctx, model.AuditActionUpdate, model.AuditEntityAccount, balance.AccountID, balanceSnapshot(before), balanceSnapshot(&balance))
+	})
+}
+
+func (r *Repository) append(ctx context.Context, action model.AuditAction, entityType model.AuditEntityType, entityID string, before any, after any) error {
+	record := model.AuditRecord{
+		Actor:      ActorFromContext(ctx),
+		RequestID:  RequestIDFromContext(ctx),
+		Timestamp:  time.Now().UTC(),
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+	}
+	var err error
+	if record.Before, err = snapshot(before); err != nil {
+		return err
+	}
+	if record.After, err = snapshot(after); err != nil {
+		return err
+	}
+	if err := r.store.AppendAuditRecord(ctx, record); err != nil {
+		return err
+	}
+	r.logger.DebugContext(ctx, "appended audit record", "actor", record.Actor, "action", action, "entityType", entityType, "entityID", entityID)
+	return nil
+}
+
+func snapshot(entity any) (json.RawMessage, error) {
+	if entity == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+	return data, nil
+}
+
+type accountRecord struct {
+	AccountID      string `json:"account_id"`
+	PublicID       string `json:"public_id"`
+	DocumentNumber string `json:"document_number"`
+}
+
+// accountSnapshot returns nil for a nil account so the snapshot is left out rather than written as null
+func accountSnapshot(acc *model.Account) any {
+	if acc == nil {
+		return nil
+	}
+	return accountRecord{AccountID: acc.ID.Hex(), PublicID: acc.PublicID, DocumentNumber: acc.DocumentNumber}
+}
+
+type openDebtRecord struct {
+	TransactionID string `json:"transaction_id"`
+	BalanceCents  int64  `json:"balance_cents"`
+}
+
+type balanceRecord struct {
+	AccountID            string           `json:"account_id"`
+	TotalDebtCents       int64            `json:"total_debt_cents"`
+	AvailableCreditCents int64            `json:"available_credit_cents"`
+	LastTransactionAt    time.Time        `json:"last_transaction_at"`
+	Version              int64            `json:"version"`
+	OpenDebts            []openDebtRecord `json:"open_debts"`
+}
+
+func balanceSnapshot(balance *model.AccountBalance) any {
+	if balance == nil {
+		return nil
+	}
+	openDebts := make([]openDebtRecord, len(balance.OpenDebts))
+	for i, debt := range balance.OpenDebts {
+		openDebts[i] = openDebtRecord{TransactionID: debt.TransactionID, BalanceCents: debt.BalanceCents}
+	}
+	return balanceRecord{
+		AccountID:            balance.AccountID,
+		TotalDebtCents:       balance.TotalDebtCents,
+		AvailableCreditCents: balance.AvailableCreditCents,
+		LastTransactionAt:    balance.LastTransactionAt,
+		Version:              balance.Version,
+		OpenDebts:            openDebts,
+	}
+}
+
+type transactionRecord struct {
+	TransactionID  string              `json:"transaction_id"`
+	PublicID       string              `json:"public_id"`
+	AccountID      string              `json:"account_id"`
+	OperationID    model.OperationType `json:"operation_type_id"`
+	Amount         float64             `json:"amount"`
+	Balance        float64             `json:"balance"`
+	EventDate      time.Time           `json:"event_date"`
+	IdempotencyKey string              `json:"idempotency_key"`
+}
+
+func transactionSnapshot(tx *model.Transaction) any {
+	if tx == nil {
+		return nil
+	}
+	return transactionRecord{
+		TransactionID:  tx.ID.Hex(),
+		PublicID:       tx.PublicID,
+		AccountID:      tx.AccountID,
+		OperationID:    tx.OperationID,
+		Amount:         tx.Amount,
+		Balance:        tx.Balance,
+		EventDate:      tx.EventDate,
+		IdempotencyKey: tx.IdempotencyKey,
+	}
+}
@@ -0,0 +1,236 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// fakeRepo keeps transactions in a map, only the methods the decorator overrides or calls are implemented
+type fakeRepo struct {
+	repository.DatabaseRepository
+	transactions map[string]model.Transaction
+	balances     map[string]model.AccountBalance
+}
+
+func (f *fakeRepo) CreateAccount(ctx context.Context, documentID string) (*model.Account, error) {
+	if documentID == "" {
+		return nil, errors.New("invalid document ID")
+	}
+	return &model.Account{ID: bson.NewObjectID(), DocumentNumber: documentID}, nil
+}
+
+func (f *fakeRepo) CreateTransaction(ctx context.Context, transaction model.Transaction) (*model.Transaction, error) {
+	transaction.ID = bson.NewObjectID()
+	transaction.Version = 1
+	f.transactions[transaction.ID.Hex()] = transaction
+	return &transaction, nil
+}
+
+func (f *fakeRepo) GetTransactionByID(ctx context.Context, transactionID string) (*model.Transaction, error) {
+	tx, ok := f.transactions[transactionID]
+	if !ok {
+		return nil, nil
+	}
+	return &tx, nil
+}
+
+func (f *fakeRepo) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction, expectedVersion int64) error {
+	existing, ok := f.transactions[transactionID]
+	if !ok {
+		return errors.New("transaction not found")
+	}
+	if existing.Version != expectedVersion {
+		return repository.ErrConflict
+	}
+	transaction.ID = existing.ID
+	transaction.Version = expectedVersion + 1
+	f.transactions[transactionID] = transaction
+	return nil
+}
+
+func (f *fakeRepo) GetAccountBalance(ctx context.Context, accountID string) (*model.AccountBalance, error) {
+	balance, ok := f.balances[accountID]
+	if !ok {
+		return nil, nil
+	}
+	return &balance, nil
+}
+
+func (f *fakeRepo) SaveAccountBalance(ctx context.Context, balance model.AccountBalance) error {
+	if f.balances[balance.AccountID].Version != balance.Version-1 {
+		return repository.ErrConflict
+	}
+	f.balances[balance.AccountID] = balance
+	return nil
+}
+
+func (f *fakeRepo) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type fakeStore struct {
+	records []model.AuditRecord
+	err     error
+}
+
+func (f *fakeStore) AppendAuditRecord(ctx context.Context, record model.AuditRecord) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.records = append(f.records, record)
+	return nil
+}
+
+func (f *fakeStore) FindAuditRecords(ctx context.Context, entityType model.AuditEntityType, entityID string, limit int) ([]model.AuditRecord, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func newTestRepository() (*Repository, *fakeRepo, *fakeStore) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	next := &fakeRepo{transactions: map[string]model.Transaction{}, balances: map[string]model.AccountBalance{}}
+	store := &fakeStore{}
+	return NewRepository(next, store, logger), next, store
+}
+
+func TestRepositoryCreateAccount(t *testing.T) {
+	repo, _, store := newTestRepository()
+	ctx := WithRequestID(WithActor(context.Background(), "backoffice"), "req-1")
+
+	acc, err := repo.CreateAccount(ctx, "12345678900")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(store.records) != 1 {
+		t.Fatalf("expected 1 audit record, got %d", len(store.records))
+	}
+	record := store.records[0]
+	if record.Actor != "backoffice" || record.RequestID != "req-1" || record.Action != model.AuditActionCreate || record.EntityType != model.AuditEntityAccount || record.EntityID != acc.ID.Hex() {
+		t.Errorf("unexpected audit record %+v", record)
+	}
+	if record.Before != nil {
+		t.Errorf("expected no before snapshot for a create, got %s", record.Before)
+	}
+	var after accountRecord
+	if err := json.Unmarshal(record.After, &after); err != nil || after.DocumentNumber != "12345678900" {
+		t.Errorf("expected the account as the after snapshot, got %s (%v)", record.After, err)
+	}
+
+	if _, err := repo.CreateAccount(context.Background(), ""); err == nil || len(store.records) != 1 {
+		t.Errorf("expected a failed create to leave no audit record, got %v", err)
+	}
+}
+
+func TestRepositoryUpdateTransaction(t *testing.T) {
+	repo, _, store := newTestRepository()
+	tx, err := repo.CreateTransaction(context.Background(), model.Transaction{AccountID: "acc-1", OperationID: model.OperationTypePurchase, Amount: -50, Balance: -50})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = repo.UpdateTransactionByID(context.Background(), tx.ID.Hex(), model.Transaction{AccountID: "acc-1", OperationID: model.OperationTypePurchase, Amount: -50, Balance: -20}, tx.Version)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(store.records) != 2 {
+		t.Fatalf("expected 2 audit records, got %d", len(store.records))
+	}
+	record := store.records[1]
+	if record.Actor != AnonymousActor || record.Action != model.AuditActionUpdate || record.EntityID != tx.ID.Hex() {
+		t.Errorf("unexpected audit record %+v", record)
+	}
+	var before, after transactionRecord
+	if err := json.Unmarshal(record.Before, &before); err != nil || before.Balance != -50 {
+		t.Errorf("expected a before balance of -50, got %s (%v)", record.Before, err)
+	}
+	if err := json.Unmarshal(record.After, &after); err != nil || after.Balance != -20 {
+		t.Errorf("expected an after balance of -20, got %s (%v)", record.After, err)
+	}
+
+	if err := repo.UpdateTransactionByID(context.Background(), bson.NewObjectID().Hex(), model.Transaction{}, 1); err == nil || len(store.records) != 2 {
+		t.Errorf("expected updating a missing transaction to fail without an audit record, got %v", err)
+	}
+	if err := repo.UpdateTransactionByID(context.Background(), tx.ID.Hex(), model.Transaction{}, tx.Version); !errors.Is(err, repository.ErrConflict) || len(store.records) != 2 {
+		t.Errorf("expected a conflicting update to fail without an audit record, got %v", err)
+	}
+}
+
+func TestRepositorySaveAccountBalance(t *testing.T) {
+	repo, _, store := newTestRepository()
+	first := model.AccountBalance{AccountID: "acc-1", TotalDebtCents: 5000, Version: 1, OpenDebts: []model.OpenDebt{{TransactionID: "tx-1", BalanceCents: -5000}}}
+	if err := repo.SaveAccountBalance(context.Background(), first); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	second := model.AccountBalance{AccountID: "acc-1", AvailableCreditCents: 1000, Version: 2, OpenDebts: []model.OpenDebt{}}
+	if err := repo.SaveAccountBalance(context.Background(), second); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(store.records) != 2 {
+		t.Fatalf("expected 2 audit records, got %d", len(store.records))
+	}
+	if record := store.records[0]; record.Action != model.AuditActionUpdate || record.EntityType != model.AuditEntityAccount || record.EntityID != "acc-1" || record.Before != nil {
+		t.Errorf("expected the first projection on the account's trail without a before snapshot, got %+v", record)
+	}
+	var before, after balanceRecord
+	if err := json.Unmarshal(store.records[1].Before, &before); err != nil || before.TotalDebtCents != 5000 || len(before.OpenDebts) != 1 {
+		t.Errorf("expected a before debt of 5000 on one open debt, got %s (%v)", store.records[1].Before, err)
+	}
+	if err := json.Unmarshal(store.records[1].After, &after); err != nil || after.AvailableCreditCents != 1000 || after.Version != 2 {
+		t.Errorf("expected an after credit of 1000 at version 2, got %s (%v)", store.records[1].After, err)
+	}
+
+	if err := repo.SaveAccountBalance(context.Background(), second); !errors.Is(err, repository.ErrConflict) || len(store.records) != 2 {
+		t.Errorf("expected a stale write to fail without an audit record, got %v", err)
+	}
+}
+
+func TestRepositoryStoreFailure(t *testing.T) {
+	repo, _, store := newTestRepository()
+	store.err = errors.New("database error")
+	if _, err := repo.CreateTransaction(context.Background(), model.Transaction{AccountID: "acc-1"}); err == nil {
+		t.Errorf("expected the mutation to fail when its audit record cannot be written")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var actor, requestID string
+	handler := middleware.RequestID(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		detached := Detach(r.Context())
+		actor = ActorFromContext(detached)
+		requestID = RequestIDFromContext(detached)
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/accounts", nil)
+	req.Header.Set(ActorHeader, "partner-onboarding")
+	req.Header.Set(middleware.RequestIDHeader, "req-42")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if actor != "partner-onboarding" || requestID != "req-42" {
+		t.Errorf("expected the actor and request ID to survive detaching, got %q and %q", actor, requestID)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/accounts", nil))
+	if actor != AnonymousActor || requestID == "" {
+		t.Errorf("expected an anonymous actor and a generated request ID, got %q and %q", actor, requestID)
+	}
+}
+
+func TestDetachTenant(t *testing.T) {
+	ctx, cancel := context.WithCancel(tenant.WithID(context.Background(), "program-a"))
+	detached := Detach(ctx)
+	cancel()
+	if got := tenant.FromContext(detached); got != "program-a" || detached.Err() != nil {
+		t.Errorf("expected the tenant to survive detaching from a cancelled request, got %q, %v", got, detached.Err())
+	}
+}
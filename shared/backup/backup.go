@@ -0,0 +1,354 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+)
+
+// FormatVersion is written to the manifest of every archive, archives of another version are not restored
+const FormatVersion = 1
+
+// manifestName is the first file of an archive
+const manifestName = "manifest.json"
+
+// restoreBatchSize is how many documents a restore writes per database transaction
+const restoreBatchSize = 500
+
+var (
+	// ErrNotEmpty is returned when a restore is asked to load an archive into a database that already holds data
+	ErrNotEmpty = errors.New("database is not empty")
+	// ErrCorrupt is returned, wrapped, when an archive cannot be read or does not match its manifest
+	ErrCorrupt = errors.New("corrupt archive")
+)
+
+// Store is what a backup is taken from and restored into, the store itself rather than one decorated with an audit
+// log or a cache
+type Store interface {
+	repository.DatabaseRepository
+	repository.AuditRepository
+	repository.BackupRepository
+}
+
+// Manifest describes an archive, it lists every file with the number of records in it and its SHA-256 checksum
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Files         []File    `json:"files"`
+}
+
+type File struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// collection is one NDJSON file of the archive. Idempotency keys are kept on the transactions so they come along with
+// them. Balance projections are left out, they are rebuilt from the transactions on the next read of each account, and
+// so are the outbox and webhooks so a restored copy never delivers events to the subscribers of the original
+type collection struct {
+	name string
+	dump func(ctx context.Context, store Store, write func(record any) error) error
+	load func(ctx context.Context, store Store, line []byte) error
+}
+
+// collections are written and restored in this order
+var collections = []collection{
+	{
+		name: "accounts.ndjson",
+		dump: func(ctx context.Context, store Store, write func(record any) error) error {
+			return store.StreamAccounts(ctx, func(acc model.Account) error { return write(newAccountRecord(acc)) })
+		},
+		load: func(ctx context.Context, store Store, line []byte) error {
+			return load[accountRecord](line, func(acc model.Account) error { return store.RestoreAccount(ctx, acc) })
+		},
+	},
+	{
+		name: "transactions.ndjson",
+		dump: func(ctx context.Context, store Store, write func(record any) error) error {
+			return store.StreamTransactions(ctx, model.TransactionFilter{}, func(tx model.Transaction) error { return write(newTransactionRecord(tx)) })
+		},
+		load: func(ctx context.Context, store Store, line []byte) error {
+			return load[transactionRecord](line, func(tx model.Transaction) error { return store.RestoreTransaction(ctx, tx) })
+		},
+	},
+	{
+		name: "journal.ndjson",
+		dump: func(ctx context.Context, store Store, write func(record any) error) error {
+			return store.StreamJournalEntries(ctx, func(entry model.JournalEntry) error { return write(newJournalRecord(entry)) })
+		},
+		load: func(ctx context.Context, store Store, line []byte) error {
+			return load[journalRecord](line, func(entry model.JournalEntry) error { return store.PostJournalEntry(ctx, entry) })
+		},
+	},
+	{
+		name: "audit.ndjson",
+		dump: func(ctx context.Context, store Store, write func(record any) error) error {
+			return store.StreamAuditRecords(ctx, func(record model.AuditRecord) error { return write(newAuditRecord(record)) })
+		},
+		load: func(ctx context.Context, store Store, line []byte) error {
+			return load[auditRecord](line, func(record model.AuditRecord) error { return store.AppendAuditRecord(ctx, record) })
+		},
+	},
+}
+
+// load decodes a line into an R and restores the document it holds
+func load[R interface{ model() (M, error) }, M any](line []byte, restore func(M) error) error {
+	var record R
+	if err := json.Unmarshal(line, &record); err != nil {
+		return fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	doc, err := record.model()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	return restore(doc)
+}
+
+// Write backs store up to w as a gzip compressed tar archive holding the manifest followed by an NDJSON file per
+// collection. Every collection is read from the same snapshot of the store so the archive is consistent. The files
+// are spooled to a temporary directory first, as the archive needs their sizes before their contents
+func Write(ctx context.Context, store Store, w io.Writer) (*Manifest, error) {
+	dir, err := os.MkdirTemp("", "pismo-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := &Manifest{FormatVersion: FormatVersion, CreatedAt: time.Now().UTC()}
+	err = store.Snapshot(ctx, func(ctx context.Context) error {
+		manifest.Files = nil
+		for _, c := range collections {
+			file, err := spool(ctx, store, c, filepath.Join(dir, c.name))
+			if err != nil {
+				return fmt.Errorf("failed to back up %s: %w", c.name, err)
+			}
+			manifest.Files = append(manifest.Files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := addFile(archive, manifestName, manifest.CreatedAt, bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, err
+	}
+	for _, file := range manifest.Files {
+		if err := addSpooled(archive, filepath.Join(dir, file.Name), file.Name, manifest.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// spool writes every record of the collection to path, one JSON document per line
+func spool(ctx context.Context, store Store, c collection, path string) (File, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	buffered := bufio.NewWriter(f)
+	encoder := json.NewEncoder(io.MultiWriter(buffered, hash))
+	file := File{Name: c.name}
+	err = c.dump(ctx, store, func(record any) error {
+		file.Records++
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		return File{}, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return File{}, err
+	}
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return file, f.Close()
+}
+
+func addSpooled(archive *tar.Writer, path string, name string, modTime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return addFile(archive, name, modTime, f, info.Size())
+}
+
+func addFile(archive *tar.Writer, name string, modTime time.Time, r io.Reader, size int64) error {
+	if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := io.Copy(archive, r)
+	return err
+}
+
+// Verify reads the whole archive and checks every file in it against the manifest, which it returns
+func Verify(r io.Reader) (*Manifest, error) {
+	return readArchive(r, nil)
+}
+
+// Restore loads the archive into store, which must hold no data. The archive is verified in full before anything is
+// written, then read again from the start to be loaded, a batch of documents per database transaction. A restore
+// that fails part way leaves what it wrote behind, the database has to be emptied before it is tried again
+func Restore(ctx context.Context, store Store, r io.ReadSeeker) (*Manifest, error) {
+	if _, err := Verify(r); err != nil {
+		return nil, err
+	}
+	empty, err := store.Empty(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !empty {
+		return nil, ErrNotEmpty
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return readArchive(r, func(c collection, body io.Reader) error {
+		if err := restoreCollection(ctx, store, c, body); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", c.name, err)
+		}
+		return nil
+	})
+}
+
+func restoreCollection(ctx context.Context, store Store, c collection, body io.Reader) error {
+	var batch [][]byte
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			for _, line := range batch {
+				if err := c.load(ctx, store, line); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	lines := bufio.NewReader(body)
+	for {
+		line, err := lines.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			batch = append(batch, line)
+			if len(batch) == restoreBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readArchive reads the manifest and then every file of the archive, handing each one to fn when it is set. A file
+// whose record count or checksum is not the one in the manifest, or a file missing from the archive, fails the read
+// once fn has seen it
+func readArchive(r io.Reader, fn func(c collection, body io.Reader) error) (*Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+
+	header, err := archive.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	if header.Name != manifestName {
+		return nil, fmt.Errorf("%w: expected %s first, got %s", ErrCorrupt, manifestName, header.Name)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(archive).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: invalid manifest: %w", ErrCorrupt, err)
+	}
+	if manifest.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("unsupported archive format version %d, expected %d", manifest.FormatVersion, FormatVersion)
+	}
+	files := make(map[string]File, len(manifest.Files))
+	for _, file := range manifest.Files {
+		files[file.Name] = file
+	}
+
+	for _, c := range collections {
+		file, ok := files[c.name]
+		if !ok {
+			return nil, fmt.Errorf("%w: manifest does not list %s", ErrCorrupt, c.name)
+		}
+		header, err := archive.Next()
+		if err != nil {
+			return nil, fmt.Errorf("%w: expected %s: %w", ErrCorrupt, c.name, err)
+		}
+		if header.Name != c.name {
+			return nil, fmt.Errorf("%w: expected %s, got %s", ErrCorrupt, c.name, header.Name)
+		}
+
+		hash, counter := sha256.New(), &lineCounter{}
+		body := io.TeeReader(archive, io.MultiWriter(hash, counter))
+		if fn != nil {
+			if err := fn(c, body); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+		}
+		if counter.lines != file.Records || hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+			return nil, fmt.Errorf("%w: %s does not match the manifest", ErrCorrupt, c.name)
+		}
+	}
+	return &manifest, nil
+}
+
+// lineCounter counts the lines written to it, every record is one line
+type lineCounter struct {
+	lines int
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	c.lines += bytes.Count(p, []byte{'\n'})
+	return len(p), nil
+}
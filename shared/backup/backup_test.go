@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/database"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// seed fills a store with one document of every kind an archive holds
+func seed(t *testing.T) *database.Memory {
+	t.Helper()
+	ctx := context.Background()
+	store := database.NewMemory()
+	acc, err := store.CreateAccount(ctx, "12345678900")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	tx, err := store.CreateTransaction(ctx, model.Transaction{AccountID: acc.ID.Hex(), OperationID: model.OperationTypePurchase, Amount: -10,
+		EventDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), IdempotencyKey: "key-1"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = store.PostJournalEntry(ctx, model.JournalEntry{ID: bson.NewObjectID(), TransactionID: tx.ID.Hex(), AccountID: acc.ID.Hex(),
+		PostedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Lines: []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 1000, AppliesTo: tx.ID.Hex()},
+			{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 1000},
+		}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = store.AppendAuditRecord(ctx, model.AuditRecord{ID: bson.NewObjectID(), Actor: "backoffice", Timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Action: model.AuditActionCreate, EntityType: model.AuditEntityAccount, EntityID: acc.ID.Hex(), After: []byte(`{"document_number":"12345678900"}`)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return store
+}
+
+func backUp(t *testing.T, store Store) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := Write(context.Background(), store, &buf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return buf.Bytes()
+}
+
+// dump reads every document a store holds
+func dump(t *testing.T, store Store) []any {
+	t.Helper()
+	var docs []any
+	err := store.Snapshot(context.Background(), func(ctx context.Context) error {
+		for _, c := range collections {
+			if err := c.dump(ctx, store, func(record any) error {
+				docs = append(docs, record)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return docs
+}
+
+// rewrite returns a copy of archive with the named file passed through edit
+func rewrite(t *testing.T, archive []byte, name string, edit func([]byte) []byte) []byte {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	reader := tar.NewReader(gz)
+	var buf bytes.Buffer
+	out := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(out)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if header.Name == name {
+			data = edit(data)
+		}
+		header.Size = int64(len(data))
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := writer.Write(data); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	source := seed(t)
+	archive := backUp(t, source)
+
+	manifest, err := Verify(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if manifest.FormatVersion != FormatVersion || len(manifest.Files) != len(collections) {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	for _, file := range manifest.Files {
+		if file.Records != 1 || len(file.SHA256) != 64 {
+			t.Errorf("expected one checksummed record in %s, got %+v", file.Name, file)
+		}
+	}
+
+	target := database.NewMemory()
+	if _, err := Restore(ctx, target, bytes.NewReader(archive)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if expected, got := dump(t, source), dump(t, target); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected the restored store to hold %+v, got %+v", expected, got)
+	}
+
+	// the idempotency key came along with its transaction
+	tx, err := target.FindTransactionByIdempotencyKey(ctx, "key-1")
+	if err != nil || tx == nil {
+		t.Errorf("expected the transaction to be found by its idempotency key, got %v, %v", tx, err)
+	}
+}
+
+func TestRestoreIntoANonEmptyStore(t *testing.T) {
+	archive := backUp(t, seed(t))
+	target := seed(t)
+	if _, err := Restore(context.Background(), target, bytes.NewReader(archive)); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("expected %v, got %v", ErrNotEmpty, err)
+	}
+}
+
+func TestRestoreRejectsCorruptArchives(t *testing.T) {
+	archive := backUp(t, seed(t))
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{name: "Not gzip", archive: []byte("accounts")},
+		{name: "Truncated", archive: archive[:len(archive)/2]},
+		{name: "Tampered record", archive: rewrite(t, archive, "accounts.ndjson", func(data []byte) []byte {
+			return bytes.Replace(data, []byte("12345678900"), []byte("99999999999"), 1)
+		})},
+		{name: "Missing record", archive: rewrite(t, archive, "transactions.ndjson", func([]byte) []byte { return nil })},
+		{name: "Manifest not listing a file", archive: rewrite(t, archive, manifestName, func(data []byte) []byte {
+			return bytes.Replace(data, []byte("audit.ndjson"), []byte("other.ndjson"), 1)
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := database.NewMemory()
+			if _, err := Restore(context.Background(), target, bytes.NewReader(tt.archive)); !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("expected %v, got %v", ErrCorrupt, err)
+			}
+			if empty, err := target.Empty(context.Background()); err != nil || !empty {
+				t.Errorf("expected nothing to be restored from a corrupt archive, got %v, %v", empty, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package backup
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// The records are the lines of the archive's NDJSON files, documents are written with every field they are stored with
+// so they are restored unchanged whichever backend wrote them
+
+type accountRecord struct {
+	ID             string `json:"id"`
+	PublicID       string `json:"public_id"`
+	DocumentNumber string `json:"document_number"`
+	Version        int64  `json:"version"`
+}
+
+func newAccountRecord(acc model.Account) accountRecord {
+	return accountRecord{ID: acc.ID.Hex(), PublicID: acc.PublicID, DocumentNumber: acc.DocumentNumber, Version: acc.Version}
+}
+
+func (r accountRecord) model() (model.Account, error) {
+	id, err := bson.ObjectIDFromHex(r.ID)
+	return model.Account{ID: id, PublicID: r.PublicID, DocumentNumber: r.DocumentNumber, Version: r.Version}, err
+}
+
+type transactionRecord struct {
+	ID             string              `json:"id"`
+	PublicID       string              `json:"public_id"`
+	AccountID      string              `json:"account_id"`
+	OperationID    model.OperationType `json:"operation_type_id"`
+	Amount         float64             `json:"amount"`
+	EventDate      time.Time           `json:"event_date"`
+	Balance        float64             `json:"balance"`
+	IdempotencyKey string              `json:"idempotency_key"`
+	Version        int64               `json:"version"`
+}
+
+func newTransactionRecord(tx model.Transaction) transactionRecord {
+	return transactionRecord{
+		ID:             tx.ID.Hex(),
+		PublicID:       tx.PublicID,
+		AccountID:      tx.AccountID,
+		OperationID:    tx.OperationID,
+		Amount:         tx.Amount,
+		EventDate:      tx.EventDate,
+		Balance:        tx.Balance,
+		IdempotencyKey: tx.IdempotencyKey,
+		Version:        tx.Version,
+	}
+}
+
+func (r transactionRecord) model() (model.Transaction, error) {
+	id, err := bson.ObjectIDFromHex(r.ID)
+	return model.Transaction{
+		ID:             id,
+		PublicID:       r.PublicID,
+		AccountID:      r.AccountID,
+		OperationID:    r.OperationID,
+		Amount:         r.Amount,
+		EventDate:      r.EventDate.UTC(),
+		Balance:        r.Balance,
+		IdempotencyKey: r.IdempotencyKey,
+		Version:        r.Version,
+	}, err
+}
+
+type journalRecord struct {
+	ID            string              `json:"id"`
+	TransactionID string              `json:"transaction_id"`
+	AccountID     string              `json:"account_id"`
+	PostedAt      time.Time           `json:"posted_at"`
+	Lines         []journalLineRecord `json:"lines"`
+}
+
+type journalLineRecord struct {
+	LedgerAccount model.LedgerAccount `json:"ledger_account"`
+	Side          model.EntrySide     `json:"side"`
+	AmountCents   int64               `json:"amount_cents"`
+	AppliesTo     string              `json:"applies_to,omitempty"`
+}
+
+func newJournalRecord(entry model.JournalEntry) journalRecord {
+	record := journalRecord{ID: entry.ID.Hex(), TransactionID: entry.TransactionID, AccountID: entry.AccountID, PostedAt: entry.PostedAt}
+	for _, line := range entry.Lines {
+		record.Lines = append(record.Lines, journalLineRecord(line))
+	}
+	return record
+}
+
+func (r journalRecord) model() (model.JournalEntry, error) {
+	id, err := bson.ObjectIDFromHex(r.ID)
+	entry := model.JournalEntry{ID: id, TransactionID: r.TransactionID, AccountID: r.AccountID, PostedAt: r.PostedAt.UTC()}
+	for _, line := range r.Lines {
+		entry.Lines = append(entry.Lines, model.JournalLine(line))
+	}
+	return entry, err
+}
+
+type auditRecord struct {
+	ID         string                `json:"id"`
+	Actor      string                `json:"actor"`
+	RequestID  string                `json:"request_id,omitempty"`
+	Timestamp  time.Time             `json:"timestamp"`
+	Action     model.AuditAction     `json:"action"`
+	EntityType model.AuditEntityType `json:"entity_type"`
+	EntityID   string                `json:"entity_id"`
+	Before     json.RawMessage       `json:"before,omitempty"`
+	After      json.RawMessage       `json:"after,omitempty"`
+}
+
+func newAuditRecord(record model.AuditRecord) auditRecord {
+	return auditRecord{
+		ID:         record.ID.Hex(),
+		Actor:      record.Actor,
+		RequestID:  record.RequestID,
+		Timestamp:  record.Timestamp,
+		Action:     record.Action,
+		EntityType: record.EntityType,
+		EntityID:   record.EntityID,
+		Before:     record.Before,
+		After:      record.After,
+	}
+}
+
+func (r auditRecord) model() (model.AuditRecord, error) {
+	id, err := bson.ObjectIDFromHex(r.ID)
+	return model.AuditRecord{
+		ID:         id,
+		Actor:      r.Actor,
+		RequestID:  r.RequestID,
+		Timestamp:  r.Timestamp.UTC(),
+		Action:     r.Action,
+		EntityType: r.EntityType,
+		EntityID:   r.EntityID,
+		Before:     r.Before,
+		After:      r.After,
+	}, err
+}
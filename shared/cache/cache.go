@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+const (
+	// DefaultSize is how many accounts the LRU keeps unless told otherwise
+	DefaultSize = 10000
+	// DefaultTTL bounds how long an account is served from the cache, and so how stale it can get when it was changed
+	// by another instance
+	DefaultTTL = 5 * time.Minute
+)
+
+// Cache keeps accounts by key, implementations must be safe for concurrent use. An account is held once however many
+// keys it can be found by, and getting or deleting it by any of them does the same
+type Cache interface {
+	Get(key string) (model.Account, bool)
+	// Set caches the account under key, aliases are the other keys it can be found by
+	Set(key string, account model.Account, aliases ...string)
+	Delete(key string)
+}
+
+// Stats counts what the cache did since it was created
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+}
+
+// LRU is an in-process Cache holding up to size accounts, each one for at most ttl. Once full the least recently used
+// account makes room for the new one, along with its aliases
+type LRU struct {
+	mu   sync.Mutex
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+	// by key and by alias
+	entries map[string]*list.Element
+	// most recently used first
+	order *list.List
+	stats Stats
+}
+
+type entry struct {
+	keys      []string // the key first, then the aliases
+	account   model.Account
+	expiresAt time.Time
+}
+
+func NewLRU(size int, ttl time.Duration) *LRU {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &LRU{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *LRU) Get(key string) (model.Account, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return model.Account{}, false
+	}
+	e := element.Value.(*entry)
+	if !c.now().Before(e.expiresAt) {
+		c.remove(element)
+		c.stats.Misses++
+		return model.Account{}, false
+	}
+	c.order.MoveToFront(element)
+	c.stats.Hits++
+	return e.account, true
+}
+
+func (c *LRU) Set(key string, account model.Account, aliases ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := append([]string{key}, aliases...)
+	// whatever was cached under any of the keys is replaced, with the keys it was cached under
+	for _, k := range keys {
+		if element, ok := c.entries[k]; ok {
+			c.remove(element)
+		}
+	}
+	element := c.order.PushFront(&entry{keys: keys, account: account, expiresAt: c.now().Add(c.ttl)})
+	for _, k := range keys {
+		c.entries[k] = element
+	}
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *LRU) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+// Stats returns the counters of the cache, Entries counts accounts whatever the number of keys they are cached under
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+func (c *LRU) remove(element *list.Element) {
+	c.order.Remove(element)
+	for _, k := range element.Value.(*entry).keys {
+		delete(c.entries, k)
+	}
+}
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// fakeRepo keeps accounts in a map and counts the lookups that reach it, only the methods the decorator overrides or
+// calls are implemented
+type fakeRepo struct {
+	repository.DatabaseRepository
+	accounts map[string]model.Account
+	lookups  int
+}
+
+func (f *fakeRepo) CreateAccount(ctx context.Context, documentID string) (*model.Account, error) {
+	account := model.Account{ID: bson.NewObjectID(), PublicID: publicid.NewAccountID(), DocumentNumber: documentID}
+	f.accounts[account.ID.Hex()] = account
+	f.accounts[account.PublicID] = account
+	return &account, nil
+}
+
+func (f *fakeRepo) GetAccountByID(ctx context.Context, accountID string) (*model.Account, error) {
+	f.lookups++
+	account, ok := f.accounts[accountID]
+	if !ok {
+		return nil, fmt.Errorf("account %s: %w", accountID, repository.ErrNotFound)
+	}
+	return &account, nil
+}
+
+func (f *fakeRepo) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func TestLRU(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	lru := NewLRU(2, time.Minute)
+	lru.now = func() time.Time { return now }
+	account := func(documentNumber string) model.Account {
+		return model.Account{ID: bson.NewObjectID(), DocumentNumber: documentNumber}
+	}
+
+	lru.Set("a", account("1"))
+	lru.Set("b", account("2"))
+	if got, ok := lru.Get("a"); !ok || got.DocumentNumber != "1" {
+		t.Fatalf("expected account a, got %+v, %v", got, ok)
+	}
+	// b is now the least recently used
+	lru.Set("c", account("3"))
+	if _, ok := lru.Get("b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+	if _, ok := lru.Get("a"); !ok {
+		t.Errorf("expected a to be kept")
+	}
+
+	lru.Delete("a")
+	if _, ok := lru.Get("a"); ok {
+		t.Errorf("expected a to be deleted")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := lru.Get("c"); ok {
+		t.Errorf("expected c to have expired")
+	}
+
+	want := Stats{Hits: 2, Misses: 3, Evictions: 1, Entries: 0}
+	if got := lru.Stats(); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestLRUAliases(t *testing.T) {
+	lru := NewLRU(2, time.Minute)
+	lru.Set("a", model.Account{DocumentNumber: "1"}, "acc_a")
+	lru.Set("b", model.Account{DocumentNumber: "2"}, "acc_b")
+	if got, ok := lru.Get("acc_a"); !ok || got.DocumentNumber != "1" {
+		t.Fatalf("expected account a by its alias, got %+v, %v", got, ok)
+	}
+	if stats := lru.Stats(); stats.Entries != 2 || stats.Evictions != 0 {
+		t.Errorf("expected an account with an alias to take up a single entry, got %+v", stats)
+	}
+
+	// b is now the least recently used, it goes along with its alias
+	lru.Set("c", model.Account{DocumentNumber: "3"}, "acc_c")
+	for _, k := range []string{"b", "acc_b"} {
+		if _, ok := lru.Get(k); ok {
+			t.Errorf("expected b to be evicted by %s", k)
+		}
+	}
+
+	lru.Delete("acc_a")
+	if _, ok := lru.Get("a"); ok {
+		t.Errorf("expected deleting a by its alias to drop its key")
+	}
+	if stats := lru.Stats(); stats.Entries != 1 || stats.Evictions != 1 {
+		t.Errorf("expected only c left after one eviction, got %+v", stats)
+	}
+}
+
+func TestRepositoryGetAccountByID(t *testing.T) {
+	ctx := context.Background()
+	next := &fakeRepo{accounts: map[string]model.Account{}}
+	lru := NewLRU(10, time.Minute)
+	repo := NewRepository(next, lru, slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	created, _ := next.CreateAccount(ctx, "12345678900")
+
+	for range 3 {
+		account, err := repo.GetAccountByID(ctx, created.ID.Hex())
+		if err != nil || account == nil || *account != *created {
+			t.Fatalf("expected %+v, got %+v, %v", created, account, err)
+		}
+	}
+	if next.lookups != 1 {
+		t.Errorf("expected one lookup to reach the repository, got %d", next.lookups)
+	}
+	if account, err := repo.GetAccountByID(ctx, created.PublicID); err != nil || *account != *created || next.lookups != 1 {
+		t.Errorf("expected the account cached by its public ID as well, got %+v, %v after %d lookups", account, err, next.lookups)
+	}
+	if entries := lru.Stats().Entries; entries != 1 {
+		t.Errorf("expected the account cached once, got %d entries", entries)
+	}
+
+	// the same ID of another tenant is another account
+	if _, err := repo.GetAccountByID(tenant.WithID(ctx, "program-a"), created.ID.Hex()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if next.lookups != 2 {
+		t.Errorf("expected the lookup of another tenant to reach the repository, got %d lookups", next.lookups)
+	}
+
+	missing := bson.NewObjectID().Hex()
+	for range 2 {
+		if _, err := repo.GetAccountByID(ctx, missing); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if next.lookups != 4 {
+		t.Errorf("expected accounts that do not exist not to be cached, got %d lookups", next.lookups)
+	}
+}
+
+func TestRepositoryInvalidation(t *testing.T) {
+	ctx := context.Background()
+	next := &fakeRepo{accounts: map[string]model.Account{}}
+	lru := NewLRU(10, time.Minute)
+	repo := NewRepository(next, lru, slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	var created *model.Account
+	err := repo.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if created, err = repo.CreateAccount(ctx, "12345678900"); err != nil {
+			return err
+		}
+		// read inside the transaction, before it has been committed
+		_, err = repo.GetAccountByID(ctx, created.ID.Hex())
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, id := range []string{created.ID.Hex(), created.PublicID} {
+		if _, ok := lru.Get(key(ctx, id)); ok {
+			t.Errorf("expected the account read inside the transaction to be dropped by %s once it ended", id)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
+)
+
+// Repository decorates a DatabaseRepository so accounts are read through a Cache, everything else passes straight
+// through. Only accounts that exist are cached. A mutation of an account drops it from the cache, and drops it again
+// once the transaction it was made in has ended so a read made in between cannot bring back the old account. Each
+// instance has a cache of its own, a change made by another instance is seen once the cached account expires. An
+// account mutation added to the repository has to be overridden here as well to invalidate the cache. An account can be
+// looked up by its public ID or its internal ID, it is cached once under its internal ID with its public ID as an alias
+// so either finds it, and dropping it by either drops both
+type Repository struct {
+	repository.DatabaseRepository
+	cache  Cache
+	logger *slog.Logger
+}
+
+func NewRepository(next repository.DatabaseRepository, cache Cache, logger *slog.Logger) *Repository {
+	logger.InfoContext(context.Background(), "cache Repository initialized")
+	return &Repository{DatabaseRepository: next, cache: cache, logger: logger}
+}
+
+type invalidationsKey struct{}
+
+// invalidations are the keys mutated by a running transaction, dropped again once it has ended
+type invalidations struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+// key keeps the accounts of each tenant apart, they live in databases of their own so their IDs may collide
+func key(ctx context.Context, accountID string) string {
+	return tenant.FromContext(ctx) + "/" + accountID
+}
+
+func (r *Repository) GetAccountByID(ctx context.Context, accountID string) (*model.Account, error) {
+	k := key(ctx, accountID)
+	if account, ok := r.cache.Get(k); ok {
+		return &account, nil
+	}
+	account, err := r.DatabaseRepository.GetAccountByID(ctx, accountID)
+	if err != nil || account == nil {
+		return account, err
+	}
+	var aliases []string
+	if account.PublicID != "" {
+		aliases = append(aliases, key(ctx, account.PublicID))
+	}
+	r.cache.Set(key(ctx, account.ID.Hex()), *account, aliases...)
+	return account, nil
+}
+
+// accountIDs are the IDs the account can be looked up by
+func accountIDs(account *model.Account) []string {
+	if account.PublicID == "" {
+		return []string{account.ID.Hex()}
+	}
+	return []string{account.PublicID, account.ID.Hex()}
+}
+
+func (r *Repository) CreateAccount(ctx context.Context, documentID string) (*model.Account, error) {
+	account, err := r.DatabaseRepository.CreateAccount(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
+	r.invalidate(ctx, accountIDs(account)...)
+	return account, nil
+}
+
+// WithTransaction drops the accounts mutated by fn from the cache once the transaction has ended, whether it was
+// committed or rolled back
+func (r *Repository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(invalidationsKey{}).(*invalidations); ok {
+		return r.DatabaseRepository.WithTransaction(ctx, fn)
+	}
+	pending := &invalidations{}
+	err := r.DatabaseRepository.WithTransaction(context.WithValue(ctx, invalidationsKey{}, pending), fn)
+	for _, k := range pending.keys {
+		r.cache.Delete(k)
+	}
+	return err
+}
+
+// invalidate drops the account, by every ID it is cached under, from the cache now and, when ctx is part of a
+// transaction, once the transaction ends
+func (r *Repository) invalidate(ctx context.Context, accountIDs ...string) {
+	pending, _ := ctx.Value(invalidationsKey{}).(*invalidations)
+	for _, accountID := range accountIDs {
+		k := key(ctx, accountID)
+		r.cache.Delete(k)
+		if pending != nil {
+			pending.mu.Lock()
+			pending.keys = append(pending.keys, k)
+			pending.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (m *MongoDB) AppendAuditRecord(ctx context.Context, record model.AuditRecord) error {
+	if _, err := m.collection(ctx, m.config.Collections.AuditLog).InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("failed to append audit record: %w", mongoErr(err))
+	}
+	return nil
+}
+
+func (m *MongoDB) FindAuditRecords(ctx context.Context, entityType model.AuditEntityType, entityID string, limit int) ([]model.AuditRecord, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+	cursor, err := m.collection(ctx, m.config.Collections.AuditLog).
+		Find(ctx, bson.M{"entity_type": entityType, "entity_id": entityID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find audit records: %w", mongoErr(err))
+	}
+	var records []model.AuditRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode audit records: %w", mongoErr(err))
+	}
+	return records, nil
+}
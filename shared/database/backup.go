@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Snapshot runs fn in a session reading with the snapshot read concern, every read made with its ctx sees the data at
+// the same point in time. Like transactions it needs a replica set
+func (m *MongoDB) Snapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	session, err := m.client.StartSession(options.Session().SetSnapshot(true))
+	if err != nil {
+		return fmt.Errorf("failed to start snapshot session: %w", mongoErr(err))
+	}
+	defer session.EndSession(ctx)
+	return fn(mongo.NewSessionContext(ctx, session))
+}
+
+// streamCollection calls fn with every document of the collection decoded into a T, in sort order
+func streamCollection[T any](ctx context.Context, collection *mongo.Collection, sort bson.D, fn func(T) error) error {
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(sort).SetBatchSize(streamBatchSize))
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %w", collection.Name(), mongoErr(err))
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", collection.Name(), mongoErr(err))
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to stream %s: %w", collection.Name(), mongoErr(err))
+	}
+	return nil
+}
+
+func (m *MongoDB) StreamAccounts(ctx context.Context, fn func(model.Account) error) error {
+	return streamCollection(ctx, m.collection(ctx, m.config.Collections.Accounts), bson.D{{Key: "_id", Value: 1}}, fn)
+}
+
+func (m *MongoDB) StreamJournalEntries(ctx context.Context, fn func(model.JournalEntry) error) error {
+	return streamCollection(ctx, m.collection(ctx, m.config.Collections.Journal), bson.D{{Key: "posted_at", Value: 1}, {Key: "_id", Value: 1}}, fn)
+}
+
+func (m *MongoDB) StreamAuditRecords(ctx context.Context, fn func(model.AuditRecord) error) error {
+	return streamCollection(ctx, m.collection(ctx, m.config.Collections.AuditLog), bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}, fn)
+}
+
+func (m *MongoDB) Empty(ctx context.Context) (bool, error) {
+	collections := m.config.Collections
+	for _, name := range []string{collections.Accounts, collections.Transactions, collections.Journal, collections.AuditLog} {
+		err := m.collection(ctx, name).FindOne(ctx, bson.M{}).Err()
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return false, fmt.Errorf("failed to check %s: %w", name, mongoErr(err))
+		}
+	}
+	return true, nil
+}
+
+func (m *MongoDB) RestoreAccount(ctx context.Context, account model.Account) error {
+	_, err := m.collection(ctx, m.config.Collections.Accounts).InsertOne(ctx, account)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("account %s: %w", account.ID.Hex(), repository.ErrDuplicate)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore account: %w", mongoErr(err))
+	}
+	return nil
+}
+
+func (m *MongoDB) RestoreTransaction(ctx context.Context, transaction model.Transaction) error {
+	_, err := m.collection(ctx, m.config.Collections.Transactions).InsertOne(ctx, transaction)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("transaction %s: %w", transaction.ID.Hex(), repository.ErrDuplicate)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore transaction: %w", mongoErr(err))
+	}
+	return nil
+}
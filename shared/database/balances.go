@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// errStaleAccountBalance is returned when the projection was written by someone else since it was read
+var errStaleAccountBalance = fmt.Errorf("account balance was changed concurrently: %w", repository.ErrConflict)
+
+func (m *MongoDB) GetAccountBalance(ctx context.Context, accountID string) (*model.AccountBalance, error) {
+	var balance model.AccountBalance
+	err := m.collection(ctx, m.config.Collections.AccountBalances).FindOne(ctx, bson.M{"_id": accountID}).Decode(&balance)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account balance: %w", mongoErr(err))
+	}
+	return &balance, nil
+}
+
+func (m *MongoDB) SaveAccountBalance(ctx context.Context, balance model.AccountBalance) error {
+	collection := m.collection(ctx, m.config.Collections.AccountBalances)
+	if balance.Version == 1 {
+		if _, err := collection.InsertOne(ctx, balance); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return errStaleAccountBalance
+			}
+			return fmt.Errorf("failed to create account balance: %w", mongoErr(err))
+		}
+		return nil
+	}
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": balance.AccountID, "version": balance.Version - 1}, balance)
+	if err != nil {
+		return fmt.Errorf("failed to save account balance: %w", mongoErr(err))
+	}
+	if result.MatchedCount == 0 {
+		return errStaleAccountBalance
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (m *MongoDB) CreateJob(ctx context.Context, job model.Job) error {
+	if _, err := m.collection(ctx, m.config.Collections.Jobs).InsertOne(ctx, job); err != nil {
+		return fmt.Errorf("failed to create job %s: %w", job.ID, mongoErr(err))
+	}
+	return nil
+}
+
+func (m *MongoDB) GetJob(ctx context.Context, jobID string) (*model.Job, error) {
+	var job model.Job
+	err := m.collection(ctx, m.config.Collections.Jobs).FindOne(ctx, bson.M{"_id": jobID}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job %s: %w", jobID, mongoErr(err))
+	}
+	return &job, nil
+}
+
+func (m *MongoDB) UpdateJob(ctx context.Context, job model.Job) error {
+	result, err := m.collection(ctx, m.config.Collections.Jobs).ReplaceOne(ctx, bson.M{"_id": job.ID}, job)
+	if err != nil {
+		return fmt.Errorf("failed to update job %s: %w", job.ID, mongoErr(err))
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("job %s: %w", job.ID, repository.ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteJobsUpdatedBefore finds the jobs first so it can report them, jobs that old have finished or were lost so nothing
+// updates them in between
+func (m *MongoDB) DeleteJobsUpdatedBefore(ctx context.Context, before time.Time) ([]string, error) {
+	jobs := m.collection(ctx, m.config.Collections.Jobs)
+	filter := bson.M{"updated_at": bson.M{"$lt": before}}
+	cursor, err := jobs.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired jobs: %w", mongoErr(err))
+	}
+	var found []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, fmt.Errorf("failed to decode expired jobs: %w", mongoErr(err))
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(found))
+	for i, job := range found {
+		ids[i] = job.ID
+	}
+	filter["_id"] = bson.M{"$in": ids}
+	if _, err := jobs.DeleteMany(ctx, filter); err != nil {
+		return nil, fmt.Errorf("failed to delete expired jobs: %w", mongoErr(err))
+	}
+	return ids, nil
+}
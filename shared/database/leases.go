@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// leaseExpiry is how long after expiring a lease is left in the leases collection before MongoDB removes it, an expired
+// lease is taken over in place so removing it is only housekeeping
+const leaseExpiry = time.Hour
+
+// AcquireLease upserts the lease, matching it only while it is free or already owner's. When someone else holds it the
+// upsert tries to insert a second lease with the same name, which the unique _id turns away
+func (m *MongoDB) AcquireLease(ctx context.Context, name string, owner string, now time.Time, expiresAt time.Time) (bool, error) {
+	filter := bson.M{"_id": name, "$or": bson.A{bson.M{"owner": owner}, bson.M{"expires_at": bson.M{"$lte": now}}}}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": expiresAt}}
+	_, err := m.collection(ctx, m.config.Collections.Leases).UpdateOne(ctx, filter, update, options.UpdateOne().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %w", name, mongoErr(err))
+	}
+	return true, nil
+}
+
+func (m *MongoDB) ReleaseLease(ctx context.Context, name string, owner string) error {
+	if _, err := m.collection(ctx, m.config.Collections.Leases).DeleteOne(ctx, bson.M{"_id": name, "owner": owner}); err != nil {
+		return fmt.Errorf("failed to release lease %s: %w", name, mongoErr(err))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (m *MongoDB) PostJournalEntry(ctx context.Context, entry model.JournalEntry) error {
+	if _, err := m.collection(ctx, m.config.Collections.Journal).InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("failed to post journal entry: %w", mongoErr(err))
+	}
+	return nil
+}
+
+func (m *MongoDB) FindJournalEntries(ctx context.Context, accountID string, postedBy time.Time) ([]model.JournalEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "posted_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := m.collection(ctx, m.config.Collections.Journal).
+		Find(ctx, bson.M{"account_id": accountID, "posted_at": bson.M{"$lte": postedBy}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find journal entries: %w", mongoErr(err))
+	}
+	var entries []model.JournalEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode journal entries: %w", mongoErr(err))
+	}
+	return entries, nil
+}
+
+func (m *MongoDB) TrialBalance(ctx context.Context) ([]model.TrialBalanceLine, error) {
+	sumSide := func(side model.EntrySide) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$lines.side", side}}, "$lines.amount_cents", 0}}}
+	}
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$unwind", Value: "$lines"}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":          "$lines.ledger_account",
+			"debit_cents":  sumSide(model.EntrySideDebit),
+			"credit_cents": sumSide(model.EntrySideCredit),
+		}}},
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	cursor, err := m.collection(ctx, m.config.Collections.Journal).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total journal: %w", mongoErr(err))
+	}
+	var rows []struct {
+		LedgerAccount model.LedgerAccount `bson:"_id"`
+		DebitCents    int64               `bson:"debit_cents"`
+		CreditCents   int64               `bson:"credit_cents"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to decode trial balance: %w", mongoErr(err))
+	}
+	lines := make([]model.TrialBalanceLine, len(rows))
+	for i, row := range rows {
+		lines[i] = model.TrialBalanceLine{LedgerAccount: row.LedgerAccount, DebitCents: row.DebitCents, CreditCents: row.CreditCents}
+	}
+	return lines, nil
+}
@@ -0,0 +1,750 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Memory keeps everything in process memory and behaves like MongoDB as far as the repositories go, it is meant for
+// local development, demos and tests. Documents are copied on the way in and out so callers never share them with the
+// store. A transaction takes the store's lock for as long as it runs and works on the live data, a failed transaction
+// puts back a copy taken when it started, so transactions run one at a time and cost a copy of every collection
+type Memory struct {
+	mu   sync.RWMutex
+	data memoryData
+	// leases are not part of the data, a transaction rolled back does not hand back the leases taken meanwhile
+	leaseMu sync.Mutex
+	leases  map[string]memoryLease
+	// jobs are written as they progress, outside of any transaction
+	jobMu sync.Mutex
+	jobs  map[string]model.Job
+}
+
+type memoryLease struct {
+	owner     string
+	expiresAt time.Time
+}
+
+type memoryData struct {
+	accounts      []model.Account
+	transactions  []model.Transaction
+	outbox        []model.OutboxEvent
+	journal       []model.JournalEntry
+	balances      map[string]model.AccountBalance
+	subscriptions []model.WebhookSubscription
+	deliveries    []model.WebhookDelivery
+	auditRecords  []model.AuditRecord
+}
+
+// memoryTxKey marks a context as running inside a transaction of the store it holds
+type memoryTxKey struct{}
+
+// memorySnapshotKey marks a context as reading from a snapshot of the store it holds
+type memorySnapshotKey struct{}
+
+func NewMemory() *Memory {
+	return &Memory{data: memoryData{balances: make(map[string]model.AccountBalance)}, leases: make(map[string]memoryLease), jobs: make(map[string]model.Job)}
+}
+
+// snapshot copies the collections, the documents in them are never changed in place so they can be shared
+func (d memoryData) snapshot() memoryData {
+	snapshot := memoryData{
+		accounts:      slices.Clone(d.accounts),
+		transactions:  slices.Clone(d.transactions),
+		outbox:        slices.Clone(d.outbox),
+		journal:       slices.Clone(d.journal),
+		balances:      make(map[string]model.AccountBalance, len(d.balances)),
+		subscriptions: slices.Clone(d.subscriptions),
+		deliveries:    slices.Clone(d.deliveries),
+		auditRecords:  slices.Clone(d.auditRecords),
+	}
+	for accountID, balance := range d.balances {
+		snapshot.balances[accountID] = balance
+	}
+	return snapshot
+}
+
+func (m *Memory) inTransaction(ctx context.Context) bool {
+	store, _ := ctx.Value(memoryTxKey{}).(*Memory)
+	return store == m
+}
+
+// read and write take the lock unless ctx belongs to a transaction, which already holds it. Reads made from a
+// snapshot hold the read lock already
+func (m *Memory) read(ctx context.Context) func() {
+	if store, _ := ctx.Value(memorySnapshotKey{}).(*Memory); m.inTransaction(ctx) || store == m {
+		return func() {}
+	}
+	m.mu.RLock()
+	return m.mu.RUnlock
+}
+
+func (m *Memory) write(ctx context.Context) func() {
+	if m.inTransaction(ctx) {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.mu.Unlock
+}
+
+// WithTransaction joins the transaction ctx is already part of, like MongoDB does. The ctx passed to fn must stay on
+// the goroutine running fn since repository calls made with it skip the lock
+func (m *Memory) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.inTransaction(ctx) {
+		return fn(ctx)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := m.data.snapshot()
+	err := fn(context.WithValue(ctx, memoryTxKey{}, m))
+	if err == nil {
+		// committing on a ctx that is done fails, as it would with a database
+		err = ctx.Err()
+	}
+	if err != nil {
+		m.data = snapshot
+		return err
+	}
+	return nil
+}
+
+// Snapshot holds the read lock while fn runs, so nothing is written until it returns
+func (m *Memory) Snapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.inTransaction(ctx) {
+		return fn(ctx)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return fn(context.WithValue(ctx, memorySnapshotKey{}, m))
+}
+
+func (m *Memory) CreateAccount(ctx context.Context, documentID string) (*model.Account, error) {
+	defer m.write(ctx)()
+	if slices.ContainsFunc(m.data.accounts, func(acc model.Account) bool { return acc.DocumentNumber == documentID }) {
+		return nil, fmt.Errorf("account with document number %s: %w", documentID, repository.ErrDuplicate)
+	}
+	acc := model.Account{ID: bson.NewObjectID(), PublicID: publicid.NewAccountID(), DocumentNumber: documentID, Version: 1}
+	m.data.accounts = append(m.data.accounts, acc)
+	return &acc, nil
+}
+
+func (m *Memory) GetAccountByID(ctx context.Context, accountID string) (*model.Account, error) {
+	match := func(acc model.Account) bool { return acc.PublicID == accountID }
+	if !publicid.Valid(accountID, publicid.AccountPrefix) {
+		id, err := bson.ObjectIDFromHex(accountID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account ID format: %w", err)
+		}
+		match = func(acc model.Account) bool { return acc.ID == id }
+	}
+	defer m.read(ctx)()
+	for _, acc := range m.data.accounts {
+		if match(acc) {
+			return &acc, nil
+		}
+	}
+	return nil, fmt.Errorf("account %s: %w", accountID, repository.ErrNotFound)
+}
+
+func (m *Memory) GetAccountByDocumentNumber(ctx context.Context, documentNumber string) (*model.Account, error) {
+	defer m.read(ctx)()
+	for _, acc := range m.data.accounts {
+		if acc.DocumentNumber == documentNumber {
+			return &acc, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) CreateTransaction(ctx context.Context, transaction model.Transaction) (*model.Transaction, error) {
+	defer m.write(ctx)()
+	if slices.ContainsFunc(m.data.transactions, func(tx model.Transaction) bool { return tx.IdempotencyKey == transaction.IdempotencyKey }) {
+		return nil, fmt.Errorf("transaction with idempotency key %s: %w", transaction.IdempotencyKey, repository.ErrDuplicate)
+	}
+	if transaction.ID.IsZero() {
+		transaction.ID = bson.NewObjectID()
+	}
+	if transaction.PublicID == "" {
+		transaction.PublicID = publicid.NewTransactionID()
+	}
+	transaction.Version = 1
+	m.data.transactions = append(m.data.transactions, transaction)
+	return &transaction, nil
+}
+
+func (m *Memory) GetTransactionByID(ctx context.Context, transactionID string) (*model.Transaction, error) {
+	match := func(tx model.Transaction) bool { return tx.PublicID == transactionID }
+	if !publicid.Valid(transactionID, publicid.TransactionPrefix) {
+		id, err := bson.ObjectIDFromHex(transactionID)
+		if err != nil {
+			return nil, nil
+		}
+		match = func(tx model.Transaction) bool { return tx.ID == id }
+	}
+	defer m.read(ctx)()
+	if i := slices.IndexFunc(m.data.transactions, match); i >= 0 {
+		tx := m.data.transactions[i]
+		return &tx, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) transactionIndex(id bson.ObjectID) int {
+	return slices.IndexFunc(m.data.transactions, func(tx model.Transaction) bool { return tx.ID == id })
+}
+
+func (m *Memory) FindTransactionByIdempotencyKey(ctx context.Context, idempotencyKey string) (*model.Transaction, error) {
+	defer m.read(ctx)()
+	for _, tx := range m.data.transactions {
+		if tx.IdempotencyKey == idempotencyKey {
+			return &tx, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) FindAllTransactionsForAccountID(ctx context.Context, accountID string) ([]model.Transaction, error) {
+	return m.findTransactions(ctx, model.TransactionFilter{AccountID: accountID}), nil
+}
+
+// findTransactions returns copies of the matching transactions sorted by event_date then _id
+func (m *Memory) findTransactions(ctx context.Context, filter model.TransactionFilter) []model.Transaction {
+	defer m.read(ctx)()
+	var transactions []model.Transaction
+	for _, tx := range m.data.transactions {
+		if filter.AccountID != "" && tx.AccountID != filter.AccountID {
+			continue
+		}
+		if filter.OperationID != 0 && tx.OperationID != filter.OperationID {
+			continue
+		}
+		if (!filter.From.IsZero() && tx.EventDate.Before(filter.From)) || (!filter.To.IsZero() && tx.EventDate.After(filter.To)) {
+			continue
+		}
+		transactions = append(transactions, tx)
+	}
+	slices.SortStableFunc(transactions, func(a, b model.Transaction) int {
+		return cmp.Or(a.EventDate.Compare(b.EventDate), compareObjectIDs(a.ID, b.ID))
+	})
+	return transactions
+}
+
+func compareObjectIDs(a, b bson.ObjectID) int {
+	return cmp.Compare(a.Hex(), b.Hex())
+}
+
+// UpdateTransactionByID replaces every field of the transaction but its ID, as the $set of the whole document does
+func (m *Memory) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction, expectedVersion int64) error {
+	id, err := bson.ObjectIDFromHex(transactionID)
+	if err != nil {
+		return err
+	}
+	defer m.write(ctx)()
+	i := m.transactionIndex(id)
+	if i < 0 {
+		return fmt.Errorf("transaction %s: %w", transactionID, repository.ErrNotFound)
+	}
+	if m.data.transactions[i].Version != expectedVersion {
+		return fmt.Errorf("transaction %s is at version %d, not %d: %w", transactionID, m.data.transactions[i].Version, expectedVersion, repository.ErrConflict)
+	}
+	transaction.ID = id
+	transaction.Version = expectedVersion + 1
+	m.data.transactions[i] = transaction
+	return nil
+}
+
+// StreamTransactions hands fn the transactions matching the filter once the lock has been let go, so fn is free to call
+// back into the store
+func (m *Memory) StreamTransactions(ctx context.Context, filter model.TransactionFilter, fn func(model.Transaction) error) error {
+	for _, tx := range m.findTransactions(ctx, filter) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) AppendOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	defer m.write(ctx)()
+	if event.ID.IsZero() {
+		event.ID = bson.NewObjectID()
+	}
+	m.data.outbox = append(m.data.outbox, cloneOutboxEvent(event))
+	return nil
+}
+
+func (m *Memory) FindUnpublishedOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	defer m.read(ctx)()
+	var events []model.OutboxEvent
+	for _, event := range m.data.outbox {
+		if event.PublishedAt == nil {
+			events = append(events, cloneOutboxEvent(event))
+		}
+	}
+	slices.SortStableFunc(events, func(a, b model.OutboxEvent) int {
+		return cmp.Or(a.OccurredAt.Compare(b.OccurredAt), compareObjectIDs(a.ID, b.ID))
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (m *Memory) MarkOutboxEventPublished(ctx context.Context, eventID string, publishedAt time.Time) error {
+	id, err := bson.ObjectIDFromHex(eventID)
+	if err != nil {
+		return err
+	}
+	defer m.write(ctx)()
+	for i, event := range m.data.outbox {
+		if event.ID == id {
+			event = cloneOutboxEvent(event)
+			event.PublishedAt = &publishedAt
+			m.data.outbox[i] = event
+			return nil
+		}
+	}
+	return fmt.Errorf("outbox event %s: %w", eventID, repository.ErrNotFound)
+}
+
+func (m *Memory) PostJournalEntry(ctx context.Context, entry model.JournalEntry) error {
+	defer m.write(ctx)()
+	if entry.ID.IsZero() {
+		entry.ID = bson.NewObjectID()
+	}
+	entry.Lines = slices.Clone(entry.Lines)
+	m.data.journal = append(m.data.journal, entry)
+	return nil
+}
+
+func (m *Memory) FindJournalEntries(ctx context.Context, accountID string, postedBy time.Time) ([]model.JournalEntry, error) {
+	defer m.read(ctx)()
+	var entries []model.JournalEntry
+	for _, entry := range m.data.journal {
+		if entry.AccountID == accountID && !entry.PostedAt.After(postedBy) {
+			entry.Lines = slices.Clone(entry.Lines)
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b model.JournalEntry) int {
+		return cmp.Or(a.PostedAt.Compare(b.PostedAt), compareObjectIDs(a.ID, b.ID))
+	})
+	return entries, nil
+}
+
+func (m *Memory) TrialBalance(ctx context.Context) ([]model.TrialBalanceLine, error) {
+	defer m.read(ctx)()
+	totals := make(map[model.LedgerAccount]*model.TrialBalanceLine)
+	for _, entry := range m.data.journal {
+		for _, line := range entry.Lines {
+			total, ok := totals[line.LedgerAccount]
+			if !ok {
+				total = &model.TrialBalanceLine{LedgerAccount: line.LedgerAccount}
+				totals[line.LedgerAccount] = total
+			}
+			switch line.Side {
+			case model.EntrySideDebit:
+				total.DebitCents += line.AmountCents
+			case model.EntrySideCredit:
+				total.CreditCents += line.AmountCents
+			}
+		}
+	}
+	lines := make([]model.TrialBalanceLine, 0, len(totals))
+	for _, total := range totals {
+		lines = append(lines, *total)
+	}
+	slices.SortFunc(lines, func(a, b model.TrialBalanceLine) int { return cmp.Compare(a.LedgerAccount, b.LedgerAccount) })
+	return lines, nil
+}
+
+func (m *Memory) GetAccountBalance(ctx context.Context, accountID string) (*model.AccountBalance, error) {
+	defer m.read(ctx)()
+	balance, ok := m.data.balances[accountID]
+	if !ok {
+		return nil, nil
+	}
+	balance.OpenDebts = slices.Clone(balance.OpenDebts)
+	return &balance, nil
+}
+
+func (m *Memory) SaveAccountBalance(ctx context.Context, balance model.AccountBalance) error {
+	defer m.write(ctx)()
+	current, ok := m.data.balances[balance.AccountID]
+	if (balance.Version == 1 && ok) || (balance.Version != 1 && (!ok || current.Version != balance.Version-1)) {
+		return errStaleAccountBalance
+	}
+	balance.OpenDebts = slices.Clone(balance.OpenDebts)
+	m.data.balances[balance.AccountID] = balance
+	return nil
+}
+
+func (m *Memory) CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (*model.WebhookSubscription, error) {
+	defer m.write(ctx)()
+	subscription.ID = bson.NewObjectID()
+	subscription.EventTypes = slices.Clone(subscription.EventTypes)
+	m.data.subscriptions = append(m.data.subscriptions, subscription)
+	subscription.EventTypes = slices.Clone(subscription.EventTypes)
+	return &subscription, nil
+}
+
+func (m *Memory) GetWebhookSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscription, error) {
+	id, err := bson.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return nil, nil
+	}
+	defer m.read(ctx)()
+	if i := m.subscriptionIndex(id); i >= 0 {
+		subscription := m.data.subscriptions[i]
+		subscription.EventTypes = slices.Clone(subscription.EventTypes)
+		return &subscription, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) subscriptionIndex(id bson.ObjectID) int {
+	return slices.IndexFunc(m.data.subscriptions, func(s model.WebhookSubscription) bool { return s.ID == id })
+}
+
+func (m *Memory) ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	defer m.read(ctx)()
+	subscriptions := make([]model.WebhookSubscription, len(m.data.subscriptions))
+	for i, subscription := range m.data.subscriptions {
+		subscription.EventTypes = slices.Clone(subscription.EventTypes)
+		subscriptions[i] = subscription
+	}
+	slices.SortFunc(subscriptions, func(a, b model.WebhookSubscription) int { return compareObjectIDs(a.ID, b.ID) })
+	return subscriptions, nil
+}
+
+func (m *Memory) UpdateWebhookSubscription(ctx context.Context, subscriptionID string, subscription model.WebhookSubscription) error {
+	id, err := bson.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return err
+	}
+	defer m.write(ctx)()
+	i := m.subscriptionIndex(id)
+	if i < 0 {
+		return fmt.Errorf("webhook subscription %s: %w", subscriptionID, repository.ErrNotFound)
+	}
+	subscription.ID = id
+	subscription.EventTypes = slices.Clone(subscription.EventTypes)
+	m.data.subscriptions[i] = subscription
+	return nil
+}
+
+func (m *Memory) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	id, err := bson.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return err
+	}
+	defer m.write(ctx)()
+	i := m.subscriptionIndex(id)
+	if i < 0 {
+		return fmt.Errorf("webhook subscription %s: %w", subscriptionID, repository.ErrNotFound)
+	}
+	m.data.subscriptions = slices.Delete(slices.Clone(m.data.subscriptions), i, i+1)
+	return nil
+}
+
+func (m *Memory) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	defer m.write(ctx)()
+	for _, existing := range m.data.deliveries {
+		if existing.SubscriptionID == delivery.SubscriptionID && existing.EventID == delivery.EventID {
+			return nil
+		}
+	}
+	if delivery.ID.IsZero() {
+		delivery.ID = bson.NewObjectID()
+	}
+	m.data.deliveries = append(m.data.deliveries, cloneDelivery(delivery))
+	return nil
+}
+
+func (m *Memory) GetWebhookDelivery(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error) {
+	id, err := bson.ObjectIDFromHex(deliveryID)
+	if err != nil {
+		return nil, nil
+	}
+	defer m.read(ctx)()
+	if i := m.deliveryIndex(id); i >= 0 {
+		delivery := cloneDelivery(m.data.deliveries[i])
+		return &delivery, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) deliveryIndex(id bson.ObjectID) int {
+	return slices.IndexFunc(m.data.deliveries, func(d model.WebhookDelivery) bool { return d.ID == id })
+}
+
+func (m *Memory) ListWebhookDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter, limit int) ([]model.WebhookDelivery, error) {
+	defer m.read(ctx)()
+	var deliveries []model.WebhookDelivery
+	for _, delivery := range m.data.deliveries {
+		if filter.SubscriptionID != "" && delivery.SubscriptionID != filter.SubscriptionID {
+			continue
+		}
+		if filter.Status != "" && delivery.Status != filter.Status {
+			continue
+		}
+		deliveries = append(deliveries, cloneDelivery(delivery))
+	}
+	slices.SortFunc(deliveries, func(a, b model.WebhookDelivery) int { return compareObjectIDs(a.ID, b.ID) })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (m *Memory) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	defer m.write(ctx)()
+	var due []int
+	for i, delivery := range m.data.deliveries {
+		if delivery.Status == model.WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, i)
+		}
+	}
+	slices.SortFunc(due, func(a, b int) int {
+		x, y := m.data.deliveries[a], m.data.deliveries[b]
+		return cmp.Or(x.NextAttemptAt.Compare(y.NextAttemptAt), compareObjectIDs(x.ID, y.ID))
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	deliveries := make([]model.WebhookDelivery, 0, len(due))
+	for _, i := range due {
+		delivery := cloneDelivery(m.data.deliveries[i])
+		delivery.NextAttemptAt = leaseUntil
+		m.data.deliveries[i] = delivery
+		deliveries = append(deliveries, cloneDelivery(delivery))
+	}
+	return deliveries, nil
+}
+
+func (m *Memory) UpdateWebhookDelivery(ctx context.Context, deliveryID string, delivery model.WebhookDelivery, expectedNextAttemptAt time.Time) error {
+	id, err := bson.ObjectIDFromHex(deliveryID)
+	if err != nil {
+		return err
+	}
+	defer m.write(ctx)()
+	i := m.deliveryIndex(id)
+	if i < 0 {
+		return fmt.Errorf("webhook delivery %s: %w", deliveryID, repository.ErrNotFound)
+	}
+	if !m.data.deliveries[i].NextAttemptAt.Equal(expectedNextAttemptAt) {
+		return fmt.Errorf("webhook delivery %s is no longer due at %v: %w", deliveryID, expectedNextAttemptAt, repository.ErrConflict)
+	}
+	delivery.ID = id
+	m.data.deliveries[i] = cloneDelivery(delivery)
+	return nil
+}
+
+func (m *Memory) AppendAuditRecord(ctx context.Context, record model.AuditRecord) error {
+	defer m.write(ctx)()
+	if record.ID.IsZero() {
+		record.ID = bson.NewObjectID()
+	}
+	record.Before = cloneRaw(record.Before)
+	record.After = cloneRaw(record.After)
+	m.data.auditRecords = append(m.data.auditRecords, record)
+	return nil
+}
+
+func (m *Memory) FindAuditRecords(ctx context.Context, entityType model.AuditEntityType, entityID string, limit int) ([]model.AuditRecord, error) {
+	defer m.read(ctx)()
+	var records []model.AuditRecord
+	for _, record := range m.data.auditRecords {
+		if record.EntityType == entityType && record.EntityID == entityID {
+			record.Before = cloneRaw(record.Before)
+			record.After = cloneRaw(record.After)
+			records = append(records, record)
+		}
+	}
+	slices.SortStableFunc(records, func(a, b model.AuditRecord) int {
+		return cmp.Or(a.Timestamp.Compare(b.Timestamp), compareObjectIDs(a.ID, b.ID))
+	})
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+func cloneRaw(raw json.RawMessage) json.RawMessage {
+	if raw == nil {
+		return nil
+	}
+	return slices.Clone(raw)
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	clone := *t
+	return &clone
+}
+
+func cloneOutboxEvent(event model.OutboxEvent) model.OutboxEvent {
+	event.Payload = cloneRaw(event.Payload)
+	event.PublishedAt = cloneTime(event.PublishedAt)
+	return event
+}
+
+func cloneDelivery(delivery model.WebhookDelivery) model.WebhookDelivery {
+	delivery.Body = cloneRaw(delivery.Body)
+	delivery.DeliveredAt = cloneTime(delivery.DeliveredAt)
+	return delivery
+}
+
+func (m *Memory) AcquireLease(ctx context.Context, name string, owner string, now time.Time, expiresAt time.Time) (bool, error) {
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+	if lease, ok := m.leases[name]; ok && lease.owner != owner && lease.expiresAt.After(now) {
+		return false, nil
+	}
+	m.leases[name] = memoryLease{owner: owner, expiresAt: expiresAt}
+	return true, nil
+}
+
+func (m *Memory) ReleaseLease(ctx context.Context, name string, owner string) error {
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+	if lease, ok := m.leases[name]; ok && lease.owner == owner {
+		delete(m.leases, name)
+	}
+	return nil
+}
+
+func cloneJob(job model.Job) model.Job {
+	job.IgnoredColumns = slices.Clone(job.IgnoredColumns)
+	job.StartedAt = cloneTime(job.StartedAt)
+	job.FinishedAt = cloneTime(job.FinishedAt)
+	return job
+}
+
+func (m *Memory) CreateJob(ctx context.Context, job model.Job) error {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	m.jobs[job.ID] = cloneJob(job)
+	return nil
+}
+
+func (m *Memory) GetJob(ctx context.Context, jobID string) (*model.Job, error) {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return nil, nil
+	}
+	job = cloneJob(job)
+	return &job, nil
+}
+
+func (m *Memory) UpdateJob(ctx context.Context, job model.Job) error {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	if _, ok := m.jobs[job.ID]; !ok {
+		return fmt.Errorf("job %s: %w", job.ID, repository.ErrNotFound)
+	}
+	m.jobs[job.ID] = cloneJob(job)
+	return nil
+}
+
+func (m *Memory) DeleteJobsUpdatedBefore(ctx context.Context, before time.Time) ([]string, error) {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	var deleted []string
+	for jobID, job := range m.jobs {
+		if job.UpdatedAt.Before(before) {
+			delete(m.jobs, jobID)
+			deleted = append(deleted, jobID)
+		}
+	}
+	return deleted, nil
+}
+
+func (m *Memory) StreamAccounts(ctx context.Context, fn func(model.Account) error) error {
+	unlock := m.read(ctx)
+	accounts := slices.Clone(m.data.accounts)
+	unlock()
+	for _, acc := range accounts {
+		if err := fn(acc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) StreamJournalEntries(ctx context.Context, fn func(model.JournalEntry) error) error {
+	unlock := m.read(ctx)
+	entries := slices.Clone(m.data.journal)
+	unlock()
+	slices.SortStableFunc(entries, func(a, b model.JournalEntry) int {
+		return cmp.Or(a.PostedAt.Compare(b.PostedAt), compareObjectIDs(a.ID, b.ID))
+	})
+	for _, entry := range entries {
+		entry.Lines = slices.Clone(entry.Lines)
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) StreamAuditRecords(ctx context.Context, fn func(model.AuditRecord) error) error {
+	unlock := m.read(ctx)
+	records := slices.Clone(m.data.auditRecords)
+	unlock()
+	slices.SortStableFunc(records, func(a, b model.AuditRecord) int {
+		return cmp.Or(a.Timestamp.Compare(b.Timestamp), compareObjectIDs(a.ID, b.ID))
+	})
+	for _, record := range records {
+		record.Before = cloneRaw(record.Before)
+		record.After = cloneRaw(record.After)
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Empty(ctx context.Context) (bool, error) {
+	defer m.read(ctx)()
+	return len(m.data.accounts) == 0 && len(m.data.transactions) == 0 && len(m.data.journal) == 0 && len(m.data.auditRecords) == 0, nil
+}
+
+func (m *Memory) RestoreAccount(ctx context.Context, account model.Account) error {
+	defer m.write(ctx)()
+	if slices.ContainsFunc(m.data.accounts, func(acc model.Account) bool {
+		return acc.ID == account.ID || acc.DocumentNumber == account.DocumentNumber
+	}) {
+		return fmt.Errorf("account %s: %w", account.ID.Hex(), repository.ErrDuplicate)
+	}
+	m.data.accounts = append(m.data.accounts, account)
+	return nil
+}
+
+func (m *Memory) RestoreTransaction(ctx context.Context, transaction model.Transaction) error {
+	defer m.write(ctx)()
+	if slices.ContainsFunc(m.data.transactions, func(tx model.Transaction) bool {
+		return tx.ID == transaction.ID || tx.IdempotencyKey == transaction.IdempotencyKey
+	}) {
+		return fmt.Errorf("transaction %s: %w", transaction.ID.Hex(), repository.ErrDuplicate)
+	}
+	m.data.transactions = append(m.data.transactions, transaction)
+	return nil
+}
@@ -0,0 +1,374 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/repository/repositorytest"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var (
+	_ repository.DatabaseRepository = (*Memory)(nil)
+	_ repository.WebhookRepository  = (*Memory)(nil)
+	_ repository.AuditRepository    = (*Memory)(nil)
+	_ repository.LeaseRepository    = (*Memory)(nil)
+	_ repository.JobRepository      = (*Memory)(nil)
+)
+
+func TestMemory_Accounts(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	created, err := store.CreateAccount(ctx, "12345678900")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	tests := []struct {
+		name     string
+		lookup   func() (*model.Account, error)
+		validate func(t *testing.T, acc *model.Account, err error)
+	}{
+		{
+			name:   "By ID",
+			lookup: func() (*model.Account, error) { return store.GetAccountByID(ctx, created.ID.Hex()) },
+			validate: func(t *testing.T, acc *model.Account, err error) {
+				if err != nil || acc.DocumentNumber != "12345678900" {
+					t.Fatalf("expected the account, got %+v, %v", acc, err)
+				}
+			},
+		},
+		{
+			name:   "Unknown ID",
+			lookup: func() (*model.Account, error) { return store.GetAccountByID(ctx, bson.NewObjectID().Hex()) },
+			validate: func(t *testing.T, acc *model.Account, err error) {
+				if !errors.Is(err, repository.ErrNotFound) {
+					t.Fatalf("expected ErrNotFound, got %v", err)
+				}
+			},
+		},
+		{
+			name:   "Malformed ID",
+			lookup: func() (*model.Account, error) { return store.GetAccountByID(ctx, "not-an-id") },
+			validate: func(t *testing.T, acc *model.Account, err error) {
+				if err == nil || errors.Is(err, repository.ErrNotFound) {
+					t.Fatalf("expected a format error, got %v", err)
+				}
+			},
+		},
+		{
+			name:   "By document number",
+			lookup: func() (*model.Account, error) { return store.GetAccountByDocumentNumber(ctx, "12345678900") },
+			validate: func(t *testing.T, acc *model.Account, err error) {
+				if err != nil || acc == nil || acc.ID != created.ID {
+					t.Fatalf("expected the account, got %+v, %v", acc, err)
+				}
+			},
+		},
+		{
+			name:   "Unknown document number",
+			lookup: func() (*model.Account, error) { return store.GetAccountByDocumentNumber(ctx, "0") },
+			validate: func(t *testing.T, acc *model.Account, err error) {
+				if err != nil || acc != nil {
+					t.Fatalf("expected nil without an error, got %+v, %v", acc, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc, err := tt.lookup()
+			tt.validate(t, acc, err)
+		})
+	}
+}
+
+func TestMemory_Transactions(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	for i, day := range []int{3, 1, 2} {
+		if _, err := store.CreateTransaction(ctx, model.Transaction{
+			AccountID:      "acc",
+			OperationID:    model.OperationTypePurchase,
+			Amount:         -10,
+			Balance:        -10,
+			EventDate:      time.Date(2025, 1, day, 0, 0, 0, 0, time.UTC),
+			IdempotencyKey: fmt.Sprintf("key-%d", i),
+		}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if _, err := store.CreateTransaction(ctx, model.Transaction{AccountID: "other", EventDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	transactions, err := store.FindAllTransactionsForAccountID(ctx, "acc")
+	if err != nil || len(transactions) != 3 {
+		t.Fatalf("expected three transactions, got %d, %v", len(transactions), err)
+	}
+	for i, want := range []int{1, 2, 3} {
+		if transactions[i].EventDate.Day() != want {
+			t.Errorf("expected transaction %d on day %d, got %s", i, want, transactions[i].EventDate)
+		}
+	}
+
+	var streamed []time.Time
+	filter := model.TransactionFilter{From: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)}
+	if err := store.StreamTransactions(ctx, filter, func(tx model.Transaction) error {
+		streamed = append(streamed, tx.EventDate)
+		return nil
+	}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(streamed) != 2 || !streamed[0].Equal(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the last two transactions, got %v", streamed)
+	}
+
+	found, err := store.FindTransactionByIdempotencyKey(ctx, "key-1")
+	if err != nil || found == nil || !found.EventDate.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the transaction for key-1, got %+v, %v", found, err)
+	}
+	found.Balance = 0
+	if err := store.UpdateTransactionByID(ctx, found.ID.Hex(), *found, found.Version); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	updated, err := store.GetTransactionByID(ctx, found.ID.Hex())
+	if err != nil || updated.Balance != 0 || updated.IdempotencyKey != "key-1" {
+		t.Errorf("expected the balance to be updated, got %+v, %v", updated, err)
+	}
+	if err := store.UpdateTransactionByID(ctx, bson.NewObjectID().Hex(), *found, found.Version); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if missing, err := store.GetTransactionByID(ctx, "not-an-id"); missing != nil || err != nil {
+		t.Errorf("expected nil without an error, got %+v, %v", missing, err)
+	}
+}
+
+func TestMemory_WithTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	failed := errors.New("failed")
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := store.CreateAccount(ctx, "rolled-back"); err != nil {
+			return err
+		}
+		// a nested transaction joins the outer one instead of waiting on the lock it holds
+		return store.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := store.AppendOutboxEvent(ctx, model.OutboxEvent{Type: model.EventTypeAccountCreated}); err != nil {
+				return err
+			}
+			return failed
+		})
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the error from the transaction, got %v", err)
+	}
+	if acc, _ := store.GetAccountByDocumentNumber(ctx, "rolled-back"); acc != nil {
+		t.Errorf("expected the account to be rolled back, got %+v", acc)
+	}
+	if events, _ := store.FindUnpublishedOutboxEvents(ctx, 10); len(events) != 0 {
+		t.Errorf("expected the event to be rolled back, got %v", events)
+	}
+
+	if err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := store.CreateAccount(ctx, "committed")
+		return err
+	}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if acc, _ := store.GetAccountByDocumentNumber(ctx, "committed"); acc == nil {
+		t.Errorf("expected the account to be committed")
+	}
+}
+
+func TestMemory_Concurrency(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = store.WithTransaction(ctx, func(ctx context.Context) error {
+				_, err := store.CreateTransaction(ctx, model.Transaction{AccountID: "acc", EventDate: time.Date(2025, 1, 1, 0, 0, i, 0, time.UTC), IdempotencyKey: fmt.Sprintf("key-%d", i)})
+				return err
+			})
+			_, _ = store.FindAllTransactionsForAccountID(ctx, "acc")
+		}()
+	}
+	wg.Wait()
+
+	transactions, _ := store.FindAllTransactionsForAccountID(ctx, "acc")
+	if len(transactions) != 20 {
+		t.Errorf("expected 20 transactions, got %d", len(transactions))
+	}
+}
+
+func TestMemory_Duplicates(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	if _, err := store.CreateAccount(ctx, "12345678900"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := store.CreateAccount(ctx, "12345678900"); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate for the document number, got %v", err)
+	}
+
+	tx := model.Transaction{AccountID: "acc", EventDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), IdempotencyKey: "key"}
+	if _, err := store.CreateTransaction(ctx, tx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := store.CreateTransaction(ctx, tx); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate for the idempotency key, got %v", err)
+	}
+}
+
+func TestMemory_Copies(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	balance := model.AccountBalance{AccountID: "acc", Version: 1, OpenDebts: []model.OpenDebt{{TransactionID: "tx", BalanceCents: -100}}}
+	if err := store.SaveAccountBalance(ctx, balance); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	balance.OpenDebts[0].BalanceCents = 0
+
+	stored, err := store.GetAccountBalance(ctx, "acc")
+	if err != nil || stored.OpenDebts[0].BalanceCents != -100 {
+		t.Fatalf("expected the stored balance to be unchanged, got %+v, %v", stored, err)
+	}
+	stored.OpenDebts[0].BalanceCents = 0
+	if again, _ := store.GetAccountBalance(ctx, "acc"); again.OpenDebts[0].BalanceCents != -100 {
+		t.Errorf("expected the stored balance to be unchanged, got %+v", again)
+	}
+}
+
+func TestMemory_SaveAccountBalance(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	tests := []struct {
+		name    string
+		version int64
+		wantErr bool
+	}{
+		{name: "Update before the first save", version: 2, wantErr: true},
+		{name: "First save", version: 1},
+		{name: "Insert twice", version: 1, wantErr: true},
+		{name: "Next version", version: 2},
+		{name: "Skipped version", version: 4, wantErr: true},
+		{name: "Stale version", version: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.SaveAccountBalance(ctx, model.AccountBalance{AccountID: "acc", Version: tt.version})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMemory_WebhookDeliveries(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, delivery := range []model.WebhookDelivery{
+		{SubscriptionID: "sub", EventID: "late", Status: model.WebhookDeliveryPending, NextAttemptAt: now.Add(time.Minute)},
+		{SubscriptionID: "sub", EventID: "second", Status: model.WebhookDeliveryPending, NextAttemptAt: now},
+		{SubscriptionID: "sub", EventID: "first", Status: model.WebhookDeliveryPending, NextAttemptAt: now.Add(-time.Minute)},
+		{SubscriptionID: "sub", EventID: "first", Status: model.WebhookDeliveryPending, NextAttemptAt: now.Add(-time.Hour)},
+	} {
+		if err := store.CreateWebhookDelivery(ctx, delivery); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	deliveries, _ := store.ListWebhookDeliveries(ctx, model.WebhookDeliveryFilter{SubscriptionID: "sub"}, 10)
+	if len(deliveries) != 3 {
+		t.Fatalf("expected a delivery per event, got %d", len(deliveries))
+	}
+
+	leaseUntil := now.Add(30 * time.Second)
+	claimed, err := store.ClaimDueWebhookDeliveries(ctx, now, leaseUntil, 10)
+	if err != nil || len(claimed) != 2 || claimed[0].EventID != "first" || claimed[1].EventID != "second" {
+		t.Fatalf("expected the two due deliveries oldest first, got %+v, %v", claimed, err)
+	}
+	if !claimed[0].NextAttemptAt.Equal(leaseUntil) {
+		t.Errorf("expected the delivery to be leased until %v, got %v", leaseUntil, claimed[0].NextAttemptAt)
+	}
+	if again, _ := store.ClaimDueWebhookDeliveries(ctx, now, leaseUntil, 10); len(again) != 0 {
+		t.Errorf("expected leased deliveries not to be claimed again, got %+v", again)
+	}
+
+	// an attempt is only recorded under the lease it was claimed with
+	attempted := claimed[0]
+	attempted.Attempts++
+	if err := store.UpdateWebhookDelivery(ctx, attempted.ID.Hex(), attempted, now); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected %v under another lease, got %v", repository.ErrConflict, err)
+	}
+	if err := store.UpdateWebhookDelivery(ctx, attempted.ID.Hex(), attempted, leaseUntil); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestMemory_TrialBalance(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	for _, entry := range []model.JournalEntry{
+		{AccountID: "acc", PostedAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), Lines: []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 500},
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 500},
+		}},
+		{AccountID: "acc", PostedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Lines: []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 1000},
+			{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 1000},
+		}},
+	} {
+		if err := store.PostJournalEntry(ctx, entry); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	lines, err := store.TrialBalance(ctx)
+	if err != nil || len(lines) != 3 {
+		t.Fatalf("expected three ledger accounts, got %+v, %v", lines, err)
+	}
+	want := model.TrialBalanceLine{LedgerAccount: model.LedgerAccountReceivable, DebitCents: 1000, CreditCents: 500}
+	if lines[1] != want {
+		t.Errorf("expected %+v, got %+v", want, lines[1])
+	}
+
+	entries, _ := store.FindJournalEntries(ctx, "acc", time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	if len(entries) != 1 || entries[0].Lines[0].AmountCents != 1000 {
+		t.Errorf("expected only the first entry, got %+v", entries)
+	}
+}
+
+func TestMemory_Contract(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.DatabaseRepository {
+		return NewMemory()
+	})
+	repositorytest.RunLeases(t, func(t *testing.T) repository.LeaseRepository {
+		return NewMemory()
+	})
+	repositorytest.RunJobs(t, func(t *testing.T) repository.JobRepository {
+		return NewMemory()
+	})
+	repositorytest.RunBackups(t, func(t *testing.T) repositorytest.BackupStore {
+		return NewMemory()
+	})
+}
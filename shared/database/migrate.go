@@ -0,0 +1,357 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/ledger"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoMigration is a versioned change to the documents of a database. A migration is only recorded once up has
+// finished, so up and down have to be safe to run again after failing part way
+type mongoMigration struct {
+	version     int
+	description string
+	up          func(ctx context.Context, db *mongo.Database, collections Collections) error
+	down        func(ctx context.Context, db *mongo.Database, collections Collections) error
+}
+
+// mongoMigrations are applied in order, a new one gets the next version and is never changed once released
+var mongoMigrations = []mongoMigration{
+	{
+		version:     1,
+		description: "store transaction event dates as BSON dates instead of RFC3339Nano strings",
+		up: func(ctx context.Context, db *mongo.Database, collections Collections) error {
+			return rewriteField(ctx, db.Collection(collections.Transactions), "event_date", "string", func(value bson.RawValue) (any, error) {
+				eventDate, err := time.Parse(time.RFC3339Nano, value.StringValue())
+				if err != nil {
+					return nil, err
+				}
+				return bson.NewDateTimeFromTime(eventDate), nil
+			})
+		},
+		down: func(ctx context.Context, db *mongo.Database, collections Collections) error {
+			return rewriteField(ctx, db.Collection(collections.Transactions), "event_date", "date", func(value bson.RawValue) (any, error) {
+				return value.Time().UTC().Format(time.RFC3339Nano), nil
+			})
+		},
+	},
+	{
+		version:     2,
+		description: "give accounts and transactions the version optimistic concurrency control checks",
+		up: func(ctx context.Context, db *mongo.Database, collections Collections) error {
+			for _, name := range []string{collections.Accounts, collections.Transactions} {
+				_, err := db.Collection(name).UpdateMany(ctx, bson.M{"version": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"version": int64(1)}}, options.UpdateMany().SetBypassDocumentValidation(true))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		down: func(ctx context.Context, db *mongo.Database, collections Collections) error {
+			for _, name := range []string{collections.Accounts, collections.Transactions} {
+				_, err := db.Collection(name).UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"version": ""}},
+					options.UpdateMany().SetBypassDocumentValidation(true))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     3,
+		description: "give accounts and transactions the public IDs clients know them by",
+		up: func(ctx context.Context, db *mongo.Database, collections Collections) error {
+			if err := backfillPublicIDs(ctx, db.Collection(collections.Accounts), publicid.AccountPrefix); err != nil {
+				return err
+			}
+			return backfillPublicIDs(ctx, db.Collection(collections.Transactions), publicid.TransactionPrefix)
+		},
+		down: func(ctx context.Context, db *mongo.Database, collections Collections) error {
+			for _, name := range []string{collections.Accounts, collections.Transactions} {
+				_, err := db.Collection(name).UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"public_id": ""}},
+					options.UpdateMany().SetBypassDocumentValidation(true))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     4,
+		description: "post an opening journal entry for every transaction made before the journal",
+		up: func(ctx context.Context, db *mongo.Database, collections Collections) error {
+			return backfillOpeningEntries(ctx, db.Collection(collections.Transactions), db.Collection(collections.Journal))
+		},
+		// the journal is append only so the opening entries stay, applying the migration again finds nothing to post
+		down: func(ctx context.Context, db *mongo.Database, collections Collections) error {
+			return nil
+		},
+	},
+}
+
+// Migration is a migration of a MongoDB database, AppliedAt is nil while it is pending
+type Migration struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+// migrationRecord is the document a migration leaves in the schema_migrations collection once it is applied
+type migrationRecord struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrations lists every migration this release knows of, oldest first, with when it was applied to the database ctx
+// routes to
+func (m *MongoDB) Migrations(ctx context.Context) ([]Migration, error) {
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]Migration, 0, len(mongoMigrations))
+	for _, migration := range mongoMigrations {
+		status := Migration{Version: migration.version, Description: migration.description}
+		if record, ok := applied[migration.version]; ok {
+			status.AppliedAt = &record.AppliedAt
+		}
+		migrations = append(migrations, status)
+	}
+	return migrations, nil
+}
+
+// MigrateUp applies the pending migrations to the database ctx routes to, oldest first, and returns the ones it applied.
+// It refuses to touch a database a newer release has migrated further than this one knows about
+func (m *MongoDB) MigrateUp(ctx context.Context) ([]Migration, error) {
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for version := range applied {
+		if !slices.ContainsFunc(mongoMigrations, func(migration mongoMigration) bool { return migration.version == version }) {
+			return nil, fmt.Errorf("the database has migration %d applied which this release does not know, it was migrated by a newer one", version)
+		}
+	}
+	db := m.database(ctx)
+	var done []Migration
+	for _, migration := range mongoMigrations {
+		if _, ok := applied[migration.version]; ok {
+			continue
+		}
+		if err := migration.up(ctx, db, m.config.Collections); err != nil {
+			return done, fmt.Errorf("failed to apply migration %d: %w", migration.version, mongoErr(err))
+		}
+		record := migrationRecord{Version: migration.version, Description: migration.description, AppliedAt: time.Now().UTC()}
+		_, err := m.collection(ctx, m.config.Collections.SchemaMigrations).InsertOne(ctx, record)
+		// another instance starting at the same time applied it as well, which is harmless since migrations can run twice
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return done, fmt.Errorf("failed to record migration %d: %w", migration.version, mongoErr(err))
+		}
+		done = append(done, Migration{Version: record.Version, Description: record.Description, AppliedAt: &record.AppliedAt})
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the last steps migrations applied to the database ctx routes to, newest first, and returns the
+// ones it reverted
+func (m *MongoDB) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db := m.database(ctx)
+	var done []Migration
+	for _, migration := range slices.Backward(mongoMigrations) {
+		if len(done) == steps {
+			break
+		}
+		if _, ok := applied[migration.version]; !ok {
+			continue
+		}
+		if err := migration.down(ctx, db, m.config.Collections); err != nil {
+			return done, fmt.Errorf("failed to revert migration %d: %w", migration.version, mongoErr(err))
+		}
+		if _, err := m.collection(ctx, m.config.Collections.SchemaMigrations).DeleteOne(ctx, bson.M{"_id": migration.version}); err != nil {
+			return done, fmt.Errorf("failed to record the revert of migration %d: %w", migration.version, mongoErr(err))
+		}
+		done = append(done, Migration{Version: migration.version, Description: migration.description})
+	}
+	return done, nil
+}
+
+func (m *MongoDB) appliedMigrations(ctx context.Context) (map[int]migrationRecord, error) {
+	cursor, err := m.collection(ctx, m.config.Collections.SchemaMigrations).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find applied migrations: %w", mongoErr(err))
+	}
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode applied migrations: %w", mongoErr(err))
+	}
+	applied := make(map[int]migrationRecord, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// rewriteField replaces field with what convert makes of it in every document of coll where it has the BSON type
+// fromType. The writes skip the collection's validator, the documents are between two schemas while this runs
+func rewriteField(ctx context.Context, coll *mongo.Collection, field string, fromType string, convert func(bson.RawValue) (any, error)) error {
+	opts := options.Find().SetProjection(bson.M{field: 1}).SetBatchSize(streamBatchSize)
+	cursor, err := coll.Find(ctx, bson.M{field: bson.M{"$type": fromType}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var writes []mongo.WriteModel
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		_, err := coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false).SetBypassDocumentValidation(true))
+		writes = writes[:0]
+		return err
+	}
+	for cursor.Next(ctx) {
+		id, value := cursor.Current.Lookup("_id"), cursor.Current.Lookup(field)
+		converted, err := convert(value)
+		if err != nil {
+			return fmt.Errorf("document %s has an invalid %s: %w", id, field, err)
+		}
+		// the old value is part of the filter so a document written in the meantime is left alone
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: id}, {Key: field, Value: value}}).
+			SetUpdate(bson.M{"$set": bson.M{field: converted}}))
+		if len(writes) == streamBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// backfillPublicIDs gives every document of coll without a public ID one with the prefix, made at the time its _id was
+// so the IDs still sort in the order the documents were created
+func backfillPublicIDs(ctx context.Context, coll *mongo.Collection, prefix string) error {
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetBatchSize(streamBatchSize)
+	cursor, err := coll.Find(ctx, bson.M{"public_id": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var writes []mongo.WriteModel
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		_, err := coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false).SetBypassDocumentValidation(true))
+		writes = writes[:0]
+		return err
+	}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID bson.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc.ID, "public_id": bson.M{"$exists": false}}).
+			SetUpdate(bson.M{"$set": bson.M{"public_id": publicid.New(prefix, doc.ID.Timestamp())}}))
+		if len(writes) == streamBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// backfillOpeningEntries posts an opening entry for every transaction the journal has no entry for, so running it again
+// posts nothing more. The transactions are read a batch at a time and the batch's entries are looked up together
+func backfillOpeningEntries(ctx context.Context, transactions *mongo.Collection, journal *mongo.Collection) error {
+	cursor, err := transactions.Find(ctx, bson.M{}, options.Find().SetBatchSize(streamBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var batch []model.Transaction
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ids := make([]string, len(batch))
+		for i, tx := range batch {
+			ids[i] = tx.ID.Hex()
+		}
+		// the entries of the batch's transactions along with those of later payments allocated to them
+		found, err := journal.Find(ctx, bson.M{"$or": bson.A{
+			bson.M{"transaction_id": bson.M{"$in": ids}},
+			bson.M{"lines.applies_to": bson.M{"$in": ids}},
+		}})
+		if err != nil {
+			return err
+		}
+		var posted []model.JournalEntry
+		if err := found.All(ctx, &posted); err != nil {
+			return err
+		}
+		journalled := ledger.TransactionBalances(posted)
+		var entries []any
+		for _, tx := range batch {
+			if slices.ContainsFunc(posted, func(entry model.JournalEntry) bool { return entry.TransactionID == tx.ID.Hex() }) {
+				continue
+			}
+			entry, err := ledger.NewOpeningEntry(tx.ID.Hex(), tx, journalled[tx.ID.Hex()])
+			if err != nil {
+				return fmt.Errorf("transaction %s: %w", tx.ID.Hex(), err)
+			}
+			entries = append(entries, entry)
+		}
+		batch = batch[:0]
+		if len(entries) == 0 {
+			return nil
+		}
+		_, err = journal.InsertMany(ctx, entries)
+		return err
+	}
+	for cursor.Next(ctx) {
+		var tx model.Transaction
+		if err := cursor.Decode(&tx); err != nil {
+			return err
+		}
+		batch = append(batch, tx)
+		if len(batch) == streamBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
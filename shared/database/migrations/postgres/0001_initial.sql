@@ -0,0 +1,95 @@
+-- IDs are ObjectID hex strings so the API reads the same whichever backend is behind it
+
+CREATE TABLE accounts (
+    id              TEXT PRIMARY KEY,
+    document_number TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE transactions (
+    id                TEXT PRIMARY KEY,
+    account_id        TEXT             NOT NULL,
+    operation_type_id INTEGER          NOT NULL,
+    amount            DOUBLE PRECISION NOT NULL,
+    event_date        TEXT             NOT NULL, -- RFC3339Nano in UTC, compared as a string like MongoDB does
+    balance           DOUBLE PRECISION NOT NULL,
+    idempotency_key   TEXT             NOT NULL UNIQUE
+);
+CREATE INDEX transactions_account_id_event_date ON transactions (account_id, event_date, id);
+CREATE INDEX transactions_event_date ON transactions (event_date, id);
+
+CREATE TABLE outbox_events (
+    id           TEXT PRIMARY KEY,
+    type         TEXT        NOT NULL,
+    aggregate_id TEXT        NOT NULL,
+    account_id   TEXT        NOT NULL,
+    payload      JSON        NOT NULL,
+    occurred_at  TIMESTAMPTZ NOT NULL,
+    published_at TIMESTAMPTZ
+);
+CREATE INDEX outbox_events_unpublished ON outbox_events (occurred_at, id) WHERE published_at IS NULL;
+
+CREATE TABLE journal_entries (
+    id             TEXT PRIMARY KEY,
+    transaction_id TEXT        NOT NULL,
+    account_id     TEXT        NOT NULL,
+    posted_at      TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX journal_entries_account_id_posted_at ON journal_entries (account_id, posted_at, id);
+
+CREATE TABLE journal_lines (
+    entry_id       TEXT    NOT NULL REFERENCES journal_entries (id),
+    line           INTEGER NOT NULL,
+    ledger_account TEXT    NOT NULL,
+    side           TEXT    NOT NULL,
+    amount_cents   BIGINT  NOT NULL,
+    applies_to     TEXT    NOT NULL DEFAULT '',
+    PRIMARY KEY (entry_id, line)
+);
+
+CREATE TABLE account_balances (
+    account_id             TEXT PRIMARY KEY,
+    total_debt_cents       BIGINT      NOT NULL,
+    available_credit_cents BIGINT      NOT NULL,
+    last_transaction_at    TIMESTAMPTZ,
+    version                BIGINT      NOT NULL,
+    open_debts             JSON        NOT NULL
+);
+
+CREATE TABLE webhook_subscriptions (
+    id          TEXT PRIMARY KEY,
+    url         TEXT        NOT NULL,
+    event_types JSON        NOT NULL,
+    secret      TEXT        NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+    id               TEXT PRIMARY KEY,
+    subscription_id  TEXT        NOT NULL,
+    event_id         TEXT        NOT NULL,
+    event_type       TEXT        NOT NULL,
+    body             JSON        NOT NULL,
+    status           TEXT        NOT NULL,
+    attempts         INTEGER     NOT NULL,
+    next_attempt_at  TIMESTAMPTZ NOT NULL,
+    last_error       TEXT        NOT NULL DEFAULT '',
+    last_status_code INTEGER     NOT NULL DEFAULT 0,
+    created_at       TIMESTAMPTZ NOT NULL,
+    delivered_at     TIMESTAMPTZ,
+    UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at, id);
+
+CREATE TABLE audit_records (
+    id          TEXT PRIMARY KEY,
+    actor       TEXT        NOT NULL,
+    request_id  TEXT        NOT NULL DEFAULT '',
+    timestamp   TIMESTAMPTZ NOT NULL,
+    action      TEXT        NOT NULL,
+    entity_type TEXT        NOT NULL,
+    entity_id   TEXT        NOT NULL,
+    before      JSON,
+    after       JSON
+);
+CREATE INDEX audit_records_entity ON audit_records (entity_type, entity_id, timestamp, id);
@@ -0,0 +1,3 @@
+-- event_date was an RFC3339Nano string compared as text, which sorts a time with a fraction of a second before the same
+-- time without one. The indexes on it are rebuilt by the ALTER
+ALTER TABLE transactions ALTER COLUMN event_date TYPE TIMESTAMPTZ USING event_date::TIMESTAMPTZ;
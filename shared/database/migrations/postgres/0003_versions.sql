@@ -0,0 +1,4 @@
+-- accounts and transactions carry a version bumped on every write so an update made from a stale read can be refused,
+-- the rows already stored start at the version a new row is created with
+ALTER TABLE accounts ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE transactions ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
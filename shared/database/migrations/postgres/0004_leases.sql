@@ -0,0 +1,6 @@
+-- leases let instances take turns at work that must not run concurrently, like the transactions of one account
+CREATE TABLE leases (
+    name       TEXT PRIMARY KEY,
+    owner      TEXT        NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,6 @@
+-- accounts and transactions get the public IDs clients know them by, the rows already stored are given theirs by
+-- Migrate right after this script since SQL has no way to make a ULID
+ALTER TABLE accounts ADD COLUMN public_id TEXT;
+ALTER TABLE transactions ADD COLUMN public_id TEXT;
+CREATE UNIQUE INDEX accounts_public_id ON accounts (public_id);
+CREATE UNIQUE INDEX transactions_public_id ON transactions (public_id);
@@ -0,0 +1,19 @@
+-- background jobs are kept in the store so every instance can report on them and they survive a restart
+CREATE TABLE jobs (
+    id              TEXT PRIMARY KEY,
+    tenant          TEXT        NOT NULL,
+    type            TEXT        NOT NULL,
+    status          TEXT        NOT NULL,
+    row_count       INTEGER     NOT NULL,
+    created         INTEGER     NOT NULL,
+    duplicates      INTEGER     NOT NULL,
+    invalid         INTEGER     NOT NULL,
+    failed          INTEGER     NOT NULL,
+    ignored_columns JSON        NOT NULL,
+    error           TEXT        NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL,
+    started_at      TIMESTAMPTZ,
+    finished_at     TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX jobs_updated_at ON jobs (updated_at);
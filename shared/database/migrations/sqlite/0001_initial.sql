@@ -0,0 +1,96 @@
+-- IDs are ObjectID hex strings so the API reads the same whichever backend is behind it. TIMESTAMP columns hold Unix
+-- nanoseconds, see OpenSQLite
+
+CREATE TABLE accounts (
+    id              TEXT PRIMARY KEY,
+    document_number TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE transactions (
+    id                TEXT PRIMARY KEY,
+    account_id        TEXT    NOT NULL,
+    operation_type_id INTEGER NOT NULL,
+    amount            REAL    NOT NULL,
+    event_date        TEXT    NOT NULL, -- RFC3339Nano in UTC, compared as a string like MongoDB does
+    balance           REAL    NOT NULL,
+    idempotency_key   TEXT    NOT NULL UNIQUE
+);
+CREATE INDEX transactions_account_id_event_date ON transactions (account_id, event_date, id);
+CREATE INDEX transactions_event_date ON transactions (event_date, id);
+
+CREATE TABLE outbox_events (
+    id           TEXT PRIMARY KEY,
+    type         TEXT      NOT NULL,
+    aggregate_id TEXT      NOT NULL,
+    account_id   TEXT      NOT NULL,
+    payload      TEXT      NOT NULL,
+    occurred_at  TIMESTAMP NOT NULL,
+    published_at TIMESTAMP
+);
+CREATE INDEX outbox_events_unpublished ON outbox_events (occurred_at, id) WHERE published_at IS NULL;
+
+CREATE TABLE journal_entries (
+    id             TEXT PRIMARY KEY,
+    transaction_id TEXT      NOT NULL,
+    account_id     TEXT      NOT NULL,
+    posted_at      TIMESTAMP NOT NULL
+);
+CREATE INDEX journal_entries_account_id_posted_at ON journal_entries (account_id, posted_at, id);
+
+CREATE TABLE journal_lines (
+    entry_id       TEXT    NOT NULL REFERENCES journal_entries (id),
+    line           INTEGER NOT NULL,
+    ledger_account TEXT    NOT NULL,
+    side           TEXT    NOT NULL,
+    amount_cents   INTEGER NOT NULL,
+    applies_to     TEXT    NOT NULL DEFAULT '',
+    PRIMARY KEY (entry_id, line)
+);
+
+CREATE TABLE account_balances (
+    account_id             TEXT PRIMARY KEY,
+    total_debt_cents       INTEGER NOT NULL,
+    available_credit_cents INTEGER NOT NULL,
+    last_transaction_at    TIMESTAMP,
+    version                INTEGER NOT NULL,
+    open_debts             TEXT    NOT NULL
+);
+
+CREATE TABLE webhook_subscriptions (
+    id          TEXT PRIMARY KEY,
+    url         TEXT      NOT NULL,
+    event_types TEXT      NOT NULL,
+    secret      TEXT      NOT NULL,
+    created_at  TIMESTAMP NOT NULL,
+    updated_at  TIMESTAMP NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+    id               TEXT PRIMARY KEY,
+    subscription_id  TEXT      NOT NULL,
+    event_id         TEXT      NOT NULL,
+    event_type       TEXT      NOT NULL,
+    body             TEXT      NOT NULL,
+    status           TEXT      NOT NULL,
+    attempts         INTEGER   NOT NULL,
+    next_attempt_at  TIMESTAMP NOT NULL,
+    last_error       TEXT      NOT NULL DEFAULT '',
+    last_status_code INTEGER   NOT NULL DEFAULT 0,
+    created_at       TIMESTAMP NOT NULL,
+    delivered_at     TIMESTAMP,
+    UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at, id);
+
+CREATE TABLE audit_records (
+    id          TEXT PRIMARY KEY,
+    actor       TEXT      NOT NULL,
+    request_id  TEXT      NOT NULL DEFAULT '',
+    timestamp   TIMESTAMP NOT NULL,
+    action      TEXT      NOT NULL,
+    entity_type TEXT      NOT NULL,
+    entity_id   TEXT      NOT NULL,
+    before      TEXT,
+    after       TEXT
+);
+CREATE INDEX audit_records_entity ON audit_records (entity_type, entity_id, timestamp, id);
@@ -0,0 +1,26 @@
+-- event_date was an RFC3339Nano string compared as text, which sorts a time with a fraction of a second before the same
+-- time without one. SQLite cannot change the type of a column so the table is rebuilt, the dates are converted to Unix
+-- nanoseconds keeping the milliseconds, which is all MongoDB keeps of them as well
+
+CREATE TABLE transactions_new (
+    id                TEXT PRIMARY KEY,
+    account_id        TEXT      NOT NULL,
+    operation_type_id INTEGER   NOT NULL,
+    amount            REAL      NOT NULL,
+    event_date        TIMESTAMP NOT NULL,
+    balance           REAL      NOT NULL,
+    idempotency_key   TEXT      NOT NULL UNIQUE
+);
+
+INSERT INTO transactions_new (id, account_id, operation_type_id, amount, event_date, balance, idempotency_key)
+SELECT id, account_id, operation_type_id, amount,
+       CAST(strftime('%s', event_date) AS INTEGER) * 1000000000
+           + CAST(substr(strftime('%f', event_date), 4) AS INTEGER) * 1000000,
+       balance, idempotency_key
+FROM transactions;
+
+DROP TABLE transactions;
+ALTER TABLE transactions_new RENAME TO transactions;
+
+CREATE INDEX transactions_account_id_event_date ON transactions (account_id, event_date, id);
+CREATE INDEX transactions_event_date ON transactions (event_date, id);
@@ -0,0 +1,5 @@
+-- transactions made before the journal get an opening entry, posted by Migrate right after this script since it is
+-- worked out from each transaction's amount and balance. The indexes find the transactions that already have one and
+-- what payments posted since have allocated to those that do not
+CREATE INDEX journal_entries_transaction_id ON journal_entries (transaction_id);
+CREATE INDEX journal_lines_applies_to ON journal_lines (applies_to);
@@ -4,104 +4,395 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
+// streamBatchSize is the number of documents the cursor pulls from the server per round trip when streaming
+const streamBatchSize = 500
+
+// Collections names the collection each kind of document is kept in
+type Collections struct {
+	Accounts             string
+	Transactions         string
+	Outbox               string
+	Journal              string
+	AccountBalances      string
+	WebhookSubscriptions string
+	WebhookDeliveries    string
+	AuditLog             string
+	SchemaMigrations     string
+	Leases               string
+	Jobs                 string
+}
+
+func DefaultCollections() Collections {
+	return Collections{
+		Accounts:             "accounts",
+		Transactions:         "transactions",
+		Outbox:               "outbox",
+		Journal:              "journal",
+		AccountBalances:      "account_balances",
+		WebhookSubscriptions: "webhook_subscriptions",
+		WebhookDeliveries:    "webhook_deliveries",
+		AuditLog:             "audit_log",
+		SchemaMigrations:     "schema_migrations",
+		Leases:               "leases",
+		Jobs:                 "jobs",
+	}
+}
+
+// WithPrefix returns the names with prefix in front of each of them, for deployments sharing a database
+func (c Collections) WithPrefix(prefix string) Collections {
+	for _, name := range c.names() {
+		*name = prefix + *name
+	}
+	return c
+}
+
+func (c *Collections) names() []*string {
+	return []*string{&c.Accounts, &c.Transactions, &c.Outbox, &c.Journal, &c.AccountBalances, &c.WebhookSubscriptions, &c.WebhookDeliveries, &c.AuditLog, &c.SchemaMigrations, &c.Leases, &c.Jobs}
+}
+
+// MongoConfig names where the store keeps its data, the zero value of any field falls back to its default
+type MongoConfig struct {
+	// Database holds the data of requests without a tenant, each tenant gets a database of its own named
+	// <Database>_<tenant>
+	Database    string
+	Collections Collections
+}
+
+func DefaultMongoConfig() MongoConfig {
+	return MongoConfig{
+		Database:    "pismo",
+		Collections: DefaultCollections(),
+	}
+}
+
+func (c MongoConfig) withDefaults() MongoConfig {
+	if c.Database == "" {
+		c.Database = DefaultMongoConfig().Database
+	}
+	defaults := DefaultCollections()
+	for i, name := range c.Collections.names() {
+		if *name == "" {
+			*name = *defaults.names()[i]
+		}
+	}
+	return c
+}
+
+// DatabaseName is the database the data of ctx is kept in, see tenant.WithID
+func (c MongoConfig) DatabaseName(ctx context.Context) string {
+	if id := tenant.FromContext(ctx); id != "" {
+		return c.Database + "_" + id
+	}
+	return c.Database
+}
+
 type MongoDB struct {
 	client *mongo.Client
+	config MongoConfig
 }
 
-func NewMongoDB(client *mongo.Client) *MongoDB {
+func NewMongoDB(client *mongo.Client, config MongoConfig) *MongoDB {
 	return &MongoDB{
 		client: client,
+		config: config.withDefaults(),
 	}
 }
 
+func (m *MongoDB) database(ctx context.Context) *mongo.Database {
+	return m.client.Database(m.config.DatabaseName(ctx))
+}
+
+func (m *MongoDB) collection(ctx context.Context, name string) *mongo.Collection {
+	return m.database(ctx).Collection(name)
+}
+
+// transientMongoCodes are the server errors a replica set returns while it elects a new primary or shuts a member down,
+// and WriteConflict (112) which is returned when two transactions write the same document
+var transientMongoCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	112,   // WriteConflict
+	189,   // PrimarySteppedDown
+	262,   // ExceededTimeLimit
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// mongoErr wraps repository.ErrUnavailable around the errors that may go away when the call is retried, anything
+// else is returned as is
+func mongoErr(err error) error {
+	if err == nil || errors.Is(err, repository.ErrUnavailable) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	transient := mongo.IsNetworkError(err) || mongo.IsTimeout(err)
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) && (labeled.HasErrorLabel("TransientTransactionError") || labeled.HasErrorLabel("RetryableWriteError")) {
+		transient = true
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && slices.ContainsFunc(transientMongoCodes, serverErr.HasErrorCode) {
+		transient = true
+	}
+	if !transient {
+		return err
+	}
+	return fmt.Errorf("%w: %w", repository.ErrUnavailable, err)
+}
+
 func (m *MongoDB) CreateAccount(ctx context.Context, documentID string) (*model.Account, error) {
-	result, err := m.client.Database("pismo").Collection("accounts").InsertOne(ctx, model.Account{DocumentNumber: documentID})
+	acc := model.Account{PublicID: publicid.NewAccountID(), DocumentNumber: documentID, Version: 1}
+	result, err := m.collection(ctx, m.config.Collections.Accounts).InsertOne(ctx, acc)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("account with document number %s: %w", documentID, repository.ErrDuplicate)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create account: %w", err)
+		return nil, fmt.Errorf("failed to create account: %w", mongoErr(err))
 	}
-	return &model.Account{
-		ID:             result.InsertedID.(bson.ObjectID),
-		DocumentNumber: documentID,
-	}, nil
+	acc.ID = result.InsertedID.(bson.ObjectID)
+	return &acc, nil
+}
+
+// idFilter matches the document id is the public ID of, or the _id of when it is not a public ID with the prefix
+func idFilter(id string, prefix string) (bson.M, error) {
+	if publicid.Valid(id, prefix) {
+		return bson.M{"public_id": id}, nil
+	}
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"_id": objectID}, nil
 }
 
 func (m *MongoDB) GetAccountByID(ctx context.Context, accountID string) (*model.Account, error) {
-	objectID, err := bson.ObjectIDFromHex(accountID)
+	filter, err := idFilter(accountID, publicid.AccountPrefix)
 	if err != nil {
 		return nil, fmt.Errorf("invalid account ID format: %w", err)
 	}
 	var acc model.Account
-	err = m.client.Database("pismo").Collection("accounts").FindOne(ctx, bson.M{"_id": objectID}).Decode(&acc)
+	err = m.collection(ctx, m.config.Collections.Accounts).FindOne(ctx, filter).Decode(&acc)
 	if errors.Is(err, mongo.ErrNoDocuments) {
-		return nil, err
+		return nil, fmt.Errorf("account %s: %w", accountID, repository.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", mongoErr(err))
 	}
 	return &acc, nil
 }
 
 func (m *MongoDB) GetAccountByDocumentNumber(ctx context.Context, documentNumber string) (*model.Account, error) {
 	var acc model.Account
-	err := m.client.Database("pismo").Collection("accounts").
+	err := m.collection(ctx, m.config.Collections.Accounts).
 		FindOne(ctx, bson.M{"document_number": documentNumber}).Decode(&acc)
 	if errors.Is(err, mongo.ErrNoDocuments) {
 		return nil, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get account by document number: %w", err)
+		return nil, fmt.Errorf("failed to get account by document number: %w", mongoErr(err))
 	}
 	return &acc, nil
 }
 
 func (m *MongoDB) CreateTransaction(ctx context.Context, transaction model.Transaction) (*model.Transaction, error) {
-	result, err := m.client.Database("pismo").Collection("transactions").InsertOne(ctx, transaction)
+	if transaction.PublicID == "" {
+		transaction.PublicID = publicid.NewTransactionID()
+	}
+	transaction.Version = 1
+	result, err := m.collection(ctx, m.config.Collections.Transactions).InsertOne(ctx, transaction)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("transaction with idempotency key %s: %w", transaction.IdempotencyKey, repository.ErrDuplicate)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create transaction: %w", err)
+		return nil, fmt.Errorf("failed to create transaction: %w", mongoErr(err))
 	}
 	transaction.ID = result.InsertedID.(bson.ObjectID)
 	return &transaction, nil
 }
 
+func (m *MongoDB) GetTransactionByID(ctx context.Context, transactionID string) (*model.Transaction, error) {
+	filter, err := idFilter(transactionID, publicid.TransactionPrefix)
+	if err != nil {
+		return nil, nil
+	}
+	var tx model.Transaction
+	err = m.collection(ctx, m.config.Collections.Transactions).FindOne(ctx, filter).Decode(&tx)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %w", mongoErr(err))
+	}
+	return &tx, nil
+}
+
 func (m *MongoDB) FindTransactionByIdempotencyKey(ctx context.Context, idempotencyKey string) (*model.Transaction, error) {
 	var tx model.Transaction
-	err := m.client.Database("pismo").Collection("transactions").
+	err := m.collection(ctx, m.config.Collections.Transactions).
 		FindOne(ctx, bson.M{"idempotency_key": idempotencyKey}).Decode(&tx)
 	if errors.Is(err, mongo.ErrNoDocuments) {
 		return nil, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find transaction by idempotency key: %w", err)
+		return nil, fmt.Errorf("failed to find transaction by idempotency key: %w", mongoErr(err))
 	}
 	return &tx, nil
 }
 
 func (m *MongoDB) FindAllTransactionsForAccountID(ctx context.Context, accountID string) ([]model.Transaction, error) {
 	var transactions []model.Transaction
-	result, err := m.client.Database("pismo").Collection("transactions").Find(ctx, bson.M{"account_id": accountID})
+	// oldest first, payments discharge debts in this order
+	opts := options.Find().SetSort(bson.D{{Key: "event_date", Value: 1}, {Key: "_id", Value: 1}})
+	result, err := m.collection(ctx, m.config.Collections.Transactions).Find(ctx, bson.M{"account_id": accountID}, opts)
 	if err != nil {
-		return nil, err
+		return nil, mongoErr(err)
 	}
 	if err = result.All(ctx, &transactions); err != nil {
-		return nil, err
+		return nil, mongoErr(err)
 	}
 	return transactions, nil
 }
 
-func (m *MongoDB) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction) error {
+func (m *MongoDB) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction, expectedVersion int64) error {
 	id, err := bson.ObjectIDFromHex(transactionID)
 	if err != nil {
 		return err
 	}
-	result, err := m.client.Database("pismo").Collection("transactions").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": transaction})
+	transaction.Version = expectedVersion + 1
+	collection := m.collection(ctx, m.config.Collections.Transactions)
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id, "version": expectedVersion}, bson.M{"$set": transaction})
+	if err != nil {
+		return mongoErr(err)
+	}
+	if result.MatchedCount == 0 {
+		// either the transaction is gone or it was written since it was read
+		n, err := collection.CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return mongoErr(err)
+		}
+		if n == 0 {
+			return fmt.Errorf("transaction %s: %w", id.Hex(), repository.ErrNotFound)
+		}
+		return fmt.Errorf("transaction %s is not at version %d: %w", id.Hex(), expectedVersion, repository.ErrConflict)
+	}
+	return nil
+}
+
+// StreamTransactions iterates over the transactions matching the filter in event date order, calling fn for each one.
+// The cursor is read one batch at a time so memory stays flat regardless of how many transactions the account has.
+func (m *MongoDB) StreamTransactions(ctx context.Context, filter model.TransactionFilter, fn func(model.Transaction) error) error {
+	query := bson.M{}
+	if filter.AccountID != "" {
+		query["account_id"] = filter.AccountID
+	}
+	if filter.OperationID != 0 {
+		query["operation_type_id"] = filter.OperationID
+	}
+	eventDate := bson.M{}
+	if !filter.From.IsZero() {
+		eventDate["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		eventDate["$lte"] = filter.To
+	}
+	if len(eventDate) > 0 {
+		query["event_date"] = eventDate
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "event_date", Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(streamBatchSize)
+	cursor, err := m.collection(ctx, m.config.Collections.Transactions).Find(ctx, query, opts)
+	if err != nil {
+		return fmt.Errorf("failed to find transactions: %w", mongoErr(err))
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var tx model.Transaction
+		if err := cursor.Decode(&tx); err != nil {
+			return fmt.Errorf("failed to decode transaction: %w", mongoErr(err))
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to stream transactions: %w", mongoErr(err))
+	}
+	return nil
+}
+
+// WithTransaction runs fn in a multi-document transaction, this needs MongoDB to run as a replica set. If ctx is already
+// part of a transaction fn simply joins it so callers can nest without caring who started it
+func (m *MongoDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	session, err := m.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", mongoErr(err))
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		return nil, fn(ctx)
+	})
+	return mongoErr(err)
+}
+
+func (m *MongoDB) AppendOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	if _, err := m.collection(ctx, m.config.Collections.Outbox).InsertOne(ctx, event); err != nil {
+		return fmt.Errorf("failed to append outbox event: %w", mongoErr(err))
+	}
+	return nil
+}
+
+func (m *MongoDB) FindUnpublishedOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+	cursor, err := m.collection(ctx, m.config.Collections.Outbox).
+		Find(ctx, bson.M{"published_at": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find unpublished outbox events: %w", mongoErr(err))
+	}
+	var events []model.OutboxEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode outbox events: %w", mongoErr(err))
+	}
+	return events, nil
+}
+
+func (m *MongoDB) MarkOutboxEventPublished(ctx context.Context, eventID string, publishedAt time.Time) error {
+	id, err := bson.ObjectIDFromHex(eventID)
 	if err != nil {
 		return err
 	}
+	result, err := m.collection(ctx, m.config.Collections.Outbox).
+		UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"published_at": publishedAt}})
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event published: %w", mongoErr(err))
+	}
 	if result.MatchedCount == 0 {
-		return errors.New("transaction not found")
+		return fmt.Errorf("outbox event %s: %w", id.Hex(), repository.ErrNotFound)
 	}
 	return nil
 }
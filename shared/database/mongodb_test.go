@@ -1,3 +1,169 @@
 package database
 
-// I should add tests.....
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/repository/repositorytest"
+	"github.com/joolshouston/pismo-technical-test/shared/tenant"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	_ repository.DatabaseRepository = (*MongoDB)(nil)
+	_ repository.WebhookRepository  = (*MongoDB)(nil)
+	_ repository.AuditRepository    = (*MongoDB)(nil)
+	_ repository.LeaseRepository    = (*MongoDB)(nil)
+	_ repository.JobRepository      = (*MongoDB)(nil)
+)
+
+// mongoTestClient connects to MONGODB_URI, which has to point at a replica set, and skips the test when it is not set
+func mongoTestClient(t *testing.T) *mongo.Client {
+	t.Helper()
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		t.Skip("MONGODB_URI is not set")
+	}
+	client, err := mongo.Connect(options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+	return client
+}
+
+// newTestMongoDB returns a store on a database of its own with the schema in place, it is dropped once the test is done
+func newTestMongoDB(t *testing.T, client *mongo.Client) *MongoDB {
+	t.Helper()
+	config := DefaultMongoConfig()
+	config.Database = "pismo_test_" + bson.NewObjectID().Hex()
+	t.Cleanup(func() { _ = client.Database(config.Database).Drop(context.Background()) })
+	store := NewMongoDB(client, config)
+	if err := store.EnsureSchema(context.Background()); err != nil {
+		t.Fatalf("failed to create the schema: %v", err)
+	}
+	return store
+}
+
+func TestMongoDB_Contract(t *testing.T) {
+	client := mongoTestClient(t)
+	repositorytest.Run(t, func(t *testing.T) repository.DatabaseRepository {
+		return newTestMongoDB(t, client)
+	})
+	repositorytest.RunLeases(t, func(t *testing.T) repository.LeaseRepository {
+		return newTestMongoDB(t, client)
+	})
+	repositorytest.RunJobs(t, func(t *testing.T) repository.JobRepository {
+		return newTestMongoDB(t, client)
+	})
+	repositorytest.RunBackups(t, func(t *testing.T) repositorytest.BackupStore {
+		return newTestMongoDB(t, client)
+	})
+	repositorytest.RunJournalBackfill(t, func(t *testing.T) (repository.DatabaseRepository, func(ctx context.Context) error) {
+		store := newTestMongoDB(t, client)
+		return store, func(ctx context.Context) error {
+			return backfillOpeningEntries(ctx, store.collection(ctx, store.config.Collections.Transactions), store.collection(ctx, store.config.Collections.Journal))
+		}
+	})
+}
+
+func TestMongoDB_Migrations(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMongoDB(t, mongoTestClient(t))
+	transactions := store.collection(ctx, store.config.Collections.Transactions)
+	// a transaction written by a release that stored event dates as strings
+	id := bson.NewObjectID()
+	if _, err := transactions.InsertOne(ctx, bson.M{
+		"_id": id, "account_id": "acc", "operation_type_id": 1, "amount": -10.0, "balance": -10.0,
+		"event_date": "2025-01-02T10:00:00.123456789Z", "idempotency_key": "key-1",
+	}, options.InsertOne().SetBypassDocumentValidation(true)); err != nil {
+		t.Fatalf("failed to insert the transaction: %v", err)
+	}
+
+	applied, err := store.MigrateUp(ctx)
+	if err != nil || len(applied) != len(mongoMigrations) {
+		t.Fatalf("expected every migration to be applied, got %+v, %v", applied, err)
+	}
+	if again, err := store.MigrateUp(ctx); err != nil || len(again) != 0 {
+		t.Errorf("expected migrating twice to be a no-op, got %+v, %v", again, err)
+	}
+	tx, err := store.GetTransactionByID(ctx, id.Hex())
+	if want := time.Date(2025, 1, 2, 10, 0, 0, 123000000, time.UTC); err != nil || tx == nil || !tx.EventDate.Equal(want) || tx.Version != 1 {
+		t.Fatalf("expected the event date to be converted to %v at version 1, got %+v, %v", want, tx, err)
+	}
+	if got, err := store.GetTransactionByID(ctx, tx.PublicID); err != nil || got == nil || got.ID != id {
+		t.Errorf("expected the transaction by the public ID it was given, got %+v, %v", got, err)
+	}
+	if entries, err := store.FindJournalEntries(ctx, "acc", tx.EventDate); err != nil || len(entries) != 1 || entries[0].TransactionID != id.Hex() {
+		t.Errorf("expected an opening entry for the transaction, got %+v, %v", entries, err)
+	}
+
+	reverted, err := store.MigrateDown(ctx, 4)
+	if err != nil || len(reverted) != 4 || reverted[0].Version != 4 || reverted[3].Version != 1 {
+		t.Fatalf("expected migrations 4 to 1 to be reverted, got %+v, %v", reverted, err)
+	}
+	var raw bson.M
+	if err := transactions.FindOne(ctx, bson.M{"_id": id}).Decode(&raw); err != nil || raw["event_date"] != "2025-01-02T10:00:00.123Z" {
+		t.Errorf("expected the event date to be a string again, got %v, %v", raw["event_date"], err)
+	}
+	if _, ok := raw["version"]; ok {
+		t.Errorf("expected the version to be removed, got %v", raw)
+	}
+	if _, ok := raw["public_id"]; ok {
+		t.Errorf("expected the public ID to be removed, got %v", raw)
+	}
+	migrations, err := store.Migrations(ctx)
+	if err != nil || len(migrations) == 0 || migrations[0].AppliedAt != nil {
+		t.Errorf("expected migration 1 to be pending, got %+v, %v", migrations, err)
+	}
+}
+
+func TestMongoConfig(t *testing.T) {
+	config := MongoConfig{Collections: Collections{Accounts: "customers"}}.withDefaults()
+	if config.Database != "pismo" || config.Collections.Accounts != "customers" || config.Collections.AuditLog != "audit_log" {
+		t.Errorf("expected the unset names to fall back to their defaults, got %+v", config)
+	}
+
+	prefixed := DefaultCollections().WithPrefix("cards_")
+	if prefixed.Transactions != "cards_transactions" || DefaultCollections().Transactions != "transactions" {
+		t.Errorf("expected only the copy to be prefixed, got %+v", prefixed)
+	}
+
+	ctx := context.Background()
+	if got := config.DatabaseName(ctx); got != "pismo" {
+		t.Errorf("expected the shared database without a tenant, got %s", got)
+	}
+	if got := config.DatabaseName(tenant.WithID(ctx, "program-a")); got != "pismo_program-a" {
+		t.Errorf("expected the database of the tenant, got %s", got)
+	}
+}
+
+func TestMongoErr(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{name: "Primary stepped down", err: mongo.CommandError{Code: 189, Name: "PrimarySteppedDown"}, transient: true},
+		{name: "Write conflict", err: mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 112}}}, transient: true},
+		{name: "Transient transaction error", err: mongo.CommandError{Code: 251, Labels: []string{"TransientTransactionError"}}, transient: true},
+		{name: "Duplicate key", err: mongo.CommandError{Code: 11000, Name: "DuplicateKey"}},
+		{name: "Validation failure", err: mongo.CommandError{Code: 121, Name: "DocumentValidationFailure"}},
+		{name: "Canceled", err: context.Canceled},
+		{name: "Not found", err: mongo.ErrNoDocuments},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mongoErr(tt.err)
+			if errors.Is(err, repository.ErrUnavailable) != tt.transient {
+				t.Errorf("expected transient to be %v, got %v", tt.transient, err)
+			}
+		})
+	}
+}
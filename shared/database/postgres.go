@@ -0,0 +1,46 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the pgx database/sql driver
+)
+
+// PostgreSQL error codes the store cares about, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation      = "23505"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+var postgresDialect = dialect{
+	name:                 "postgres",
+	numberedPlaceholders: true,
+	duplicate: func(err error) bool {
+		return pgErrorCode(err) == pgUniqueViolation
+	},
+	retryable: func(err error) bool {
+		code := pgErrorCode(err)
+		return code == pgSerializationFailure || code == pgDeadlockDetected
+	},
+	// pgx knows when a statement never reached the server
+	unavailable: pgconn.SafeToRetry,
+}
+
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// NewPostgres returns a store on a database opened with the "pgx" driver, call Migrate before using it
+func NewPostgres(db *sql.DB) *SQL {
+	return &SQL{
+		db:      db,
+		dialect: postgresDialect,
+	}
+}
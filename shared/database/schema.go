@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// collectionSchema is the validator and the indexes a collection is created with
+type collectionSchema struct {
+	name      string
+	validator bson.M
+	indexes   []mongo.IndexModel
+}
+
+// publicIDIndex keeps public IDs unique, documents stored before they had one are left out until migration 3 gives
+// them one
+var publicIDIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "public_id", Value: 1}},
+	Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"public_id": bson.M{"$type": "string"}}),
+}
+
+func mongoSchema(collections Collections) []collectionSchema {
+	return []collectionSchema{
+		{
+			name: collections.Accounts,
+			validator: bson.M{"$jsonSchema": bson.M{
+				"bsonType": "object",
+				"required": bson.A{"public_id", "document_number", "version"},
+				"properties": bson.M{
+					"public_id":       bson.M{"bsonType": "string", "pattern": "^acc_"},
+					"document_number": bson.M{"bsonType": "string", "minLength": 1},
+					"version":         bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 1},
+				},
+			}},
+			indexes: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "document_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+				publicIDIndex,
+			},
+		},
+		{
+			name: collections.Transactions,
+			validator: bson.M{"$jsonSchema": bson.M{
+				"bsonType": "object",
+				"required": bson.A{"public_id", "account_id", "operation_type_id", "amount", "event_date", "balance", "idempotency_key", "version"},
+				"properties": bson.M{
+					"public_id":         bson.M{"bsonType": "string", "pattern": "^txn_"},
+					"account_id":        bson.M{"bsonType": "string"},
+					"operation_type_id": bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 1, "maximum": 4},
+					"amount":            bson.M{"bsonType": bson.A{"double", "int", "long", "decimal"}},
+					"event_date":        bson.M{"bsonType": "date"},
+					"balance":           bson.M{"bsonType": bson.A{"double", "int", "long", "decimal"}},
+					"idempotency_key":   bson.M{"bsonType": "string", "minLength": 1},
+					"version":           bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 1},
+				},
+			}},
+			indexes: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "idempotency_key", Value: 1}}, Options: options.Index().SetUnique(true)},
+				publicIDIndex,
+				{Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "event_date", Value: 1}, {Key: "_id", Value: 1}}},
+				// exports of every account
+				{Keys: bson.D{{Key: "event_date", Value: 1}, {Key: "_id", Value: 1}}},
+			},
+		},
+		{
+			name: collections.Outbox,
+			indexes: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "published_at", Value: 1}, {Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}}},
+			},
+		},
+		{
+			name: collections.Journal,
+			indexes: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "posted_at", Value: 1}, {Key: "_id", Value: 1}}},
+				// the opening entry backfill looks up which transactions already have an entry, and what was allocated to them
+				{Keys: bson.D{{Key: "transaction_id", Value: 1}}},
+				{Keys: bson.D{{Key: "lines.applies_to", Value: 1}}},
+			},
+		},
+		{
+			name: collections.WebhookDeliveries,
+			indexes: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+				{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}, {Key: "_id", Value: 1}}},
+			},
+		},
+		{
+			name: collections.Leases,
+			indexes: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(leaseExpiry.Seconds()))},
+			},
+		},
+		{
+			name: collections.Jobs,
+			indexes: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+			},
+		},
+		{
+			name: collections.AuditLog,
+			indexes: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "entity_type", Value: 1}, {Key: "entity_id", Value: 1}, {Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}},
+			},
+		},
+	}
+}
+
+// EnsureSchema creates the collections of the database ctx routes to with their validators and indexes, or brings
+// existing ones up to date. It is idempotent so it runs on every start, once per tenant. Validation is moderate,
+// documents already stored that break the schema can still be updated but no new ones can be written. A unique index
+// cannot be built while the collection holds duplicates, those have to be cleaned up by hand first
+func (m *MongoDB) EnsureSchema(ctx context.Context) error {
+	db := m.database(ctx)
+	existing, err := db.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %w", err)
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		exists[name] = true
+	}
+
+	for _, schema := range mongoSchema(m.config.Collections) {
+		if schema.validator != nil {
+			if err := ensureValidator(ctx, db, schema, exists[schema.name]); err != nil {
+				return err
+			}
+		}
+		if _, err := db.Collection(schema.name).Indexes().CreateMany(ctx, schema.indexes); err != nil {
+			return fmt.Errorf("failed to create indexes on %s: %w", schema.name, err)
+		}
+	}
+	return nil
+}
+
+func ensureValidator(ctx context.Context, db *mongo.Database, schema collectionSchema, exists bool) error {
+	if !exists {
+		opts := options.CreateCollection().SetValidator(schema.validator).SetValidationLevel("moderate")
+		err := db.CreateCollection(ctx, schema.name, opts)
+		if err == nil {
+			return nil
+		}
+		var cmdErr mongo.CommandError
+		if !errors.As(err, &cmdErr) || cmdErr.Name != "NamespaceExists" {
+			return fmt.Errorf("failed to create %s: %w", schema.name, err)
+		}
+		// another instance starting at the same time created it first, its validator is brought up to date below
+	}
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: schema.name},
+		{Key: "validator", Value: schema.validator},
+		{Key: "validationLevel", Value: "moderate"},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update the validator of %s: %w", schema.name, err)
+	}
+	return nil
+}
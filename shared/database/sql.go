@@ -0,0 +1,601 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/ledger"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+// sqlTransactionAttempts bounds how many times WithTransaction runs fn when the database aborts the transaction
+// because it conflicted with another one
+const sqlTransactionAttempts = 3
+
+// dialect is what differs between the SQL databases the store runs on
+type dialect struct {
+	name string
+	// numbered placeholders ($1, $2...) instead of ?
+	numberedPlaceholders bool
+	// duplicate reports whether a write failed on a unique constraint
+	duplicate func(err error) bool
+	// retryable reports whether a transaction failed only because it conflicted with another one
+	retryable func(err error) bool
+	// unavailable reports whether a call failed before the database could act on it, on top of the connection
+	// failures every driver reports alike. It may be nil
+	unavailable func(err error) bool
+}
+
+// SQL stores everything in a relational database through database/sql, documents keep their ObjectID hex strings as
+// primary keys so IDs look the same as with MongoDB. The schema is created and upgraded by Migrate
+type SQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// querier is what the *sql.DB and the *sql.Tx of a running transaction have in common
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqlTxKey holds the transaction a context is running in, with the store it belongs to
+type sqlTxKey struct{}
+
+type sqlTx struct {
+	store *SQL
+	tx    *sql.Tx
+}
+
+// sqlMigrationSteps run right after the script of the migration with the same version and in its transaction, for
+// the changes SQL cannot make on its own
+var sqlMigrationSteps = map[string]func(s *SQL, ctx context.Context) error{
+	"0005_public_ids":      (*SQL).backfillPublicIDs,
+	"0006_opening_entries": (*SQL).backfillOpeningEntries,
+}
+
+// Migrate applies the migrations of the store's dialect that have not been applied yet, in file name order, each one
+// in its own transaction. It is safe to run on every start
+func (s *SQL) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	dir := "migrations/" + s.dialect.name
+	files, err := fs.Glob(migrations, dir+"/*.sql")
+	if err != nil {
+		return err
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		version := strings.TrimSuffix(strings.TrimPrefix(file, dir+"/"), ".sql")
+		var applied int
+		if err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), version).Scan(&applied); err != nil {
+			return fmt.Errorf("failed to check migration %s: %w", version, err)
+		}
+		if applied > 0 {
+			continue
+		}
+		script, err := migrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := s.WithTransaction(ctx, func(ctx context.Context) error {
+			if _, err := s.conn(ctx).ExecContext(ctx, string(script)); err != nil {
+				return err
+			}
+			if step, ok := sqlMigrationSteps[version]; ok {
+				if err := step(s, ctx); err != nil {
+					return err
+				}
+			}
+			_, err := s.conn(ctx).ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+				version, time.Now().UTC().Format(time.RFC3339Nano))
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// conn returns the transaction ctx is running in or the database when there is none
+func (s *SQL) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(sqlTxKey{}).(sqlTx); ok && tx.store == s {
+		return tx.tx
+	}
+	return s.db
+}
+
+// rebind turns the ? placeholders queries are written with into the dialect's own
+func (s *SQL) rebind(query string) string {
+	if !s.dialect.numberedPlaceholders {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+func (s *SQL) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	result, err := s.conn(ctx).ExecContext(ctx, s.rebind(query), args...)
+	return result, s.sqlErr(err)
+}
+
+func (s *SQL) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, s.rebind(query), args...)
+	return rows, s.sqlErr(err)
+}
+
+func (s *SQL) queryRow(ctx context.Context, query string, args ...any) sqlRow {
+	return sqlRow{Row: s.conn(ctx).QueryRowContext(ctx, s.rebind(query), args...), store: s}
+}
+
+// sqlRow classifies the error of the row it wraps like exec and query do
+type sqlRow struct {
+	*sql.Row
+	store *SQL
+}
+
+func (r sqlRow) Scan(dest ...any) error {
+	return r.store.sqlErr(r.Row.Scan(dest...))
+}
+
+// sqlErr wraps err with repository.ErrUnavailable when the call failed on a connection that was lost or never made, so
+// it may succeed on another one. A canceled ctx is the caller's doing and is left as it is
+func (s *SQL) sqlErr(err error) error {
+	if err == nil || errors.Is(err, repository.ErrUnavailable) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	var netErr net.Error
+	transient := errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr)
+	if s.dialect.unavailable != nil && s.dialect.unavailable(err) {
+		transient = true
+	}
+	if !transient {
+		return err
+	}
+	return fmt.Errorf("%w: %w", repository.ErrUnavailable, err)
+}
+
+// WithTransaction joins the transaction ctx is already part of, like MongoDB does, otherwise it starts one and retries
+// fn when the database aborts it because of a conflict with another transaction. A conflict that outlasts the retries
+// is reported as repository.ErrUnavailable
+func (s *SQL) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if tx, ok := ctx.Value(sqlTxKey{}).(sqlTx); ok && tx.store == s {
+		return fn(ctx)
+	}
+	var err error
+	for range sqlTransactionAttempts {
+		if err = s.runTransaction(ctx, fn); err == nil || !s.dialect.retryable(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: %w", repository.ErrUnavailable, err)
+}
+
+func (s *SQL) runTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", s.sqlErr(err))
+	}
+	if err := fn(context.WithValue(ctx, sqlTxKey{}, sqlTx{store: s, tx: tx})); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", s.sqlErr(err))
+	}
+	return nil
+}
+
+// matched returns notFound when a write matched no row
+func matched(result sql.Result, notFound error) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func nullTimePtr(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return nullTime(*t)
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// nullJSON stores a missing document as NULL
+func nullJSON(raw []byte) any {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
+
+// scanID parses the hex ID read from a row back into an ObjectID
+func scanID(hex string) (bson.ObjectID, error) {
+	id, err := bson.ObjectIDFromHex(hex)
+	if err != nil {
+		return bson.ObjectID{}, fmt.Errorf("invalid stored ID %q: %w", hex, err)
+	}
+	return id, nil
+}
+
+// backfillPublicIDs gives every account and transaction without a public ID one, made at the time its ObjectID was so
+// the IDs still sort in the order the rows were created
+func (s *SQL) backfillPublicIDs(ctx context.Context) error {
+	for table, prefix := range map[string]string{"accounts": publicid.AccountPrefix, "transactions": publicid.TransactionPrefix} {
+		rows, err := s.query(ctx, `SELECT id FROM `+table+` WHERE public_id IS NULL`)
+		if err != nil {
+			return err
+		}
+		var ids []bson.ObjectID
+		for rows.Next() {
+			var hex string
+			if err := rows.Scan(&hex); err != nil {
+				rows.Close()
+				return err
+			}
+			id, err := scanID(hex)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := s.exec(ctx, `UPDATE `+table+` SET public_id = ? WHERE id = ?`, publicid.New(prefix, id.Timestamp()), id.Hex()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SQL) CreateAccount(ctx context.Context, documentID string) (*model.Account, error) {
+	acc := model.Account{ID: bson.NewObjectID(), PublicID: publicid.NewAccountID(), DocumentNumber: documentID, Version: 1}
+	if _, err := s.exec(ctx, `INSERT INTO accounts (id, public_id, document_number, version) VALUES (?, ?, ?, ?)`,
+		acc.ID.Hex(), acc.PublicID, acc.DocumentNumber, acc.Version); err != nil {
+		if s.dialect.duplicate(err) {
+			return nil, fmt.Errorf("account with document number %s: %w", documentID, repository.ErrDuplicate)
+		}
+		return nil, fmt.Errorf("failed to create account: %w", err)
+	}
+	return &acc, nil
+}
+
+// idColumn is the column id is matched against, public_id when it is a public ID with the prefix and the primary key
+// when it is an ObjectID
+func idColumn(id string, prefix string) (string, error) {
+	if publicid.Valid(id, prefix) {
+		return "public_id", nil
+	}
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return "", err
+	}
+	return "id", nil
+}
+
+const accountColumns = `id, public_id, document_number, version`
+
+func scanAccount(row interface{ Scan(dest ...any) error }) (model.Account, error) {
+	var acc model.Account
+	var id string
+	if err := row.Scan(&id, &acc.PublicID, &acc.DocumentNumber, &acc.Version); err != nil {
+		return acc, err
+	}
+	var err error
+	acc.ID, err = scanID(id)
+	return acc, err
+}
+
+func (s *SQL) GetAccountByID(ctx context.Context, accountID string) (*model.Account, error) {
+	column, err := idColumn(accountID, publicid.AccountPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account ID format: %w", err)
+	}
+	acc, err := scanAccount(s.queryRow(ctx, `SELECT `+accountColumns+` FROM accounts WHERE `+column+` = ?`, accountID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("account %s: %w", accountID, repository.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+	return &acc, nil
+}
+
+func (s *SQL) GetAccountByDocumentNumber(ctx context.Context, documentNumber string) (*model.Account, error) {
+	acc, err := scanAccount(s.queryRow(ctx, `SELECT `+accountColumns+` FROM accounts WHERE document_number = ?`, documentNumber))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account by document number: %w", err)
+	}
+	return &acc, nil
+}
+
+const transactionColumns = `id, public_id, account_id, operation_type_id, amount, event_date, balance, idempotency_key, version`
+
+func scanTransaction(row interface{ Scan(dest ...any) error }) (model.Transaction, error) {
+	var tx model.Transaction
+	var id string
+	if err := row.Scan(&id, &tx.PublicID, &tx.AccountID, &tx.OperationID, &tx.Amount, &tx.EventDate, &tx.Balance, &tx.IdempotencyKey, &tx.Version); err != nil {
+		return tx, err
+	}
+	tx.EventDate = tx.EventDate.UTC()
+	var err error
+	tx.ID, err = scanID(id)
+	return tx, err
+}
+
+func (s *SQL) CreateTransaction(ctx context.Context, transaction model.Transaction) (*model.Transaction, error) {
+	if transaction.ID.IsZero() {
+		transaction.ID = bson.NewObjectID()
+	}
+	if transaction.PublicID == "" {
+		transaction.PublicID = publicid.NewTransactionID()
+	}
+	transaction.Version = 1
+	if _, err := s.exec(ctx, `INSERT INTO transactions (`+transactionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		transaction.ID.Hex(), transaction.PublicID, transaction.AccountID, transaction.OperationID, transaction.Amount, transaction.EventDate,
+		transaction.Balance, transaction.IdempotencyKey, transaction.Version); err != nil {
+		if s.dialect.duplicate(err) {
+			return nil, fmt.Errorf("transaction with idempotency key %s: %w", transaction.IdempotencyKey, repository.ErrDuplicate)
+		}
+		return nil, fmt.Errorf("failed to create transaction: %w", err)
+	}
+	return &transaction, nil
+}
+
+func (s *SQL) GetTransactionByID(ctx context.Context, transactionID string) (*model.Transaction, error) {
+	column, err := idColumn(transactionID, publicid.TransactionPrefix)
+	if err != nil {
+		return nil, nil
+	}
+	return s.findTransaction(ctx, `SELECT `+transactionColumns+` FROM transactions WHERE `+column+` = ?`, transactionID)
+}
+
+func (s *SQL) FindTransactionByIdempotencyKey(ctx context.Context, idempotencyKey string) (*model.Transaction, error) {
+	return s.findTransaction(ctx, `SELECT `+transactionColumns+` FROM transactions WHERE idempotency_key = ?`, idempotencyKey)
+}
+
+func (s *SQL) findTransaction(ctx context.Context, query string, args ...any) (*model.Transaction, error) {
+	tx, err := scanTransaction(s.queryRow(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	return &tx, nil
+}
+
+func (s *SQL) FindAllTransactionsForAccountID(ctx context.Context, accountID string) ([]model.Transaction, error) {
+	var transactions []model.Transaction
+	err := s.StreamTransactions(ctx, model.TransactionFilter{AccountID: accountID}, func(tx model.Transaction) error {
+		transactions = append(transactions, tx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+func (s *SQL) UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction, expectedVersion int64) error {
+	id, err := bson.ObjectIDFromHex(transactionID)
+	if err != nil {
+		return err
+	}
+	result, err := s.exec(ctx, `UPDATE transactions SET account_id = ?, operation_type_id = ?, amount = ?, event_date = ?,
+		balance = ?, idempotency_key = ?, version = ? WHERE id = ? AND version = ?`,
+		transaction.AccountID, transaction.OperationID, transaction.Amount, transaction.EventDate, transaction.Balance,
+		transaction.IdempotencyKey, expectedVersion+1, id.Hex(), expectedVersion)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction: %w", err)
+	}
+	err = matched(result, fmt.Errorf("transaction %s is not at version %d: %w", id.Hex(), expectedVersion, repository.ErrConflict))
+	if !errors.Is(err, repository.ErrConflict) {
+		return err
+	}
+	// either the transaction is gone or it was written since it was read
+	var n int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM transactions WHERE id = ?`, id.Hex()).Scan(&n); err != nil {
+		return fmt.Errorf("failed to update transaction: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("transaction %s: %w", id.Hex(), repository.ErrNotFound)
+	}
+	return err
+}
+
+// StreamTransactions reads the rows through a single cursor
+func (s *SQL) StreamTransactions(ctx context.Context, filter model.TransactionFilter, fn func(model.Transaction) error) error {
+	var where []string
+	var args []any
+	if filter.AccountID != "" {
+		where = append(where, "account_id = ?")
+		args = append(args, filter.AccountID)
+	}
+	if filter.OperationID != 0 {
+		where = append(where, "operation_type_id = ?")
+		args = append(args, filter.OperationID)
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "event_date >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "event_date <= ?")
+		args = append(args, filter.To)
+	}
+	query := `SELECT ` + transactionColumns + ` FROM transactions`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	rows, err := s.query(ctx, query+` ORDER BY event_date, id`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to find transactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return fmt.Errorf("failed to decode transaction: %w", err)
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to stream transactions: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) AppendOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	if event.ID.IsZero() {
+		event.ID = bson.NewObjectID()
+	}
+	if _, err := s.exec(ctx, `INSERT INTO outbox_events (id, type, aggregate_id, account_id, payload, occurred_at, published_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		event.ID.Hex(), event.Type, event.AggregateID, event.AccountID, string(event.Payload), event.OccurredAt,
+		nullTimePtr(event.PublishedAt)); err != nil {
+		return fmt.Errorf("failed to append outbox event: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) FindUnpublishedOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	rows, err := s.query(ctx, `SELECT id, type, aggregate_id, account_id, payload, occurred_at FROM outbox_events
+		WHERE published_at IS NULL ORDER BY occurred_at, id LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find unpublished outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []model.OutboxEvent
+	for rows.Next() {
+		var event model.OutboxEvent
+		var id, payload string
+		if err := rows.Scan(&id, &event.Type, &event.AggregateID, &event.AccountID, &payload, &event.OccurredAt); err != nil {
+			return nil, fmt.Errorf("failed to decode outbox events: %w", err)
+		}
+		if event.ID, err = scanID(id); err != nil {
+			return nil, err
+		}
+		event.Payload = []byte(payload)
+		event.OccurredAt = event.OccurredAt.UTC()
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to decode outbox events: %w", err)
+	}
+	return events, nil
+}
+
+func (s *SQL) MarkOutboxEventPublished(ctx context.Context, eventID string, publishedAt time.Time) error {
+	id, err := bson.ObjectIDFromHex(eventID)
+	if err != nil {
+		return err
+	}
+	result, err := s.exec(ctx, `UPDATE outbox_events SET published_at = ? WHERE id = ?`, publishedAt, id.Hex())
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event published: %w", err)
+	}
+	return matched(result, fmt.Errorf("outbox event %s: %w", id.Hex(), repository.ErrNotFound))
+}
+
+// backfillOpeningEntries posts an opening entry for every transaction the journal has no entry for, so running it again
+// posts nothing more
+func (s *SQL) backfillOpeningEntries(ctx context.Context) error {
+	rows, err := s.query(ctx, `SELECT `+transactionColumns+` FROM transactions
+		WHERE NOT EXISTS (SELECT 1 FROM journal_entries WHERE journal_entries.transaction_id = transactions.id)
+		ORDER BY event_date, id`)
+	if err != nil {
+		return err
+	}
+	var transactions []model.Transaction
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		transactions = append(transactions, tx)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// what payments posted since have allocated to those transactions
+	var posted []model.JournalEntry
+	err = s.streamJournalEntries(ctx, `WHERE e.id IN (SELECT entry_id FROM journal_lines WHERE applies_to IN (SELECT id FROM transactions
+		WHERE NOT EXISTS (SELECT 1 FROM journal_entries WHERE journal_entries.transaction_id = transactions.id)))`, nil, func(entry model.JournalEntry) error {
+		posted = append(posted, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	journalled := ledger.TransactionBalances(posted)
+	for _, tx := range transactions {
+		entry, err := ledger.NewOpeningEntry(tx.ID.Hex(), tx, journalled[tx.ID.Hex()])
+		if err != nil {
+			return fmt.Errorf("transaction %s: %w", tx.ID.Hex(), err)
+		}
+		if err := s.PostJournalEntry(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
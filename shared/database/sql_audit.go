@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func (s *SQL) AppendAuditRecord(ctx context.Context, record model.AuditRecord) error {
+	if record.ID.IsZero() {
+		record.ID = bson.NewObjectID()
+	}
+	if _, err := s.exec(ctx, `INSERT INTO audit_records
+		(id, actor, request_id, timestamp, action, entity_type, entity_id, before, after) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.ID.Hex(), record.Actor, record.RequestID, record.Timestamp, record.Action, record.EntityType, record.EntityID,
+		nullJSON(record.Before), nullJSON(record.After)); err != nil {
+		return fmt.Errorf("failed to append audit record: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) FindAuditRecords(ctx context.Context, entityType model.AuditEntityType, entityID string, limit int) ([]model.AuditRecord, error) {
+	var records []model.AuditRecord
+	err := s.streamAuditRecords(ctx, `WHERE entity_type = ? AND entity_id = ? ORDER BY timestamp, id LIMIT ?`, []any{entityType, entityID, limit}, func(record model.AuditRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// streamAuditRecords calls fn with every record matching where, which also orders and limits them
+func (s *SQL) streamAuditRecords(ctx context.Context, where string, args []any, fn func(model.AuditRecord) error) error {
+	rows, err := s.query(ctx, `SELECT id, actor, request_id, timestamp, action, entity_type, entity_id, before, after FROM audit_records
+		`+where, args...)
+	if err != nil {
+		return fmt.Errorf("failed to find audit records: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record model.AuditRecord
+		var id string
+		var before, after sql.NullString
+		if err := rows.Scan(&id, &record.Actor, &record.RequestID, &record.Timestamp, &record.Action, &record.EntityType, &record.EntityID, &before, &after); err != nil {
+			return fmt.Errorf("failed to decode audit records: %w", err)
+		}
+		if record.ID, err = scanID(id); err != nil {
+			return err
+		}
+		record.Timestamp = record.Timestamp.UTC()
+		if before.Valid {
+			record.Before = []byte(before.String)
+		}
+		if after.Valid {
+			record.After = []byte(after.String)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to decode audit records: %w", err)
+	}
+	return nil
+}
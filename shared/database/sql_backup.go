@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+)
+
+// Snapshot runs fn in a read only transaction, repeatable read on PostgreSQL, SQLite reads from the snapshot the WAL
+// gives it at the first read and, unlike a writing transaction, does not keep writers waiting meanwhile
+func (s *SQL) Snapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	if tx, ok := ctx.Value(sqlTxKey{}).(sqlTx); ok && tx.store == s {
+		return fn(ctx)
+	}
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin snapshot: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	return fn(context.WithValue(ctx, sqlTxKey{}, sqlTx{store: s, tx: tx}))
+}
+
+func (s *SQL) StreamAccounts(ctx context.Context, fn func(model.Account) error) error {
+	rows, err := s.query(ctx, `SELECT `+accountColumns+` FROM accounts ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to find accounts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		acc, err := scanAccount(rows)
+		if err != nil {
+			return fmt.Errorf("failed to decode account: %w", err)
+		}
+		if err := fn(acc); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to stream accounts: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) StreamJournalEntries(ctx context.Context, fn func(model.JournalEntry) error) error {
+	return s.streamJournalEntries(ctx, "", nil, fn)
+}
+
+func (s *SQL) StreamAuditRecords(ctx context.Context, fn func(model.AuditRecord) error) error {
+	return s.streamAuditRecords(ctx, `ORDER BY timestamp, id`, nil, fn)
+}
+
+func (s *SQL) Empty(ctx context.Context) (bool, error) {
+	for _, table := range []string{"accounts", "transactions", "journal_entries", "audit_records"} {
+		var one int
+		err := s.queryRow(ctx, `SELECT 1 FROM `+table+` LIMIT 1`).Scan(&one)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("failed to check %s: %w", table, err)
+		}
+	}
+	return true, nil
+}
+
+func (s *SQL) RestoreAccount(ctx context.Context, account model.Account) error {
+	if _, err := s.exec(ctx, `INSERT INTO accounts (`+accountColumns+`) VALUES (?, ?, ?, ?)`,
+		account.ID.Hex(), account.PublicID, account.DocumentNumber, account.Version); err != nil {
+		if s.dialect.duplicate(err) {
+			return fmt.Errorf("account %s: %w", account.ID.Hex(), repository.ErrDuplicate)
+		}
+		return fmt.Errorf("failed to restore account: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) RestoreTransaction(ctx context.Context, transaction model.Transaction) error {
+	if _, err := s.exec(ctx, `INSERT INTO transactions (`+transactionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		transaction.ID.Hex(), transaction.PublicID, transaction.AccountID, transaction.OperationID, transaction.Amount, transaction.EventDate,
+		transaction.Balance, transaction.IdempotencyKey, transaction.Version); err != nil {
+		if s.dialect.duplicate(err) {
+			return fmt.Errorf("transaction %s: %w", transaction.ID.Hex(), repository.ErrDuplicate)
+		}
+		return fmt.Errorf("failed to restore transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+func (s *SQL) GetAccountBalance(ctx context.Context, accountID string) (*model.AccountBalance, error) {
+	balance := model.AccountBalance{AccountID: accountID}
+	var lastTransactionAt sql.NullTime
+	var openDebts string
+	err := s.queryRow(ctx, `SELECT total_debt_cents, available_credit_cents, last_transaction_at, version, open_debts
+		FROM account_balances WHERE account_id = ?`, accountID).
+		Scan(&balance.TotalDebtCents, &balance.AvailableCreditCents, &lastTransactionAt, &balance.Version, &openDebts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account balance: %w", err)
+	}
+	if lastTransactionAt.Valid {
+		balance.LastTransactionAt = lastTransactionAt.Time.UTC()
+	}
+	if err := json.Unmarshal([]byte(openDebts), &balance.OpenDebts); err != nil {
+		return nil, fmt.Errorf("failed to decode open debts: %w", err)
+	}
+	return &balance, nil
+}
+
+func (s *SQL) SaveAccountBalance(ctx context.Context, balance model.AccountBalance) error {
+	openDebts, err := json.Marshal(balance.OpenDebts)
+	if err != nil {
+		return fmt.Errorf("failed to encode open debts: %w", err)
+	}
+	if balance.Version == 1 {
+		if _, err := s.exec(ctx, `INSERT INTO account_balances
+			(account_id, total_debt_cents, available_credit_cents, last_transaction_at, version, open_debts)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			balance.AccountID, balance.TotalDebtCents, balance.AvailableCreditCents, nullTime(balance.LastTransactionAt),
+			balance.Version, string(openDebts)); err != nil {
+			if s.dialect.duplicate(err) {
+				return errStaleAccountBalance
+			}
+			return fmt.Errorf("failed to create account balance: %w", err)
+		}
+		return nil
+	}
+	result, err := s.exec(ctx, `UPDATE account_balances
+		SET total_debt_cents = ?, available_credit_cents = ?, last_transaction_at = ?, version = ?, open_debts = ?
+		WHERE account_id = ? AND version = ?`,
+		balance.TotalDebtCents, balance.AvailableCreditCents, nullTime(balance.LastTransactionAt), balance.Version,
+		string(openDebts), balance.AccountID, balance.Version-1)
+	if err != nil {
+		return fmt.Errorf("failed to save account balance: %w", err)
+	}
+	return matched(result, errStaleAccountBalance)
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+)
+
+const jobColumns = `id, tenant, type, status, row_count, created, duplicates, invalid, failed, ignored_columns, error,
+	created_at, started_at, finished_at, updated_at`
+
+func scanJob(row interface{ Scan(dest ...any) error }) (model.Job, error) {
+	var job model.Job
+	var ignoredColumns string
+	var startedAt, finishedAt sql.NullTime
+	if err := row.Scan(&job.ID, &job.Tenant, &job.Type, &job.Status, &job.Rows, &job.Created, &job.Duplicates,
+		&job.Invalid, &job.Failed, &ignoredColumns, &job.Error, &job.CreatedAt, &startedAt, &finishedAt,
+		&job.UpdatedAt); err != nil {
+		return job, err
+	}
+	if err := json.Unmarshal([]byte(ignoredColumns), &job.IgnoredColumns); err != nil {
+		return job, fmt.Errorf("failed to decode ignored columns: %w", err)
+	}
+	job.CreatedAt = job.CreatedAt.UTC()
+	job.UpdatedAt = job.UpdatedAt.UTC()
+	for _, t := range []*sql.NullTime{&startedAt, &finishedAt} {
+		if t.Valid {
+			t.Time = t.Time.UTC()
+		}
+	}
+	job.StartedAt = timePtr(startedAt)
+	job.FinishedAt = timePtr(finishedAt)
+	return job, nil
+}
+
+// jobArgs are the values of jobColumns after the ID
+func jobArgs(job model.Job) ([]any, error) {
+	ignoredColumns, err := json.Marshal(job.IgnoredColumns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ignored columns: %w", err)
+	}
+	return []any{job.Tenant, job.Type, job.Status, job.Rows, job.Created, job.Duplicates, job.Invalid, job.Failed,
+		string(ignoredColumns), job.Error, job.CreatedAt, nullTimePtr(job.StartedAt), nullTimePtr(job.FinishedAt),
+		job.UpdatedAt}, nil
+}
+
+func (s *SQL) CreateJob(ctx context.Context, job model.Job) error {
+	args, err := jobArgs(job)
+	if err != nil {
+		return err
+	}
+	if _, err := s.exec(ctx, `INSERT INTO jobs (`+jobColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		append([]any{job.ID}, args...)...); err != nil {
+		return fmt.Errorf("failed to create job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func (s *SQL) GetJob(ctx context.Context, jobID string) (*model.Job, error) {
+	job, err := scanJob(s.queryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = ?`, jobID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job %s: %w", jobID, err)
+	}
+	return &job, nil
+}
+
+func (s *SQL) UpdateJob(ctx context.Context, job model.Job) error {
+	args, err := jobArgs(job)
+	if err != nil {
+		return err
+	}
+	result, err := s.exec(ctx, `UPDATE jobs SET tenant = ?, type = ?, status = ?, row_count = ?, created = ?,
+		duplicates = ?, invalid = ?, failed = ?, ignored_columns = ?, error = ?, created_at = ?, started_at = ?,
+		finished_at = ?, updated_at = ? WHERE id = ?`, append(args, job.ID)...)
+	if err != nil {
+		return fmt.Errorf("failed to update job %s: %w", job.ID, err)
+	}
+	return matched(result, fmt.Errorf("job %s: %w", job.ID, repository.ErrNotFound))
+}
+
+func (s *SQL) DeleteJobsUpdatedBefore(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := s.query(ctx, `DELETE FROM jobs WHERE updated_at < ? RETURNING id`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var deleted []string
+	for rows.Next() {
+		var jobID string
+		if err := rows.Scan(&jobID); err != nil {
+			return nil, fmt.Errorf("failed to decode expired jobs: %w", err)
+		}
+		deleted = append(deleted, jobID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to delete expired jobs: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AcquireLease inserts the lease or, when it exists, takes it over only while it is free or already owner's. A lease
+// held by someone else is left as it is and no row is changed
+func (s *SQL) AcquireLease(ctx context.Context, name string, owner string, now time.Time, expiresAt time.Time) (bool, error) {
+	result, err := s.exec(ctx, `INSERT INTO leases (name, owner, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE leases.owner = excluded.owner OR leases.expires_at <= ?`,
+		name, owner, expiresAt, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %w", name, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %w", name, err)
+	}
+	return n == 1, nil
+}
+
+func (s *SQL) ReleaseLease(ctx context.Context, name string, owner string) error {
+	if _, err := s.exec(ctx, `DELETE FROM leases WHERE name = ? AND owner = ?`, name, owner); err != nil {
+		return fmt.Errorf("failed to release lease %s: %w", name, err)
+	}
+	return nil
+}
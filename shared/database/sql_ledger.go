@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// PostJournalEntry writes the entry and its lines together, joining the caller's transaction when there is one
+func (s *SQL) PostJournalEntry(ctx context.Context, entry model.JournalEntry) error {
+	if entry.ID.IsZero() {
+		entry.ID = bson.NewObjectID()
+	}
+	err := s.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.exec(ctx, `INSERT INTO journal_entries (id, transaction_id, account_id, posted_at) VALUES (?, ?, ?, ?)`,
+			entry.ID.Hex(), entry.TransactionID, entry.AccountID, entry.PostedAt); err != nil {
+			return err
+		}
+		for i, line := range entry.Lines {
+			if _, err := s.exec(ctx, `INSERT INTO journal_lines (entry_id, line, ledger_account, side, amount_cents, applies_to)
+				VALUES (?, ?, ?, ?, ?, ?)`,
+				entry.ID.Hex(), i, line.LedgerAccount, line.Side, line.AmountCents, line.AppliesTo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to post journal entry: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) FindJournalEntries(ctx context.Context, accountID string, postedBy time.Time) ([]model.JournalEntry, error) {
+	var entries []model.JournalEntry
+	err := s.streamJournalEntries(ctx, `WHERE e.account_id = ? AND e.posted_at <= ?`, []any{accountID, postedBy}, func(entry model.JournalEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// streamJournalEntries calls fn with every entry matching where, oldest first, once all of its lines have been read
+func (s *SQL) streamJournalEntries(ctx context.Context, where string, args []any, fn func(model.JournalEntry) error) error {
+	rows, err := s.query(ctx, `SELECT e.id, e.transaction_id, e.account_id, e.posted_at, l.ledger_account, l.side, l.amount_cents, l.applies_to
+		FROM journal_entries e LEFT JOIN journal_lines l ON l.entry_id = e.id
+		`+where+`
+		ORDER BY e.posted_at, e.id, l.line`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to find journal entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entry *model.JournalEntry
+	for rows.Next() {
+		var id, transactionID, accountID string
+		var postedAt time.Time
+		var ledgerAccount, side, appliesTo sql.NullString
+		var amountCents sql.NullInt64
+		if err := rows.Scan(&id, &transactionID, &accountID, &postedAt, &ledgerAccount, &side, &amountCents, &appliesTo); err != nil {
+			return fmt.Errorf("failed to decode journal entries: %w", err)
+		}
+		if entry == nil || entry.ID.Hex() != id {
+			if entry != nil {
+				if err := fn(*entry); err != nil {
+					return err
+				}
+			}
+			entryID, err := scanID(id)
+			if err != nil {
+				return err
+			}
+			entry = &model.JournalEntry{
+				ID:            entryID,
+				TransactionID: transactionID,
+				AccountID:     accountID,
+				PostedAt:      postedAt.UTC(),
+			}
+		}
+		if ledgerAccount.Valid {
+			entry.Lines = append(entry.Lines, model.JournalLine{
+				LedgerAccount: model.LedgerAccount(ledgerAccount.String),
+				Side:          model.EntrySide(side.String),
+				AmountCents:   amountCents.Int64,
+				AppliesTo:     appliesTo.String,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to decode journal entries: %w", err)
+	}
+	if entry != nil {
+		return fn(*entry)
+	}
+	return nil
+}
+
+func (s *SQL) TrialBalance(ctx context.Context) ([]model.TrialBalanceLine, error) {
+	rows, err := s.query(ctx, `SELECT ledger_account,
+		COALESCE(SUM(CASE WHEN side = ? THEN amount_cents ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN side = ? THEN amount_cents ELSE 0 END), 0)
+		FROM journal_lines GROUP BY ledger_account ORDER BY ledger_account`, model.EntrySideDebit, model.EntrySideCredit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total journal: %w", err)
+	}
+	defer rows.Close()
+
+	var lines []model.TrialBalanceLine
+	for rows.Next() {
+		var line model.TrialBalanceLine
+		if err := rows.Scan(&line.LedgerAccount, &line.DebitCents, &line.CreditCents); err != nil {
+			return nil, fmt.Errorf("failed to decode trial balance: %w", err)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to decode trial balance: %w", err)
+	}
+	return lines, nil
+}
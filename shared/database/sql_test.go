@@ -0,0 +1,370 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"github.com/joolshouston/pismo-technical-test/shared/repository/repositorytest"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var (
+	_ repository.DatabaseRepository = (*SQL)(nil)
+	_ repository.WebhookRepository  = (*SQL)(nil)
+	_ repository.AuditRepository    = (*SQL)(nil)
+	_ repository.LeaseRepository    = (*SQL)(nil)
+	_ repository.JobRepository      = (*SQL)(nil)
+)
+
+func TestSQL_Rebind(t *testing.T) {
+	query := `SELECT id FROM accounts WHERE id = ? AND document_number = ?`
+	if got := NewPostgres(nil).rebind(query); got != `SELECT id FROM accounts WHERE id = $1 AND document_number = $2` {
+		t.Errorf("unexpected postgres query %s", got)
+	}
+	if got := NewSQLite(nil).rebind(query); got != query {
+		t.Errorf("unexpected sqlite query %s", got)
+	}
+}
+
+// safeToRetryErr is how pgx marks an error it knows happened before the statement reached the server
+type safeToRetryErr struct{}
+
+func (safeToRetryErr) Error() string     { return "write failed before sending" }
+func (safeToRetryErr) SafeToRetry() bool { return true }
+
+func TestSQLErr(t *testing.T) {
+	tests := []struct {
+		name      string
+		store     *SQL
+		err       error
+		transient bool
+	}{
+		{name: "Bad connection", store: NewSQLite(nil), err: fmt.Errorf("query failed: %w", driver.ErrBadConn), transient: true},
+		{name: "Network error", store: NewPostgres(nil), err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, transient: true},
+		{name: "Safe to retry", store: NewPostgres(nil), err: safeToRetryErr{}, transient: true},
+		{name: "Safe to retry on SQLite", store: NewSQLite(nil), err: safeToRetryErr{}},
+		{name: "Unique violation", store: NewPostgres(nil), err: &pgconn.PgError{Code: pgUniqueViolation}},
+		{name: "Canceled", store: NewPostgres(nil), err: context.Canceled},
+		{name: "No rows", store: NewSQLite(nil), err: sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.store.sqlErr(tt.err)
+			if errors.Is(err, repository.ErrUnavailable) != tt.transient {
+				t.Errorf("expected transient to be %v, got %v", tt.transient, err)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v to be kept, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// nothing listens on the port, the connection is refused
+	db, err := sql.Open("pgx", "postgres://pismo@127.0.0.1:1/pismo?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := NewPostgres(db).GetAccountByID(context.Background(), bson.NewObjectID().Hex()); !errors.Is(err, repository.ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable without a database to connect to, got %v", err)
+	}
+}
+
+// sqlStores returns a freshly migrated store per backend, PostgreSQL is only included when POSTGRES_URL points at a
+// database the tests are free to wipe
+func sqlStores(t *testing.T) map[string]*SQL {
+	t.Helper()
+	stores := map[string]*SQL{"sqlite": newTestSQLite(t)}
+	if postgresURL := os.Getenv("POSTGRES_URL"); postgresURL != "" {
+		stores["postgres"] = newTestPostgres(t, postgresURL)
+	}
+	return stores
+}
+
+func newTestSQLite(t *testing.T) *SQL {
+	t.Helper()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "pismo.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return migrateTestStore(t, NewSQLite(db))
+}
+
+func newTestPostgres(t *testing.T, postgresURL string) *SQL {
+	t.Helper()
+	db, err := sql.Open("pgx", postgresURL)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if _, err := db.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public`); err != nil {
+		t.Fatalf("failed to reset database: %v", err)
+	}
+	return migrateTestStore(t, NewPostgres(db))
+}
+
+func migrateTestStore(t *testing.T, store *SQL) *SQL {
+	t.Helper()
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("expected migrating twice to be a no-op, got %v", err)
+	}
+	return store
+}
+
+func TestSQL_UniqueConstraints(t *testing.T) {
+	for name, store := range sqlStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if _, err := store.CreateAccount(ctx, "12345678900"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := store.CreateAccount(ctx, "12345678900"); !errors.Is(err, repository.ErrDuplicate) {
+				t.Errorf("expected ErrDuplicate for the document number, got %v", err)
+			}
+
+			tx := model.Transaction{AccountID: "acc", OperationID: model.OperationTypePayment, Amount: 10, EventDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), IdempotencyKey: "key"}
+			if _, err := store.CreateTransaction(ctx, tx); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := store.CreateTransaction(ctx, tx); !errors.Is(err, repository.ErrDuplicate) {
+				t.Errorf("expected ErrDuplicate for the idempotency key, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSQL_WithTransaction(t *testing.T) {
+	for name, store := range sqlStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			failed := errors.New("failed")
+			err := store.WithTransaction(ctx, func(ctx context.Context) error {
+				if _, err := store.CreateAccount(ctx, "rolled-back"); err != nil {
+					return err
+				}
+				return store.WithTransaction(ctx, func(ctx context.Context) error {
+					if err := store.SaveAccountBalance(ctx, model.AccountBalance{AccountID: "acc", Version: 1}); err != nil {
+						return err
+					}
+					return failed
+				})
+			})
+			if !errors.Is(err, failed) {
+				t.Fatalf("expected the error from the transaction, got %v", err)
+			}
+			if acc, _ := store.GetAccountByDocumentNumber(ctx, "rolled-back"); acc != nil {
+				t.Errorf("expected the account to be rolled back, got %+v", acc)
+			}
+			if balance, _ := store.GetAccountBalance(ctx, "acc"); balance != nil {
+				t.Errorf("expected the balance to be rolled back, got %+v", balance)
+			}
+
+			if err := store.SaveAccountBalance(ctx, model.AccountBalance{AccountID: "acc", Version: 1}); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := store.SaveAccountBalance(ctx, model.AccountBalance{AccountID: "acc", Version: 1}); !errors.Is(err, errStaleAccountBalance) {
+				t.Errorf("expected a stale balance, got %v", err)
+			}
+			if err := store.SaveAccountBalance(ctx, model.AccountBalance{AccountID: "acc", Version: 3}); !errors.Is(err, errStaleAccountBalance) {
+				t.Errorf("expected a stale balance, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSQL_Times(t *testing.T) {
+	for name, store := range sqlStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			// written in different zones, the deliveries still have to be claimed in the order they fall due
+			due := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+			for _, delivery := range []model.WebhookDelivery{
+				{SubscriptionID: "sub", EventID: "second", NextAttemptAt: due.Add(-time.Minute).In(time.FixedZone("UTC-5", -5*3600))},
+				{SubscriptionID: "sub", EventID: "first", NextAttemptAt: due.Add(-time.Hour).In(time.FixedZone("UTC+9", 9*3600))},
+				{SubscriptionID: "sub", EventID: "late", NextAttemptAt: due.Add(time.Nanosecond)},
+				{SubscriptionID: "sub", EventID: "first", NextAttemptAt: due.Add(-2 * time.Hour)},
+			} {
+				delivery.Status = model.WebhookDeliveryPending
+				if err := store.CreateWebhookDelivery(ctx, delivery); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			leaseUntil := due.Add(30 * time.Second)
+			claimed, err := store.ClaimDueWebhookDeliveries(ctx, due, leaseUntil, 10)
+			if err != nil || len(claimed) != 2 || claimed[0].EventID != "first" || claimed[1].EventID != "second" {
+				t.Fatalf("expected the two due deliveries oldest first, got %+v, %v", claimed, err)
+			}
+			stored, err := store.GetWebhookDelivery(ctx, claimed[0].ID.Hex())
+			if err != nil || !stored.NextAttemptAt.Equal(leaseUntil) || stored.NextAttemptAt.Location() != time.UTC {
+				t.Errorf("expected the delivery to be leased until %v in UTC, got %+v, %v", leaseUntil, stored, err)
+			}
+			if again, _ := store.ClaimDueWebhookDeliveries(ctx, due, leaseUntil, 10); len(again) != 0 {
+				t.Errorf("expected leased deliveries not to be claimed again, got %+v", again)
+			}
+
+			// an attempt is only recorded under the lease it was claimed with
+			attempted := claimed[0]
+			attempted.Attempts++
+			if err := store.UpdateWebhookDelivery(ctx, attempted.ID.Hex(), attempted, due); !errors.Is(err, repository.ErrConflict) {
+				t.Errorf("expected %v under another lease, got %v", repository.ErrConflict, err)
+			}
+			if err := store.UpdateWebhookDelivery(ctx, attempted.ID.Hex(), attempted, leaseUntil.In(time.FixedZone("UTC+9", 9*3600))); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if err := store.UpdateWebhookDelivery(ctx, bson.NewObjectID().Hex(), attempted, leaseUntil); !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("expected %v, got %v", repository.ErrNotFound, err)
+			}
+		})
+	}
+}
+
+func TestSQL_Journal(t *testing.T) {
+	for name, store := range sqlStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, entry := range []model.JournalEntry{
+				{TransactionID: "payment", AccountID: "acc", PostedAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), Lines: []model.JournalLine{
+					{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 500},
+					{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 500, AppliesTo: "purchase"},
+				}},
+				{TransactionID: "purchase", AccountID: "acc", PostedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Lines: []model.JournalLine{
+					{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 1000, AppliesTo: "purchase"},
+					{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 1000},
+				}},
+			} {
+				if err := store.PostJournalEntry(ctx, entry); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			lines, err := store.TrialBalance(ctx)
+			if err != nil || len(lines) != 3 {
+				t.Fatalf("expected three ledger accounts, got %+v, %v", lines, err)
+			}
+			want := model.TrialBalanceLine{LedgerAccount: model.LedgerAccountReceivable, DebitCents: 1000, CreditCents: 500}
+			if lines[1] != want {
+				t.Errorf("expected %+v, got %+v", want, lines[1])
+			}
+
+			entries, err := store.FindJournalEntries(ctx, "acc", time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC))
+			if err != nil || len(entries) != 2 || entries[0].TransactionID != "purchase" || len(entries[1].Lines) != 2 {
+				t.Fatalf("expected both entries oldest first, got %+v, %v", entries, err)
+			}
+			if entries[1].Lines[1].AppliesTo != "purchase" {
+				t.Errorf("expected the lines in the order they were posted, got %+v", entries[1].Lines)
+			}
+		})
+	}
+}
+
+func TestSQLite_EventDateMigration(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "pismo.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	// a database left by a release that stored event dates as strings
+	initial, err := migrations.ReadFile("migrations/sqlite/0001_initial.sql")
+	if err != nil {
+		t.Fatalf("failed to read the initial migration: %v", err)
+	}
+	for _, statement := range []string{
+		`CREATE TABLE schema_migrations (version TEXT PRIMARY KEY, applied_at TEXT NOT NULL)`,
+		string(initial),
+		`INSERT INTO schema_migrations (version, applied_at) VALUES ('0001_initial', '2025-01-01T00:00:00Z')`,
+		`INSERT INTO transactions VALUES ('6777e6a0c0ffee0000000001', 'acc', 1, -10, '2025-01-02T10:00:00.123456789Z', -10, 'key-1')`,
+		`INSERT INTO transactions VALUES ('6777e6a0c0ffee0000000002', 'acc', 1, -10, '2025-01-02T10:00:00Z', -10, 'key-2')`,
+	} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			t.Fatalf("failed to set up the database: %v", err)
+		}
+	}
+
+	store := NewSQLite(db)
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	transactions, err := store.FindAllTransactionsForAccountID(ctx, "acc")
+	if err != nil || len(transactions) != 2 {
+		t.Fatalf("expected both transactions, got %+v, %v", transactions, err)
+	}
+	// as strings the one with a fraction of a second sorted first
+	if transactions[0].IdempotencyKey != "key-2" {
+		t.Errorf("expected the transaction on the whole second first, got %s", transactions[0].IdempotencyKey)
+	}
+	if want := time.Date(2025, 1, 2, 10, 0, 0, 123000000, time.UTC); transactions[1].EventDate != want {
+		t.Errorf("expected the event date to be converted to %v, got %v", want, transactions[1].EventDate)
+	}
+	// the public IDs are made at the time in the ObjectIDs so they sort like them
+	for _, tx := range transactions {
+		if !strings.HasPrefix(tx.PublicID, publicid.New(publicid.TransactionPrefix, time.Unix(0x6777e6a0, 0))[:14]) {
+			t.Errorf("expected a public ID made when the transaction was created, got %s", tx.PublicID)
+		}
+		if got, err := store.GetTransactionByID(ctx, tx.PublicID); err != nil || got == nil || got.ID != tx.ID {
+			t.Errorf("expected the transaction by its public ID, got %+v, %v", got, err)
+		}
+	}
+	// both were made before the journal
+	if entries, err := store.FindJournalEntries(ctx, "acc", transactions[1].EventDate); err != nil || len(entries) != 2 {
+		t.Errorf("expected an opening entry for each transaction, got %+v, %v", entries, err)
+	}
+}
+
+func TestSQL_Contract(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.DatabaseRepository {
+		return newTestSQLite(t)
+	})
+	repositorytest.RunLeases(t, func(t *testing.T) repository.LeaseRepository {
+		return newTestSQLite(t)
+	})
+	repositorytest.RunJobs(t, func(t *testing.T) repository.JobRepository {
+		return newTestSQLite(t)
+	})
+	repositorytest.RunBackups(t, func(t *testing.T) repositorytest.BackupStore {
+		return newTestSQLite(t)
+	})
+	repositorytest.RunJournalBackfill(t, func(t *testing.T) (repository.DatabaseRepository, func(ctx context.Context) error) {
+		store := newTestSQLite(t)
+		return store, func(ctx context.Context) error { return store.WithTransaction(ctx, store.backfillOpeningEntries) }
+	})
+	if postgresURL := os.Getenv("POSTGRES_URL"); postgresURL != "" {
+		t.Run("postgres", func(t *testing.T) {
+			repositorytest.Run(t, func(t *testing.T) repository.DatabaseRepository {
+				return newTestPostgres(t, postgresURL)
+			})
+			repositorytest.RunLeases(t, func(t *testing.T) repository.LeaseRepository {
+				return newTestPostgres(t, postgresURL)
+			})
+			repositorytest.RunJobs(t, func(t *testing.T) repository.JobRepository {
+				return newTestPostgres(t, postgresURL)
+			})
+			repositorytest.RunBackups(t, func(t *testing.T) repositorytest.BackupStore {
+				return newTestPostgres(t, postgresURL)
+			})
+			repositorytest.RunJournalBackfill(t, func(t *testing.T) (repository.DatabaseRepository, func(ctx context.Context) error) {
+				store := newTestPostgres(t, postgresURL)
+				return store, func(ctx context.Context) error { return store.WithTransaction(ctx, store.backfillOpeningEntries) }
+			})
+		})
+	}
+}
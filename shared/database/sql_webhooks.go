@@ -0,0 +1,278 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const webhookSubscriptionColumns = `id, url, event_types, secret, created_at, updated_at`
+
+func scanWebhookSubscription(row interface{ Scan(dest ...any) error }) (model.WebhookSubscription, error) {
+	var subscription model.WebhookSubscription
+	var id, eventTypes string
+	if err := row.Scan(&id, &subscription.URL, &eventTypes, &subscription.Secret, &subscription.CreatedAt, &subscription.UpdatedAt); err != nil {
+		return subscription, err
+	}
+	var err error
+	if subscription.ID, err = scanID(id); err != nil {
+		return subscription, err
+	}
+	subscription.CreatedAt = subscription.CreatedAt.UTC()
+	subscription.UpdatedAt = subscription.UpdatedAt.UTC()
+	if err := json.Unmarshal([]byte(eventTypes), &subscription.EventTypes); err != nil {
+		return subscription, fmt.Errorf("failed to decode event types: %w", err)
+	}
+	return subscription, nil
+}
+
+func (s *SQL) CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (*model.WebhookSubscription, error) {
+	subscription.ID = bson.NewObjectID()
+	eventTypes, err := json.Marshal(subscription.EventTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event types: %w", err)
+	}
+	if _, err := s.exec(ctx, `INSERT INTO webhook_subscriptions (`+webhookSubscriptionColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		subscription.ID.Hex(), subscription.URL, string(eventTypes), subscription.Secret, subscription.CreatedAt,
+		subscription.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+	return &subscription, nil
+}
+
+func (s *SQL) GetWebhookSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscription, error) {
+	id, err := bson.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return nil, nil
+	}
+	subscription, err := scanWebhookSubscription(s.queryRow(ctx,
+		`SELECT `+webhookSubscriptionColumns+` FROM webhook_subscriptions WHERE id = ?`, id.Hex()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	return &subscription, nil
+}
+
+func (s *SQL) ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	rows, err := s.query(ctx, `SELECT `+webhookSubscriptionColumns+` FROM webhook_subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []model.WebhookSubscription
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode webhook subscriptions: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+func (s *SQL) UpdateWebhookSubscription(ctx context.Context, subscriptionID string, subscription model.WebhookSubscription) error {
+	id, err := bson.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return err
+	}
+	eventTypes, err := json.Marshal(subscription.EventTypes)
+	if err != nil {
+		return fmt.Errorf("failed to encode event types: %w", err)
+	}
+	result, err := s.exec(ctx, `UPDATE webhook_subscriptions SET url = ?, event_types = ?, secret = ?, created_at = ?, updated_at = ?
+		WHERE id = ?`,
+		subscription.URL, string(eventTypes), subscription.Secret, subscription.CreatedAt, subscription.UpdatedAt, id.Hex())
+	if err != nil {
+		return fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+	return matched(result, fmt.Errorf("webhook subscription %s: %w", id.Hex(), repository.ErrNotFound))
+}
+
+func (s *SQL) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	id, err := bson.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return err
+	}
+	result, err := s.exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = ?`, id.Hex())
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	return matched(result, fmt.Errorf("webhook subscription %s: %w", id.Hex(), repository.ErrNotFound))
+}
+
+const webhookDeliveryColumns = `id, subscription_id, event_id, event_type, body, status, attempts, next_attempt_at, last_error,
+	last_status_code, created_at, delivered_at`
+
+func scanWebhookDelivery(row interface{ Scan(dest ...any) error }) (model.WebhookDelivery, error) {
+	var delivery model.WebhookDelivery
+	var id, body string
+	var deliveredAt sql.NullTime
+	if err := row.Scan(&id, &delivery.SubscriptionID, &delivery.EventID, &delivery.EventType, &body, &delivery.Status,
+		&delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastError, &delivery.LastStatusCode, &delivery.CreatedAt,
+		&deliveredAt); err != nil {
+		return delivery, err
+	}
+	var err error
+	if delivery.ID, err = scanID(id); err != nil {
+		return delivery, err
+	}
+	delivery.Body = []byte(body)
+	delivery.NextAttemptAt = delivery.NextAttemptAt.UTC()
+	delivery.CreatedAt = delivery.CreatedAt.UTC()
+	if deliveredAt.Valid {
+		deliveredAt.Time = deliveredAt.Time.UTC()
+	}
+	delivery.DeliveredAt = timePtr(deliveredAt)
+	return delivery, nil
+}
+
+func (s *SQL) scanWebhookDeliveries(rows *sql.Rows) ([]model.WebhookDelivery, error) {
+	defer rows.Close()
+	var deliveries []model.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode webhook deliveries: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (s *SQL) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	if delivery.ID.IsZero() {
+		delivery.ID = bson.NewObjectID()
+	}
+	if _, err := s.exec(ctx, `INSERT INTO webhook_deliveries (`+webhookDeliveryColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (subscription_id, event_id) DO NOTHING`,
+		delivery.ID.Hex(), delivery.SubscriptionID, delivery.EventID, delivery.EventType, string(delivery.Body),
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastError, delivery.LastStatusCode,
+		delivery.CreatedAt, nullTimePtr(delivery.DeliveredAt)); err != nil {
+		return fmt.Errorf("failed to create webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) GetWebhookDelivery(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error) {
+	id, err := bson.ObjectIDFromHex(deliveryID)
+	if err != nil {
+		return nil, nil
+	}
+	delivery, err := scanWebhookDelivery(s.queryRow(ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id.Hex()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+	return &delivery, nil
+}
+
+func (s *SQL) ListWebhookDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter, limit int) ([]model.WebhookDelivery, error) {
+	var where []string
+	var args []any
+	if filter.SubscriptionID != "" {
+		where = append(where, "subscription_id = ?")
+		args = append(args, filter.SubscriptionID)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	rows, err := s.query(ctx, query+` ORDER BY id LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return s.scanWebhookDeliveries(rows)
+}
+
+// ClaimDueWebhookDeliveries leases the due deliveries in one transaction, the update only matches a delivery that is
+// still due so one another dispatcher claimed first is skipped
+func (s *SQL) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	err := s.WithTransaction(ctx, func(ctx context.Context) error {
+		deliveries = nil
+		rows, err := s.query(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?`,
+			model.WebhookDeliveryPending, now, limit)
+		if err != nil {
+			return err
+		}
+		due, err := s.scanWebhookDeliveries(rows)
+		if err != nil {
+			return err
+		}
+		for _, delivery := range due {
+			result, err := s.exec(ctx, `UPDATE webhook_deliveries SET next_attempt_at = ?
+				WHERE id = ? AND status = ? AND next_attempt_at <= ?`,
+				leaseUntil, delivery.ID.Hex(), model.WebhookDeliveryPending, now)
+			if err != nil {
+				return err
+			}
+			if claimed, err := result.RowsAffected(); err != nil {
+				return err
+			} else if claimed == 0 {
+				continue
+			}
+			delivery.NextAttemptAt = leaseUntil
+			deliveries = append(deliveries, delivery)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (s *SQL) UpdateWebhookDelivery(ctx context.Context, deliveryID string, delivery model.WebhookDelivery, expectedNextAttemptAt time.Time) error {
+	id, err := bson.ObjectIDFromHex(deliveryID)
+	if err != nil {
+		return err
+	}
+	result, err := s.exec(ctx, `UPDATE webhook_deliveries SET subscription_id = ?, event_id = ?, event_type = ?, body = ?,
+		status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, last_status_code = ?, created_at = ?, delivered_at = ?
+		WHERE id = ? AND next_attempt_at = ?`,
+		delivery.SubscriptionID, delivery.EventID, delivery.EventType, string(delivery.Body), delivery.Status,
+		delivery.Attempts, delivery.NextAttemptAt, delivery.LastError, delivery.LastStatusCode, delivery.CreatedAt,
+		nullTimePtr(delivery.DeliveredAt), id.Hex(), expectedNextAttemptAt)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	err = matched(result, fmt.Errorf("webhook delivery %s is no longer due at %v: %w", id.Hex(), expectedNextAttemptAt, repository.ErrConflict))
+	if !errors.Is(err, repository.ErrConflict) {
+		return err
+	}
+	// either the delivery is gone or it was claimed since it was read
+	var n int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM webhook_deliveries WHERE id = ?`, id.Hex()).Scan(&n); err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("webhook delivery %s: %w", id.Hex(), repository.ErrNotFound)
+	}
+	return err
+}
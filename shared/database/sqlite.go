@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var sqliteDialect = dialect{
+	name: "sqlite",
+	duplicate: func(err error) bool {
+		code := sqliteErrorCode(err)
+		return code == sqlite3.SQLITE_CONSTRAINT_UNIQUE || code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	},
+	retryable: func(err error) bool {
+		// the extended codes keep the primary code in their low byte
+		return sqliteErrorCode(err)&0xff == sqlite3.SQLITE_BUSY
+	},
+}
+
+func sqliteErrorCode(err error) int {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code()
+	}
+	return 0
+}
+
+// OpenSQLite opens the database file at path, creating it if needed. The file is shared between connections in WAL mode,
+// transactions take the write lock when they begin so two of them never deadlock upgrading to it, and times are
+// stored as Unix nanoseconds so they sort and compare as numbers whatever zone they were written in
+func OpenSQLite(path string) (*sql.DB, error) {
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Set("_txlock", "immediate")
+	params.Set("_time_integer_format", "unix_nano")
+	params.Set("_inttotime", "1")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewSQLite returns a store on a database opened with OpenSQLite, call Migrate before using it
+func NewSQLite(db *sql.DB) *SQL {
+	return &SQL{
+		db:      db,
+		dialect: sqliteDialect,
+	}
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (m *MongoDB) CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (*model.WebhookSubscription, error) {
+	result, err := m.collection(ctx, m.config.Collections.WebhookSubscriptions).InsertOne(ctx, subscription)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook subscription: %w", mongoErr(err))
+	}
+	subscription.ID = result.InsertedID.(bson.ObjectID)
+	return &subscription, nil
+}
+
+func (m *MongoDB) GetWebhookSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscription, error) {
+	id, err := bson.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return nil, nil
+	}
+	var subscription model.WebhookSubscription
+	err = m.collection(ctx, m.config.Collections.WebhookSubscriptions).FindOne(ctx, bson.M{"_id": id}).Decode(&subscription)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", mongoErr(err))
+	}
+	return &subscription, nil
+}
+
+func (m *MongoDB) ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := m.collection(ctx, m.config.Collections.WebhookSubscriptions).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", mongoErr(err))
+	}
+	var subscriptions []model.WebhookSubscription
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook subscriptions: %w", mongoErr(err))
+	}
+	return subscriptions, nil
+}
+
+func (m *MongoDB) UpdateWebhookSubscription(ctx context.Context, subscriptionID string, subscription model.WebhookSubscription) error {
+	id, err := bson.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return err
+	}
+	result, err := m.collection(ctx, m.config.Collections.WebhookSubscriptions).
+		UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": subscription})
+	if err != nil {
+		return fmt.Errorf("failed to update webhook subscription: %w", mongoErr(err))
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("webhook subscription %s: %w", id.Hex(), repository.ErrNotFound)
+	}
+	return nil
+}
+
+func (m *MongoDB) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	id, err := bson.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return err
+	}
+	result, err := m.collection(ctx, m.config.Collections.WebhookSubscriptions).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", mongoErr(err))
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("webhook subscription %s: %w", id.Hex(), repository.ErrNotFound)
+	}
+	return nil
+}
+
+func (m *MongoDB) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	filter := bson.M{"subscription_id": delivery.SubscriptionID, "event_id": delivery.EventID}
+	_, err := m.collection(ctx, m.config.Collections.WebhookDeliveries).
+		UpdateOne(ctx, filter, bson.M{"$setOnInsert": delivery}, options.UpdateOne().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook delivery: %w", mongoErr(err))
+	}
+	return nil
+}
+
+func (m *MongoDB) GetWebhookDelivery(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error) {
+	id, err := bson.ObjectIDFromHex(deliveryID)
+	if err != nil {
+		return nil, nil
+	}
+	var delivery model.WebhookDelivery
+	err = m.collection(ctx, m.config.Collections.WebhookDeliveries).FindOne(ctx, bson.M{"_id": id}).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", mongoErr(err))
+	}
+	return &delivery, nil
+}
+
+func (m *MongoDB) ListWebhookDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter, limit int) ([]model.WebhookDelivery, error) {
+	query := bson.M{}
+	if filter.SubscriptionID != "" {
+		query["subscription_id"] = filter.SubscriptionID
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := m.collection(ctx, m.config.Collections.WebhookDeliveries).Find(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", mongoErr(err))
+	}
+	var deliveries []model.WebhookDelivery
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook deliveries: %w", mongoErr(err))
+	}
+	return deliveries, nil
+}
+
+// ClaimDueWebhookDeliveries claims deliveries one at a time with FindOneAndUpdate, the filter on next_attempt_at means a
+// delivery another dispatcher has just claimed no longer matches
+func (m *MongoDB) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	collection := m.collection(ctx, m.config.Collections.WebhookDeliveries)
+	filter := bson.M{
+		"status":          model.WebhookDeliveryPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var deliveries []model.WebhookDelivery
+	for len(deliveries) < limit {
+		var delivery model.WebhookDelivery
+		err := collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"next_attempt_at": leaseUntil}}, opts).Decode(&delivery)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return deliveries, fmt.Errorf("failed to claim webhook delivery: %w", mongoErr(err))
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (m *MongoDB) UpdateWebhookDelivery(ctx context.Context, deliveryID string, delivery model.WebhookDelivery, expectedNextAttemptAt time.Time) error {
+	id, err := bson.ObjectIDFromHex(deliveryID)
+	if err != nil {
+		return err
+	}
+	delivery.ID = id
+	collection := m.collection(ctx, m.config.Collections.WebhookDeliveries)
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": id, "next_attempt_at": expectedNextAttemptAt}, delivery)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", mongoErr(err))
+	}
+	if result.MatchedCount == 0 {
+		// either the delivery is gone or it was claimed since it was read
+		n, err := collection.CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return fmt.Errorf("failed to update webhook delivery: %w", mongoErr(err))
+		}
+		if n == 0 {
+			return fmt.Errorf("webhook delivery %s: %w", id.Hex(), repository.ErrNotFound)
+		}
+		return fmt.Errorf("webhook delivery %s is no longer due at %v: %w", id.Hex(), expectedNextAttemptAt, repository.ErrConflict)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported export format")
+
+// csvHeader is the column order of a CSV export, it matches the json tags of model.TransactionExportRecord
+var csvHeader = []string{"transaction_id", "account_id", "operation_type_id", "operation_type", "amount", "balance", "event_date"}
+
+// ContentType returns the media type written in the Content-Type header for the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// NegotiateFormat picks the export format, an explicit format query parameter wins over the Accept header.
+// When neither is given CSV is returned as that is what most accounting tools import
+func NegotiateFormat(queryFormat string, accept string) (Format, error) {
+	if queryFormat != "" {
+		switch Format(strings.ToLower(strings.TrimSpace(queryFormat))) {
+		case FormatCSV:
+			return FormatCSV, nil
+		case FormatNDJSON:
+			return FormatNDJSON, nil
+		default:
+			return "", ErrUnsupportedFormat
+		}
+	}
+	if accept == "" {
+		return FormatCSV, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/csv":
+			return FormatCSV, nil
+		case "application/x-ndjson", "application/ndjson", "application/jsonl":
+			return FormatNDJSON, nil
+		case "*/*", "text/*":
+			return FormatCSV, nil
+		}
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Encoder writes transactions one at a time, nothing is held in memory beyond the underlying write buffer
+type Encoder interface {
+	Encode(tx model.Transaction) error
+	Flush() error
+}
+
+func NewEncoder(format Format, w io.Writer) (Encoder, error) {
+	switch format {
+	case FormatCSV:
+		return &csvEncoder{writer: csv.NewWriter(w)}, nil
+	case FormatNDJSON:
+		buf := bufio.NewWriter(w)
+		return &ndjsonEncoder{buf: buf, encoder: json.NewEncoder(buf)}, nil
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// NewRecord maps a stored transaction to its export representation
+func NewRecord(tx model.Transaction) model.TransactionExportRecord {
+	return model.TransactionExportRecord{
+		TransactionID: tx.PublicID,
+		AccountID:     tx.AccountID,
+		OperationID:   tx.OperationID,
+		OperationType: tx.OperationID.String(),
+		Amount:        tx.Amount,
+		Balance:       tx.Balance,
+		EventDate:     tx.EventDate,
+	}
+}
+
+type csvEncoder struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (e *csvEncoder) Encode(tx model.Transaction) error {
+	if !e.headerWritten {
+		if err := e.writer.Write(csvHeader); err != nil {
+			return err
+		}
+		e.headerWritten = true
+	}
+	record := NewRecord(tx)
+	return e.writer.Write([]string{
+		record.TransactionID,
+		record.AccountID,
+		strconv.Itoa(int(record.OperationID)),
+		record.OperationType,
+		strconv.FormatFloat(record.Amount, 'f', 2, 64),
+		strconv.FormatFloat(record.Balance, 'f', 2, 64),
+		record.EventDate.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// Flush writes any buffered rows, an export with no rows still gets its header so the file is importable
+func (e *csvEncoder) Flush() error {
+	if !e.headerWritten {
+		if err := e.writer.Write(csvHeader); err != nil {
+			return err
+		}
+		e.headerWritten = true
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type ndjsonEncoder struct {
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(tx model.Transaction) error {
+	return e.encoder.Encode(NewRecord(tx))
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return e.buf.Flush()
+}
@@ -0,0 +1,120 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		queryFormat string
+		accept      string
+		expected    Format
+		expectedErr error
+	}{
+		{name: "Defaults to csv", expected: FormatCSV},
+		{name: "Query parameter wins over Accept", queryFormat: "NDJSON", accept: "text/csv", expected: FormatNDJSON},
+		{name: "Accept header csv", accept: "text/csv; charset=utf-8", expected: FormatCSV},
+		{name: "Accept header ndjson", accept: "application/json;q=0.9, application/x-ndjson", expected: FormatNDJSON},
+		{name: "Accept any", accept: "*/*", expected: FormatCSV},
+		{name: "Unknown query format", queryFormat: "xlsx", expectedErr: ErrUnsupportedFormat},
+		{name: "Unknown Accept header", accept: "application/pdf", expectedErr: ErrUnsupportedFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := NegotiateFormat(tt.queryFormat, tt.accept)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if format != tt.expected {
+				t.Errorf("expected format %q, got %q", tt.expected, format)
+			}
+		})
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	txs := []model.Transaction{
+		{ID: bson.NewObjectID(), PublicID: publicid.NewTransactionID(), AccountID: "acc-1", OperationID: model.OperationTypePurchase, Amount: -50, Balance: 0, EventDate: time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{ID: bson.NewObjectID(), PublicID: publicid.NewTransactionID(), AccountID: "acc-1", OperationID: model.OperationTypePayment, Amount: 60, Balance: 10, EventDate: time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)},
+	}
+
+	t.Run("CSV writes a header and one row per transaction", func(t *testing.T) {
+		var buf bytes.Buffer
+		encoder, err := NewEncoder(FormatCSV, &buf)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, tx := range txs {
+			if err := encoder.Encode(tx); err != nil {
+				t.Fatalf("expected no error encoding, got %v", err)
+			}
+		}
+		if err := encoder.Flush(); err != nil {
+			t.Fatalf("expected no error flushing, got %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("expected 3 lines, got %d", len(lines))
+		}
+		if lines[0] != "transaction_id,account_id,operation_type_id,operation_type,amount,balance,event_date" {
+			t.Errorf("unexpected header %q", lines[0])
+		}
+		if !strings.HasSuffix(lines[2], ",acc-1,4,PAYMENT,60.00,10.00,2025-01-02T10:00:00Z") {
+			t.Errorf("unexpected row %q", lines[2])
+		}
+	})
+
+	t.Run("CSV with no transactions still has a header", func(t *testing.T) {
+		var buf bytes.Buffer
+		encoder, _ := NewEncoder(FormatCSV, &buf)
+		if err := encoder.Flush(); err != nil {
+			t.Fatalf("expected no error flushing, got %v", err)
+		}
+		if !strings.HasPrefix(buf.String(), "transaction_id,") {
+			t.Errorf("expected header, got %q", buf.String())
+		}
+	})
+
+	t.Run("NDJSON writes one object per line", func(t *testing.T) {
+		var buf bytes.Buffer
+		encoder, err := NewEncoder(FormatNDJSON, &buf)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, tx := range txs {
+			if err := encoder.Encode(tx); err != nil {
+				t.Fatalf("expected no error encoding, got %v", err)
+			}
+		}
+		if err := encoder.Flush(); err != nil {
+			t.Fatalf("expected no error flushing, got %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 lines, got %d", len(lines))
+		}
+		var record model.TransactionExportRecord
+		if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+			t.Fatalf("expected no error decoding line, got %v", err)
+		}
+		if record.TransactionID != txs[0].PublicID || record.Amount != -50 || record.OperationType != "PURCHASE" {
+			t.Errorf("unexpected record %+v", record)
+		}
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		if _, err := NewEncoder("xml", &bytes.Buffer{}); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package json_handler
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/joolshouston/pismo-technical-test/shared/model"
 )
@@ -21,6 +22,9 @@ func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
 
 func WriteError(w http.ResponseWriter, error *model.ErrorResponse) {
 	w.Header().Set("Content-Type", "application/json")
+	if error.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(error.RetryAfter))
+	}
 	w.WriteHeader(error.Status)
 
 	if err := json.NewEncoder(w).Encode(error); err != nil {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/joolshouston/pismo-technical-test/shared/model"
@@ -244,3 +245,20 @@ func TestWriteError(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteErrorRetryAfter(t *testing.T) {
+	resp := httptest.NewRecorder()
+	WriteError(resp, &model.ErrorResponse{Message: "database unavailable", Status: http.StatusServiceUnavailable, RetryAfter: 2})
+	if resp.Code != http.StatusServiceUnavailable || resp.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected a 503 with Retry-After 2, got %d with %q", resp.Code, resp.Header().Get("Retry-After"))
+	}
+	if strings.Contains(resp.Body.String(), "retry") {
+		t.Errorf("expected the wait to only be sent as a header, got %s", resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	WriteError(resp, &model.ErrorResponse{Message: "failed", Status: http.StatusInternalServerError})
+	if resp.Header().Get("Retry-After") != "" {
+		t.Errorf("expected no Retry-After, got %q", resp.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,141 @@
+package ledger
+
+import (
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// NewAccountBalance builds the projection of an account from its stored transactions, oldest first. It is how the
+// projection is created for accounts that had transactions before it existed and rebuilt after a repair. The version is
+// left at zero for the caller to set
+func NewAccountBalance(accountID string, transactions []model.Transaction) model.AccountBalance {
+	balance := model.AccountBalance{AccountID: accountID, OpenDebts: []model.OpenDebt{}}
+	for _, tx := range transactions {
+		cents := Cents(tx.Balance)
+		switch {
+		case cents < 0:
+			balance.OpenDebts = append(balance.OpenDebts, model.OpenDebt{TransactionID: tx.ID.Hex(), BalanceCents: cents})
+			balance.TotalDebtCents -= cents
+		case cents > 0:
+			balance.AvailableCreditCents += cents
+		}
+		if tx.EventDate.After(balance.LastTransactionAt) {
+			balance.LastTransactionAt = tx.EventDate
+		}
+	}
+	return balance
+}
+
+// Debts returns the open debts in the shape Allocate takes them
+func Debts(balance model.AccountBalance) []model.Transaction {
+	debts := make([]model.Transaction, 0, len(balance.OpenDebts))
+	for _, debt := range balance.OpenDebts {
+		id, err := bson.ObjectIDFromHex(debt.TransactionID)
+		if err != nil {
+			continue
+		}
+		debts = append(debts, model.Transaction{ID: id, Balance: Amount(debt.BalanceCents)})
+	}
+	return debts
+}
+
+// Apply moves the projection on by one transaction: the allocations of a payment are taken off the debts they were made
+// to, a debt is opened for a purchase or withdrawal and whatever is left of a payment becomes available credit. The
+// version is bumped so the write can be checked against the version that was read
+func Apply(balance *model.AccountBalance, transactionID string, tx model.Transaction, allocations []Allocation, at time.Time) {
+	allocated := make(map[string]Allocation, len(allocations))
+	for _, allocation := range allocations {
+		allocated[allocation.TransactionID] = allocation
+		balance.TotalDebtCents -= allocation.AmountCents
+	}
+	open := make([]model.OpenDebt, 0, len(balance.OpenDebts)+1)
+	for _, debt := range balance.OpenDebts {
+		if allocation, ok := allocated[debt.TransactionID]; ok {
+			debt.BalanceCents = allocation.Balance
+		}
+		if debt.BalanceCents < 0 {
+			open = append(open, debt)
+		}
+	}
+	switch cents := Cents(tx.Balance); {
+	case cents < 0:
+		open = append(open, model.OpenDebt{TransactionID: transactionID, BalanceCents: cents})
+		balance.TotalDebtCents -= cents
+	case cents > 0:
+		balance.AvailableCreditCents += cents
+	}
+	balance.OpenDebts = open
+	balance.LastTransactionAt = at
+	balance.Version++
+}
+
+// BalanceAsOf works out what the account owed from its journal entries posted up to asOf. Receivable lines carry the
+// debt they apply to, so the debits say how much each debt was for and the credits how much of it had been paid off
+// by then. A debt made before the journal that has not been given its opening entry has payment credits but no debit,
+// its amount is then taken from its transaction. Nothing is ever owed below zero, a debt paid beyond its amount counts
+// as paid off
+func BalanceAsOf(accountID string, entries []model.JournalEntry, transactions []model.Transaction, asOf time.Time) model.AccountBalanceResponseBody {
+	resp := model.AccountBalanceResponseBody{AccountID: accountID, AsOf: &asOf, Debts: []model.Debt{}}
+	type debt struct{ amount, paid int64 }
+	var (
+		order  []string
+		debts  = make(map[string]*debt)
+		total  int64
+		credit int64
+	)
+	for _, entry := range entries {
+		if entry.PostedAt.After(asOf) {
+			continue
+		}
+		for _, line := range entry.Lines {
+			switch {
+			case line.LedgerAccount == model.LedgerAccountReceivable:
+				d, ok := debts[line.AppliesTo]
+				if !ok {
+					d = &debt{}
+					debts[line.AppliesTo] = d
+					order = append(order, line.AppliesTo)
+				}
+				if line.Side == model.EntrySideDebit {
+					d.amount += line.AmountCents
+				} else {
+					d.paid += line.AmountCents
+				}
+			case line.LedgerAccount == model.LedgerAccountCustomerCredit && line.Side == model.EntrySideCredit:
+				credit += line.AmountCents
+			case line.LedgerAccount == model.LedgerAccountCustomerCredit:
+				credit -= line.AmountCents
+			}
+		}
+		postedAt := entry.PostedAt
+		resp.LastTransactionAt = &postedAt
+	}
+	amounts := make(map[string]int64, len(transactions))
+	for _, tx := range transactions {
+		if !tx.EventDate.After(asOf) {
+			amounts[tx.ID.Hex()] = -Cents(tx.Amount)
+		}
+	}
+	for _, transactionID := range order {
+		d := debts[transactionID]
+		if d.amount == 0 {
+			d.amount = amounts[transactionID]
+		}
+		owed := max(d.amount-d.paid, 0)
+		resp.Debts = append(resp.Debts, model.Debt{
+			TransactionID: transactionID,
+			Amount:        Amount(-d.amount),
+			Paid:          Amount(d.paid),
+			Balance:       Amount(-owed),
+		})
+		if owed > 0 {
+			resp.OpenDebts++
+			total += owed
+		}
+	}
+	resp.TotalDebt = Amount(total)
+	resp.AvailableCredit = Amount(credit)
+	return resp
+}
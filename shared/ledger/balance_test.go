@@ -0,0 +1,113 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestNewAccountBalance(t *testing.T) {
+	debt := bson.NewObjectID()
+	balance := NewAccountBalance("acc-1", []model.Transaction{
+		{ID: bson.NewObjectID(), Balance: 0, EventDate: time.Date(2025, 1, 10, 10, 0, 0, 0, time.UTC)},
+		{ID: debt, Balance: -12.3, EventDate: time.Date(2025, 1, 20, 10, 0, 0, 0, time.UTC)},
+		{ID: bson.NewObjectID(), Balance: 5, EventDate: time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)},
+	})
+
+	if balance.TotalDebtCents != 1230 || balance.AvailableCreditCents != 500 || balance.Version != 0 {
+		t.Errorf("unexpected balance %+v", balance)
+	}
+	if len(balance.OpenDebts) != 1 || balance.OpenDebts[0] != (model.OpenDebt{TransactionID: debt.Hex(), BalanceCents: -1230}) {
+		t.Errorf("unexpected open debts %+v", balance.OpenDebts)
+	}
+	if !balance.LastTransactionAt.Equal(time.Date(2025, 1, 20, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the latest event date, got %v", balance.LastTransactionAt)
+	}
+}
+
+func TestApply(t *testing.T) {
+	first, second, third := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+	balance := model.AccountBalance{
+		AccountID:      "acc-1",
+		TotalDebtCents: 7000,
+		Version:        2,
+		OpenDebts: []model.OpenDebt{
+			{TransactionID: first.Hex(), BalanceCents: -5000},
+			{TransactionID: second.Hex(), BalanceCents: -2000},
+		},
+	}
+	at := time.Date(2025, 2, 1, 10, 0, 0, 0, time.UTC)
+
+	Apply(&balance, third.Hex(), model.Transaction{OperationID: model.OperationTypePurchase, Amount: -10, Balance: -10}, nil, at)
+	if balance.TotalDebtCents != 8000 || len(balance.OpenDebts) != 3 || balance.OpenDebts[2].TransactionID != third.Hex() || balance.Version != 3 {
+		t.Fatalf("expected the purchase to open a debt, got %+v", balance)
+	}
+
+	allocations, unapplied := Allocate(9000, Debts(balance))
+	Apply(&balance, bson.NewObjectID().Hex(), model.Transaction{OperationID: model.OperationTypePayment, Amount: 90, Balance: Amount(unapplied)}, allocations, at)
+	if balance.TotalDebtCents != 0 || balance.AvailableCreditCents != 1000 || len(balance.OpenDebts) != 0 || balance.Version != 4 {
+		t.Errorf("expected the payment to settle every debt with credit left over, got %+v", balance)
+	}
+	if !balance.LastTransactionAt.Equal(at) {
+		t.Errorf("expected the last transaction time to move on, got %v", balance.LastTransactionAt)
+	}
+}
+
+func TestBalanceAsOf(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 10, 0, 0, 0, time.UTC) }
+	purchase, err := NewTransactionEntry("purchase", model.Transaction{OperationID: model.OperationTypePurchase, Amount: -50}, nil, day(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := NewTransactionEntry("payment", model.Transaction{OperationID: model.OperationTypePayment, Amount: 60},
+		[]Allocation{{TransactionID: "purchase", AmountCents: 5000}}, day(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []model.JournalEntry{purchase, payment}
+
+	before := BalanceAsOf("acc-1", entries, nil, day(15))
+	if before.TotalDebt != 50 || before.AvailableCredit != 0 || before.OpenDebts != 1 || !before.LastTransactionAt.Equal(day(10)) {
+		t.Errorf("unexpected balance before the payment %+v", before)
+	}
+
+	after := BalanceAsOf("acc-1", entries, nil, day(25))
+	if after.TotalDebt != 0 || after.AvailableCredit != 10 || after.OpenDebts != 0 {
+		t.Errorf("unexpected balance after the payment %+v", after)
+	}
+	if len(after.Debts) != 1 || after.Debts[0] != (model.Debt{TransactionID: "purchase", Amount: -50, Paid: 50, Balance: 0}) {
+		t.Errorf("expected the purchase to be paid off, got %+v", after.Debts)
+	}
+
+	if empty := BalanceAsOf("acc-1", entries, nil, day(1)); empty.TotalDebt != 0 || empty.LastTransactionAt != nil || empty.Debts == nil {
+		t.Errorf("expected nothing owed before the first transaction, got %+v", empty)
+	}
+}
+
+func TestBalanceAsOfDebtMadeBeforeTheJournal(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 10, 0, 0, 0, time.UTC) }
+	debt := bson.NewObjectID()
+	// the purchase has no entry of its own, only the payment made once the journal was there is posted against it
+	payment, err := NewTransactionEntry("payment", model.Transaction{OperationID: model.OperationTypePayment, Amount: 20},
+		[]Allocation{{TransactionID: debt.Hex(), AmountCents: 2000}}, day(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []model.JournalEntry{payment}
+	transactions := []model.Transaction{{ID: debt, OperationID: model.OperationTypePurchase, Amount: -50, EventDate: day(10)}}
+
+	got := BalanceAsOf("acc-1", entries, transactions, day(25))
+	if got.TotalDebt != 30 || got.OpenDebts != 1 {
+		t.Errorf("expected the debt's amount to come from its transaction, got %+v", got)
+	}
+	if len(got.Debts) != 1 || got.Debts[0] != (model.Debt{TransactionID: debt.Hex(), Amount: -50, Paid: 20, Balance: -30}) {
+		t.Errorf("unexpected debts %+v", got.Debts)
+	}
+
+	// without the transaction nothing says what the debt was for, it is never owed below zero
+	if got := BalanceAsOf("acc-1", entries, nil, day(25)); got.TotalDebt != 0 || got.OpenDebts != 0 || got.Debts[0].Balance != 0 {
+		t.Errorf("expected nothing owed, got %+v", got)
+	}
+}
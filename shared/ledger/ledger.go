@@ -0,0 +1,261 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+// ErrUnbalanced is returned for an entry whose debits do not add up to its credits
+var ErrUnbalanced = errors.New("journal entry is not balanced")
+
+// Cents converts an amount to whole cents, rounding half away from zero so float noise such as 0.1+0.2 does not leak in
+func Cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// Amount converts cents back to the float amounts used by the API
+func Amount(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// Allocation is part of a payment applied to one debt
+type Allocation struct {
+	TransactionID   string
+	AmountCents     int64
+	PreviousBalance int64 // balance of the debt before the allocation, negative while something is owed
+	Balance         int64 // balance of the debt after the allocation
+}
+
+// Allocate applies a payment to the debts in the order given, each debt is paid off in full before moving on to the
+// next. Debts are transactions with a negative balance, anything else is skipped. What is left once every debt has been
+// paid off is returned as unapplied
+func Allocate(paymentCents int64, debts []model.Transaction) (allocations []Allocation, unapplied int64) {
+	remaining := paymentCents
+	for _, debt := range debts {
+		if remaining <= 0 {
+			break
+		}
+		owed := -Cents(debt.Balance)
+		if owed <= 0 {
+			continue
+		}
+		amount := min(remaining, owed)
+		allocations = append(allocations, Allocation{
+			TransactionID:   debt.ID.Hex(),
+			AmountCents:     amount,
+			PreviousBalance: -owed,
+			Balance:         amount - owed,
+		})
+		remaining -= amount
+	}
+	return allocations, remaining
+}
+
+// NewTransactionEntry builds the posting for a newly created transaction. Purchases and withdrawals add to what the
+// customer owes, a payment takes cash in and credits the receivable of every debt it was allocated to, with anything
+// left over held as customer credit
+func NewTransactionEntry(transactionID string, tx model.Transaction, allocations []Allocation, postedAt time.Time) (model.JournalEntry, error) {
+	entry := model.JournalEntry{
+		TransactionID: transactionID,
+		AccountID:     tx.AccountID,
+		PostedAt:      postedAt,
+	}
+	amount := Cents(tx.Amount)
+	switch tx.OperationID {
+	case model.OperationTypePurchase, model.OperationTypeInstallmentPurchase:
+		entry.Lines = []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: -amount, AppliesTo: transactionID},
+			{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: -amount},
+		}
+	case model.OperationTypeWithdrawal:
+		entry.Lines = []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: -amount, AppliesTo: transactionID},
+			{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideCredit, AmountCents: -amount},
+		}
+	case model.OperationTypePayment:
+		entry.Lines = []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: amount},
+		}
+		allocated := int64(0)
+		for _, allocation := range allocations {
+			entry.Lines = append(entry.Lines, model.JournalLine{
+				LedgerAccount: model.LedgerAccountReceivable,
+				Side:          model.EntrySideCredit,
+				AmountCents:   allocation.AmountCents,
+				AppliesTo:     allocation.TransactionID,
+			})
+			allocated += allocation.AmountCents
+		}
+		if unapplied := amount - allocated; unapplied > 0 {
+			entry.Lines = append(entry.Lines, model.JournalLine{
+				LedgerAccount: model.LedgerAccountCustomerCredit,
+				Side:          model.EntrySideCredit,
+				AmountCents:   unapplied,
+				AppliesTo:     transactionID,
+			})
+		}
+	default:
+		return model.JournalEntry{}, fmt.Errorf("no posting rule for operation type %d", tx.OperationID)
+	}
+	if err := Validate(entry); err != nil {
+		return model.JournalEntry{}, err
+	}
+	return entry, nil
+}
+
+// NewOpeningEntry builds the posting that brings a transaction made before the journal was introduced into it, from its
+// amount and the balance stored on it. The transaction is posted as NewTransactionEntry would have posted it, and
+// whatever its stored balance says was paid off the debt, or allocated out of the payment, since is posted against the
+// opening balances account. Journalled is what entries posted since have already moved the transaction's balance by,
+// in cents as TransactionBalances gives it, a debt made before the journal can have been paid off by a payment made
+// after. It is posted at the transaction's event date, so a debt paid off before the journal shows as paid off from
+// when it was made
+func NewOpeningEntry(transactionID string, tx model.Transaction, journalled int64) (model.JournalEntry, error) {
+	// a payment is posted as if none of it had been allocated, so what was allocated comes out of its credit
+	entry, err := NewTransactionEntry(transactionID, tx, nil, tx.EventDate)
+	if err != nil {
+		return model.JournalEntry{}, err
+	}
+	ledgerAccount := model.LedgerAccountReceivable
+	if tx.OperationID == model.OperationTypePayment {
+		ledgerAccount = model.LedgerAccountCustomerCredit
+	}
+	entry.Lines = append(entry.Lines, openingLines(ledgerAccount, transactionID, Cents(tx.Balance)-Cents(tx.Amount)-journalled)...)
+	if err := Validate(entry); err != nil {
+		return model.JournalEntry{}, err
+	}
+	return entry, nil
+}
+
+// openingLines moves the balance of the transaction on ledgerAccount by cents against the opening balances account, a
+// positive amount is a credit and nothing is posted for zero
+func openingLines(ledgerAccount model.LedgerAccount, transactionID string, cents int64) []model.JournalLine {
+	side, other := model.EntrySideCredit, model.EntrySideDebit
+	switch {
+	case cents == 0:
+		return nil
+	case cents < 0:
+		side, other, cents = other, side, -cents
+	}
+	return []model.JournalLine{
+		{LedgerAccount: ledgerAccount, Side: side, AmountCents: cents, AppliesTo: transactionID},
+		{LedgerAccount: model.LedgerAccountOpeningBalances, Side: other, AmountCents: cents},
+	}
+}
+
+// TransactionBalances works out the balance of every transaction in the account's journal entries, in cents: what is
+// left of a debt from its receivable lines and what a payment holds as customer credit. Transactions made before the
+// journal was introduced are only in it once their opening entries have been posted
+func TransactionBalances(entries []model.JournalEntry) map[string]int64 {
+	balances := make(map[string]int64)
+	for _, entry := range entries {
+		// a payment allocated in full has no line of its own
+		if _, ok := balances[entry.TransactionID]; !ok && entry.TransactionID != "" {
+			balances[entry.TransactionID] = 0
+		}
+		for _, line := range entry.Lines {
+			if line.LedgerAccount != model.LedgerAccountReceivable && line.LedgerAccount != model.LedgerAccountCustomerCredit {
+				continue
+			}
+			if line.Side == model.EntrySideCredit {
+				balances[line.AppliesTo] += line.AmountCents
+			} else {
+				balances[line.AppliesTo] -= line.AmountCents
+			}
+		}
+	}
+	return balances
+}
+
+// JournalBalance sets the balance of the transaction to the one the journal gives it, from the account's balances as
+// TransactionBalances works them out. The balance stored on a transaction is the one it was made with, what payments
+// allocated to it since is only posted to the journal. A transaction the journal does not know keeps its stored balance
+func JournalBalance(tx *model.Transaction, balances map[string]int64) {
+	if cents, ok := balances[tx.ID.Hex()]; ok {
+		tx.Balance = Amount(cents)
+	}
+}
+
+// NewAdjustmentEntry builds the posting that brings the journal in line with the repaired balances of an account's
+// transactions. The difference between each repaired balance and the one the journal gives the transaction is posted to
+// its receivable, or to customer credit for a payment, against the suspense account. Transactions the journal does not
+// know are left out, as are those it already agrees with. It returns false when there is nothing to post
+func NewAdjustmentEntry(accountID string, entries []model.JournalEntry, repaired []model.Transaction, postedAt time.Time) (model.JournalEntry, bool, error) {
+	entry := model.JournalEntry{AccountID: accountID, PostedAt: postedAt}
+	balances := TransactionBalances(entries)
+	for _, tx := range repaired {
+		journalled, ok := balances[tx.ID.Hex()]
+		if !ok {
+			continue
+		}
+		difference := Cents(tx.Balance) - journalled
+		if difference == 0 {
+			continue
+		}
+		ledgerAccount := model.LedgerAccountReceivable
+		if tx.OperationID == model.OperationTypePayment {
+			ledgerAccount = model.LedgerAccountCustomerCredit
+		}
+		side, other := model.EntrySideCredit, model.EntrySideDebit
+		if difference < 0 {
+			side, other, difference = other, side, -difference
+		}
+		entry.Lines = append(entry.Lines,
+			model.JournalLine{LedgerAccount: ledgerAccount, Side: side, AmountCents: difference, AppliesTo: tx.ID.Hex()},
+			model.JournalLine{LedgerAccount: model.LedgerAccountSuspense, Side: other, AmountCents: difference},
+		)
+	}
+	if len(entry.Lines) == 0 {
+		return model.JournalEntry{}, false, nil
+	}
+	if err := Validate(entry); err != nil {
+		return model.JournalEntry{}, false, err
+	}
+	return entry, true, nil
+}
+
+// Validate checks every line has a positive amount and the entry balances
+func Validate(entry model.JournalEntry) error {
+	if len(entry.Lines) < 2 {
+		return fmt.Errorf("%w: an entry needs at least two lines", ErrUnbalanced)
+	}
+	var debits, credits int64
+	for _, line := range entry.Lines {
+		if line.AmountCents <= 0 {
+			return fmt.Errorf("journal line on %s must have a positive amount, got %d", line.LedgerAccount, line.AmountCents)
+		}
+		switch line.Side {
+		case model.EntrySideDebit:
+			debits += line.AmountCents
+		case model.EntrySideCredit:
+			credits += line.AmountCents
+		default:
+			return fmt.Errorf("journal line on %s has unknown side %q", line.LedgerAccount, line.Side)
+		}
+	}
+	if debits != credits {
+		return fmt.Errorf("%w: debits %d, credits %d", ErrUnbalanced, debits, credits)
+	}
+	return nil
+}
+
+// NewTrialBalance totals the lines and reports whether the ledger balances as a whole
+func NewTrialBalance(lines []model.TrialBalanceLine) model.TrialBalanceResponseBody {
+	resp := model.TrialBalanceResponseBody{Accounts: make([]model.TrialBalanceAccount, len(lines))}
+	for i, line := range lines {
+		resp.Accounts[i] = model.TrialBalanceAccount{
+			LedgerAccount: line.LedgerAccount,
+			DebitCents:    line.DebitCents,
+			CreditCents:   line.CreditCents,
+			BalanceCents:  line.DebitCents - line.CreditCents,
+		}
+		resp.TotalDebitCents += line.DebitCents
+		resp.TotalCreditCents += line.CreditCents
+	}
+	resp.Balanced = resp.TotalDebitCents == resp.TotalCreditCents
+	return resp
+}
@@ -0,0 +1,290 @@
+package ledger
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestCents(t *testing.T) {
+	if got := Cents(0.1 + 0.2); got != 30 {
+		t.Errorf("expected 30, got %d", got)
+	}
+	if got := Cents(-23.455); got != -2346 {
+		t.Errorf("expected -2346, got %d", got)
+	}
+	if got := Amount(-1350); got != -13.5 {
+		t.Errorf("expected -13.5, got %v", got)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	first, second := bson.NewObjectID(), bson.NewObjectID()
+	debts := []model.Transaction{
+		{ID: bson.NewObjectID(), Balance: 0},
+		{ID: first, Balance: -50},
+		{ID: bson.NewObjectID(), Balance: 20},
+		{ID: second, Balance: -23.5},
+	}
+
+	tests := []struct {
+		name      string
+		payment   int64
+		want      []Allocation
+		unapplied int64
+	}{
+		{
+			name:    "Oldest debt is paid off first",
+			payment: 6000,
+			want: []Allocation{
+				{TransactionID: first.Hex(), AmountCents: 5000, PreviousBalance: -5000, Balance: 0},
+				{TransactionID: second.Hex(), AmountCents: 1000, PreviousBalance: -2350, Balance: -1350},
+			},
+		},
+		{
+			name:    "Partial payment",
+			payment: 1234,
+			want:    []Allocation{{TransactionID: first.Hex(), AmountCents: 1234, PreviousBalance: -5000, Balance: -3766}},
+		},
+		{
+			name:    "Excess is unapplied",
+			payment: 10000,
+			want: []Allocation{
+				{TransactionID: first.Hex(), AmountCents: 5000, PreviousBalance: -5000, Balance: 0},
+				{TransactionID: second.Hex(), AmountCents: 2350, PreviousBalance: -2350, Balance: 0},
+			},
+			unapplied: 2650,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unapplied := Allocate(tt.payment, debts)
+			if unapplied != tt.unapplied {
+				t.Errorf("expected %d unapplied, got %d", tt.unapplied, unapplied)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("allocation %d: expected %+v, got %+v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewTransactionEntry(t *testing.T) {
+	postedAt := time.Date(2025, 1, 10, 10, 0, 0, 0, time.UTC)
+
+	entry, err := NewTransactionEntry("tx-1", model.Transaction{AccountID: "acc-1", OperationID: model.OperationTypeWithdrawal, Amount: -40}, nil, postedAt)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if entry.AccountID != "acc-1" || !entry.PostedAt.Equal(postedAt) || entry.Lines[1].LedgerAccount != model.LedgerAccountCash || entry.Lines[1].Side != model.EntrySideCredit {
+		t.Errorf("expected a withdrawal to be paid out of cash, got %+v", entry)
+	}
+
+	entry, err = NewTransactionEntry("tx-2", model.Transaction{OperationID: model.OperationTypePayment, Amount: 30}, []Allocation{{TransactionID: "tx-1", AmountCents: 3000}}, postedAt)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(entry.Lines) != 2 || entry.Lines[1].AppliesTo != "tx-1" {
+		t.Errorf("expected the payment to be allocated to tx-1 without customer credit, got %+v", entry.Lines)
+	}
+
+	if _, err := NewTransactionEntry("tx-3", model.Transaction{OperationID: 9, Amount: 1}, nil, postedAt); err == nil {
+		t.Errorf("expected an error for an unknown operation type")
+	}
+	// allocations larger than the payment cannot be balanced
+	_, err = NewTransactionEntry("tx-4", model.Transaction{OperationID: model.OperationTypePayment, Amount: 10}, []Allocation{{TransactionID: "tx-1", AmountCents: 2000}}, postedAt)
+	if !errors.Is(err, ErrUnbalanced) {
+		t.Errorf("expected ErrUnbalanced, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []model.JournalLine
+		valid bool
+	}{
+		{
+			name: "Balanced",
+			lines: []model.JournalLine{
+				{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 100},
+				{LedgerAccount: model.LedgerAccountCustomerCredit, Side: model.EntrySideCredit, AmountCents: 100},
+			},
+			valid: true,
+		},
+		{
+			name: "Unbalanced",
+			lines: []model.JournalLine{
+				{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 100},
+				{LedgerAccount: model.LedgerAccountCustomerCredit, Side: model.EntrySideCredit, AmountCents: 99},
+			},
+		},
+		{
+			name: "Zero amount",
+			lines: []model.JournalLine{
+				{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 0},
+				{LedgerAccount: model.LedgerAccountCustomerCredit, Side: model.EntrySideCredit, AmountCents: 0},
+			},
+		},
+		{
+			name:  "Single line",
+			lines: []model.JournalLine{{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 100}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(model.JournalEntry{Lines: tt.lines})
+			if (err == nil) != tt.valid {
+				t.Errorf("expected valid %v, got %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestNewTrialBalance(t *testing.T) {
+	resp := NewTrialBalance([]model.TrialBalanceLine{
+		{LedgerAccount: model.LedgerAccountCash, DebitCents: 500, CreditCents: 200},
+		{LedgerAccount: model.LedgerAccountReceivable, DebitCents: 200, CreditCents: 500},
+	})
+	if !resp.Balanced || resp.TotalDebitCents != 700 || resp.Accounts[0].BalanceCents != 300 || resp.Accounts[1].BalanceCents != -300 {
+		t.Errorf("unexpected trial balance %+v", resp)
+	}
+	if resp := NewTrialBalance(nil); !resp.Balanced || resp.Accounts == nil {
+		t.Errorf("expected an empty journal to balance with an empty list, got %+v", resp)
+	}
+}
+
+func TestNewAdjustmentEntry(t *testing.T) {
+	at := time.Date(2025, 2, 1, 10, 0, 0, 0, time.UTC)
+	purchase, payment, older := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+	purchaseEntry, err := NewTransactionEntry(purchase.Hex(), model.Transaction{OperationID: model.OperationTypePurchase, Amount: -50}, nil, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the payment was journalled as paying off 30 of the purchase, with 10 left over as credit
+	paymentEntry, err := NewTransactionEntry(payment.Hex(), model.Transaction{OperationID: model.OperationTypePayment, Amount: 40},
+		[]Allocation{{TransactionID: purchase.Hex(), AmountCents: 3000}}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []model.JournalEntry{purchaseEntry, paymentEntry}
+	if balances := TransactionBalances(entries); balances[purchase.Hex()] != -2000 || balances[payment.Hex()] != 1000 {
+		t.Fatalf("unexpected journal balances %v", balances)
+	}
+
+	// the repair says all of the payment went to the purchase, and a transaction older than the journal drifted too
+	repaired := []model.Transaction{
+		{ID: purchase, OperationID: model.OperationTypePurchase, Balance: -10},
+		{ID: payment, OperationID: model.OperationTypePayment, Balance: 0},
+		{ID: older, OperationID: model.OperationTypePurchase, Balance: -5},
+	}
+	entry, ok, err := NewAdjustmentEntry("acc-1", entries, repaired, at)
+	if err != nil || !ok {
+		t.Fatalf("expected an adjustment, got %v, %v", ok, err)
+	}
+	expected := []model.JournalLine{
+		{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 1000, AppliesTo: purchase.Hex()},
+		{LedgerAccount: model.LedgerAccountSuspense, Side: model.EntrySideDebit, AmountCents: 1000},
+		{LedgerAccount: model.LedgerAccountCustomerCredit, Side: model.EntrySideDebit, AmountCents: 1000, AppliesTo: payment.Hex()},
+		{LedgerAccount: model.LedgerAccountSuspense, Side: model.EntrySideCredit, AmountCents: 1000},
+	}
+	if entry.AccountID != "acc-1" || !entry.PostedAt.Equal(at) || !slices.Equal(entry.Lines, expected) {
+		t.Errorf("expected lines %+v, got %+v", expected, entry.Lines)
+	}
+
+	// once posted the journal agrees with the repaired transactions
+	entries = append(entries, entry)
+	if balance := BalanceAsOf("acc-1", entries, nil, at); balance.TotalDebt != 10 || balance.AvailableCredit != 0 {
+		t.Errorf("expected the journal to give the repaired balances, got %+v", balance)
+	}
+	if _, ok, err := NewAdjustmentEntry("acc-1", entries, repaired, at); ok || err != nil {
+		t.Errorf("expected nothing to adjust once the journal agrees, got %v, %v", ok, err)
+	}
+}
+
+func TestNewOpeningEntry(t *testing.T) {
+	at := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	purchase, withdrawal, payment := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+	// before the journal the payment of 80 paid off 30 of the purchase and all of the withdrawal, keeping 30 as credit
+	transactions := []model.Transaction{
+		{ID: purchase, AccountID: "acc-1", OperationID: model.OperationTypePurchase, Amount: -50, Balance: -20, EventDate: at},
+		{ID: withdrawal, AccountID: "acc-1", OperationID: model.OperationTypeWithdrawal, Amount: -20, Balance: 0, EventDate: at},
+		{ID: payment, AccountID: "acc-1", OperationID: model.OperationTypePayment, Amount: 80, Balance: 30, EventDate: at.Add(time.Hour)},
+	}
+	var entries []model.JournalEntry
+	for _, tx := range transactions {
+		entry, err := NewOpeningEntry(tx.ID.Hex(), tx, 0)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if entry.TransactionID != tx.ID.Hex() || entry.AccountID != "acc-1" || !entry.PostedAt.Equal(tx.EventDate) {
+			t.Errorf("expected the entry to be posted for the transaction when it was made, got %+v", entry)
+		}
+		entries = append(entries, entry)
+	}
+	expected := []model.JournalLine{
+		{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 5000, AppliesTo: purchase.Hex()},
+		{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 5000},
+		{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 3000, AppliesTo: purchase.Hex()},
+		{LedgerAccount: model.LedgerAccountOpeningBalances, Side: model.EntrySideDebit, AmountCents: 3000},
+	}
+	if !slices.Equal(entries[0].Lines, expected) {
+		t.Errorf("expected lines %+v, got %+v", expected, entries[0].Lines)
+	}
+
+	balances := TransactionBalances(entries)
+	for _, tx := range transactions {
+		if balances[tx.ID.Hex()] != Cents(tx.Balance) {
+			t.Errorf("expected the journal to give %s its stored balance %v, got %d", tx.ID.Hex(), tx.Balance, balances[tx.ID.Hex()])
+		}
+	}
+	var opening int64
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			if line.LedgerAccount != model.LedgerAccountOpeningBalances {
+				continue
+			}
+			if line.Side == model.EntrySideDebit {
+				opening += line.AmountCents
+			} else {
+				opening -= line.AmountCents
+			}
+		}
+	}
+	if opening != 0 {
+		t.Errorf("expected the opening balances to net to zero for balances that agree, got %d", opening)
+	}
+
+	// a debt made before the journal and paid off by a payment made after it already has the payment's credit
+	debt := model.Transaction{ID: bson.NewObjectID(), AccountID: "acc-1", OperationID: model.OperationTypePurchase, Amount: -40, Balance: 0, EventDate: at}
+	paid, err := NewTransactionEntry(payment.Hex(), model.Transaction{AccountID: "acc-1", OperationID: model.OperationTypePayment, Amount: 40},
+		[]Allocation{{TransactionID: debt.ID.Hex(), AmountCents: 4000, PreviousBalance: -4000}}, at.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	entry, err := NewOpeningEntry(debt.ID.Hex(), debt, TransactionBalances([]model.JournalEntry{paid})[debt.ID.Hex()])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(entry.Lines) != 2 {
+		t.Errorf("expected nothing paid off before the journal to be posted, got %+v", entry.Lines)
+	}
+	if got := TransactionBalances([]model.JournalEntry{paid, entry})[debt.ID.Hex()]; got != 0 {
+		t.Errorf("expected the journal to give the debt its stored balance 0, got %d", got)
+	}
+
+	if _, err := NewOpeningEntry(purchase.Hex(), model.Transaction{OperationID: 9, Amount: -1}, 0); err == nil {
+		t.Errorf("expected an unknown operation type to fail")
+	}
+}
@@ -0,0 +1,120 @@
+package ledger
+
+import (
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+// Replay recomputes transaction balances from scratch by applying transactions in the order they were booked, the same
+// way CreateTransaction does: purchases and withdrawals open a debt for their amount and payments are allocated to the
+// open debts oldest first, keeping what is left over as their own balance. Accounts are independent of each other so
+// the transactions of several accounts can be interleaved as long as each account's are in event_date order
+type Replay struct {
+	accounts     map[string]*replayAccount
+	order        []string
+	transactions int
+}
+
+type replayAccount struct {
+	// open holds the debts that still have something owed on them with their expected balance
+	open   []model.Transaction
+	stored map[string]model.Transaction
+	drift  []model.TransactionDrift
+}
+
+func NewReplay() *Replay {
+	return &Replay{accounts: make(map[string]*replayAccount)}
+}
+
+// Apply replays the next transaction and records it as drifted when its stored balance is not the replayed one. Drift
+// on a debt can only be known once every later payment has been applied, so it is worked out in Drift
+func (r *Replay) Apply(tx model.Transaction) {
+	account, ok := r.accounts[tx.AccountID]
+	if !ok {
+		account = &replayAccount{stored: make(map[string]model.Transaction)}
+		r.accounts[tx.AccountID] = account
+		r.order = append(r.order, tx.AccountID)
+	}
+	r.transactions++
+	account.stored[tx.ID.Hex()] = tx
+
+	expected := Cents(tx.Amount)
+	if tx.OperationID == model.OperationTypePayment {
+		allocations, unapplied := Allocate(expected, account.open)
+		for _, allocation := range allocations {
+			for i := range account.open {
+				if account.open[i].ID.Hex() == allocation.TransactionID {
+					account.open[i].Balance = Amount(allocation.Balance)
+				}
+			}
+		}
+		account.prune()
+		expected = unapplied
+	}
+	if expected < 0 {
+		debt := tx
+		debt.Balance = Amount(expected)
+		account.open = append(account.open, debt)
+		return
+	}
+	account.compare(tx, expected)
+}
+
+// prune drops the debts that have been paid off, comparing them with what is stored as they go
+func (a *replayAccount) prune() {
+	open := a.open[:0]
+	for _, debt := range a.open {
+		if debt.Balance < 0 {
+			open = append(open, debt)
+			continue
+		}
+		a.compare(a.stored[debt.ID.Hex()], Cents(debt.Balance))
+	}
+	a.open = open
+}
+
+// compare records drift for any difference, even float noise below a cent, since that is how drift starts
+func (a *replayAccount) compare(stored model.Transaction, expected int64) {
+	delete(a.stored, stored.ID.Hex())
+	if stored.Balance == Amount(expected) {
+		return
+	}
+	a.drift = append(a.drift, model.TransactionDrift{
+		TransactionID:   stored.ID.Hex(),
+		StoredBalance:   stored.Balance,
+		ExpectedBalance: Amount(expected),
+	})
+}
+
+// Drift returns the drifted transactions of every account with drift, accounts in the order they were first seen. It
+// finishes the replay, no more transactions can be applied afterwards
+func (r *Replay) Drift() []model.AccountDrift {
+	var drift []model.AccountDrift
+	for _, accountID := range r.order {
+		account := r.accounts[accountID]
+		for _, debt := range account.open {
+			account.compare(account.stored[debt.ID.Hex()], Cents(debt.Balance))
+		}
+		account.open = nil
+		if len(account.drift) == 0 {
+			continue
+		}
+		accountDrift := model.AccountDrift{AccountID: accountID, Transactions: account.drift}
+		var cents int64
+		for _, tx := range account.drift {
+			cents += Cents(tx.ExpectedBalance) - Cents(tx.StoredBalance)
+		}
+		accountDrift.Drift = Amount(cents)
+		drift = append(drift, accountDrift)
+	}
+	return drift
+}
+
+// Accounts is the number of accounts replayed so far
+func (r *Replay) Accounts() int {
+	return len(r.order)
+}
+
+// Transactions is the number of transactions replayed so far
+func (r *Replay) Transactions() int {
+	return r.transactions
+}
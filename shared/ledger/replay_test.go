@@ -0,0 +1,49 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestReplay(t *testing.T) {
+	purchase, withdrawal, payment := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+	other := bson.NewObjectID()
+
+	replay := NewReplay()
+	for _, tx := range []model.Transaction{
+		{ID: purchase, AccountID: "acc-1", OperationID: model.OperationTypePurchase, Amount: -0.1, Balance: 0},
+		// another account's transactions can be interleaved
+		{ID: other, AccountID: "acc-2", OperationID: model.OperationTypePurchase, Amount: -10, Balance: -10},
+		{ID: withdrawal, AccountID: "acc-1", OperationID: model.OperationTypeWithdrawal, Amount: -0.2, Balance: -0.2},
+		// float arithmetic left noise behind in the payment's balance
+		{ID: payment, AccountID: "acc-1", OperationID: model.OperationTypePayment, Amount: 0.5, Balance: 0.20000000000000004},
+	} {
+		replay.Apply(tx)
+	}
+
+	drift := replay.Drift()
+	if replay.Accounts() != 2 || replay.Transactions() != 4 {
+		t.Errorf("expected 2 accounts and 4 transactions, got %d and %d", replay.Accounts(), replay.Transactions())
+	}
+	if len(drift) != 1 || drift[0].AccountID != "acc-1" {
+		t.Fatalf("expected drift on acc-1 only, got %+v", drift)
+	}
+	// debts are compared as the payment that settles them is applied, before the payment itself
+	want := []model.TransactionDrift{
+		{TransactionID: withdrawal.Hex(), StoredBalance: -0.2, ExpectedBalance: 0},
+		{TransactionID: payment.Hex(), StoredBalance: 0.20000000000000004, ExpectedBalance: 0.2},
+	}
+	if len(drift[0].Transactions) != len(want) {
+		t.Fatalf("expected %+v, got %+v", want, drift[0].Transactions)
+	}
+	for i := range want {
+		if drift[0].Transactions[i] != want[i] {
+			t.Errorf("drift %d: expected %+v, got %+v", i, want[i], drift[0].Transactions[i])
+		}
+	}
+	if drift[0].Drift != 0.2 {
+		t.Errorf("expected 0.2 of drift, got %v", drift[0].Drift)
+	}
+}
@@ -0,0 +1,149 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var (
+	// ErrTimeout is returned when the lock was still held by someone else once Config.Wait had passed
+	ErrTimeout = errors.New("timed out waiting for the lock")
+	// ErrLost is the cause the ctx returned by Lock is canceled with once the lock may be held by someone else
+	ErrLost = errors.New("lost the lock")
+)
+
+// Locker serializes work on a key. Lock blocks until the caller holds the key and returns the func that lets it go,
+// work on other keys is not held up. The work must run on the ctx Lock returns, it is canceled with ErrLost as its
+// cause when the lock is lost before it is let go, so the work stops rather than carry on alongside the new holder
+type Locker interface {
+	Lock(ctx context.Context, key string) (locked context.Context, unlock func(), err error)
+}
+
+// Config controls how long a Lease is held and waited for, the zero value of any field falls back to its default
+type Config struct {
+	TTL  time.Duration // how long a lease lasts unless renewed, a holder that dies frees it this long after its last renewal
+	Wait time.Duration // how long Lock waits for a held key before giving up with ErrTimeout
+	Poll time.Duration // wait between attempts to take a held key
+}
+
+func DefaultConfig() Config {
+	return Config{
+		TTL:  10 * time.Second,
+		Wait: 5 * time.Second,
+		Poll: 20 * time.Millisecond,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	defaults := DefaultConfig()
+	if c.TTL <= 0 {
+		c.TTL = defaults.TTL
+	}
+	if c.Wait <= 0 {
+		c.Wait = defaults.Wait
+	}
+	if c.Poll <= 0 {
+		c.Poll = defaults.Poll
+	}
+	return c
+}
+
+// Lease is a Locker on the leases of a repository.LeaseRepository, so it serializes work across every instance sharing
+// the store. Each Lock takes the lease under an owner of its own, two goroutines of one instance take turns as well.
+// The lease is renewed while it is held so work outlasting the TTL keeps it, it is lost once it is taken over or has gone
+// a TTL without a renewal. Lock must be called with a ctx outside of any transaction
+type Lease struct {
+	repo   repository.LeaseRepository
+	logger *slog.Logger
+	config Config
+	now    func() time.Time
+}
+
+func NewLease(repo repository.LeaseRepository, logger *slog.Logger, config Config) *Lease {
+	logger.InfoContext(context.Background(), "Lease locker initialized")
+	return &Lease{repo: repo, logger: logger, config: config.withDefaults(), now: time.Now}
+}
+
+func (l *Lease) Lock(ctx context.Context, key string) (context.Context, func(), error) {
+	owner := bson.NewObjectID().Hex()
+	deadline := time.NewTimer(l.config.Wait)
+	defer deadline.Stop()
+	var expiresAt time.Time
+	for {
+		now := l.now()
+		expiresAt = now.Add(l.config.TTL)
+		acquired, err := l.repo.AcquireLease(ctx, key, owner, now, expiresAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		if acquired {
+			break
+		}
+		// half the poll plus up to as much again at random so waiters do not all try again together
+		wait := l.config.Poll/2 + rand.N(l.config.Poll/2+1)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-deadline.C:
+			return nil, nil, ErrTimeout
+		case <-time.After(wait):
+		}
+	}
+
+	locked, cancel := context.WithCancelCause(ctx)
+	// the lease outlives a canceled request until it is released, the renewals and the release must still go through
+	ctx = context.WithoutCancel(ctx)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go l.renew(ctx, key, owner, expiresAt, func() { cancel(ErrLost) }, stop, stopped)
+	var once sync.Once
+	return locked, func() {
+		once.Do(func() {
+			close(stop)
+			<-stopped
+			cancel(nil)
+			if err := l.repo.ReleaseLease(ctx, key, owner); err != nil {
+				l.logger.WarnContext(ctx, "failed to release lease, it is freed once it expires", "key", key, "error", err)
+			}
+		})
+	}, nil
+}
+
+// renew extends the lease every third of its TTL until stop is closed, so two renewals can fail before it expires. It
+// calls lost and gives up once the lease has been taken over or has expired at expiresAt without a renewal
+func (l *Lease) renew(ctx context.Context, key string, owner string, expiresAt time.Time, lost func(), stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(l.config.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			now := l.now()
+			acquired, err := l.repo.AcquireLease(ctx, key, owner, now, now.Add(l.config.TTL))
+			if err != nil && now.Before(expiresAt) {
+				l.logger.WarnContext(ctx, "failed to renew lease", "key", key, "error", err)
+				continue
+			}
+			// the work holding it is no longer alone
+			if err != nil {
+				l.logger.ErrorContext(ctx, "lost lease, it expired before it could be renewed", "key", key, "error", err)
+				lost()
+				return
+			}
+			if !acquired {
+				l.logger.ErrorContext(ctx, "lost lease, it was taken over", "key", key)
+				lost()
+				return
+			}
+			expiresAt = now.Add(l.config.TTL)
+		}
+	}
+}
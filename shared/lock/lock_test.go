@@ -0,0 +1,148 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/database"
+)
+
+func newTestLease(config Config) *Lease {
+	return NewLease(database.NewMemory(), slog.New(slog.NewJSONHandler(os.Stdout, nil)), config)
+}
+
+func TestLeaseSerializesAKey(t *testing.T) {
+	locker := newTestLease(Config{Poll: time.Millisecond})
+	var (
+		wg      sync.WaitGroup
+		holders atomic.Int32
+		overlap atomic.Bool
+	)
+	for range 8 {
+		wg.Go(func() {
+			_, unlock, err := locker.Lock(context.Background(), "account/1")
+			if err != nil {
+				t.Errorf("expected no error, got %v", err)
+				return
+			}
+			if holders.Add(1) > 1 {
+				overlap.Store(true)
+			}
+			time.Sleep(2 * time.Millisecond)
+			holders.Add(-1)
+			unlock()
+		})
+	}
+	wg.Wait()
+	if overlap.Load() {
+		t.Errorf("expected the holders of a key to take turns")
+	}
+}
+
+func TestLeaseKeysAreIndependent(t *testing.T) {
+	locker := newTestLease(Config{Wait: 50 * time.Millisecond})
+	_, unlock, err := locker.Lock(context.Background(), "account/1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer unlock()
+	_, other, err := locker.Lock(context.Background(), "account/2")
+	if err != nil {
+		t.Fatalf("expected another key to be locked while the first is held, got %v", err)
+	}
+	other()
+}
+
+func TestLeaseTimeout(t *testing.T) {
+	locker := newTestLease(Config{TTL: 30 * time.Millisecond, Wait: 100 * time.Millisecond, Poll: time.Millisecond})
+	_, unlock, err := locker.Lock(context.Background(), "account/1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// the wait outlasts the TTL, only the renewals keep the lease held
+	if _, _, err := locker.Lock(context.Background(), "account/1"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout while the lease is held, got %v", err)
+	}
+	unlock()
+	unlock()
+	_, again, err := locker.Lock(context.Background(), "account/1")
+	if err != nil {
+		t.Fatalf("expected the released key to be locked, got %v", err)
+	}
+	again()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, held, _ := locker.Lock(context.Background(), "account/1")
+	defer held()
+	if _, _, err := locker.Lock(ctx, "account/1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the wait to stop with ctx, got %v", err)
+	}
+}
+
+// failingLeases fails every call once fail is set
+type failingLeases struct {
+	*database.Memory
+	fail atomic.Bool
+}
+
+func (f *failingLeases) AcquireLease(ctx context.Context, name string, owner string, now time.Time, expiresAt time.Time) (bool, error) {
+	if f.fail.Load() {
+		return false, errors.New("connection refused")
+	}
+	return f.Memory.AcquireLease(ctx, name, owner, now, expiresAt)
+}
+
+func TestLeaseLost(t *testing.T) {
+	store := database.NewMemory()
+	locker := NewLease(store, slog.New(slog.NewJSONHandler(os.Stdout, nil)), Config{TTL: 30 * time.Millisecond})
+	ctx, unlock, err := locker.Lock(context.Background(), "account/1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer unlock()
+	// taken over as if the lease had expired
+	if _, err := store.AcquireLease(context.Background(), "account/1", "other", time.Now().Add(time.Minute), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the ctx to be canceled once the lease was taken over")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrLost) {
+		t.Errorf("expected ErrLost as the cause, got %v", cause)
+	}
+
+	leases := &failingLeases{Memory: database.NewMemory()}
+	locker = NewLease(leases, slog.New(slog.NewJSONHandler(os.Stdout, nil)), Config{TTL: 30 * time.Millisecond})
+	ctx, unlock, err = locker.Lock(context.Background(), "account/1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer unlock()
+	leases.fail.Store(true)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the ctx to be canceled once the lease expired without a renewal")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrLost) {
+		t.Errorf("expected ErrLost as the cause, got %v", cause)
+	}
+
+	ctx, release, err := newTestLease(Config{}).Lock(context.Background(), "account/1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	release()
+	if cause := context.Cause(ctx); errors.Is(cause, ErrLost) {
+		t.Errorf("expected a released lease not to be reported as lost, got %v", cause)
+	}
+}
@@ -1,12 +1,17 @@
 package model
 
 import (
+	"encoding/json"
+	"time"
+
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 type Account struct {
 	ID             bson.ObjectID `bson:"_id,omitempty"`
+	PublicID       string        `bson:"public_id"` // the acc_ ID clients know the account by, ID is internal to the store
 	DocumentNumber string        `bson:"document_number"`
+	Version        int64         `bson:"version"` // 1 once created, bumped on every write
 }
 
 // AccoundRequestBody model info
@@ -14,7 +19,7 @@ type Account struct {
 //	@Description	Account request body
 //	@Description	Document number used to create an account
 type AccountRequestBody struct {
-	DocumentNumber string `json:"document_number"` // Document number
+	DocumentNumber string `json:"document_number" validate:"required,max=64,format=document_number"` // Document number
 }
 
 // AccountResponseBody model info
@@ -26,16 +31,89 @@ type AccountResponseBody struct {
 	DocumentNumber string `json:"document_number"`
 }
 
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusCompleted JobStatus = "completed"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+// JobResponseBody model info
+//
+//	@Description	Job response body
+//	@Description	Progress of an asynchronous job, the result can be downloaded once the job has completed
+type JobResponseBody struct {
+	JobID      string    `json:"job_id"`
+	Type       string    `json:"type"`
+	Status     JobStatus `json:"status" enums:"pending,running,completed,failed"`
+	Rows       int       `json:"rows"`
+	Created    int       `json:"created"`
+	Duplicates int       `json:"duplicates"`
+	Invalid    int       `json:"invalid"`
+	Failed     int       `json:"failed"`
+	// IgnoredColumns are the columns, or NDJSON fields, of the upload other than document_number. Accounts only hold a
+	// document number so they are dropped
+	IgnoredColumns []string   `json:"ignored_columns,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	ResultURL      string     `json:"result_url,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// Job is a background job as it is stored, only the tenant that started it can see it. UpdatedAt is written with every
+// change so a job that is not finished and stops being updated was lost along with the instance running it
+type Job struct {
+	ID             string     `bson:"_id"`
+	Tenant         string     `bson:"tenant"`
+	Type           string     `bson:"type"`
+	Status         JobStatus  `bson:"status"`
+	Rows           int        `bson:"rows"`
+	Created        int        `bson:"created"`
+	Duplicates     int        `bson:"duplicates"`
+	Invalid        int        `bson:"invalid"`
+	Failed         int        `bson:"failed"`
+	IgnoredColumns []string   `bson:"ignored_columns,omitempty"`
+	Error          string     `bson:"error,omitempty"`
+	CreatedAt      time.Time  `bson:"created_at"`
+	StartedAt      *time.Time `bson:"started_at,omitempty"`
+	FinishedAt     *time.Time `bson:"finished_at,omitempty"`
+	UpdatedAt      time.Time  `bson:"updated_at"`
+}
+
+type AccountImportRowStatus string
+
+const (
+	AccountImportRowCreated   AccountImportRowStatus = "created"
+	AccountImportRowDuplicate AccountImportRowStatus = "duplicate"
+	AccountImportRowInvalid   AccountImportRowStatus = "invalid"
+	AccountImportRowFailed    AccountImportRowStatus = "failed" // the row was valid but the database call failed, it can be resubmitted
+)
+
+// AccountImportRowResult model info
+//
+//	@Description	Account import row result
+//	@Description	One line of an account import result file, rows are numbered from 1 excluding any CSV header
+type AccountImportRowResult struct {
+	Row            int                    `json:"row"`
+	DocumentNumber string                 `json:"document_number"`
+	Status         AccountImportRowStatus `json:"status" enums:"created,duplicate,invalid,failed"`
+	AccountID      string                 `json:"account_id,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	Errors         []FieldError           `json:"errors,omitempty"` // the rules an invalid document number broke
+}
+
 // TransactionRequestBody model info
 //
 //	@Description	Transaction request body
 //	@Description	Account ID, Operation type ID and Amount are required to create a transaction
 type TransactionRequestBody struct {
-
-	// required: true
-	AccountID   string        `json:"account_id"`
-	OperationID OperationType `json:"operation_type_id"`
-	Amount      float64       `json:"amount"`
+	AccountID   string        `json:"account_id" validate:"required"`
+	OperationID OperationType `json:"operation_type_id" validate:"required,oneof=1 2 3 4"`
+	// Amount is a whole number of cents, capped at a trillion either way so its cents are held exactly
+	Amount float64 `json:"amount" validate:"required,cents,min=-1000000000000,max=1000000000000"`
 }
 
 // TransactionResponseBody model info
@@ -49,14 +127,95 @@ type TransactionResponseBody struct {
 	Amount        float64       `json:"amount"`
 }
 
+type BatchMode string
+
+const (
+	BatchModeAtomic     BatchMode = "atomic"      // every item is committed or none are
+	BatchModeBestEffort BatchMode = "best_effort" // each item is committed on its own
+)
+
+type BatchItemStatus string
+
+const (
+	BatchItemStatusCreated  BatchItemStatus = "created"
+	BatchItemStatusReplayed BatchItemStatus = "replayed"
+	BatchItemStatusRejected BatchItemStatus = "rejected"
+)
+
+// TransactionBatchItem model info
+//
+//	@Description	Transaction batch item
+//	@Description	A transaction request body with the idempotency key for that transaction
+type TransactionBatchItem struct {
+	IdempotencyKey string `json:"idempotency_key" validate:"required"`
+	TransactionRequestBody
+}
+
+// TransactionBatchRequestBody model info
+//
+//	@Description	Transaction batch request body
+//	@Description	Mode is either atomic (all or nothing) or best_effort, atomic is used when it is left out
+type TransactionBatchRequestBody struct {
+	Mode  BatchMode              `json:"mode" enums:"atomic,best_effort"`
+	Items []TransactionBatchItem `json:"items"`
+}
+
+// TransactionBatchItemResult model info
+//
+//	@Description	Transaction batch item result
+//	@Description	Outcome of a single batch item, the transaction is set when it was created or replayed and the error when it was rejected
+type TransactionBatchItemResult struct {
+	Index          int                      `json:"index"`
+	IdempotencyKey string                   `json:"idempotency_key"`
+	Status         BatchItemStatus          `json:"status" enums:"created,replayed,rejected"`
+	Transaction    *TransactionResponseBody `json:"transaction,omitempty"`
+	Error          *ErrorResponse           `json:"error,omitempty"`
+}
+
+// TransactionBatchResponseBody model info
+//
+//	@Description	Transaction batch response body
+//	@Description	Per item results in request order along with counts for each outcome
+type TransactionBatchResponseBody struct {
+	Mode     BatchMode                    `json:"mode"`
+	Created  int                          `json:"created"`
+	Replayed int                          `json:"replayed"`
+	Rejected int                          `json:"rejected"`
+	Results  []TransactionBatchItemResult `json:"results"`
+}
+
 type Transaction struct {
 	ID             bson.ObjectID `bson:"_id,omitempty"`
-	AccountID      string        `bson:"account_id"`
+	PublicID       string        `bson:"public_id"`  // the txn_ ID clients know the transaction by, ID is internal to the store
+	AccountID      string        `bson:"account_id"` // the internal ID of the account
 	OperationID    OperationType `bson:"operation_type_id"`
 	Amount         float64       `bson:"amount"`
-	EventDate      string        `bson:"event_date"`
-	Balance        float64       `bson:"balance"`
+	EventDate      time.Time     `bson:"event_date"`      // stored as a BSON date, which only keeps milliseconds
+	Balance        float64       `bson:"balance"`         // the balance it was made with, what is left of it now is read from the journal
 	IdempotencyKey string        `bson:"idempotency_key"` // Idempotency Key this is to ensure idempotency of transactions, e.g., if the same request is sent multiple times, it will only be processed once
+	Version        int64         `bson:"version"`         // 1 once created, bumped on every write, an update expecting another version fails
+}
+
+// TransactionFilter narrows down the transactions read from the repository, zero values are ignored
+type TransactionFilter struct {
+	AccountID   string
+	OperationID OperationType
+	From        time.Time
+	To          time.Time
+}
+
+// TransactionExportRecord model info
+//
+//	@Description	Transaction export record
+//	@Description	A single transaction as written to an NDJSON export, CSV exports use the same columns
+type TransactionExportRecord struct {
+	TransactionID string        `json:"transaction_id"`
+	AccountID     string        `json:"account_id"`
+	OperationID   OperationType `json:"operation_type_id"`
+	OperationType string        `json:"operation_type"`
+	Amount        float64       `json:"amount"`
+	Balance       float64       `json:"balance"`
+	EventDate     time.Time     `json:"event_date"`
 }
 
 type OperationType int
@@ -90,4 +249,385 @@ func (ot OperationType) String() string {
 type ErrorResponse struct {
 	Message string `json:"message"`
 	Status  int    `json:"status"`
+	// Errors lists every rule the fields of an invalid request body break, Message joins their messages
+	Errors []FieldError `json:"errors,omitempty"`
+	// RetryAfter is how many seconds the client should wait before trying again, sent as the Retry-After header
+	RetryAfter int `json:"-"`
+}
+
+// FieldError model info
+//
+//	@Description	A rule a field of the request body breaks
+//	@Description	Code is one of required, min, max, oneof, format or cents
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type EventType string
+
+const (
+	EventTypeAccountCreated     EventType = "AccountCreated"
+	EventTypeTransactionCreated EventType = "TransactionCreated"
+	EventTypeDebtDischarged     EventType = "DebtDischarged" // a payment paid off some or all of an earlier debt
+)
+
+func (t EventType) Valid() bool {
+	switch t {
+	case EventTypeAccountCreated, EventTypeTransactionCreated, EventTypeDebtDischarged:
+		return true
+	default:
+		return false
+	}
+}
+
+// OutboxEvent is a domain event written in the same database transaction as the change it describes, the relay picks
+// it up afterwards and publishes it. PublishedAt stays nil until then
+type OutboxEvent struct {
+	ID          bson.ObjectID   `bson:"_id,omitempty" json:"event_id"`
+	Type        EventType       `bson:"type" json:"type"`
+	AggregateID string          `bson:"aggregate_id" json:"aggregate_id"` // ID of the account or transaction the event is about
+	AccountID   string          `bson:"account_id" json:"account_id"`
+	Payload     json.RawMessage `bson:"payload" json:"payload"`
+	OccurredAt  time.Time       `bson:"occurred_at" json:"occurred_at"`
+	PublishedAt *time.Time      `bson:"published_at,omitempty" json:"-"`
+}
+
+// AccountCreatedEvent is the payload of an AccountCreated event
+type AccountCreatedEvent struct {
+	AccountID      string `json:"account_id"`
+	DocumentNumber string `json:"document_number"`
+}
+
+// TransactionCreatedEvent is the payload of a TransactionCreated event
+type TransactionCreatedEvent struct {
+	TransactionID string        `json:"transaction_id"`
+	AccountID     string        `json:"account_id"`
+	OperationID   OperationType `json:"operation_type_id"`
+	Amount        float64       `json:"amount"`
+	Balance       float64       `json:"balance"`
+	EventDate     time.Time     `json:"event_date"`
+}
+
+// DebtDischargedEvent is the payload of a DebtDischarged event, Amount is how much of the debt the payment covered and
+// Balance is what is left of it afterwards
+type DebtDischargedEvent struct {
+	TransactionID        string  `json:"transaction_id"`
+	PaymentTransactionID string  `json:"payment_transaction_id"`
+	AccountID            string  `json:"account_id"`
+	Amount               float64 `json:"amount"`
+	PreviousBalance      float64 `json:"previous_balance"`
+	Balance              float64 `json:"balance"`
+}
+
+type WebhookSubscription struct {
+	ID         bson.ObjectID `bson:"_id,omitempty"`
+	URL        string        `bson:"url"`
+	EventTypes []EventType   `bson:"event_types"`
+	Secret     string        `bson:"secret"`
+	CreatedAt  time.Time     `bson:"created_at"`
+	UpdatedAt  time.Time     `bson:"updated_at"`
+}
+
+// Subscribes reports whether events of the given type should be delivered to the subscription
+func (s WebhookSubscription) Subscribes(eventType EventType) bool {
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookSubscriptionRequestBody model info
+//
+//	@Description	Webhook subscription request body
+//	@Description	URL to deliver to, the event types to deliver and the secret deliveries are signed with
+//	@Description	A secret is generated when it is left out
+type WebhookSubscriptionRequestBody struct {
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"event_types" enums:"AccountCreated,TransactionCreated,DebtDischarged"`
+	Secret     string      `json:"secret,omitempty"`
+}
+
+// WebhookSubscriptionResponseBody model info
+//
+//	@Description	Webhook subscription response body
+//	@Description	The secret is only returned when the subscription is created
+type WebhookSubscriptionResponseBody struct {
+	SubscriptionID string      `json:"subscription_id"`
+	URL            string      `json:"url"`
+	EventTypes     []EventType `json:"event_types"`
+	Secret         string      `json:"secret,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending    WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded  WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryDeadLetter WebhookDeliveryStatus = "dead_letter" // every attempt failed, it is only retried again when replayed
+)
+
+// WebhookDelivery is one event on its way to one subscription, Body is the exact JSON that is posted and signed
+type WebhookDelivery struct {
+	ID             bson.ObjectID         `bson:"_id,omitempty"`
+	SubscriptionID string                `bson:"subscription_id"`
+	EventID        string                `bson:"event_id"`
+	EventType      EventType             `bson:"event_type"`
+	Body           json.RawMessage       `bson:"body"`
+	Status         WebhookDeliveryStatus `bson:"status"`
+	Attempts       int                   `bson:"attempts"`
+	NextAttemptAt  time.Time             `bson:"next_attempt_at"`
+	LastError      string                `bson:"last_error,omitempty"`
+	LastStatusCode int                   `bson:"last_status_code,omitempty"`
+	CreatedAt      time.Time             `bson:"created_at"`
+	DeliveredAt    *time.Time            `bson:"delivered_at,omitempty"`
+}
+
+// WebhookDeliveryFilter narrows down the deliveries listed, zero values are ignored
+type WebhookDeliveryFilter struct {
+	SubscriptionID string
+	Status         WebhookDeliveryStatus
+}
+
+// WebhookDeliveryResponseBody model info
+//
+//	@Description	Webhook delivery response body
+//	@Description	State of a single event delivery to a subscription
+type WebhookDeliveryResponseBody struct {
+	DeliveryID     string                `json:"delivery_id"`
+	SubscriptionID string                `json:"subscription_id"`
+	EventID        string                `json:"event_id"`
+	EventType      EventType             `json:"event_type"`
+	Status         WebhookDeliveryStatus `json:"status" enums:"pending,succeeded,dead_letter"`
+	Attempts       int                   `json:"attempts"`
+	NextAttemptAt  *time.Time            `json:"next_attempt_at,omitempty"`
+	LastError      string                `json:"last_error,omitempty"`
+	LastStatusCode int                   `json:"last_status_code,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+}
+
+type AuditAction string
+
+const (
+	AuditActionCreate AuditAction = "create"
+	AuditActionUpdate AuditAction = "update"
+)
+
+type AuditEntityType string
+
+const (
+	AuditEntityAccount     AuditEntityType = "account"
+	AuditEntityTransaction AuditEntityType = "transaction"
+)
+
+// AuditRecord is an append only record of a single mutation, Before is empty for creates. Snapshots are the JSON of the
+// entity as it was stored so they survive later changes to the models
+type AuditRecord struct {
+	ID         bson.ObjectID   `bson:"_id,omitempty"`
+	Actor      string          `bson:"actor"`
+	RequestID  string          `bson:"request_id,omitempty"`
+	Timestamp  time.Time       `bson:"timestamp"`
+	Action     AuditAction     `bson:"action"`
+	EntityType AuditEntityType `bson:"entity_type"`
+	EntityID   string          `bson:"entity_id"`
+	Before     json.RawMessage `bson:"before,omitempty"`
+	After      json.RawMessage `bson:"after,omitempty"`
+}
+
+// AuditRecordResponseBody model info
+//
+//	@Description	Audit record response body
+//	@Description	Who changed an entity, when, and what it looked like before and after the change
+type AuditRecordResponseBody struct {
+	AuditID    string          `json:"audit_id"`
+	Actor      string          `json:"actor"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Action     AuditAction     `json:"action" enums:"create,update"`
+	EntityType AuditEntityType `json:"entity_type" enums:"account,transaction"`
+	EntityID   string          `json:"entity_id"`
+	Before     json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After      json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+}
+
+// LedgerAccount is an account in the chart of accounts, customer balances are kept per transaction underneath
+// LedgerAccountReceivable
+type LedgerAccount string
+
+const (
+	LedgerAccountReceivable      LedgerAccount = "customer_receivable" // asset, what customers owe for purchases and withdrawals
+	LedgerAccountCash            LedgerAccount = "cash"                // asset, money received from payments and paid out by withdrawals
+	LedgerAccountMerchantPayable LedgerAccount = "merchant_payable"    // liability, purchases owed to merchants through the card network
+	LedgerAccountCustomerCredit  LedgerAccount = "customer_credit"     // liability, payments received beyond what the customer owed
+	LedgerAccountFeeIncome       LedgerAccount = "fee_income"          // income, fees charged to customers
+	// LedgerAccountSuspense takes the other side of the adjustments posted when a reconciliation repairs drifted
+	// balances, what is on it is drift that still has to be explained
+	LedgerAccountSuspense LedgerAccount = "reconciliation_suspense"
+	// LedgerAccountOpeningBalances takes the other side of what was paid off debts, and allocated out of payments, before
+	// the journal was introduced, since which payment paid which debt was never recorded. It nets to zero for an
+	// account whose stored balances agree with each other
+	LedgerAccountOpeningBalances LedgerAccount = "opening_balances"
+)
+
+type EntrySide string
+
+const (
+	EntrySideDebit  EntrySide = "debit"
+	EntrySideCredit EntrySide = "credit"
+)
+
+// JournalEntry is one balanced posting to the ledger, the debit lines always add up to the credit lines. Entries are
+// only ever appended, a correction is a new entry
+type JournalEntry struct {
+	ID            bson.ObjectID `bson:"_id,omitempty"`
+	TransactionID string        `bson:"transaction_id"` // the transaction that caused the posting
+	AccountID     string        `bson:"account_id"`
+	PostedAt      time.Time     `bson:"posted_at"`
+	Lines         []JournalLine `bson:"lines"`
+}
+
+type JournalLine struct {
+	LedgerAccount LedgerAccount `bson:"ledger_account"`
+	Side          EntrySide     `bson:"side"`
+	AmountCents   int64         `bson:"amount_cents"`
+	// AppliesTo is the debt transaction a receivable line belongs to, a credit to the receivable is an allocation of a
+	// payment to that debt
+	AppliesTo string `bson:"applies_to,omitempty"`
+}
+
+// TrialBalanceLine is the total posted to one ledger account
+type TrialBalanceLine struct {
+	LedgerAccount LedgerAccount
+	DebitCents    int64
+	CreditCents   int64
+}
+
+// TrialBalanceAccount model info
+//
+//	@Description	Trial balance account
+//	@Description	Totals posted to one ledger account in cents, balance is debits minus credits
+type TrialBalanceAccount struct {
+	LedgerAccount LedgerAccount `json:"ledger_account"`
+	DebitCents    int64         `json:"debit_cents"`
+	CreditCents   int64         `json:"credit_cents"`
+	BalanceCents  int64         `json:"balance_cents"`
+}
+
+// TrialBalanceResponseBody model info
+//
+//	@Description	Trial balance response body
+//	@Description	Totals for every ledger account, balanced is true when total debits equal total credits
+type TrialBalanceResponseBody struct {
+	Accounts         []TrialBalanceAccount `json:"accounts"`
+	TotalDebitCents  int64                 `json:"total_debit_cents"`
+	TotalCreditCents int64                 `json:"total_credit_cents"`
+	Balanced         bool                  `json:"balanced"`
+}
+
+// AccountCacheStatsResponseBody model info
+//
+//	@Description	Account cache stats response body
+//	@Description	Counters of the account cache of the instance answering since it started, and the accounts it holds now
+type AccountCacheStatsResponseBody struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+}
+
+// ReconciliationRequestBody model info
+//
+//	@Description	Reconciliation request body
+//	@Description	Leave account_id out to reconcile every account, the journal is only adjusted when repair is true
+type ReconciliationRequestBody struct {
+	AccountID string `json:"account_id,omitempty"`
+	Repair    bool   `json:"repair"`
+}
+
+// TransactionDrift model info
+//
+//	@Description	Transaction drift
+//	@Description	A transaction whose balance in the journal differs from the balance replaying the account gives it
+type TransactionDrift struct {
+	TransactionID   string  `json:"transaction_id"`
+	StoredBalance   float64 `json:"stored_balance"`
+	ExpectedBalance float64 `json:"expected_balance"`
+	Repaired        bool    `json:"repaired"`
+}
+
+// AccountDrift model info
+//
+//	@Description	Account drift
+//	@Description	The transactions of one account that have drifted, drift is the sum of expected minus stored balances
+type AccountDrift struct {
+	AccountID    string             `json:"account_id"`
+	Drift        float64            `json:"drift"`
+	Transactions []TransactionDrift `json:"transactions"`
+	// Error is set when the account could not be repaired, nothing was written to it
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+// ReconciliationResponseBody model info
+//
+//	@Description	Reconciliation response body
+//	@Description	Counts of what was checked and the drift found per account
+type ReconciliationResponseBody struct {
+	AccountsChecked     int            `json:"accounts_checked"`
+	TransactionsChecked int            `json:"transactions_checked"`
+	TransactionsDrifted int            `json:"transactions_drifted"`
+	Repaired            int            `json:"repaired"`
+	Failed              int            `json:"failed"` // accounts whose repair failed
+	Accounts            []AccountDrift `json:"accounts"`
+}
+
+// AccountBalance is the account_balances projection of an account's position, it is written in the same database
+// transaction as every transaction on the account so it can be read instead of the transactions themselves.
+// Amounts are kept in cents so the running totals do not pick up float noise
+type AccountBalance struct {
+	AccountID            string     `bson:"_id"`
+	TotalDebtCents       int64      `bson:"total_debt_cents"`       // what is still owed on purchases and withdrawals
+	AvailableCreditCents int64      `bson:"available_credit_cents"` // what has been paid beyond the debt, held as customer credit
+	LastTransactionAt    time.Time  `bson:"last_transaction_at,omitempty"`
+	Version              int64      `bson:"version"` // bumped on every write, a write expecting an older version fails
+	OpenDebts            []OpenDebt `bson:"open_debts"`
+}
+
+// OpenDebt is a transaction that still has something owed on it, open debts are kept oldest first which is the order
+// payments discharge them in
+type OpenDebt struct {
+	TransactionID string `bson:"transaction_id"`
+	BalanceCents  int64  `bson:"balance_cents"` // negative while something is owed
+}
+
+// AccountBalanceResponseBody model info
+//
+//	@Description	Account balance response body
+//	@Description	What the account owes and the credit it holds, version increases with every transaction
+//	@Description	A balance as of a past time is worked out from the journal and lists the state of every debt instead of a version
+type AccountBalanceResponseBody struct {
+	AccountID         string     `json:"account_id"`
+	TotalDebt         float64    `json:"total_debt"`
+	AvailableCredit   float64    `json:"available_credit"`
+	OpenDebts         int        `json:"open_debts"`
+	LastTransactionAt *time.Time `json:"last_transaction_at,omitempty"`
+	Version           int64      `json:"version"`
+	AsOf              *time.Time `json:"as_of,omitempty"`
+	Debts             []Debt     `json:"debts,omitempty"` // only returned for as_of queries
+}
+
+// Debt model info
+//
+//	@Description	Debt
+//	@Description	How much of a purchase or withdrawal had been paid off at the time of the balance
+type Debt struct {
+	TransactionID string  `json:"transaction_id"`
+	Amount        float64 `json:"amount"`
+	Paid          float64 `json:"paid"`
+	Balance       float64 `json:"balance"` // negative while something is owed
 }
@@ -0,0 +1,119 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+)
+
+const (
+	// DefaultPollInterval is how long the relay waits between polls once the outbox has been drained
+	DefaultPollInterval = time.Second
+	// relayBatchSize is the most events read from the outbox per poll
+	relayBatchSize = 100
+)
+
+// EventPublisher delivers an outbox event to whatever is downstream, a broker, webhooks or just the log. Publish may be
+// called more than once for the same event, e.g. when the relay crashes after publishing but before marking the event,
+// so consumers should deduplicate on the event ID
+type EventPublisher interface {
+	Publish(ctx context.Context, event model.OutboxEvent) error
+}
+
+// PublisherFunc lets an ordinary function be used as an EventPublisher
+type PublisherFunc func(ctx context.Context, event model.OutboxEvent) error
+
+func (f PublisherFunc) Publish(ctx context.Context, event model.OutboxEvent) error {
+	return f(ctx, event)
+}
+
+// LogPublisher writes each event to the logger, it is the default when nothing else is configured
+type LogPublisher struct {
+	logger *slog.Logger
+}
+
+func NewLogPublisher(logger *slog.Logger) *LogPublisher {
+	return &LogPublisher{logger: logger}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, event model.OutboxEvent) error {
+	p.logger.InfoContext(ctx, "domain event", "eventID", event.ID.Hex(), "type", event.Type, "aggregateID", event.AggregateID, "accountID", event.AccountID, "payload", string(event.Payload))
+	return nil
+}
+
+// NewEvent builds an outbox event with the payload encoded as JSON, it is appended with repository.AppendOutboxEvent
+func NewEvent(eventType model.EventType, aggregateID string, accountID string, payload any) (model.OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return model.OutboxEvent{}, fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+	return model.OutboxEvent{
+		Type:        eventType,
+		AggregateID: aggregateID,
+		AccountID:   accountID,
+		Payload:     data,
+		OccurredAt:  time.Now().UTC(),
+	}, nil
+}
+
+// Relay moves events from the outbox to an EventPublisher. Events are published in the order they occurred and a
+// failure stops the batch, so later events are never published ahead of an earlier one that is still being retried
+type Relay struct {
+	repo      repository.DatabaseRepository
+	publisher EventPublisher
+	logger    *slog.Logger
+	interval  time.Duration
+}
+
+func NewRelay(repo repository.DatabaseRepository, publisher EventPublisher, logger *slog.Logger, interval time.Duration) *Relay {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	logger.InfoContext(context.Background(), "outbox Relay initialized", "interval", interval.String())
+	return &Relay{repo: repo, publisher: publisher, logger: logger, interval: interval}
+}
+
+// Run polls the outbox until ctx is cancelled. A full batch is followed straight away by the next one so a backlog is
+// drained without waiting for the interval
+func (r *Relay) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		published, err := r.RelayOnce(ctx)
+		if err != nil {
+			r.logger.ErrorContext(ctx, "failed to relay outbox events", "error", err)
+		}
+		if err == nil && published == relayBatchSize {
+			timer.Reset(0)
+			continue
+		}
+		timer.Reset(r.interval)
+	}
+}
+
+// RelayOnce publishes one batch of unpublished events and returns how many were published
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	events, err := r.repo.FindUnpublishedOutboxEvents(ctx, relayBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for i, event := range events {
+		if err := r.publisher.Publish(ctx, event); err != nil {
+			return i, fmt.Errorf("failed to publish event %s: %w", event.ID.Hex(), err)
+		}
+		if err := r.repo.MarkOutboxEventPublished(ctx, event.ID.Hex(), time.Now().UTC()); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
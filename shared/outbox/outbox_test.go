@@ -0,0 +1,168 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// fakeOutboxRepo keeps the outbox in a slice, only the outbox methods of the repository are implemented
+type fakeOutboxRepo struct {
+	repository.DatabaseRepository
+	events    []model.OutboxEvent
+	published []string
+	findErr   error
+}
+
+func (f *fakeOutboxRepo) FindUnpublishedOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	if f.findErr != nil {
+		return nil, f.findErr
+	}
+	var events []model.OutboxEvent
+	for _, event := range f.events {
+		if event.PublishedAt == nil && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (f *fakeOutboxRepo) MarkOutboxEventPublished(ctx context.Context, eventID string, publishedAt time.Time) error {
+	for i := range f.events {
+		if f.events[i].ID.Hex() == eventID {
+			f.events[i].PublishedAt = &publishedAt
+			f.published = append(f.published, eventID)
+			return nil
+		}
+	}
+	return errors.New("outbox event not found")
+}
+
+func newFakeOutboxRepo(t *testing.T, count int) *fakeOutboxRepo {
+	repo := &fakeOutboxRepo{}
+	for i := 0; i < count; i++ {
+		event, err := NewEvent(model.EventTypeAccountCreated, "acc", "acc", model.AccountCreatedEvent{AccountID: "acc"})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		event.ID = bson.NewObjectID()
+		repo.events = append(repo.events, event)
+	}
+	return repo
+}
+
+func TestNewEvent(t *testing.T) {
+	event, err := NewEvent(model.EventTypeTransactionCreated, "tx-1", "acc-1", model.TransactionCreatedEvent{TransactionID: "tx-1", Amount: -10})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if event.Type != model.EventTypeTransactionCreated || event.AggregateID != "tx-1" || event.AccountID != "acc-1" || event.OccurredAt.IsZero() {
+		t.Errorf("unexpected event %+v", event)
+	}
+	var payload model.TransactionCreatedEvent
+	if err := json.Unmarshal(event.Payload, &payload); err != nil || payload.Amount != -10 {
+		t.Errorf("expected the payload to round trip, got %+v (%v)", payload, err)
+	}
+}
+
+func TestRelayOnce(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	tests := []struct {
+		name     string
+		repo     *fakeOutboxRepo
+		failAt   int
+		validate func(t *testing.T, repo *fakeOutboxRepo, delivered []string, published int, err error)
+	}{
+		{
+			name:   "Publishes every event in order",
+			repo:   newFakeOutboxRepo(t, 3),
+			failAt: -1,
+			validate: func(t *testing.T, repo *fakeOutboxRepo, delivered []string, published int, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if published != 3 || len(repo.published) != 3 {
+					t.Fatalf("expected 3 events published, got %d", published)
+				}
+				for i, event := range repo.events {
+					if delivered[i] != event.ID.Hex() {
+						t.Errorf("expected event %d to be delivered in order", i)
+					}
+				}
+			},
+		},
+		{
+			name:   "Stops at the first failure",
+			repo:   newFakeOutboxRepo(t, 3),
+			failAt: 1,
+			validate: func(t *testing.T, repo *fakeOutboxRepo, delivered []string, published int, err error) {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				if published != 1 || len(repo.published) != 1 || repo.events[1].PublishedAt != nil || repo.events[2].PublishedAt != nil {
+					t.Errorf("expected only the first event to be marked published, got %v", repo.published)
+				}
+				if len(delivered) != 2 {
+					t.Errorf("expected nothing to be attempted after the failure, got %d attempts", len(delivered))
+				}
+			},
+		},
+		{
+			name:   "Outbox read fails",
+			repo:   &fakeOutboxRepo{findErr: errors.New("database error")},
+			failAt: -1,
+			validate: func(t *testing.T, repo *fakeOutboxRepo, delivered []string, published int, err error) {
+				if err == nil || published != 0 {
+					t.Fatalf("expected error and nothing published, got %d (%v)", published, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delivered []string
+			publisher := PublisherFunc(func(ctx context.Context, event model.OutboxEvent) error {
+				delivered = append(delivered, event.ID.Hex())
+				if len(delivered)-1 == tt.failAt {
+					return errors.New("broker unavailable")
+				}
+				return nil
+			})
+			relay := NewRelay(tt.repo, publisher, logger, time.Millisecond)
+			published, err := relay.RelayOnce(context.Background())
+			tt.validate(t, tt.repo, delivered, published, err)
+		})
+	}
+}
+
+func TestRelayRun(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	repo := newFakeOutboxRepo(t, relayBatchSize+5)
+	done := make(chan struct{})
+	publisher := PublisherFunc(func(ctx context.Context, event model.OutboxEvent) error {
+		if event.ID == repo.events[len(repo.events)-1].ID {
+			close(done)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the interval is far longer than the test so the second batch is only reached if a full batch is followed straight away
+	go NewRelay(repo, publisher, logger, time.Hour).Run(ctx)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the relay to drain the outbox")
+	}
+}
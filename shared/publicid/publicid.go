@@ -0,0 +1,82 @@
+package publicid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Prefixes name what a public ID refers to, the rest of the ID is a ULID
+const (
+	AccountPrefix     = "acc_"
+	TransactionPrefix = "txn_"
+)
+
+// crockford is the Crockford base32 alphabet ULIDs are written in, it leaves out I, L, O and U so IDs are not misread
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidLength is how many characters the 128 bits of a ULID take, the first one only carries 3 bits
+const ulidLength = 26
+
+// New returns prefix followed by a ULID made at t. ULIDs start with the time in milliseconds so IDs made later sort
+// after earlier ones, the remaining 80 bits are random
+func New(prefix string, t time.Time) string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(t.UnixMilli())<<16)
+	_, _ = rand.Read(id[6:])
+	return prefix + encode(id)
+}
+
+func NewAccountID() string {
+	return New(AccountPrefix, time.Now())
+}
+
+func NewTransactionID() string {
+	return New(TransactionPrefix, time.Now())
+}
+
+// DeprecationHeader is set on responses to requests that named an account or transaction by its internal ID, which is
+// still accepted while clients move over to public IDs
+const DeprecationHeader = "Deprecation"
+
+// Valid reports whether id is prefix followed by a well formed ULID
+func Valid(id string, prefix string) bool {
+	ulid, ok := strings.CutPrefix(id, prefix)
+	if !ok || len(ulid) != ulidLength || ulid[0] > '7' {
+		return false
+	}
+	for i := range len(ulid) {
+		if strings.IndexByte(crockford, ulid[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encode writes the 128 bits of id 5 at a time, most significant first, after 2 leading zero bits that pad it to 130
+func encode(id [16]byte) string {
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	var out [ulidLength]byte
+	for i := ulidLength - 1; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// Deprecate returns middleware that sets the DeprecationHeader when the route's id is not a public ID with prefix
+func Deprecate(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id := chi.URLParam(r, "id"); id != "" && !Valid(id, prefix) {
+				w.Header().Set(DeprecationHeader, "true")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
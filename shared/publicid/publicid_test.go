@@ -0,0 +1,76 @@
+package publicid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestNew(t *testing.T) {
+	at := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+	id := New(AccountPrefix, at)
+	if !Valid(id, AccountPrefix) || len(id) != len(AccountPrefix)+ulidLength {
+		t.Fatalf("expected a valid account ID, got %s", id)
+	}
+	// the first 10 characters are the time, 1735812000000 milliseconds
+	if got := id[len(AccountPrefix) : len(AccountPrefix)+10]; got != "01JGK7AA80" {
+		t.Errorf("expected the time to be encoded as 01JGK7AA80, got %s", got)
+	}
+	if other := New(AccountPrefix, at); other == id {
+		t.Errorf("expected IDs made at the same time to differ, got %s twice", id)
+	}
+
+	var ids []string
+	for i := range 5 {
+		ids = append(ids, New(TransactionPrefix, at.Add(time.Duration(i)*time.Millisecond)))
+	}
+	if !slices.IsSorted(ids) {
+		t.Errorf("expected IDs to sort in the order they were made, got %v", ids)
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{id: "acc_01JGK7AA80ABCDEFGHJKMNPQRS", valid: true},
+		{id: "txn_01JGK7AA80ABCDEFGHJKMNPQRS"},
+		{id: "acc_01JGK7AA80ABCDEFGHJKMNPQR"},
+		{id: "acc_01JGK7AA80ABCDEFGHJKMNPQRSZ"},
+		{id: "acc_01JGK7AA80ABCDEFGHJKMNPQRU"},
+		{id: "acc_81JGJ1JH00ABCDEFGHJKMNPQRS"},
+		{id: "acc_01jgj1jh00abcdefghjkmnpqrs"},
+		{id: "6787f1c2a1b2c3d4e5f60718"},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.id, AccountPrefix); got != tt.valid {
+			t.Errorf("expected Valid(%s) to be %v, got %v", tt.id, tt.valid, got)
+		}
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.With(Deprecate(AccountPrefix)).Get("/accounts/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		id         string
+		deprecated bool
+	}{
+		{id: NewAccountID()},
+		{id: "6787f1c2a1b2c3d4e5f60718", deprecated: true},
+		{id: NewTransactionID(), deprecated: true},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/accounts/"+tt.id, nil))
+		if got := rec.Header().Get(DeprecationHeader) == "true"; got != tt.deprecated {
+			t.Errorf("expected %s to be deprecated: %v, got %v", tt.id, tt.deprecated, got)
+		}
+	}
+}
@@ -2,16 +2,136 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/joolshouston/pismo-technical-test/shared/model"
 )
 
+// ErrNotFound is returned, wrapped, when the document a call needs does not exist, whichever backend is behind the
+// repository. Lookups documented to return nil when nothing matches do that instead
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned, wrapped, when a write would store a second account with the same document number or a second
+// transaction with the same idempotency key
+var ErrDuplicate = errors.New("duplicate")
+
+// ErrUnavailable is returned, wrapped, when a call failed for a reason that may go away on its own, like a lost
+// connection, a replica set electing a new primary or a write conflicting with another transaction. Retrying the call,
+// or the whole transaction it was part of, may succeed
+var ErrUnavailable = errors.New("unavailable")
+
+// ErrConflict is returned, wrapped, when a write expected a version of the document other than the stored one, someone
+// else changed it since it was read. Reading it again and redoing the write may succeed
+var ErrConflict = errors.New("conflict")
+
 type DatabaseRepository interface {
+	// CreateAccount stores the account with version 1 and a new public ID, it returns ErrDuplicate when an account with the document number already exists
 	CreateAccount(ctx context.Context, documentID string) (*model.Account, error)
+	// GetAccountByID looks the account up by its public ID or by its internal ID, it returns ErrNotFound when the
+	// account does not exist
 	GetAccountByID(ctx context.Context, accountID string) (*model.Account, error)
 	GetAccountByDocumentNumber(ctx context.Context, documentNumber string) (*model.Account, error)
+	// CreateTransaction stores the transaction with version 1 and, unless it has one, a new public ID. It returns
+	// ErrDuplicate when a transaction with the idempotency key already exists
 	CreateTransaction(ctx context.Context, transaction model.Transaction) (*model.Transaction, error)
+	// GetTransactionByID looks the transaction up by its public ID or by its internal ID, it returns nil without an
+	// error when the transaction does not exist
+	GetTransactionByID(ctx context.Context, transactionID string) (*model.Transaction, error)
 	FindTransactionByIdempotencyKey(ctx context.Context, idempotencyKey string) (*model.Transaction, error)
+	// FindAllTransactionsForAccountID returns the account's transactions oldest first
 	FindAllTransactionsForAccountID(ctx context.Context, accountID string) ([]model.Transaction, error)
-	UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction) error
+	// UpdateTransactionByID replaces the transaction with the internal ID if its stored version is expectedVersion, storing it with the next
+	// version. It returns ErrNotFound when the transaction does not exist and ErrConflict when its version is another
+	UpdateTransactionByID(ctx context.Context, transactionID string, transaction model.Transaction, expectedVersion int64) error
+	StreamTransactions(ctx context.Context, filter model.TransactionFilter, fn func(model.Transaction) error) error
+	// WithTransaction runs fn inside a database transaction, every repository call made with the ctx passed to fn is
+	// committed or rolled back together. fn may be called more than once if the transaction has to be retried
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	// AppendOutboxEvent stores an event in the outbox, call it with the ctx of the transaction making the change the
+	// event describes so both are committed together
+	AppendOutboxEvent(ctx context.Context, event model.OutboxEvent) error
+	// FindUnpublishedOutboxEvents returns up to limit events that have not been published yet, oldest first
+	FindUnpublishedOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error)
+	MarkOutboxEventPublished(ctx context.Context, eventID string, publishedAt time.Time) error
+	// PostJournalEntry appends a balanced entry to the journal, call it with the ctx of the transaction it belongs to
+	PostJournalEntry(ctx context.Context, entry model.JournalEntry) error
+	// FindJournalEntries returns the account's entries posted at or before postedBy, oldest first
+	FindJournalEntries(ctx context.Context, accountID string, postedBy time.Time) ([]model.JournalEntry, error)
+	// TrialBalance totals the debits and credits posted to every ledger account, ordered by ledger account
+	TrialBalance(ctx context.Context) ([]model.TrialBalanceLine, error)
+	// GetAccountBalance returns nil without an error when the account has no balance projection yet
+	GetAccountBalance(ctx context.Context, accountID string) (*model.AccountBalance, error)
+	// SaveAccountBalance writes the projection only if the stored version is the one before balance.Version, a
+	// version of 1 creates it. Call it with the ctx of the transaction that moved the balance
+	SaveAccountBalance(ctx context.Context, balance model.AccountBalance) error
+}
+
+// WebhookRepository stores webhook subscriptions and the deliveries made to them. Lookups by ID return nil without an
+// error when nothing matches, updates and deletes of a missing document return an error
+type WebhookRepository interface {
+	CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (*model.WebhookSubscription, error)
+	GetWebhookSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscription, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error)
+	UpdateWebhookSubscription(ctx context.Context, subscriptionID string, subscription model.WebhookSubscription) error
+	DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error
+	// CreateWebhookDelivery stores a delivery unless one already exists for the same subscription and event, so an
+	// event published twice is still only delivered once
+	CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error
+	GetWebhookDelivery(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error)
+	ListWebhookDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter, limit int) ([]model.WebhookDelivery, error)
+	// ClaimDueWebhookDeliveries returns up to limit pending deliveries whose next attempt is due at now, pushing their next
+	// attempt out to leaseUntil so no other dispatcher picks them up while they are being sent
+	ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error)
+	// UpdateWebhookDelivery replaces the delivery as long as its next attempt is still at expectedNextAttemptAt, the lease
+	// it was claimed with, and returns ErrConflict once it has been claimed or written since
+	UpdateWebhookDelivery(ctx context.Context, deliveryID string, delivery model.WebhookDelivery, expectedNextAttemptAt time.Time) error
+}
+
+// LeaseRepository hands out named leases, each held by at most one owner until it expires or is released. Instances
+// sharing the store use them to take turns at work that must not run concurrently. Call it with a ctx outside of any
+// transaction so the lease is seen by everyone as soon as it is taken
+type LeaseRepository interface {
+	// AcquireLease gives the lease on name to owner until expiresAt unless another owner holds it past now, and reports
+	// whether owner holds it. An owner that already holds the lease extends it
+	AcquireLease(ctx context.Context, name string, owner string, now time.Time, expiresAt time.Time) (bool, error)
+	// ReleaseLease lets go of the lease if owner still holds it and does nothing otherwise
+	ReleaseLease(ctx context.Context, name string, owner string) error
+}
+
+// JobRepository stores background jobs so every instance sharing the store can report on them, and they outlive the
+// instance that ran them
+type JobRepository interface {
+	CreateJob(ctx context.Context, job model.Job) error
+	// GetJob returns nil without an error when the job does not exist
+	GetJob(ctx context.Context, jobID string) (*model.Job, error)
+	// UpdateJob replaces the job, it returns ErrNotFound when the job does not exist
+	UpdateJob(ctx context.Context, job model.Job) error
+	// DeleteJobsUpdatedBefore removes the jobs last updated before before and returns their IDs
+	DeleteJobsUpdatedBefore(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// AuditRepository stores audit records, it has no way to change or remove a record once it has been appended
+type AuditRepository interface {
+	AppendAuditRecord(ctx context.Context, record model.AuditRecord) error
+	// FindAuditRecords returns up to limit records for the entity, oldest first
+	FindAuditRecords(ctx context.Context, entityType model.AuditEntityType, entityID string, limit int) ([]model.AuditRecord, error)
+}
+
+// BackupRepository reads every account, transaction, journal entry and audit record out of the store for a backup, and
+// writes accounts and transactions back exactly as they were read, IDs and versions included. Journal entries and audit
+// records already keep the IDs they are written with
+type BackupRepository interface {
+	// Snapshot runs fn with a ctx on which every read sees the store as it was when fn started, fn must only read
+	Snapshot(ctx context.Context, fn func(ctx context.Context) error) error
+	// StreamAccounts calls fn with every account, in the order they were created
+	StreamAccounts(ctx context.Context, fn func(model.Account) error) error
+	// StreamJournalEntries calls fn with every journal entry, oldest first
+	StreamJournalEntries(ctx context.Context, fn func(model.JournalEntry) error) error
+	// StreamAuditRecords calls fn with every audit record, oldest first
+	StreamAuditRecords(ctx context.Context, fn func(model.AuditRecord) error) error
+	// Empty reports whether the store holds no accounts, transactions, journal entries or audit records
+	Empty(ctx context.Context) (bool, error)
+	RestoreAccount(ctx context.Context, account model.Account) error
+	RestoreTransaction(ctx context.Context, transaction model.Transaction) error
 }
@@ -0,0 +1,782 @@
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/publicid"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Factory returns an empty repository. It is called once per test so no test sees what another one wrote
+type Factory func(t *testing.T) repository.DatabaseRepository
+
+// Run checks that the repositories made by newRepository keep the contract documented on repository.DatabaseRepository,
+// every backend is expected to pass it unchanged
+func Run(t *testing.T, newRepository Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo repository.DatabaseRepository)
+	}{
+		{name: "Accounts", test: testAccounts},
+		{name: "Transactions", test: testTransactions},
+		{name: "UpdateTransaction", test: testUpdateTransaction},
+		{name: "TransactionOrder", test: testTransactionOrder},
+		{name: "StreamTransactions", test: testStreamTransactions},
+		{name: "WithTransaction", test: testWithTransaction},
+		{name: "Outbox", test: testOutbox},
+		{name: "Journal", test: testJournal},
+		{name: "AccountBalance", test: testAccountBalance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepository(t))
+		})
+	}
+}
+
+func testAccounts(t *testing.T, repo repository.DatabaseRepository) {
+	ctx := context.Background()
+	created, err := repo.CreateAccount(ctx, "12345678900")
+	if err != nil || created.ID.IsZero() || !publicid.Valid(created.PublicID, publicid.AccountPrefix) || created.DocumentNumber != "12345678900" || created.Version != 1 {
+		t.Fatalf("expected the account with an ID and a public ID at version 1, got %+v, %v", created, err)
+	}
+
+	if acc, err := repo.GetAccountByID(ctx, created.ID.Hex()); err != nil || *acc != *created {
+		t.Errorf("expected %+v by ID, got %+v, %v", created, acc, err)
+	}
+	if acc, err := repo.GetAccountByID(ctx, created.PublicID); err != nil || *acc != *created {
+		t.Errorf("expected %+v by public ID, got %+v, %v", created, acc, err)
+	}
+	if acc, err := repo.GetAccountByID(ctx, bson.NewObjectID().Hex()); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown ID, got %+v, %v", acc, err)
+	}
+	if acc, err := repo.GetAccountByID(ctx, publicid.NewAccountID()); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown public ID, got %+v, %v", acc, err)
+	}
+	if acc, err := repo.GetAccountByID(ctx, "not-an-id"); err == nil || errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected a format error for a malformed ID, got %+v, %v", acc, err)
+	}
+	if acc, err := repo.GetAccountByDocumentNumber(ctx, "12345678900"); err != nil || acc == nil || *acc != *created {
+		t.Errorf("expected %+v by document number, got %+v, %v", created, acc, err)
+	}
+	if acc, err := repo.GetAccountByDocumentNumber(ctx, "0"); err != nil || acc != nil {
+		t.Errorf("expected nil without an error for an unknown document number, got %+v, %v", acc, err)
+	}
+
+	if acc, err := repo.CreateAccount(ctx, "12345678900"); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate for a repeated document number, got %+v, %v", acc, err)
+	}
+	if acc, err := repo.GetAccountByDocumentNumber(ctx, "12345678900"); err != nil || acc == nil || acc.ID != created.ID {
+		t.Errorf("expected the first account to be kept, got %+v, %v", acc, err)
+	}
+}
+
+func testTransactions(t *testing.T, repo repository.DatabaseRepository) {
+	ctx := context.Background()
+	tx := model.Transaction{
+		AccountID:      "acc",
+		OperationID:    model.OperationTypePurchase,
+		Amount:         -50.25,
+		EventDate:      date(t, "2025-01-01T10:00:00.123Z"),
+		Balance:        -50.25,
+		IdempotencyKey: "key-1",
+	}
+	created, err := repo.CreateTransaction(ctx, tx)
+	if err != nil || created.ID.IsZero() || !publicid.Valid(created.PublicID, publicid.TransactionPrefix) {
+		t.Fatalf("expected the transaction with an ID and a public ID, got %+v, %v", created, err)
+	}
+	tx.ID = created.ID
+	tx.PublicID = created.PublicID
+	tx.Version = 1
+	if *created != tx {
+		t.Errorf("expected %+v, got %+v", tx, *created)
+	}
+
+	if got, err := repo.GetTransactionByID(ctx, created.ID.Hex()); err != nil || got == nil || *got != tx {
+		t.Errorf("expected %+v by ID, got %+v, %v", tx, got, err)
+	}
+	if got, err := repo.GetTransactionByID(ctx, created.PublicID); err != nil || got == nil || *got != tx {
+		t.Errorf("expected %+v by public ID, got %+v, %v", tx, got, err)
+	}
+	if got, err := repo.GetTransactionByID(ctx, bson.NewObjectID().Hex()); err != nil || got != nil {
+		t.Errorf("expected nil without an error for an unknown ID, got %+v, %v", got, err)
+	}
+	if got, err := repo.GetTransactionByID(ctx, publicid.NewTransactionID()); err != nil || got != nil {
+		t.Errorf("expected nil without an error for an unknown public ID, got %+v, %v", got, err)
+	}
+	if got, err := repo.GetTransactionByID(ctx, "not-an-id"); err != nil || got != nil {
+		t.Errorf("expected nil without an error for a malformed ID, got %+v, %v", got, err)
+	}
+	if got, err := repo.FindTransactionByIdempotencyKey(ctx, "key-1"); err != nil || got == nil || *got != tx {
+		t.Errorf("expected %+v by idempotency key, got %+v, %v", tx, got, err)
+	}
+	if got, err := repo.FindTransactionByIdempotencyKey(ctx, "key-2"); err != nil || got != nil {
+		t.Errorf("expected nil without an error for an unknown idempotency key, got %+v, %v", got, err)
+	}
+
+	retry := tx
+	retry.ID = bson.ObjectID{}
+	retry.PublicID = ""
+	retry.Amount = -99
+	if got, err := repo.CreateTransaction(ctx, retry); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate for a repeated idempotency key, got %+v, %v", got, err)
+	}
+	if all, err := repo.FindAllTransactionsForAccountID(ctx, "acc"); err != nil || len(all) != 1 || all[0] != tx {
+		t.Errorf("expected only the first transaction to be kept, got %+v, %v", all, err)
+	}
+}
+
+func testUpdateTransaction(t *testing.T, repo repository.DatabaseRepository) {
+	ctx := context.Background()
+	created, err := repo.CreateTransaction(ctx, model.Transaction{
+		AccountID:      "acc",
+		OperationID:    model.OperationTypePurchase,
+		Amount:         -100,
+		EventDate:      date(t, "2025-01-01T10:00:00Z"),
+		Balance:        -100,
+		IdempotencyKey: "key-1",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if created.Version != 1 {
+		t.Errorf("expected a created transaction to be at version 1, got %d", created.Version)
+	}
+
+	updated := *created
+	updated.Balance = -40
+	if err := repo.UpdateTransactionByID(ctx, created.ID.Hex(), updated, created.Version); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	updated.Version = 2
+	if got, err := repo.GetTransactionByID(ctx, created.ID.Hex()); err != nil || got == nil || *got != updated {
+		t.Errorf("expected %+v after the update, got %+v, %v", updated, got, err)
+	}
+
+	// a write made from the transaction as it was before the update above
+	stale := *created
+	stale.Balance = 0
+	if err := repo.UpdateTransactionByID(ctx, created.ID.Hex(), stale, created.Version); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict updating from a stale version, got %v", err)
+	}
+	if got, err := repo.GetTransactionByID(ctx, created.ID.Hex()); err != nil || got == nil || *got != updated {
+		t.Errorf("expected the conflicting update to change nothing, got %+v, %v", got, err)
+	}
+
+	missing := bson.NewObjectID()
+	updated.ID = missing
+	if err := repo.UpdateTransactionByID(ctx, missing.Hex(), updated, 1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating an unknown transaction, got %v", err)
+	}
+	if err := repo.UpdateTransactionByID(ctx, "not-an-id", updated, 1); err == nil {
+		t.Errorf("expected an error updating a malformed ID")
+	}
+}
+
+// LeaseFactory returns an empty lease repository, it is called once per test like Factory
+type LeaseFactory func(t *testing.T) repository.LeaseRepository
+
+// RunLeases checks that the lease repositories made by newRepository keep the contract documented on
+// repository.LeaseRepository
+func RunLeases(t *testing.T, newRepository LeaseFactory) {
+	t.Run("Leases", func(t *testing.T) {
+		testLeases(t, newRepository(t))
+	})
+}
+
+func testLeases(t *testing.T, repo repository.LeaseRepository) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	acquire := func(name string, owner string, at time.Time) bool {
+		t.Helper()
+		acquired, err := repo.AcquireLease(ctx, name, owner, at, at.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return acquired
+	}
+
+	if !acquire("account/1", "a", now) {
+		t.Fatalf("expected a free lease to be acquired")
+	}
+	if acquire("account/1", "b", now.Add(30*time.Second)) {
+		t.Errorf("expected a held lease not to be acquired by another owner")
+	}
+	if !acquire("account/2", "b", now) {
+		t.Errorf("expected leases with other names to be independent")
+	}
+	if !acquire("account/1", "a", now.Add(30*time.Second)) {
+		t.Errorf("expected the owner to extend its lease")
+	}
+	// extended to a minute after the last acquire
+	if acquire("account/1", "b", now.Add(time.Minute)) {
+		t.Errorf("expected the extended lease to still be held")
+	}
+	if !acquire("account/1", "b", now.Add(90*time.Second)) {
+		t.Errorf("expected an expired lease to be taken over")
+	}
+
+	// a only lost the lease to b, its release must not free it
+	if err := repo.ReleaseLease(ctx, "account/1", "a"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if acquire("account/1", "c", now.Add(100*time.Second)) {
+		t.Errorf("expected a release by a former owner to leave the lease held")
+	}
+	if err := repo.ReleaseLease(ctx, "account/1", "b"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !acquire("account/1", "c", now.Add(100*time.Second)) {
+		t.Errorf("expected a released lease to be acquired")
+	}
+	if err := repo.ReleaseLease(ctx, "unknown", "c"); err != nil {
+		t.Errorf("expected releasing an unknown lease to do nothing, got %v", err)
+	}
+}
+
+// JobFactory returns an empty job repository, it is called once per test like Factory
+type JobFactory func(t *testing.T) repository.JobRepository
+
+// RunJobs checks that the job repositories made by newRepository keep the contract documented on
+// repository.JobRepository
+func RunJobs(t *testing.T, newRepository JobFactory) {
+	t.Run("Jobs", func(t *testing.T) {
+		testJobs(t, newRepository(t))
+	})
+}
+
+func testJobs(t *testing.T, repo repository.JobRepository) {
+	ctx := context.Background()
+	at := date(t, "2025-01-01T10:00:00Z")
+	job := model.Job{
+		ID:        bson.NewObjectID().Hex(),
+		Tenant:    "acme",
+		Type:      "account_import",
+		Status:    model.JobStatusPending,
+		CreatedAt: at,
+		UpdatedAt: at,
+	}
+	if err := repo.CreateJob(ctx, job); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	stale := model.Job{ID: bson.NewObjectID().Hex(), Type: "account_import", Status: model.JobStatusRunning, CreatedAt: at, UpdatedAt: at}
+	if err := repo.CreateJob(ctx, stale); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	started, finished := at.Add(time.Second), at.Add(time.Minute)
+	job.Status = model.JobStatusCompleted
+	job.Rows, job.Created, job.Duplicates, job.Invalid, job.Failed = 5, 1, 2, 1, 1
+	job.IgnoredColumns = []string{"email", "name"}
+	job.Error = "upload is empty"
+	job.StartedAt = &started
+	job.FinishedAt = &finished
+	job.UpdatedAt = finished
+	if err := repo.UpdateJob(ctx, job); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	got, err := repo.GetJob(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got == nil || !reflect.DeepEqual(*got, job) {
+		t.Errorf("expected the updated job %+v, got %+v", job, got)
+	}
+
+	if got, err := repo.GetJob(ctx, bson.NewObjectID().Hex()); err != nil || got != nil {
+		t.Errorf("expected no job and no error for an unknown ID, got %+v and %v", got, err)
+	}
+	if err := repo.UpdateJob(ctx, model.Job{ID: bson.NewObjectID().Hex(), UpdatedAt: at}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating an unknown job, got %v", err)
+	}
+
+	deleted, err := repo.DeleteJobsUpdatedBefore(ctx, at.Add(time.Second))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !slices.Equal(deleted, []string{stale.ID}) {
+		t.Errorf("expected only the job last updated before the cutoff to be deleted, got %v", deleted)
+	}
+	if got, err := repo.GetJob(ctx, stale.ID); err != nil || got != nil {
+		t.Errorf("expected the deleted job to be gone, got %+v and %v", got, err)
+	}
+	if got, err := repo.GetJob(ctx, job.ID); err != nil || got == nil {
+		t.Errorf("expected the job updated since to be kept, got %+v and %v", got, err)
+	}
+}
+
+// BackfillFactory returns an empty repository along with the backfill that posts an opening journal entry for every
+// transaction made before the journal, it is called once per test like Factory
+type BackfillFactory func(t *testing.T) (repository.DatabaseRepository, func(ctx context.Context) error)
+
+// RunJournalBackfill checks that the opening entry backfill of a backend brings the transactions it stored before the
+// journal into it, and only those
+func RunJournalBackfill(t *testing.T, newRepository BackfillFactory) {
+	t.Run("JournalBackfill", func(t *testing.T) {
+		repo, backfill := newRepository(t)
+		testJournalBackfill(t, repo, backfill)
+	})
+}
+
+func testJournalBackfill(t *testing.T, repo repository.DatabaseRepository, backfill func(ctx context.Context) error) {
+	ctx := context.Background()
+	at := date(t, "2024-06-01T10:00:00Z")
+	// before the journal the payment paid off 30 of the purchase and all of the withdrawal, keeping 30 as credit
+	var transactions []model.Transaction
+	for _, tx := range []model.Transaction{
+		{AccountID: "acc", OperationID: model.OperationTypePurchase, Amount: -50, Balance: -20, EventDate: at, IdempotencyKey: "purchase"},
+		{AccountID: "acc", OperationID: model.OperationTypeWithdrawal, Amount: -20, Balance: 0, EventDate: at.Add(time.Minute), IdempotencyKey: "withdrawal"},
+		{AccountID: "acc", OperationID: model.OperationTypePayment, Amount: 80, Balance: 30, EventDate: at.Add(time.Hour), IdempotencyKey: "payment"},
+		{AccountID: "acc", OperationID: model.OperationTypePurchase, Amount: -10, Balance: 0, EventDate: at.Add(90 * time.Minute), IdempotencyKey: "paid"},
+		{AccountID: "acc", OperationID: model.OperationTypePayment, Amount: 10, Balance: 0, EventDate: at.Add(2 * time.Hour), IdempotencyKey: "journalled"},
+	} {
+		created, err := repo.CreateTransaction(ctx, tx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		transactions = append(transactions, *created)
+	}
+	// the last one was made once the journal was there and paid off the purchase made just before it
+	paid, journalled := transactions[3], transactions[4]
+	if err := repo.PostJournalEntry(ctx, model.JournalEntry{TransactionID: journalled.ID.Hex(), AccountID: "acc", PostedAt: journalled.EventDate, Lines: []model.JournalLine{
+		{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 1000},
+		{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 1000, AppliesTo: paid.ID.Hex()},
+	}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for range 2 {
+		if err := backfill(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	entries, err := repo.FindJournalEntries(ctx, "acc", at.Add(24*time.Hour))
+	if err != nil || len(entries) != len(transactions) {
+		t.Fatalf("expected one entry per transaction however often the backfill runs, got %d, %v", len(entries), err)
+	}
+	balances := make(map[string]int64)
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			if line.AppliesTo == "" {
+				continue
+			}
+			if line.Side == model.EntrySideCredit {
+				balances[line.AppliesTo] += line.AmountCents
+			} else {
+				balances[line.AppliesTo] -= line.AmountCents
+			}
+		}
+	}
+	for _, tx := range transactions {
+		if got := float64(balances[tx.ID.Hex()]) / 100; got != tx.Balance {
+			t.Errorf("expected the journal to give %s its stored balance %v, got %v", tx.IdempotencyKey, tx.Balance, got)
+		}
+	}
+	if entries[0].TransactionID != transactions[0].ID.Hex() || !entries[0].PostedAt.Equal(at) {
+		t.Errorf("expected the opening entries to be posted when their transactions were made, got %+v", entries[0])
+	}
+
+	lines, err := repo.TrialBalance(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var debits, credits int64
+	for _, line := range lines {
+		debits += line.DebitCents
+		credits += line.CreditCents
+		if line.LedgerAccount == model.LedgerAccountOpeningBalances && line.DebitCents != line.CreditCents {
+			t.Errorf("expected the opening balances to net to zero, got %+v", line)
+		}
+	}
+	if debits != credits {
+		t.Errorf("expected the trial balance to balance, got debits %d and credits %d", debits, credits)
+	}
+}
+
+// BackupStore is a store that can be backed up and restored
+type BackupStore interface {
+	repository.DatabaseRepository
+	repository.AuditRepository
+	repository.BackupRepository
+}
+
+// BackupFactory returns an empty store, it is called once per store a test needs like Factory
+type BackupFactory func(t *testing.T) BackupStore
+
+// RunBackups checks that the stores made by newStore keep the contract documented on repository.BackupRepository
+func RunBackups(t *testing.T, newStore BackupFactory) {
+	t.Run("Backups", func(t *testing.T) {
+		testBackups(t, newStore(t), newStore(t))
+	})
+}
+
+func testBackups(t *testing.T, source BackupStore, target BackupStore) {
+	ctx := context.Background()
+	if empty, err := source.Empty(ctx); err != nil || !empty {
+		t.Fatalf("expected a new store to be empty, got %v, %v", empty, err)
+	}
+	acc, err := source.CreateAccount(ctx, "12345678900")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if empty, err := source.Empty(ctx); err != nil || empty {
+		t.Fatalf("expected a store with an account not to be empty, got %v, %v", empty, err)
+	}
+	transactions := createTransactions(t, source, acc.ID.Hex(), model.OperationTypePurchase, "2025-01-02T00:00:00Z", "2025-01-01T00:00:00Z")
+	// written back once, so its version is restored as 2 rather than created as 1
+	transactions[0].Version = 2
+	if err := source.UpdateTransactionByID(ctx, transactions[0].ID.Hex(), transactions[0], 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	entry := model.JournalEntry{ID: bson.NewObjectID(), TransactionID: transactions[1].ID.Hex(), AccountID: acc.ID.Hex(), PostedAt: date(t, "2025-01-01T00:00:00Z"), Lines: []model.JournalLine{
+		{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 1000, AppliesTo: transactions[1].ID.Hex()},
+		{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 1000},
+	}}
+	if err := source.PostJournalEntry(ctx, entry); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	record := model.AuditRecord{ID: bson.NewObjectID(), Actor: "backoffice", Timestamp: date(t, "2025-01-01T00:00:00Z"), Action: model.AuditActionCreate,
+		EntityType: model.AuditEntityAccount, EntityID: acc.ID.Hex(), After: []byte(`{"document_number":"12345678900"}`)}
+	if err := source.AppendAuditRecord(ctx, record); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// everything read from the snapshot is written to the empty target as it was read
+	err = source.Snapshot(ctx, func(ctx context.Context) error {
+		var restored int
+		if err := source.StreamAccounts(ctx, func(acc model.Account) error {
+			restored++
+			return target.RestoreAccount(context.Background(), acc)
+		}); err != nil {
+			return err
+		}
+		if err := source.StreamTransactions(ctx, model.TransactionFilter{}, func(tx model.Transaction) error {
+			restored++
+			return target.RestoreTransaction(context.Background(), tx)
+		}); err != nil {
+			return err
+		}
+		if err := source.StreamJournalEntries(ctx, func(entry model.JournalEntry) error {
+			restored++
+			return target.PostJournalEntry(context.Background(), entry)
+		}); err != nil {
+			return err
+		}
+		if err := source.StreamAuditRecords(ctx, func(record model.AuditRecord) error {
+			restored++
+			return target.AppendAuditRecord(context.Background(), record)
+		}); err != nil {
+			return err
+		}
+		if restored != 5 {
+			return fmt.Errorf("expected 5 documents, streamed %d", restored)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if got, err := target.GetAccountByID(ctx, acc.PublicID); err != nil || *got != *acc {
+		t.Errorf("expected the account restored as %+v, got %+v, %v", *acc, got, err)
+	}
+	for _, tx := range transactions {
+		if got, err := target.GetTransactionByID(ctx, tx.PublicID); err != nil || got == nil || *got != tx {
+			t.Errorf("expected the transaction restored as %+v, got %+v, %v", tx, got, err)
+		}
+	}
+	if entries, err := target.FindJournalEntries(ctx, acc.ID.Hex(), entry.PostedAt); err != nil || len(entries) != 1 || entries[0].ID != entry.ID || !slices.Equal(entries[0].Lines, entry.Lines) {
+		t.Errorf("expected the journal entry restored as %+v, got %+v, %v", entry, entries, err)
+	}
+	if records, err := target.FindAuditRecords(ctx, model.AuditEntityAccount, acc.ID.Hex(), 10); err != nil || len(records) != 1 || records[0].ID != record.ID || string(records[0].After) != string(record.After) {
+		t.Errorf("expected the audit record restored as %+v, got %+v, %v", record, records, err)
+	}
+	if err := target.RestoreAccount(ctx, *acc); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("expected restoring an account twice to fail with ErrDuplicate, got %v", err)
+	}
+	if err := target.RestoreTransaction(ctx, transactions[0]); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("expected restoring a transaction twice to fail with ErrDuplicate, got %v", err)
+	}
+}
+
+// date parses an RFC 3339 time, backends hand event dates back in UTC so they compare equal to it
+func date(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t.Fatalf("invalid date %q: %v", value, err)
+	}
+	return parsed.UTC()
+}
+
+// createTransactions stores a transaction per event date, IDs are handed out in the order given so ties on the event
+// date have a known order
+func createTransactions(t *testing.T, repo repository.DatabaseRepository, accountID string, operation model.OperationType, eventDates ...string) []model.Transaction {
+	t.Helper()
+	var transactions []model.Transaction
+	for _, eventDate := range eventDates {
+		transactions = append(transactions, model.Transaction{
+			ID:             bson.NewObjectID(),
+			PublicID:       publicid.NewTransactionID(),
+			AccountID:      accountID,
+			OperationID:    operation,
+			Amount:         -10,
+			EventDate:      date(t, eventDate),
+			Balance:        -10,
+			IdempotencyKey: bson.NewObjectID().Hex(),
+			Version:        1,
+		})
+	}
+	// stored newest ID first so a backend that returns them in insertion order is caught out
+	for _, tx := range slices.Backward(transactions) {
+		if _, err := repo.CreateTransaction(context.Background(), tx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	return transactions
+}
+
+func testTransactionOrder(t *testing.T, repo repository.DatabaseRepository) {
+	ctx := context.Background()
+	created := createTransactions(t, repo, "acc", model.OperationTypePurchase,
+		"2025-01-03T00:00:00Z", "2025-01-01T00:00:00Z", "2025-01-02T00:00:00Z", "2025-01-02T00:00:00Z")
+	createTransactions(t, repo, "other", model.OperationTypePurchase, "2025-01-01T12:00:00Z")
+
+	all, err := repo.FindAllTransactionsForAccountID(ctx, "acc")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// oldest first, the two on the same date in ID order
+	expected := []model.Transaction{created[1], created[2], created[3], created[0]}
+	if !slices.Equal(all, expected) {
+		t.Errorf("expected %+v, got %+v", expected, all)
+	}
+
+	if none, err := repo.FindAllTransactionsForAccountID(ctx, "none"); err != nil || len(none) != 0 {
+		t.Errorf("expected no transactions for an unknown account, got %+v, %v", none, err)
+	}
+}
+
+func testStreamTransactions(t *testing.T, repo repository.DatabaseRepository) {
+	ctx := context.Background()
+	purchases := createTransactions(t, repo, "acc", model.OperationTypePurchase,
+		"2025-01-01T00:00:00Z", "2025-01-02T00:00:00Z", "2025-01-03T00:00:00Z")
+	payments := createTransactions(t, repo, "acc", model.OperationTypePayment, "2025-01-02T12:00:00Z")
+	others := createTransactions(t, repo, "other", model.OperationTypePurchase, "2025-01-02T06:00:00Z")
+
+	tests := []struct {
+		name     string
+		filter   model.TransactionFilter
+		expected []model.Transaction
+	}{
+		{
+			name:     "Every transaction",
+			expected: []model.Transaction{purchases[0], purchases[1], others[0], payments[0], purchases[2]},
+		},
+		{
+			name:     "By account",
+			filter:   model.TransactionFilter{AccountID: "acc"},
+			expected: []model.Transaction{purchases[0], purchases[1], payments[0], purchases[2]},
+		},
+		{
+			name:     "By operation",
+			filter:   model.TransactionFilter{AccountID: "acc", OperationID: model.OperationTypePayment},
+			expected: payments,
+		},
+		{
+			name: "Range including both ends",
+			filter: model.TransactionFilter{
+				AccountID: "acc",
+				From:      time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+				To:        time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+			},
+			expected: []model.Transaction{purchases[1], payments[0], purchases[2]},
+		},
+		{
+			name: "Range given in another zone",
+			filter: model.TransactionFilter{
+				From: time.Date(2025, 1, 2, 9, 0, 0, 0, time.FixedZone("UTC+3", 3*3600)),
+				To:   time.Date(2025, 1, 2, 9, 0, 0, 0, time.FixedZone("UTC-3", -3*3600)),
+			},
+			expected: []model.Transaction{others[0], payments[0]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var streamed []model.Transaction
+			err := repo.StreamTransactions(ctx, tt.filter, func(tx model.Transaction) error {
+				streamed = append(streamed, tx)
+				return nil
+			})
+			if err != nil || !slices.Equal(streamed, tt.expected) {
+				t.Errorf("expected %+v, got %+v, %v", tt.expected, streamed, err)
+			}
+		})
+	}
+
+	t.Run("Stops on error", func(t *testing.T) {
+		stop := errors.New("stop")
+		calls := 0
+		err := repo.StreamTransactions(ctx, model.TransactionFilter{}, func(tx model.Transaction) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("expected the error of fn after one call, got %v after %d", err, calls)
+		}
+	})
+}
+
+func testWithTransaction(t *testing.T, repo repository.DatabaseRepository) {
+	ctx := context.Background()
+	failed := errors.New("failed")
+	err := repo.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := repo.CreateAccount(ctx, "rolled-back"); err != nil {
+			return err
+		}
+		if _, err := repo.CreateTransaction(ctx, model.Transaction{AccountID: "acc", OperationID: model.OperationTypePayment, Amount: 10, EventDate: date(t, "2025-01-01T00:00:00Z"), Balance: 10, IdempotencyKey: "rolled-back"}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+	if acc, err := repo.GetAccountByDocumentNumber(ctx, "rolled-back"); err != nil || acc != nil {
+		t.Errorf("expected the account to be rolled back, got %+v, %v", acc, err)
+	}
+	if tx, err := repo.FindTransactionByIdempotencyKey(ctx, "rolled-back"); err != nil || tx != nil {
+		t.Errorf("expected the transaction to be rolled back, got %+v, %v", tx, err)
+	}
+
+	var created *model.Account
+	err = repo.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		created, err = repo.CreateAccount(ctx, "committed")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if acc, err := repo.GetAccountByID(ctx, created.ID.Hex()); err != nil || *acc != *created {
+		t.Errorf("expected the account to be committed, got %+v, %v", acc, err)
+	}
+}
+
+func testOutbox(t *testing.T, repo repository.DatabaseRepository) {
+	ctx := context.Background()
+	occurredAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var events []model.OutboxEvent
+	for i, offset := range []time.Duration{time.Hour, 0, 2 * time.Hour} {
+		event := model.OutboxEvent{
+			ID:          bson.NewObjectID(),
+			Type:        model.EventTypeAccountCreated,
+			AggregateID: fmt.Sprintf("aggregate-%d", i),
+			AccountID:   "acc",
+			Payload:     []byte(`{"account_id":"acc"}`),
+			OccurredAt:  occurredAt.Add(offset),
+		}
+		if err := repo.AppendOutboxEvent(ctx, event); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		events = append(events, event)
+	}
+
+	oldest, err := repo.FindUnpublishedOutboxEvents(ctx, 2)
+	if err != nil || len(oldest) != 2 || oldest[0].ID != events[1].ID || oldest[1].ID != events[0].ID {
+		t.Fatalf("expected the two oldest events, got %+v, %v", oldest, err)
+	}
+	if !oldest[0].OccurredAt.Equal(events[1].OccurredAt) || string(oldest[0].Payload) != string(events[1].Payload) || oldest[0].PublishedAt != nil {
+		t.Errorf("expected %+v, got %+v", events[1], oldest[0])
+	}
+
+	if err := repo.MarkOutboxEventPublished(ctx, events[1].ID.Hex(), occurredAt.Add(3*time.Hour)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	remaining, err := repo.FindUnpublishedOutboxEvents(ctx, 10)
+	if err != nil || len(remaining) != 2 || remaining[0].ID != events[0].ID || remaining[1].ID != events[2].ID {
+		t.Errorf("expected the published event to be left out, got %+v, %v", remaining, err)
+	}
+	if err := repo.MarkOutboxEventPublished(ctx, bson.NewObjectID().Hex(), occurredAt); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound marking an unknown event, got %v", err)
+	}
+}
+
+func testJournal(t *testing.T, repo repository.DatabaseRepository) {
+	ctx := context.Background()
+	for _, entry := range []model.JournalEntry{
+		{TransactionID: "payment", AccountID: "acc", PostedAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), Lines: []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountCash, Side: model.EntrySideDebit, AmountCents: 500},
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideCredit, AmountCents: 500, AppliesTo: "purchase"},
+		}},
+		{TransactionID: "purchase", AccountID: "acc", PostedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Lines: []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 1000, AppliesTo: "purchase"},
+			{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 1000},
+		}},
+		{TransactionID: "later", AccountID: "acc", PostedAt: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC), Lines: []model.JournalLine{
+			{LedgerAccount: model.LedgerAccountReceivable, Side: model.EntrySideDebit, AmountCents: 200, AppliesTo: "later"},
+			{LedgerAccount: model.LedgerAccountMerchantPayable, Side: model.EntrySideCredit, AmountCents: 200},
+		}},
+	} {
+		if err := repo.PostJournalEntry(ctx, entry); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	entries, err := repo.FindJournalEntries(ctx, "acc", time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(entries) != 2 || entries[0].TransactionID != "purchase" || entries[1].TransactionID != "payment" {
+		t.Fatalf("expected the entries posted by the time oldest first, got %+v, %v", entries, err)
+	}
+	if len(entries[1].Lines) != 2 || entries[1].Lines[1].AppliesTo != "purchase" {
+		t.Errorf("expected the lines in the order they were posted, got %+v", entries[1].Lines)
+	}
+
+	lines, err := repo.TrialBalance(ctx)
+	expected := []model.TrialBalanceLine{
+		{LedgerAccount: model.LedgerAccountCash, DebitCents: 500},
+		{LedgerAccount: model.LedgerAccountReceivable, DebitCents: 1200, CreditCents: 500},
+		{LedgerAccount: model.LedgerAccountMerchantPayable, CreditCents: 1200},
+	}
+	if err != nil || !slices.Equal(lines, expected) {
+		t.Errorf("expected %+v, got %+v, %v", expected, lines, err)
+	}
+}
+
+func testAccountBalance(t *testing.T, repo repository.DatabaseRepository) {
+	ctx := context.Background()
+	if balance, err := repo.GetAccountBalance(ctx, "acc"); err != nil || balance != nil {
+		t.Fatalf("expected nil without an error before the first write, got %+v, %v", balance, err)
+	}
+
+	first := model.AccountBalance{AccountID: "acc", TotalDebtCents: 1000, Version: 1, OpenDebts: []model.OpenDebt{{TransactionID: "purchase", BalanceCents: -1000}}}
+	if err := repo.SaveAccountBalance(ctx, first); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := repo.SaveAccountBalance(ctx, first); err == nil {
+		t.Errorf("expected creating the balance twice to fail")
+	}
+
+	second := model.AccountBalance{AccountID: "acc", AvailableCreditCents: 200, LastTransactionAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), Version: 2, OpenDebts: []model.OpenDebt{}}
+	if err := repo.SaveAccountBalance(ctx, second); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := repo.SaveAccountBalance(ctx, model.AccountBalance{AccountID: "acc", Version: 4}); err == nil {
+		t.Errorf("expected skipping a version to fail")
+	}
+
+	balance, err := repo.GetAccountBalance(ctx, "acc")
+	if err != nil || balance == nil {
+		t.Fatalf("expected the balance, got %+v, %v", balance, err)
+	}
+	if balance.Version != 2 || balance.AvailableCreditCents != 200 || balance.TotalDebtCents != 0 || len(balance.OpenDebts) != 0 || !balance.LastTransactionAt.Equal(second.LastTransactionAt) {
+		t.Errorf("expected %+v, got %+v", second, balance)
+	}
+}
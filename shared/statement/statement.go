@@ -0,0 +1,362 @@
+package statement
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+type Format string
+
+const (
+	FormatCAMT053 Format = "camt053"
+	FormatOFX     Format = "ofx"
+)
+
+// DefaultCurrency is used when a statement is requested without one, amounts are not stored with a currency
+const DefaultCurrency = "BRL"
+
+var ErrUnsupportedFormat = errors.New("unsupported statement format")
+
+// Statement is an account's transactions for a period along with the balance either side of it.
+// Balances are the signed sum of transaction amounts, so a negative balance is money owed by the customer
+type Statement struct {
+	ID             string
+	AccountID      string
+	Currency       string
+	From           time.Time
+	To             time.Time
+	CreatedAt      time.Time
+	OpeningBalance float64
+	ClosingBalance float64
+	Entries        []Entry
+}
+
+type Entry struct {
+	TransactionID string
+	OperationID   model.OperationType
+	Amount        float64
+	BookedAt      time.Time
+}
+
+// BankTransactionCode is the ISO 20022 domain, family and sub family an operation type is reported under
+type BankTransactionCode struct {
+	Domain    string
+	Family    string
+	SubFamily string
+	// OFXType is the closest OFX TRNTYPE for the operation type
+	OFXType string
+}
+
+// CodeFor maps an operation type to the bank transaction codes used in statements
+func CodeFor(operation model.OperationType) BankTransactionCode {
+	switch operation {
+	case model.OperationTypePurchase, model.OperationTypeInstallmentPurchase:
+		return BankTransactionCode{Domain: "PMNT", Family: "CCRD", SubFamily: "POSC", OFXType: "POS"}
+	case model.OperationTypeWithdrawal:
+		return BankTransactionCode{Domain: "PMNT", Family: "CCRD", SubFamily: "CWDL", OFXType: "ATM"}
+	case model.OperationTypePayment:
+		return BankTransactionCode{Domain: "PMNT", Family: "RCDT", SubFamily: "DMCT", OFXType: "CREDIT"}
+	default:
+		return BankTransactionCode{Domain: "PMNT", Family: "MCOP", SubFamily: "OTHR", OFXType: "OTHER"}
+	}
+}
+
+// CreditDebitIndicator derives CRDT or DBIT from the sign of the amount, zero is reported as a credit
+func CreditDebitIndicator(amount float64) string {
+	if amount < 0 {
+		return "DBIT"
+	}
+	return "CRDT"
+}
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(strings.ToLower(strings.TrimSpace(format))) {
+	case FormatCAMT053, "camt.053":
+		return FormatCAMT053, nil
+	case FormatOFX:
+		return FormatOFX, nil
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCAMT053:
+		return "application/xml"
+	case FormatOFX:
+		return "application/x-ofx"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func (f Format) FileExtension() string {
+	switch f {
+	case FormatCAMT053:
+		return "xml"
+	default:
+		return string(f)
+	}
+}
+
+// Encode writes the statement in the requested format
+func Encode(w io.Writer, format Format, st Statement) error {
+	switch format {
+	case FormatCAMT053:
+		return encodeCAMT053(w, st)
+	case FormatOFX:
+		return encodeOFX(w, st)
+	default:
+		return ErrUnsupportedFormat
+	}
+}
+
+// formatAmount renders the absolute amount with two decimals, the sign is carried separately by every format
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(math.Abs(amount), 'f', 2, 64)
+}
+
+type camtDocument struct {
+	XMLName xml.Name `xml:"urn:iso:std:iso:20022:tech:xsd:camt.053.001.08 Document"`
+	Stmt    camtBkToCstmrStmt
+}
+
+type camtBkToCstmrStmt struct {
+	XMLName xml.Name `xml:"BkToCstmrStmt"`
+	GrpHdr  camtGrpHdr
+	Stmt    camtStmt
+}
+
+type camtGrpHdr struct {
+	MsgID    string `xml:"MsgId"`
+	CreDtTm  string `xml:"CreDtTm"`
+	MsgPgntn struct {
+		PgNb      int  `xml:"PgNb"`
+		LastPgInd bool `xml:"LastPgInd"`
+	} `xml:"MsgPgntn"`
+}
+
+type camtStmt struct {
+	ID        string `xml:"Id"`
+	CreDtTm   string `xml:"CreDtTm"`
+	FrToDt    camtFrToDt
+	Acct      camtAcct
+	Bal       []camtBal
+	TxsSummry camtTxsSummry
+	Ntry      []camtNtry
+}
+
+type camtFrToDt struct {
+	FrDtTm string `xml:"FrDtTm"`
+	ToDtTm string `xml:"ToDtTm"`
+}
+
+type camtAcct struct {
+	ID  string `xml:"Id>Othr>Id"`
+	Ccy string `xml:"Ccy"`
+}
+
+type camtAmt struct {
+	Ccy   string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+type camtBal struct {
+	Tp        string `xml:"Tp>CdOrPrtry>Cd"`
+	Amt       camtAmt
+	CdtDbtInd string `xml:"CdtDbtInd"`
+	Dt        string `xml:"Dt>DtTm"`
+}
+
+type camtTxsSummry struct {
+	NbOfNtries int    `xml:"TtlNtries>NbOfNtries"`
+	Sum        string `xml:"TtlNtries>Sum"`
+}
+
+type camtNtry struct {
+	NtryRef      string `xml:"NtryRef"`
+	Amt          camtAmt
+	CdtDbtInd    string `xml:"CdtDbtInd"`
+	Sts          string `xml:"Sts>Cd"`
+	BookgDt      string `xml:"BookgDt>DtTm"`
+	ValDt        string `xml:"ValDt>DtTm"`
+	AcctSvcrRef  string `xml:"AcctSvcrRef"`
+	BkTxCd       camtBkTxCd
+	AddtlNtryInf string `xml:"AddtlNtryInf"`
+}
+
+type camtBkTxCd struct {
+	XMLName   xml.Name `xml:"BkTxCd"`
+	Domn      string   `xml:"Domn>Cd"`
+	Fmly      string   `xml:"Domn>Fmly>Cd"`
+	SubFmly   string   `xml:"Domn>Fmly>SubFmlyCd"`
+	PrtryCd   string   `xml:"Prtry>Cd"`
+	PrtryIssr string   `xml:"Prtry>Issr"`
+}
+
+func camtDateTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func encodeCAMT053(w io.Writer, st Statement) error {
+	doc := camtDocument{}
+	doc.Stmt.GrpHdr.MsgID = st.ID
+	doc.Stmt.GrpHdr.CreDtTm = camtDateTime(st.CreatedAt)
+	doc.Stmt.GrpHdr.MsgPgntn.PgNb = 1
+	doc.Stmt.GrpHdr.MsgPgntn.LastPgInd = true
+
+	stmt := camtStmt{
+		ID:      st.ID,
+		CreDtTm: camtDateTime(st.CreatedAt),
+		FrToDt:  camtFrToDt{FrDtTm: camtDateTime(st.From), ToDtTm: camtDateTime(st.To)},
+		Acct:    camtAcct{ID: st.AccountID, Ccy: st.Currency},
+		Bal: []camtBal{
+			{Tp: "OPBD", Amt: camtAmt{Ccy: st.Currency, Value: formatAmount(st.OpeningBalance)}, CdtDbtInd: CreditDebitIndicator(st.OpeningBalance), Dt: camtDateTime(st.From)},
+			{Tp: "CLBD", Amt: camtAmt{Ccy: st.Currency, Value: formatAmount(st.ClosingBalance)}, CdtDbtInd: CreditDebitIndicator(st.ClosingBalance), Dt: camtDateTime(st.To)},
+		},
+	}
+	var sum float64
+	for _, entry := range st.Entries {
+		code := CodeFor(entry.OperationID)
+		sum += math.Abs(entry.Amount)
+		stmt.Ntry = append(stmt.Ntry, camtNtry{
+			NtryRef:     entry.TransactionID,
+			Amt:         camtAmt{Ccy: st.Currency, Value: formatAmount(entry.Amount)},
+			CdtDbtInd:   CreditDebitIndicator(entry.Amount),
+			Sts:         "BOOK",
+			BookgDt:     camtDateTime(entry.BookedAt),
+			ValDt:       camtDateTime(entry.BookedAt),
+			AcctSvcrRef: entry.TransactionID,
+			BkTxCd: camtBkTxCd{
+				Domn:      code.Domain,
+				Fmly:      code.Family,
+				SubFmly:   code.SubFamily,
+				PrtryCd:   strconv.Itoa(int(entry.OperationID)),
+				PrtryIssr: "OPERATION_TYPE",
+			},
+			AddtlNtryInf: entry.OperationID.String(),
+		})
+	}
+	stmt.TxsSummry = camtTxsSummry{NbOfNtries: len(st.Entries), Sum: formatAmount(sum)}
+	doc.Stmt.Stmt = stmt
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode camt.053 statement: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type ofxDocument struct {
+	XMLName xml.Name `xml:"OFX"`
+	SignOn  ofxSignOn
+	CC      ofxCreditCard
+}
+
+type ofxSignOn struct {
+	XMLName  xml.Name `xml:"SIGNONMSGSRSV1"`
+	Code     int      `xml:"SONRS>STATUS>CODE"`
+	Severity string   `xml:"SONRS>STATUS>SEVERITY"`
+	DTServer string   `xml:"SONRS>DTSERVER"`
+	Language string   `xml:"SONRS>LANGUAGE"`
+}
+
+type ofxCreditCard struct {
+	XMLName  xml.Name  `xml:"CREDITCARDMSGSRSV1"`
+	TrnUID   string    `xml:"CCSTMTTRNRS>TRNUID"`
+	Code     int       `xml:"CCSTMTTRNRS>STATUS>CODE"`
+	Severity string    `xml:"CCSTMTTRNRS>STATUS>SEVERITY"`
+	Stmt     ofxCCStmt `xml:"CCSTMTTRNRS>CCSTMTRS"`
+}
+
+type ofxCCStmt struct {
+	CurDef     string `xml:"CURDEF"`
+	AcctID     string `xml:"CCACCTFROM>ACCTID"`
+	TranList   ofxTranList
+	LedgerBal  string `xml:"LEDGERBAL>BALAMT"`
+	LedgerAsOf string `xml:"LEDGERBAL>DTASOF"`
+}
+
+type ofxTranList struct {
+	XMLName xml.Name `xml:"BANKTRANLIST"`
+	DTStart string   `xml:"DTSTART"`
+	DTEnd   string   `xml:"DTEND"`
+	Trn     []ofxTrn `xml:"STMTTRN"`
+}
+
+type ofxTrn struct {
+	TrnType  string `xml:"TRNTYPE"`
+	DTPosted string `xml:"DTPOSTED"`
+	TrnAmt   string `xml:"TRNAMT"`
+	FITID    string `xml:"FITID"`
+	Name     string `xml:"NAME"`
+	Memo     string `xml:"MEMO"`
+}
+
+func ofxDateTime(t time.Time) string {
+	return t.UTC().Format("20060102150405.000") + "[0:GMT]"
+}
+
+// ofxAmount keeps the sign, OFX amounts are negative for money leaving the account
+func ofxAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func encodeOFX(w io.Writer, st Statement) error {
+	doc := ofxDocument{
+		SignOn: ofxSignOn{Code: 0, Severity: "INFO", DTServer: ofxDateTime(st.CreatedAt), Language: "ENG"},
+		CC: ofxCreditCard{
+			TrnUID:   st.ID,
+			Code:     0,
+			Severity: "INFO",
+			Stmt: ofxCCStmt{
+				CurDef:     st.Currency,
+				AcctID:     st.AccountID,
+				TranList:   ofxTranList{DTStart: ofxDateTime(st.From), DTEnd: ofxDateTime(st.To)},
+				LedgerBal:  ofxAmount(st.ClosingBalance),
+				LedgerAsOf: ofxDateTime(st.To),
+			},
+		},
+	}
+	for _, entry := range st.Entries {
+		doc.CC.Stmt.TranList.Trn = append(doc.CC.Stmt.TranList.Trn, ofxTrn{
+			TrnType:  CodeFor(entry.OperationID).OFXType,
+			DTPosted: ofxDateTime(entry.BookedAt),
+			TrnAmt:   ofxAmount(entry.Amount),
+			FITID:    entry.TransactionID,
+			Name:     entry.OperationID.String(),
+			Memo:     CreditDebitIndicator(entry.Amount),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header+`<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`+"\n"); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode OFX statement: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
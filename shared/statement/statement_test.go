@@ -0,0 +1,143 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+func testStatement() Statement {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	return Statement{
+		ID:             "stmt-1",
+		AccountID:      "acc-1",
+		Currency:       "BRL",
+		From:           from,
+		To:             from.AddDate(0, 1, 0),
+		CreatedAt:      from.AddDate(0, 1, 1),
+		OpeningBalance: -10,
+		ClosingBalance: 0,
+		Entries: []Entry{
+			{TransactionID: "tx-1", OperationID: model.OperationTypePurchase, Amount: -50, BookedAt: from.Add(time.Hour)},
+			{TransactionID: "tx-2", OperationID: model.OperationTypePayment, Amount: 60, BookedAt: from.Add(2 * time.Hour)},
+		},
+	}
+}
+
+func TestCodeFor(t *testing.T) {
+	tests := []struct {
+		operation model.OperationType
+		subFamily string
+		ofxType   string
+	}{
+		{model.OperationTypePurchase, "POSC", "POS"},
+		{model.OperationTypeInstallmentPurchase, "POSC", "POS"},
+		{model.OperationTypeWithdrawal, "CWDL", "ATM"},
+		{model.OperationTypePayment, "DMCT", "CREDIT"},
+		{model.OperationType(9), "OTHR", "OTHER"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operation.String(), func(t *testing.T) {
+			code := CodeFor(tt.operation)
+			if code.Domain != "PMNT" || code.SubFamily != tt.subFamily || code.OFXType != tt.ofxType {
+				t.Errorf("unexpected code %+v", code)
+			}
+		})
+	}
+}
+
+func TestCreditDebitIndicator(t *testing.T) {
+	if CreditDebitIndicator(-0.01) != "DBIT" {
+		t.Errorf("expected negative amounts to be debits")
+	}
+	if CreditDebitIndicator(0.01) != "CRDT" || CreditDebitIndicator(0) != "CRDT" {
+		t.Errorf("expected positive and zero amounts to be credits")
+	}
+}
+
+func TestEncodeCAMT053(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, FormatCAMT053, testStatement()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var doc struct {
+		Stmt struct {
+			Bal []struct {
+				Tp        string `xml:"Tp>CdOrPrtry>Cd"`
+				Amt       string `xml:"Amt"`
+				CdtDbtInd string `xml:"CdtDbtInd"`
+			} `xml:"Bal"`
+			Ntry []struct {
+				Amt       string `xml:"Amt"`
+				CdtDbtInd string `xml:"CdtDbtInd"`
+				Fmly      string `xml:"BkTxCd>Domn>Fmly>Cd"`
+			} `xml:"Ntry"`
+		} `xml:"BkToCstmrStmt>Stmt"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("expected valid XML, got %v", err)
+	}
+	if len(doc.Stmt.Bal) != 2 || doc.Stmt.Bal[0].Tp != "OPBD" || doc.Stmt.Bal[0].Amt != "10.00" || doc.Stmt.Bal[0].CdtDbtInd != "DBIT" {
+		t.Errorf("unexpected balances %+v", doc.Stmt.Bal)
+	}
+	if len(doc.Stmt.Ntry) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(doc.Stmt.Ntry))
+	}
+	if doc.Stmt.Ntry[0].Amt != "50.00" || doc.Stmt.Ntry[0].CdtDbtInd != "DBIT" || doc.Stmt.Ntry[0].Fmly != "CCRD" {
+		t.Errorf("unexpected purchase entry %+v", doc.Stmt.Ntry[0])
+	}
+	if doc.Stmt.Ntry[1].CdtDbtInd != "CRDT" || doc.Stmt.Ntry[1].Fmly != "RCDT" {
+		t.Errorf("unexpected payment entry %+v", doc.Stmt.Ntry[1])
+	}
+}
+
+func TestEncodeOFX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, FormatOFX, testStatement()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.Contains(buf.String(), `<?OFX OFXHEADER="200"`) {
+		t.Errorf("expected an OFX processing instruction")
+	}
+
+	var doc struct {
+		Trn []struct {
+			TrnType  string `xml:"TRNTYPE"`
+			DTPosted string `xml:"DTPOSTED"`
+			TrnAmt   string `xml:"TRNAMT"`
+			FITID    string `xml:"FITID"`
+		} `xml:"CREDITCARDMSGSRSV1>CCSTMTTRNRS>CCSTMTRS>BANKTRANLIST>STMTTRN"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("expected valid XML, got %v", err)
+	}
+	if len(doc.Trn) != 2 {
+		t.Fatalf("expected 2 transactions, got %d", len(doc.Trn))
+	}
+	if doc.Trn[0].TrnType != "POS" || doc.Trn[0].TrnAmt != "-50.00" || doc.Trn[0].FITID != "tx-1" {
+		t.Errorf("unexpected purchase %+v", doc.Trn[0])
+	}
+	if doc.Trn[0].DTPosted != "20250101010000.000[0:GMT]" {
+		t.Errorf("unexpected posted date %s", doc.Trn[0].DTPosted)
+	}
+	if doc.Trn[1].TrnType != "CREDIT" || doc.Trn[1].TrnAmt != "60.00" {
+		t.Errorf("unexpected payment %+v", doc.Trn[1])
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for input, expected := range map[string]Format{"camt053": FormatCAMT053, "CAMT.053": FormatCAMT053, "ofx": FormatOFX} {
+		if format, err := ParseFormat(input); err != nil || format != expected {
+			t.Errorf("expected %s for %q, got %s (%v)", expected, input, format, err)
+		}
+	}
+	if _, err := ParseFormat("mt940"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
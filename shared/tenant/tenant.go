@@ -0,0 +1,127 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/joolshouston/pismo-technical-test/shared/json_handler"
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+const (
+	// Header names the tenant a request is for, like the actor header it is trusted as is so it must be set by the
+	// gateway in front of the API
+	Header = "X-Tenant-ID"
+	// APIKeyHeader carries an API credential, each credential belongs to exactly one tenant
+	APIKeyHeader = "X-API-Key"
+)
+
+var (
+	ErrMissing       = errors.New("tenant is required")
+	ErrUnknown       = errors.New("unknown tenant")
+	ErrUnknownAPIKey = errors.New("unknown API key")
+	ErrMismatch      = errors.New("tenant does not match the API key")
+)
+
+// validID keeps tenant IDs usable as part of a database name on every backend
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+type contextKey int
+
+const tenantKey contextKey = iota
+
+// WithID returns a copy of ctx whose reads and writes go to the data of tenant id
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+// FromContext returns the tenant ctx belongs to, empty when it does not belong to any
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey).(string)
+	return id
+}
+
+// Resolver works out which tenant a request belongs to, from its API key or from the tenant header. Only the tenants
+// it was created with are accepted, a request that names none of them is rejected rather than served from shared data
+type Resolver struct {
+	tenants []string
+	apiKeys map[string]string
+}
+
+// NewResolver accepts the given tenants, apiKeys maps each credential to the tenant it belongs to
+func NewResolver(tenants []string, apiKeys map[string]string) (*Resolver, error) {
+	if len(tenants) == 0 {
+		return nil, errors.New("at least one tenant is required")
+	}
+	for _, id := range tenants {
+		if !validID.MatchString(id) {
+			return nil, fmt.Errorf("invalid tenant ID %q, it must be 1 to 32 lower case letters, digits, _ or - and start with a letter or digit", id)
+		}
+	}
+	for key, id := range apiKeys {
+		if key == "" {
+			return nil, errors.New("API keys must not be empty")
+		}
+		if !slices.Contains(tenants, id) {
+			return nil, fmt.Errorf("API key for %s: %w", id, ErrUnknown)
+		}
+	}
+	return &Resolver{tenants: slices.Clone(tenants), apiKeys: apiKeys}, nil
+}
+
+// Tenants returns every tenant the resolver accepts
+func (r *Resolver) Tenants() []string {
+	return slices.Clone(r.tenants)
+}
+
+// Resolve returns the tenant of req. When both an API key and the tenant header are sent they have to agree
+func (r *Resolver) Resolve(req *http.Request) (string, error) {
+	header := strings.TrimSpace(req.Header.Get(Header))
+	if key := req.Header.Get(APIKeyHeader); key != "" {
+		id, ok := r.apiKeys[key]
+		if !ok {
+			return "", ErrUnknownAPIKey
+		}
+		if header != "" && header != id {
+			return "", ErrMismatch
+		}
+		return id, nil
+	}
+	if header == "" {
+		return "", ErrMissing
+	}
+	if !slices.Contains(r.tenants, header) {
+		return "", fmt.Errorf("%s: %w", header, ErrUnknown)
+	}
+	return header, nil
+}
+
+// Middleware puts the tenant of the request on its context, requests without a known tenant never reach next
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id, err := r.Resolve(req)
+		if err != nil {
+			json_handler.WriteError(w, errorResponse(err))
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(WithID(req.Context(), id)))
+	})
+}
+
+func errorResponse(err error) *model.ErrorResponse {
+	switch {
+	case errors.Is(err, ErrMissing):
+		return &model.ErrorResponse{Status: http.StatusBadRequest, Message: fmt.Sprintf("one of the %s or %s headers is required", Header, APIKeyHeader)}
+	case errors.Is(err, ErrUnknownAPIKey):
+		return &model.ErrorResponse{Status: http.StatusUnauthorized, Message: ErrUnknownAPIKey.Error()}
+	case errors.Is(err, ErrMismatch):
+		return &model.ErrorResponse{Status: http.StatusForbidden, Message: ErrMismatch.Error()}
+	default:
+		return &model.ErrorResponse{Status: http.StatusForbidden, Message: ErrUnknown.Error()}
+	}
+}
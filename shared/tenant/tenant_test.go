@@ -0,0 +1,100 @@
+package tenant
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []string
+		apiKeys map[string]string
+	}{
+		{name: "No tenants"},
+		{name: "Upper case tenant", tenants: []string{"Program-A"}},
+		{name: "Tenant with a dot", tenants: []string{"program.a"}},
+		{name: "Key of an unknown tenant", tenants: []string{"program-a"}, apiKeys: map[string]string{"key": "program-b"}},
+		{name: "Empty key", tenants: []string{"program-a"}, apiKeys: map[string]string{"": "program-a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewResolver(tt.tenants, tt.apiKeys); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	resolver, err := NewResolver([]string{"program-a", "program-b"}, map[string]string{"key-a": "program-a"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected string
+		err      error
+	}{
+		{name: "Tenant header", headers: map[string]string{Header: "program-b"}, expected: "program-b"},
+		{name: "API key", headers: map[string]string{APIKeyHeader: "key-a"}, expected: "program-a"},
+		{name: "API key and matching header", headers: map[string]string{APIKeyHeader: "key-a", Header: "program-a"}, expected: "program-a"},
+		{name: "API key of another tenant", headers: map[string]string{APIKeyHeader: "key-a", Header: "program-b"}, err: ErrMismatch},
+		{name: "Unknown API key", headers: map[string]string{APIKeyHeader: "key-b", Header: "program-b"}, err: ErrUnknownAPIKey},
+		{name: "Unknown tenant", headers: map[string]string{Header: "program-c"}, err: ErrUnknown},
+		{name: "No tenant", err: ErrMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/accounts/1", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			id, err := resolver.Resolve(req)
+			if !errors.Is(err, tt.err) || id != tt.expected {
+				t.Errorf("expected %q and %v, got %q and %v", tt.expected, tt.err, id, err)
+			}
+		})
+	}
+}
+
+func TestResolver_Middleware(t *testing.T) {
+	resolver, err := NewResolver([]string{"program-a"}, map[string]string{"key-a": "program-a"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var seen string
+	handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		expectedStatus int
+		expected       string
+	}{
+		{name: "Known tenant", headers: map[string]string{Header: "program-a"}, expectedStatus: http.StatusOK, expected: "program-a"},
+		{name: "No tenant", expectedStatus: http.StatusBadRequest},
+		{name: "Unknown API key", headers: map[string]string{APIKeyHeader: "key-b"}, expectedStatus: http.StatusUnauthorized},
+		{name: "Unknown tenant", headers: map[string]string{Header: "program-b"}, expectedStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = ""
+			req := httptest.NewRequest(http.MethodGet, "/v1/accounts/1", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			if resp.Code != tt.expectedStatus || seen != tt.expected {
+				t.Errorf("expected status %d for %q, got %d for %q", tt.expectedStatus, tt.expected, resp.Code, seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package validation
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+// Tag is the struct tag holding the rules of a field, a comma separated list of:
+//
+//	required     the field must not be left out or zero
+//	min=N, max=N numbers must be within the range, strings must be at least or at most N bytes long
+//	oneof=A B C  the field must be one of the space separated values
+//	format=NAME  strings must match one of the formats below
+//	cents        numbers must be a whole number of cents, amounts are kept in cents once written
+//
+// Rules other than required are only checked for fields that are set
+const Tag = "validate"
+
+// The codes of the FieldErrors, one per rule
+const (
+	CodeRequired = "required"
+	CodeMin      = "min"
+	CodeMax      = "max"
+	CodeOneOf    = "oneof"
+	CodeFormat   = "format"
+	CodeCents    = "cents"
+)
+
+type format struct {
+	pattern     *regexp.Regexp
+	description string
+}
+
+var formats = map[string]format{
+	"document_number": {regexp.MustCompile(`^[\p{L}\p{N}./-]+$`), "only letters, digits, '.', '-' and '/'"},
+}
+
+// Validate checks v, a struct or a pointer to one, against the rules in its validate tags. It returns a 400 listing
+// every rule broken, or nil when there are none
+func Validate(v any) *model.ErrorResponse {
+	errs := Struct(v)
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Message
+	}
+	return &model.ErrorResponse{
+		Status:  http.StatusBadRequest,
+		Message: strings.Join(messages, ", "),
+		Errors:  errs,
+	}
+}
+
+// Struct checks v, a struct or a pointer to one, against the rules in its validate tags and returns an error for every
+// rule broken, in field order. Fields are named by their json tag and the fields of embedded structs are checked as if
+// they were declared in v
+func Struct(v any) []model.FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	var errs []model.FieldError
+	for _, field := range fieldsOf(value.Type()) {
+		errs = append(errs, field.check(value.FieldByIndex(field.index))...)
+	}
+	return errs
+}
+
+// field is a field with rules, parsed once per struct type
+type field struct {
+	name     string
+	index    []int
+	required bool
+	rules    []rule
+}
+
+type rule struct {
+	code  string
+	check func(v reflect.Value) bool
+	// message follows the name of the field
+	message string
+}
+
+func (f field) check(v reflect.Value) []model.FieldError {
+	if v.IsZero() {
+		if f.required {
+			return []model.FieldError{{Field: f.name, Code: CodeRequired, Message: f.name + " is required"}}
+		}
+		return nil
+	}
+	var errs []model.FieldError
+	for _, r := range f.rules {
+		if !r.check(v) {
+			errs = append(errs, model.FieldError{Field: f.name, Code: r.code, Message: f.name + " " + r.message})
+		}
+	}
+	return errs
+}
+
+var fieldCache sync.Map // reflect.Type to []field
+
+func fieldsOf(t reflect.Type) []field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field)
+	}
+	fields := parseFields(t, nil)
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// parseFields panics on a malformed tag, they are part of the code rather than the request
+func parseFields(t reflect.Type, index []int) []field {
+	var fields []field
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		fieldIndex := append(append([]int(nil), index...), i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, parseFields(sf.Type, fieldIndex)...)
+			continue
+		}
+		tag, ok := sf.Tag.Lookup(Tag)
+		if !ok || !sf.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "" {
+			name = sf.Name
+		}
+		f := field{name: name, index: fieldIndex}
+		for spec := range strings.SplitSeq(tag, ",") {
+			code, arg, _ := strings.Cut(strings.TrimSpace(spec), "=")
+			if code == CodeRequired {
+				f.required = true
+				continue
+			}
+			r, err := newRule(sf.Type, code, arg)
+			if err != nil {
+				panic(fmt.Sprintf("validation: field %s.%s: %v", t.Name(), sf.Name, err))
+			}
+			f.rules = append(f.rules, r)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func newRule(t reflect.Type, code string, arg string) (rule, error) {
+	switch code {
+	case CodeMin, CodeMax:
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid %s %q", code, arg)
+		}
+		within := func(n float64) bool { return n >= limit }
+		message := "must be at least " + arg
+		if code == CodeMax {
+			within = func(n float64) bool { return n <= limit }
+			message = "must be at most " + arg
+		}
+		if t.Kind() == reflect.String {
+			// characters as the client sees them, not the bytes they take
+			return rule{code: code, message: message + " characters long", check: func(v reflect.Value) bool {
+				return within(float64(utf8.RuneCountInString(v.String())))
+			}}, nil
+		}
+		number, err := numberOf(t)
+		if err != nil {
+			return rule{}, err
+		}
+		return rule{code: code, message: message, check: func(v reflect.Value) bool { return within(number(v)) }}, nil
+	case CodeOneOf:
+		values := strings.Fields(arg)
+		if len(values) == 0 {
+			return rule{}, fmt.Errorf("%s without values", code)
+		}
+		return rule{code: code, message: "must be one of " + strings.Join(values, ", "), check: func(v reflect.Value) bool {
+			for _, value := range values {
+				if valueOf(v) == value {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	case CodeFormat:
+		f, ok := formats[arg]
+		if !ok || t.Kind() != reflect.String {
+			return rule{}, fmt.Errorf("unknown string format %q", arg)
+		}
+		return rule{code: code, message: "must contain " + f.description, check: func(v reflect.Value) bool { return f.pattern.MatchString(v.String()) }}, nil
+	case CodeCents:
+		if t.Kind() != reflect.Float32 && t.Kind() != reflect.Float64 {
+			return rule{}, fmt.Errorf("%s needs a float, got %s", code, t)
+		}
+		return rule{code: code, message: "must be a whole number of cents", check: func(v reflect.Value) bool {
+			return math.Round(v.Float()*100)/100 == v.Float()
+		}}, nil
+	default:
+		return rule{}, fmt.Errorf("unknown rule %q", code)
+	}
+}
+
+// numberOf returns a func reading a value of t as a float64
+func numberOf(t reflect.Type) (func(v reflect.Value) float64, error) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) float64 { return float64(v.Int()) }, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(v reflect.Value) float64 { return float64(v.Uint()) }, nil
+	case reflect.Float32, reflect.Float64:
+		return func(v reflect.Value) float64 { return v.Float() }, nil
+	default:
+		return nil, fmt.Errorf("min and max need a number or a string, got %s", t)
+	}
+}
+
+// valueOf formats v the way it is written in a oneof rule, the underlying value rather than what a String method
+// returns
+func valueOf(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.String:
+		return v.String()
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
@@ -0,0 +1,115 @@
+package validation
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+type embedded struct {
+	Code string `json:"code" validate:"oneof=a b"`
+}
+
+type request struct {
+	Name    string  `json:"name,omitempty" validate:"required,min=2,max=4"`
+	Count   int     `json:"count" validate:"min=1,max=10"`
+	Ratio   float64 `validate:"required,min=-1,max=1"`
+	Kind    uint8   `json:"kind" validate:"oneof=1 2"`
+	Number  string  `json:"number" validate:"format=document_number"`
+	Amount  float64 `json:"amount" validate:"cents"`
+	Ignored string  `json:"ignored"`
+	embedded
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  any
+		expected []model.FieldError
+	}{
+		{name: "Valid", request: request{Name: "abc", Count: 10, Ratio: -1, Kind: 2, Number: "12.345/6-7", Amount: 0.29, embedded: embedded{Code: "b"}}},
+		{name: "Pointer", request: &request{Name: "ab", Ratio: 0.5}},
+		{name: "Rules other than required are skipped for zero values", request: request{Name: "ab", Ratio: 1}},
+		{name: "Lengths count characters, not bytes", request: request{Name: "ção", Ratio: 1}},
+		{name: "Lengths over the maximum in characters", request: request{Name: "ãéíõú", Ratio: 1}, expected: []model.FieldError{
+			{Field: "name", Code: CodeMax, Message: "name must be at most 4 characters long"},
+		}},
+		{name: "Required", request: request{}, expected: []model.FieldError{
+			{Field: "name", Code: CodeRequired, Message: "name is required"},
+			{Field: "Ratio", Code: CodeRequired, Message: "Ratio is required"},
+		}},
+		{name: "Ranges", request: request{Name: "abcde", Count: 11, Ratio: -1.5}, expected: []model.FieldError{
+			{Field: "name", Code: CodeMax, Message: "name must be at most 4 characters long"},
+			{Field: "count", Code: CodeMax, Message: "count must be at most 10"},
+			{Field: "Ratio", Code: CodeMin, Message: "Ratio must be at least -1"},
+		}},
+		{name: "Minimums", request: request{Name: "a", Count: -1, Ratio: 1}, expected: []model.FieldError{
+			{Field: "name", Code: CodeMin, Message: "name must be at least 2 characters long"},
+			{Field: "count", Code: CodeMin, Message: "count must be at least 1"},
+		}},
+		{name: "Enums and formats", request: request{Name: "ab", Ratio: 1, Kind: 3, Number: "12 34", Amount: -0.004, embedded: embedded{Code: "c"}}, expected: []model.FieldError{
+			{Field: "kind", Code: CodeOneOf, Message: "kind must be one of 1, 2"},
+			{Field: "number", Code: CodeFormat, Message: "number must contain only letters, digits, '.', '-' and '/'"},
+			{Field: "amount", Code: CodeCents, Message: "amount must be a whole number of cents"},
+			{Field: "code", Code: CodeOneOf, Message: "code must be one of a, b"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errs := Struct(tt.request); !reflect.DeepEqual(errs, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, errs)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if errResp := Validate(model.TransactionRequestBody{AccountID: "acc", OperationID: model.OperationTypePayment, Amount: 10}); errResp != nil {
+		t.Fatalf("expected a valid request, got %+v", errResp)
+	}
+	errResp := Validate(model.TransactionRequestBody{OperationID: 5, Amount: 10})
+	if errResp == nil || errResp.Status != http.StatusBadRequest || len(errResp.Errors) != 2 {
+		t.Fatalf("expected a 400 with two errors, got %+v", errResp)
+	}
+	if errResp.Message != "account_id is required, operation_type_id must be one of 1, 2, 3, 4" {
+		t.Errorf("expected the messages of every error, got %s", errResp.Message)
+	}
+}
+
+func TestMalformedTagsPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		request any
+	}{
+		{name: "Unknown rule", request: struct {
+			A string `validate:"email"`
+		}{}},
+		{name: "Unknown format", request: struct {
+			A string `validate:"format=email"`
+		}{}},
+		{name: "Invalid limit", request: struct {
+			A int `validate:"max=ten"`
+		}{}},
+		{name: "Range on a bool", request: struct {
+			A bool `validate:"min=1"`
+		}{}},
+		{name: "Cents on an int", request: struct {
+			A int `validate:"cents"`
+		}{}},
+		{name: "Empty oneof", request: struct {
+			A string `validate:"oneof="`
+		}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			Struct(tt.request)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenDestination is returned, wrapped, for a webhook URL that leads to an address off the public internet, such
+// as loopback, a private network or the cloud metadata endpoint
+var ErrForbiddenDestination = errors.New("webhook destination is not a public address")
+
+// Resolver looks up the addresses of a host, *net.Resolver is one
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network string, host string) ([]netip.Addr, error)
+}
+
+// nonPublic are the ranges netip.Addr has no method for that must not be reached either
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // this network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, may embed any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local use NAT64
+	netip.MustParsePrefix("2002::/16"),      // 6to4, may embed any IPv4 address
+	netip.MustParsePrefix("2001::/32"),      // Teredo
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site local
+	netip.MustParsePrefix("100::/64"),       // discard only
+}
+
+// PublicAddress reports whether addr is a unicast address on the public internet
+func PublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckDestination resolves the host of a webhook URL and fails with ErrForbiddenDestination unless every address it
+// has is public. It is what a subscription is checked with, the client from NewClient checks the address again on
+// every connection as the name may resolve differently by then
+func CheckDestination(ctx context.Context, resolver Resolver, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := target.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !PublicAddress(addr) {
+			return fmt.Errorf("%w: %s", ErrForbiddenDestination, addr)
+		}
+		return nil
+	}
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !PublicAddress(addr) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrForbiddenDestination, host, addr)
+		}
+	}
+	return nil
+}
+
+// NewClient returns the client deliveries are sent with. It only connects to public addresses, checked once the name
+// has been resolved for each connection so a name resolving to a private address by then is refused, it never goes
+// through a proxy, and it does not follow redirects, a redirect is a failed attempt like any other non 2xx response
+func NewClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !PublicAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenDestination, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+)
+
+// staticResolver resolves every host to its addresses
+type staticResolver []netip.Addr
+
+func (r staticResolver) LookupNetIP(ctx context.Context, network string, host string) ([]netip.Addr, error) {
+	return r, nil
+}
+
+func TestCheckDestination(t *testing.T) {
+	public := staticResolver{netip.MustParseAddr("93.184.215.14")}
+	tests := []struct {
+		name      string
+		url       string
+		resolver  Resolver
+		forbidden bool
+	}{
+		{name: "Public host", url: "https://example.com/hook", resolver: public},
+		{name: "Public address", url: "https://93.184.215.14/hook", resolver: public},
+		{name: "Public IPv6 address", url: "https://[2606:2800:21f:cb07:6820:80da:af6b:8b2c]/hook", resolver: public},
+		{name: "Loopback", url: "http://127.0.0.1:8080/hook", resolver: public, forbidden: true},
+		{name: "IPv6 loopback", url: "http://[::1]/hook", resolver: public, forbidden: true},
+		{name: "Cloud metadata", url: "http://169.254.169.254/latest/meta-data", resolver: public, forbidden: true},
+		{name: "Private network", url: "http://10.0.0.7/hook", resolver: public, forbidden: true},
+		{name: "Private network mapped into IPv6", url: "http://[::ffff:192.168.1.1]/hook", resolver: public, forbidden: true},
+		{name: "Unspecified", url: "http://0.0.0.0/hook", resolver: public, forbidden: true},
+		{name: "Carrier grade NAT", url: "http://100.64.0.1/hook", resolver: public, forbidden: true},
+		{name: "Unique local IPv6", url: "http://[fd12:3456::1]/hook", resolver: public, forbidden: true},
+		{name: "Host resolving to loopback", url: "https://localhost/hook", resolver: staticResolver{netip.MustParseAddr("127.0.0.1")}, forbidden: true},
+		{name: "Host resolving to a public and a private address", url: "https://example.com/hook", resolver: staticResolver{
+			netip.MustParseAddr("93.184.215.14"), netip.MustParseAddr("172.16.0.1"),
+		}, forbidden: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckDestination(context.Background(), tt.resolver, tt.url)
+			if tt.forbidden != errors.Is(err, ErrForbiddenDestination) || (!tt.forbidden && err != nil) {
+				t.Errorf("expected forbidden to be %v, got %v", tt.forbidden, err)
+			}
+		})
+	}
+}
+
+func TestClientRefusesPrivateAddressesAndRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// the test server listens on loopback, which is as far as the client ever gets
+	resp, err := NewClient().Post(server.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, ErrForbiddenDestination) {
+		t.Errorf("expected %v, got %v", ErrForbiddenDestination, err)
+	}
+
+	// a redirect is handed back as the response rather than followed
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+	}))
+	defer redirect.Close()
+	client := NewClient()
+	client.Transport = redirect.Client().Transport
+	resp, err = client.Get(redirect.URL)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("expected the redirect not to be followed, got %d", resp.StatusCode)
+	}
+}
+
+func TestDispatcherDeadLettersPrivateDestinations(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected no request to reach a loopback address")
+	}))
+	defer server.Close()
+
+	repo := newFakeWebhookRepo()
+	_, _ = repo.CreateWebhookSubscription(context.Background(), model.WebhookSubscription{
+		URL:        server.URL,
+		EventTypes: []model.EventType{model.EventTypeAccountCreated},
+		Secret:     "0123456789abcdef",
+	})
+	if err := NewPublisher(repo, logger).Publish(context.Background(), testEvent(t)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sent, err := NewDispatcher(repo, NewClient(), logger, Config{}).DispatchOnce(context.Background()); err != nil || sent != 1 {
+		t.Fatalf("expected 1 delivery attempted, got %d (%v)", sent, err)
+	}
+	if delivery := repo.onlyDelivery(t); delivery.Status != model.WebhookDeliveryDeadLetter || delivery.Attempts != 1 {
+		t.Errorf("expected the delivery to be dead lettered straight away, got %+v", delivery)
+	}
+}
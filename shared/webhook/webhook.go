@@ -0,0 +1,271 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+)
+
+const (
+	// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the subscription secret
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader carries the unix time the request was signed at, receivers should reject stale timestamps
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventTypeHeader = "X-Webhook-Event"
+	// DeliveryIDHeader stays the same across retries of a delivery so receivers can deduplicate
+	DeliveryIDHeader = "X-Webhook-Delivery"
+
+	// maxResponseBytes is as much of a failed response body as is kept for the delivery's last error
+	maxResponseBytes = 512
+)
+
+// Sign returns the signature header value for a body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header value in constant time, it is what a receiver is expected to do
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Publisher is an outbox.EventPublisher that queues a delivery of the event for every subscription interested in it,
+// the Dispatcher sends them afterwards
+type Publisher struct {
+	repo   repository.WebhookRepository
+	logger *slog.Logger
+}
+
+func NewPublisher(repo repository.WebhookRepository, logger *slog.Logger) *Publisher {
+	return &Publisher{repo: repo, logger: logger}
+}
+
+func (p *Publisher) Publish(ctx context.Context, event model.OutboxEvent) error {
+	subscriptions, err := p.repo.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %w", event.ID.Hex(), err)
+	}
+	now := time.Now().UTC()
+	for _, subscription := range subscriptions {
+		if !subscription.Subscribes(event.Type) {
+			continue
+		}
+		err := p.repo.CreateWebhookDelivery(ctx, model.WebhookDelivery{
+			SubscriptionID: subscription.ID.Hex(),
+			EventID:        event.ID.Hex(),
+			EventType:      event.Type,
+			Body:           body,
+			Status:         model.WebhookDeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+		if err != nil {
+			return err
+		}
+		p.logger.InfoContext(ctx, "queued webhook delivery", "eventID", event.ID.Hex(), "type", event.Type, "subscriptionID", subscription.ID.Hex())
+	}
+	return nil
+}
+
+// Config controls how the Dispatcher retries, the zero value of any field falls back to its default
+type Config struct {
+	Interval    time.Duration // how long to wait between polls once nothing is due
+	Timeout     time.Duration // per request timeout, a claimed delivery is leased for twice as long
+	MaxAttempts int           // attempts before a delivery is dead lettered
+	BaseBackoff time.Duration // wait after the first failure, doubled after every further failure
+	MaxBackoff  time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:    time.Second,
+		Timeout:     10 * time.Second,
+		MaxAttempts: 8,
+		BaseBackoff: 30 * time.Second,
+		MaxBackoff:  6 * time.Hour,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	defaults := DefaultConfig()
+	if c.Interval <= 0 {
+		c.Interval = defaults.Interval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaults.MaxAttempts
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = defaults.BaseBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = defaults.MaxBackoff
+	}
+	return c
+}
+
+// Backoff is how long to wait before the next attempt once attempts have failed
+func (c Config) Backoff(attempts int) time.Duration {
+	backoff := c.BaseBackoff
+	for i := 1; i < attempts && backoff < c.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, c.MaxBackoff)
+}
+
+// dispatchBatchSize is the most deliveries claimed per poll
+const dispatchBatchSize = 50
+
+// Dispatcher sends queued deliveries, retrying failures with exponential backoff until they succeed or run out of
+// attempts and are dead lettered
+type Dispatcher struct {
+	repo   repository.WebhookRepository
+	client *http.Client
+	logger *slog.Logger
+	config Config
+}
+
+func NewDispatcher(repo repository.WebhookRepository, client *http.Client, logger *slog.Logger, config Config) *Dispatcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	logger.InfoContext(context.Background(), "webhook Dispatcher initialized")
+	return &Dispatcher{repo: repo, client: client, logger: logger, config: config.withDefaults()}
+}
+
+// Run sends due deliveries until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		sent, err := d.DispatchOnce(ctx)
+		if err != nil {
+			d.logger.ErrorContext(ctx, "failed to dispatch webhook deliveries", "error", err)
+		}
+		if err == nil && sent == dispatchBatchSize {
+			timer.Reset(0)
+			continue
+		}
+		timer.Reset(d.config.Interval)
+	}
+}
+
+// DispatchOnce claims one batch of due deliveries and attempts all of them at once, returning how many were attempted.
+// Each request is cut off at the timeout so the whole batch is sent and recorded within the lease of twice as long,
+// an attempt finishing after another dispatcher has claimed the delivery again is not recorded
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	// the lease is matched exactly when the attempt is recorded, every store keeps times to the millisecond
+	leaseUntil := now.Add(2 * d.config.Timeout).Truncate(time.Millisecond)
+	deliveries, err := d.repo.ClaimDueWebhookDeliveries(ctx, now, leaseUntil, dispatchBatchSize)
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Go(func() {
+			err := d.attempt(ctx, delivery)
+			switch {
+			case errors.Is(err, repository.ErrConflict):
+				d.logger.WarnContext(ctx, "webhook delivery was claimed again before its attempt was recorded", "deliveryID", delivery.ID.Hex())
+			case err != nil:
+				d.logger.ErrorContext(ctx, "failed to record webhook delivery attempt", "deliveryID", delivery.ID.Hex(), "error", err)
+			}
+		})
+	}
+	wg.Wait()
+	return len(deliveries), err
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery model.WebhookDelivery) error {
+	leaseUntil := delivery.NextAttemptAt
+	subscription, err := d.repo.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	var statusCode int
+	giveUp := false
+	if subscription == nil {
+		// nothing is left to deliver to so there is no point retrying
+		err = errors.New("subscription no longer exists")
+		giveUp = true
+	} else {
+		statusCode, err = d.send(ctx, *subscription, delivery)
+		// the destination is not going to become public by trying again
+		giveUp = errors.Is(err, ErrForbiddenDestination)
+	}
+
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	switch {
+	case err == nil:
+		delivery.Status = model.WebhookDeliverySucceeded
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	case giveUp || delivery.Attempts >= d.config.MaxAttempts:
+		delivery.Status = model.WebhookDeliveryDeadLetter
+		delivery.LastError = err.Error()
+		d.logger.WarnContext(ctx, "webhook delivery dead lettered", "deliveryID", delivery.ID.Hex(), "attempts", delivery.Attempts, "error", err)
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(d.config.Backoff(delivery.Attempts))
+		d.logger.InfoContext(ctx, "webhook delivery failed, will retry", "deliveryID", delivery.ID.Hex(), "attempts", delivery.Attempts, "nextAttemptAt", delivery.NextAttemptAt, "error", err)
+	}
+	return d.repo.UpdateWebhookDelivery(ctx, delivery.ID.Hex(), delivery, leaseUntil)
+}
+
+// send posts the delivery body and treats anything but a 2xx response as a failure
+func (d *Dispatcher) send(ctx context.Context, subscription model.WebhookSubscription, delivery model.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, string(delivery.EventType))
+	req.Header.Set(DeliveryIDHeader, delivery.ID.Hex())
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, timestamp, delivery.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,412 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joolshouston/pismo-technical-test/shared/model"
+	"github.com/joolshouston/pismo-technical-test/shared/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// fakeWebhookRepo keeps subscriptions and deliveries in maps
+type fakeWebhookRepo struct {
+	mu            sync.Mutex
+	subscriptions map[string]model.WebhookSubscription
+	deliveries    map[string]model.WebhookDelivery
+}
+
+func newFakeWebhookRepo() *fakeWebhookRepo {
+	return &fakeWebhookRepo{
+		subscriptions: map[string]model.WebhookSubscription{},
+		deliveries:    map[string]model.WebhookDelivery{},
+	}
+}
+
+func (f *fakeWebhookRepo) CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (*model.WebhookSubscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subscription.ID = bson.NewObjectID()
+	f.subscriptions[subscription.ID.Hex()] = subscription
+	return &subscription, nil
+}
+
+func (f *fakeWebhookRepo) GetWebhookSubscription(ctx context.Context, subscriptionID string) (*model.WebhookSubscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subscription, ok := f.subscriptions[subscriptionID]
+	if !ok {
+		return nil, nil
+	}
+	return &subscription, nil
+}
+
+func (f *fakeWebhookRepo) ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var subscriptions []model.WebhookSubscription
+	for _, subscription := range f.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (f *fakeWebhookRepo) UpdateWebhookSubscription(ctx context.Context, subscriptionID string, subscription model.WebhookSubscription) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (f *fakeWebhookRepo) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subscriptions, subscriptionID)
+	return nil
+}
+
+func (f *fakeWebhookRepo) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, existing := range f.deliveries {
+		if existing.SubscriptionID == delivery.SubscriptionID && existing.EventID == delivery.EventID {
+			return nil
+		}
+	}
+	delivery.ID = bson.NewObjectID()
+	f.deliveries[delivery.ID.Hex()] = delivery
+	return nil
+}
+
+func (f *fakeWebhookRepo) GetWebhookDelivery(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delivery, ok := f.deliveries[deliveryID]
+	if !ok {
+		return nil, nil
+	}
+	return &delivery, nil
+}
+
+func (f *fakeWebhookRepo) ListWebhookDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter, limit int) ([]model.WebhookDelivery, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (f *fakeWebhookRepo) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var deliveries []model.WebhookDelivery
+	for id, delivery := range f.deliveries {
+		if delivery.Status == model.WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) && len(deliveries) < limit {
+			delivery.NextAttemptAt = leaseUntil
+			f.deliveries[id] = delivery
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (f *fakeWebhookRepo) UpdateWebhookDelivery(ctx context.Context, deliveryID string, delivery model.WebhookDelivery, expectedNextAttemptAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.deliveries[deliveryID]
+	if !ok {
+		return errors.New("webhook delivery not found")
+	}
+	if !existing.NextAttemptAt.Equal(expectedNextAttemptAt) {
+		return repository.ErrConflict
+	}
+	f.deliveries[deliveryID] = delivery
+	return nil
+}
+
+// onlyDelivery returns the single delivery a test has queued
+func (f *fakeWebhookRepo) onlyDelivery(t *testing.T) model.WebhookDelivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.deliveries) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(f.deliveries))
+	}
+	for _, delivery := range f.deliveries {
+		return delivery
+	}
+	return model.WebhookDelivery{}
+}
+
+func testEvent(t *testing.T) model.OutboxEvent {
+	payload, err := json.Marshal(model.AccountCreatedEvent{AccountID: "acc-1", DocumentNumber: "12345678900"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return model.OutboxEvent{
+		ID:          bson.NewObjectID(),
+		Type:        model.EventTypeAccountCreated,
+		AggregateID: "acc-1",
+		AccountID:   "acc-1",
+		Payload:     payload,
+		OccurredAt:  time.Now().UTC(),
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"AccountCreated"}`)
+	signature := Sign("secret-secret-secret", 1700000000, body)
+	if signature != Sign("secret-secret-secret", 1700000000, body) || signature[:7] != "sha256=" {
+		t.Fatalf("expected a stable sha256 signature, got %s", signature)
+	}
+	if !Verify("secret-secret-secret", 1700000000, body, signature) {
+		t.Errorf("expected the signature to verify")
+	}
+	if Verify("another-secret-value", 1700000000, body, signature) {
+		t.Errorf("expected a different secret to fail")
+	}
+	if Verify("secret-secret-secret", 1700000001, body, signature) {
+		t.Errorf("expected a different timestamp to fail")
+	}
+	if Verify("secret-secret-secret", 1700000000, []byte(`{"type":"TransactionCreated"}`), signature) {
+		t.Errorf("expected a different body to fail")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	config := Config{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	for attempts, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 50: 10 * time.Second} {
+		if backoff := config.Backoff(attempts); backoff != expected {
+			t.Errorf("expected %s after %d attempts, got %s", expected, attempts, backoff)
+		}
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	repo := newFakeWebhookRepo()
+	subscribed, _ := repo.CreateWebhookSubscription(context.Background(), model.WebhookSubscription{
+		URL:        "http://example.com/hook",
+		EventTypes: []model.EventType{model.EventTypeAccountCreated},
+	})
+	_, _ = repo.CreateWebhookSubscription(context.Background(), model.WebhookSubscription{
+		URL:        "http://example.com/other",
+		EventTypes: []model.EventType{model.EventTypeTransactionCreated},
+	})
+
+	publisher := NewPublisher(repo, logger)
+	event := testEvent(t)
+	// the relay may publish the same event twice, it must still be queued once
+	for i := 0; i < 2; i++ {
+		if err := publisher.Publish(context.Background(), event); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	delivery := repo.onlyDelivery(t)
+	if delivery.SubscriptionID != subscribed.ID.Hex() || delivery.EventID != event.ID.Hex() || delivery.Status != model.WebhookDeliveryPending {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+	var body model.OutboxEvent
+	if err := json.Unmarshal(delivery.Body, &body); err != nil || body.Type != model.EventTypeAccountCreated {
+		t.Errorf("expected the event as the body, got %s (%v)", delivery.Body, err)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	const secret = "0123456789abcdef"
+
+	tests := []struct {
+		name         string
+		responses    []int
+		attempts     int
+		unsubscribe  bool
+		validate     func(t *testing.T, delivery model.WebhookDelivery)
+		expectedHits int
+	}{
+		{
+			name:         "Delivered and signed",
+			responses:    []int{http.StatusNoContent},
+			attempts:     1,
+			expectedHits: 1,
+			validate: func(t *testing.T, delivery model.WebhookDelivery) {
+				if delivery.Status != model.WebhookDeliverySucceeded || delivery.DeliveredAt == nil || delivery.Attempts != 1 {
+					t.Errorf("expected a successful delivery, got %+v", delivery)
+				}
+			},
+		},
+		{
+			name:         "Failure is retried with backoff",
+			responses:    []int{http.StatusInternalServerError},
+			attempts:     1,
+			expectedHits: 1,
+			validate: func(t *testing.T, delivery model.WebhookDelivery) {
+				if delivery.Status != model.WebhookDeliveryPending || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusInternalServerError {
+					t.Errorf("expected a pending delivery after one failure, got %+v", delivery)
+				}
+				if delivery.NextAttemptAt.Before(time.Now().Add(50 * time.Second)) {
+					t.Errorf("expected the next attempt to be pushed out by the backoff, got %s", delivery.NextAttemptAt)
+				}
+			},
+		},
+		{
+			name:         "Dead lettered once attempts run out",
+			responses:    []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			attempts:     3,
+			expectedHits: 3,
+			validate: func(t *testing.T, delivery model.WebhookDelivery) {
+				if delivery.Status != model.WebhookDeliveryDeadLetter || delivery.Attempts != 3 || delivery.LastError == "" {
+					t.Errorf("expected a dead lettered delivery, got %+v", delivery)
+				}
+			},
+		},
+		{
+			name:         "Deleted subscription is dead lettered straight away",
+			unsubscribe:  true,
+			attempts:     1,
+			expectedHits: 0,
+			validate: func(t *testing.T, delivery model.WebhookDelivery) {
+				if delivery.Status != model.WebhookDeliveryDeadLetter || delivery.Attempts != 1 {
+					t.Errorf("expected a dead lettered delivery, got %+v", delivery)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			hits := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				body, _ := io.ReadAll(r.Body)
+				timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+				if err != nil || !Verify(secret, timestamp, body, r.Header.Get(SignatureHeader)) {
+					t.Errorf("expected a valid signature, got %q", r.Header.Get(SignatureHeader))
+				}
+				if r.Header.Get(EventTypeHeader) != string(model.EventTypeAccountCreated) || r.Header.Get(DeliveryIDHeader) == "" {
+					t.Errorf("expected the event type and delivery headers to be set")
+				}
+				w.WriteHeader(tt.responses[hits])
+				hits++
+			}))
+			defer server.Close()
+
+			repo := newFakeWebhookRepo()
+			subscription, _ := repo.CreateWebhookSubscription(context.Background(), model.WebhookSubscription{
+				URL:        server.URL,
+				EventTypes: []model.EventType{model.EventTypeAccountCreated},
+				Secret:     secret,
+			})
+			if err := NewPublisher(repo, logger).Publish(context.Background(), testEvent(t)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.unsubscribe {
+				_ = repo.DeleteWebhookSubscription(context.Background(), subscription.ID.Hex())
+			}
+
+			dispatcher := NewDispatcher(repo, server.Client(), logger, Config{MaxAttempts: 3, BaseBackoff: time.Minute})
+			for i := 0; i < tt.attempts; i++ {
+				if i > 0 {
+					// skip the backoff so the next attempt is due
+					delivery := repo.onlyDelivery(t)
+					leaseUntil := delivery.NextAttemptAt
+					delivery.NextAttemptAt = time.Now().Add(-time.Second)
+					_ = repo.UpdateWebhookDelivery(context.Background(), delivery.ID.Hex(), delivery, leaseUntil)
+				}
+				if sent, err := dispatcher.DispatchOnce(context.Background()); err != nil || sent != 1 {
+					t.Fatalf("expected 1 delivery attempted, got %d (%v)", sent, err)
+				}
+			}
+			if hits != tt.expectedHits {
+				t.Errorf("expected %d requests, got %d", tt.expectedHits, hits)
+			}
+			tt.validate(t, repo.onlyDelivery(t))
+		})
+	}
+}
+
+func TestDispatcherSendsTheBatchAtOnce(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	const deliveries = 3
+	// every request is held until all of them have arrived, which only happens when they are sent at the same time
+	var arrived sync.WaitGroup
+	arrived.Add(deliveries)
+	released := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(released)
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		select {
+		case <-released:
+			w.WriteHeader(http.StatusNoContent)
+		case <-time.After(time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	defer server.Close()
+
+	repo := newFakeWebhookRepo()
+	_, _ = repo.CreateWebhookSubscription(context.Background(), model.WebhookSubscription{
+		URL:        server.URL,
+		EventTypes: []model.EventType{model.EventTypeAccountCreated},
+		Secret:     "0123456789abcdef",
+	})
+	publisher := NewPublisher(repo, logger)
+	for range deliveries {
+		if err := publisher.Publish(context.Background(), testEvent(t)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	dispatcher := NewDispatcher(repo, server.Client(), logger, Config{})
+	if sent, err := dispatcher.DispatchOnce(context.Background()); err != nil || sent != deliveries {
+		t.Fatalf("expected %d deliveries attempted, got %d (%v)", deliveries, sent, err)
+	}
+	for _, delivery := range repo.deliveries {
+		if delivery.Status != model.WebhookDeliverySucceeded {
+			t.Errorf("expected every delivery to succeed, got %+v", delivery)
+		}
+	}
+}
+
+func TestDispatcherDoesNotRecordAnAttemptAfterItsLease(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	repo := newFakeWebhookRepo()
+	// the lease runs out while the request is in flight and another dispatcher claims the delivery
+	var reclaimedUntil time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reclaimedUntil = time.Now().Add(time.Minute)
+		claimed, err := repo.ClaimDueWebhookDeliveries(context.Background(), time.Now().Add(time.Hour), reclaimedUntil, 1)
+		if err != nil || len(claimed) != 1 {
+			t.Errorf("expected the delivery to be claimed again, got %+v, %v", claimed, err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, _ = repo.CreateWebhookSubscription(context.Background(), model.WebhookSubscription{
+		URL:        server.URL,
+		EventTypes: []model.EventType{model.EventTypeAccountCreated},
+		Secret:     "0123456789abcdef",
+	})
+	if err := NewPublisher(repo, logger).Publish(context.Background(), testEvent(t)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	dispatcher := NewDispatcher(repo, server.Client(), logger, Config{})
+	if sent, err := dispatcher.DispatchOnce(context.Background()); err != nil || sent != 1 {
+		t.Fatalf("expected 1 delivery attempted, got %d (%v)", sent, err)
+	}
+	delivery := repo.onlyDelivery(t)
+	if delivery.Attempts != 0 || !delivery.NextAttemptAt.Equal(reclaimedUntil) {
+		t.Errorf("expected the delivery to be left to the dispatcher holding it now, got %+v", delivery)
+	}
+}